@@ -1,6 +1,7 @@
 package sam3
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/base32"
 	"encoding/base64"
@@ -15,8 +16,9 @@ var (
 // The public and private keys associated with an I2P destination. I2P hides the
 // details of exactly what this is, so treat them as blobs, but generally: One
 // pair of DSA keys, one pair of ElGamal keys, and sometimes (almost never) also
-// a certificate. String() returns you the full content of I2PKeys and Addr()
-// returns the public keys.
+// a certificate. String() or Private() returns you the full content of I2PKeys
+// and Addr() returns the public keys. Use NewI2PKeys to create I2PKeys from
+// stored strings, so that mistakes show up there instead of at SESSION CREATE.
 type I2PKeys struct {
 	addr I2PAddr // only the public key
 	both string  // both public and private keys
@@ -28,6 +30,35 @@ func NewKeys(addr I2PAddr, both string) I2PKeys {
 	return I2PKeys{addr, both}
 }
 
+// Returns placeholder keys that make the bridge generate a new destination
+// (DESTINATION=TRANSIENT) for the session created with them. The session then
+// has the generated keys, private part included, see StreamSession.Keys, so
+// they can be saved to reuse the identity.
+func TransientKeys() I2PKeys {
+	return NewKeys(I2PAddr("TRANSIENT"), "TRANSIENT")
+}
+
+// Creates I2PKeys from a destination and the private keys belonging to it (as
+// generated by Private()), after checking that both are well-formed and that
+// the private keys start with the destination.
+func NewI2PKeys(addr I2PAddr, priv string) (I2PKeys, error) {
+	if _, err := NewI2PAddrFromString(string(addr)); err != nil {
+		return I2PKeys{}, err
+	}
+	pub, _ := addr.ToBytes()
+	if n, _, _, err := parseDestination(pub); err != nil || n != len(pub) {
+		return I2PKeys{}, errors.New("Malformed destination")
+	}
+	b, err := i2pB64enc.DecodeString(priv)
+	if err != nil {
+		return I2PKeys{}, errors.New("Private keys are not base64-encoded")
+	}
+	if len(b) <= len(pub) || !bytes.HasPrefix(b, pub) {
+		return I2PKeys{}, errors.New("The private keys do not belong to the destination")
+	}
+	return I2PKeys{addr, priv}, nil
+}
+
 // Returns the public keys of the I2PKeys.
 func (k I2PKeys) Addr() I2PAddr {
 	return k.addr
@@ -39,6 +70,12 @@ func (k I2PKeys) String() string {
 	return k.both
 }
 
+// Returns the keys (both public and private), in I2Ps base64 format. The same
+// as String(), but harder to print by accident.
+func (k I2PKeys) Private() string {
+	return k.both
+}
+
 // I2PAddr represents an I2P destination, almost equivalent to an IP address.
 // This is the humongously huge base64 representation of such an address, which
 // really is just a pair of public keys and also maybe a certificate. (I2P hides
@@ -87,10 +124,11 @@ func NewI2PAddrFromBytes(addr []byte) (I2PAddr, error) {
 // Turns an I2P address to a byte array. The inverse of NewI2PAddrFromBytes().
 func (addr I2PAddr) ToBytes() ([]byte, error) {
 	buf := make([]byte, i2pB64enc.DecodedLen(len(addr)))
-	if _, err := i2pB64enc.Decode(buf, []byte(addr)); err != nil {
+	n, err := i2pB64enc.Decode(buf, []byte(addr))
+	if err != nil {
 		return buf, errors.New("Address is not base64-encoded")
 	}
-	return buf, nil
+	return buf[:n], nil
 }
 
 // Returns the *.b32.i2p address of the I2P address. It is supposed to be a
@@ -100,12 +138,23 @@ func (addr I2PAddr) ToBytes() ([]byte, error) {
 // performing a Lookup(). Lookup only works if you are using the I2PAddr from
 // which the b32 address was generated.
 func (addr I2PAddr) Base32() string {
-	hash := sha256.New()
-	hash.Write([]byte(string(addr)))
-	digest := hash.Sum(nil)
+	return addr.Base32NoSuffix() + ".b32.i2p"
+}
+
+// Returns the b32 address of the I2P address without the ".b32.i2p" suffix:
+// the 52 lowercase characters of the label alone. Base32 labels are not case
+// sensitive, so tools wanting upper case can use strings.ToUpper on it.
+func (addr I2PAddr) Base32NoSuffix() string {
+	digest := sha256.Sum256([]byte(string(addr)))
 	b32addr := make([]byte, 56)
-	i2pB32enc.Encode(b32addr, digest)
-	return string(b32addr[:52]) + ".b32.i2p"
+	i2pB32enc.Encode(b32addr, digest[:])
+	return string(b32addr[:52])
+}
+
+// Returns the 52 character label of the b32 address of the I2P address, the
+// hash of the destination it encodes; the same as Base32NoSuffix.
+func (addr I2PAddr) Base32Hash() string {
+	return addr.Base32NoSuffix()
 }
 
 // Makes any string into a *.b32.i2p human-readable I2P address. This makes no
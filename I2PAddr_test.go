@@ -0,0 +1,54 @@
+package sam3
+
+import (
+	"crypto/sha256"
+	"strings"
+	"testing"
+)
+
+func Test_NewI2PKeys(t *testing.T) {
+	seeded, err := GenerateKeysFromSeed([]byte("keys"), Sig_EdDSA_SHA512_Ed25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewI2PKeys(seeded.Addr(), seeded.Private())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys != seeded {
+		t.Fatal("keys changed")
+	}
+	other, _ := GenerateKeysFromSeed([]byte("other"), Sig_EdDSA_SHA512_Ed25519)
+	for _, bad := range []struct {
+		addr I2PAddr
+		priv string
+	}{
+		{"short", seeded.Private()},
+		{seeded.Addr(), "not base64!"},
+		{seeded.Addr(), string(seeded.Addr())},
+		{seeded.Addr(), other.Private()},
+		{seeded.Addr() + "AAAA", seeded.Private()},
+	} {
+		if _, err := NewI2PKeys(bad.addr, bad.priv); err == nil {
+			t.Errorf("accepted %.20q, %.20q", bad.addr, bad.priv)
+		}
+	}
+}
+
+func Test_Base32Forms(t *testing.T) {
+	addr := I2PAddr(testDest)
+	label := addr.Base32NoSuffix()
+	if len(label) != 52 || strings.ToLower(label) != label {
+		t.Fatalf("unexpected label %q", label)
+	}
+	if addr.Base32() != label+".b32.i2p" {
+		t.Fatalf("Base32 %q does not match label %q", addr.Base32(), label)
+	}
+	if addr.Base32Hash() != label {
+		t.Fatalf("Base32Hash %q does not match label %q", addr.Base32Hash(), label)
+	}
+	hash := sha256.Sum256([]byte(string(addr)))
+	if i2pB32enc.EncodeToString(hash[:])[:52] != label {
+		t.Fatal("label does not encode the hash")
+	}
+}
@@ -1,41 +1,56 @@
 package sam3
 
 import (
+	"errors"
 	"net"
 	"time"
 )
 
+// Returned by SAMConn.WriteTimeout and TryWrite when the bridge did not take
+// the data in time, because the tunnels are congested.
+var ErrWriteWouldBlock = errors.New("Write would block")
+
+// How long TryWrite waits for the buffers to take the data.
+const tryWriteTimeout = time.Millisecond
+
 // Implements net.Conn
 type SAMConn struct {
 	laddr I2PAddr
 	raddr I2PAddr
 	conn  net.Conn
+
+	fromPort, toPort int // I2P ports of accepted connections (SAM 3.2)
+
+	traffic *trafficCounter // of the session, if any
 }
 
 // Implements net.Conn
 func (sc SAMConn) Read(buf []byte) (int, error) {
 	n, err := sc.conn.Read(buf)
+	sc.traffic.addIn(n)
 	return n, err
 }
 
 // Implements net.Conn
 func (sc SAMConn) Write(buf []byte) (int, error) {
 	n, err := sc.conn.Write(buf)
+	sc.traffic.addOut(n)
 	return n, err
 }
 
-// Implements net.Conn
+// Closes this connection only. The session it was dialed or accepted from is
+// not affected, see StreamSession. Implements net.Conn
 func (sc SAMConn) Close() error {
 	return sc.conn.Close()
 }
 
 // Implements net.Conn
-func (sc SAMConn) LocalAddr() I2PAddr {
+func (sc SAMConn) LocalAddr() net.Addr {
 	return sc.laddr
 }
 
 // Implements net.Conn
-func (sc SAMConn) RemoteAddr() I2PAddr {
+func (sc SAMConn) RemoteAddr() net.Addr {
 	return sc.raddr
 }
 
@@ -49,7 +64,46 @@ func (sc SAMConn) SetReadDeadline(t time.Time) error {
 	return sc.conn.SetReadDeadline(t)
 }
 
-// Implements net.Conn
+// Sets the deadline for writes, which reflects real tunnel backpressure: when
+// the tunnels are congested, the bridge stops reading from the connection,
+// its buffers fill up, and Write blocks until the router catches up. A write
+// that hits the deadline returns a net.Error whose Timeout() is true, after
+// writing a part of buf, which is counted in the returned n. Implements
+// net.Conn
 func (sc SAMConn) SetWriteDeadline(t time.Time) error {
 	return sc.conn.SetWriteDeadline(t)
 }
+
+// Like Write, but gives up after d and returns ErrWriteWouldBlock, along with
+// how much of buf was written, so latency-sensitive applications can drop,
+// buffer or back off instead of stalling on congested tunnels. Clears the
+// write deadline when done.
+func (sc SAMConn) WriteTimeout(buf []byte, d time.Duration) (int, error) {
+	if err := sc.conn.SetWriteDeadline(time.Now().Add(d)); err != nil {
+		return 0, err
+	}
+	defer sc.conn.SetWriteDeadline(time.Time{})
+	n, err := sc.Write(buf)
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		return n, ErrWriteWouldBlock
+	}
+	return n, err
+}
+
+// Like WriteTimeout, with a timeout just long enough for a write that fits
+// into the buffers to go through.
+func (sc SAMConn) TryWrite(buf []byte) (int, error) {
+	return sc.WriteTimeout(buf, tryWriteTimeout)
+}
+
+// Returns the I2P port the peer connected from, for accepted connections on
+// SAM 3.2 and later. Zero otherwise.
+func (sc SAMConn) FromPort() int {
+	return sc.fromPort
+}
+
+// Returns the I2P port the peer connected to, for accepted connections on SAM
+// 3.2 and later. Zero otherwise.
+func (sc SAMConn) ToPort() int {
+	return sc.toPort
+}
@@ -0,0 +1,37 @@
+package sam3
+
+import (
+	"net"
+	"os"
+)
+
+// Where the SAM bridge of an I2P router listens, unless configured otherwise.
+const DefaultSAMAddress = "127.0.0.1:7656"
+
+// Returns the address of the SAM bridge to use when none was given, from, in
+// order of precedence:
+//
+//  1. the SAM_ADDRESS environment variable, as host:port
+//  2. the I2P_SAM_HOST environment variable, as host or host:port, with the
+//     port defaulting to 7656
+//  3. DefaultSAMAddress
+//
+// Empty variables are ignored.
+func ResolveSAMAddress() string {
+	if addr := os.Getenv("SAM_ADDRESS"); addr != "" {
+		return addr
+	}
+	if host := os.Getenv("I2P_SAM_HOST"); host != "" {
+		if _, _, err := net.SplitHostPort(host); err == nil {
+			return host
+		}
+		return net.JoinHostPort(host, "7656")
+	}
+	return DefaultSAMAddress
+}
+
+// Creates a new controller for the SAM bridge at ResolveSAMAddress(), which
+// is DefaultSAMAddress unless the environment says otherwise.
+func NewDefaultSAM(opts ...SAMOption) (*SAM, error) {
+	return NewSAM(ResolveSAMAddress(), opts...)
+}
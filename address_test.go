@@ -0,0 +1,35 @@
+package sam3
+
+import (
+	"testing"
+)
+
+func Test_ResolveSAMAddress(t *testing.T) {
+	tests := []struct {
+		samAddress, samHost, want string
+	}{
+		{"", "", DefaultSAMAddress},
+		{"10.0.0.1:7000", "10.0.0.2", "10.0.0.1:7000"},
+		{"", "10.0.0.2", "10.0.0.2:7656"},
+		{"", "10.0.0.2:7001", "10.0.0.2:7001"},
+		{"", "::1", "[::1]:7656"},
+	}
+	for _, test := range tests {
+		t.Setenv("SAM_ADDRESS", test.samAddress)
+		t.Setenv("I2P_SAM_HOST", test.samHost)
+		if got := ResolveSAMAddress(); got != test.want {
+			t.Errorf("SAM_ADDRESS=%q I2P_SAM_HOST=%q: got %q, want %q", test.samAddress, test.samHost, got, test.want)
+		}
+	}
+}
+
+func Test_NewDefaultSAM(t *testing.T) {
+	mock := newMockSAM(t, nil)
+	defer mock.Close()
+	t.Setenv("SAM_ADDRESS", mock.Addr())
+	sam, err := NewDefaultSAM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sam.Close()
+}
@@ -0,0 +1,173 @@
+package sam3
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"sort"
+	"time"
+)
+
+// What happened to a name in the address book.
+type AddressBookAction int
+
+const (
+	AddressAdded   AddressBookAction = iota // the name resolves to Addr now
+	AddressRemoved                          // the name no longer resolves to Addr
+)
+
+func (a AddressBookAction) String() string {
+	if a == AddressRemoved {
+		return "removed"
+	}
+	return "added"
+}
+
+// A change of a name in the address book of the router. A name that moved to
+// another destination is removed from the old one and added to the new one.
+type AddressBookUpdate struct {
+	Name   string
+	Addr   I2PAddr
+	Action AddressBookAction
+}
+
+// How often SubscribeAddressBook polls, unless set with
+// SetAddressBookInterval.
+const defaultAddressBookInterval = time.Minute
+
+// Adds names to those SubscribeAddressBook watches.
+func (sam *SAM) WatchNames(names ...string) {
+	sam.abMu.Lock()
+	defer sam.abMu.Unlock()
+	if sam.abNames == nil {
+		sam.abNames = make(map[string]bool)
+	}
+	for _, name := range names {
+		sam.abNames[name] = true
+	}
+}
+
+// Sets how often SubscribeAddressBook polls, once a minute by default.
+func (sam *SAM) SetAddressBookInterval(d time.Duration) {
+	sam.abMu.Lock()
+	defer sam.abMu.Unlock()
+	sam.abInterval = d
+}
+
+func (sam *SAM) watchedNames() ([]string, time.Duration) {
+	sam.abMu.Lock()
+	defer sam.abMu.Unlock()
+	names := make([]string, 0, len(sam.abNames))
+	for name := range sam.abNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	interval := sam.abInterval
+	if interval <= 0 {
+		interval = defaultAddressBookInterval
+	}
+	return names, interval
+}
+
+// Reports changes of the address book of the router on the returned channel,
+// until ctx is done, when the channel is closed. The first updates add the
+// names that resolve at the time.
+//
+// SAM has no command to list the address book or to be told about changes, so
+// the names set with WatchNames are looked up in the background every
+// interval (see SetAddressBookInterval), on a connection of their own, and
+// the results compared with the last ones. Names added with WatchNames later
+// are picked up by the next poll. Polls that fail to reach the bridge are
+// skipped. Returns an error only if the bridge can not be reached at all.
+func (sam *SAM) SubscribeAddressBook(ctx context.Context) (<-chan AddressBookUpdate, error) {
+	poller, err := NewSAMConfig(sam.cfg)
+	if err != nil {
+		return nil, err
+	}
+	updates := make(chan AddressBookUpdate, 16)
+	go func() {
+		defer close(updates)
+		defer func() { poller.Close() }()
+		var known map[string]I2PAddr
+		var lastHash [32]byte
+		for {
+			names, interval := sam.watchedNames()
+			addrs, err := poller.pollNames(ctx, names)
+			if err != nil && ctx.Err() == nil {
+				// the connection is likely broken, try a new one next time
+				poller.Close()
+				if p, err := NewSAMConfig(sam.cfg); err == nil {
+					poller = p
+				}
+			}
+			if err == nil {
+				if h := hashAddrs(addrs); known == nil || h != lastHash {
+					for _, u := range diffAddrs(known, addrs) {
+						select {
+						case updates <- u:
+						case <-ctx.Done():
+							return
+						}
+					}
+					known, lastHash = addrs, h
+				}
+			}
+			t := time.NewTimer(interval)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return
+			case <-t.C:
+			}
+		}
+	}()
+	return updates, nil
+}
+
+// Looks up names, leaving out those that do not resolve. Fails if any lookup
+// failed for another reason, so a broken poll is not taken for removals.
+func (sam *SAM) pollNames(ctx context.Context, names []string) (map[string]I2PAddr, error) {
+	addrs, errs, err := sam.LookupMany(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+	for _, err := range errs {
+		if !errors.Is(err, ErrNameNotFound) {
+			return nil, err
+		}
+	}
+	return addrs, nil
+}
+
+// Hashes the names and addresses of addrs, in the order of the names.
+func hashAddrs(addrs map[string]I2PAddr) [32]byte {
+	names := make([]string, 0, len(addrs))
+	for name := range addrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		h.Write([]byte(name + "=" + string(addrs[name]) + "\n"))
+	}
+	var sum [32]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// Returns the updates turning old into new, ordered by name.
+func diffAddrs(old, new map[string]I2PAddr) []AddressBookUpdate {
+	var updates []AddressBookUpdate
+	for name, addr := range old {
+		if new[name] != addr {
+			updates = append(updates, AddressBookUpdate{Name: name, Addr: addr, Action: AddressRemoved})
+		}
+	}
+	for name, addr := range new {
+		if old[name] != addr {
+			updates = append(updates, AddressBookUpdate{Name: name, Addr: addr, Action: AddressAdded})
+		}
+	}
+	sort.SliceStable(updates, func(i, j int) bool { return updates[i].Name < updates[j].Name })
+	return updates
+}
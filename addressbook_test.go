@@ -0,0 +1,51 @@
+package sam3
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_SubscribeAddressBook(t *testing.T) {
+	var mu sync.Mutex
+	moved := false
+	mock := newMockSAM(t, func(cmd string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		if moved && strings.HasPrefix(cmd, "NAMING LOOKUP NAME=known2") {
+			return "NAMING REPLY RESULT=KEY_NOT_FOUND NAME=known2.i2p\n"
+		}
+		return mockLookup(cmd)
+	})
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	sam.WatchNames("known1.i2p", "known2.i2p", "missing.i2p")
+	sam.SetAddressBookInterval(20 * time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates, err := sam.SubscribeAddressBook(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"known1.i2p", "known2.i2p"} {
+		u := <-updates
+		if u.Name != name || u.Action != AddressAdded || u.Addr != testDest {
+			t.Fatalf("expected %s to be added, got %+v", name, u)
+		}
+	}
+	mu.Lock()
+	moved = true
+	mu.Unlock()
+	if u := <-updates; u.Name != "known2.i2p" || u.Action != AddressRemoved {
+		t.Fatalf("expected known2.i2p to be removed, got %+v", u)
+	}
+	cancel()
+	for range updates {
+	}
+}
@@ -0,0 +1,109 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+)
+
+// Registers a service with jump services (such as those of stats.i2p or
+// i2pjump.i2p), so people can find it by name, by requesting a registration
+// URL of each with the name and destination of the service. Configure the URLs
+// with WithJumpServices; none are built in, since every jump service has its
+// own registration page, and they change over time.
+type ServiceAnnouncer struct {
+	mu           sync.Mutex
+	jumpServices []string
+}
+
+// Sets the registration URLs of the jump services to announce to, replacing
+// any set before. Each is requested with GET, with the query parameters
+// "hostname" (the name of the service) and "destination" (its base64
+// destination) added to any it already has. Returns a.
+func (a *ServiceAnnouncer) WithJumpServices(urls []string) *ServiceAnnouncer {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.jumpServices = append([]string(nil), urls...)
+	return a
+}
+
+// Announces the service serviceName, such as "example.i2p", at addr to every
+// jump service, one after another, through transport, such as the
+// HTTPTransport of a StreamSession. Returns the first error, after trying all
+// of them.
+func (a *ServiceAnnouncer) Announce(ctx context.Context, serviceName string, addr I2PAddr, transport http.RoundTripper) error {
+	urls, err := a.urls()
+	if err != nil {
+		return err
+	}
+	var first error
+	for _, u := range urls {
+		if err := announce(ctx, u, serviceName, addr, transport); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Like Announce, but announces to all jump services at once, and returns the
+// outcome by URL: nil for every jump service that accepted the request.
+func (a *ServiceAnnouncer) AnnounceAll(ctx context.Context, serviceName string, addr I2PAddr, transport http.RoundTripper) map[string]error {
+	urls, err := a.urls()
+	if err != nil {
+		return map[string]error{"": err}
+	}
+	results := make(map[string]error, len(urls))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, u := range urls {
+		wg.Add(1)
+		go func(u string) {
+			defer wg.Done()
+			err := announce(ctx, u, serviceName, addr, transport)
+			mu.Lock()
+			results[u] = err
+			mu.Unlock()
+		}(u)
+	}
+	wg.Wait()
+	return results
+}
+
+func (a *ServiceAnnouncer) urls() ([]string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.jumpServices) == 0 {
+		return nil, errors.New("No jump services configured")
+	}
+	return a.jumpServices, nil
+}
+
+func announce(ctx context.Context, jumpService, serviceName string, addr I2PAddr, transport http.RoundTripper) error {
+	u, err := url.Parse(jumpService)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	q.Set("hostname", serviceName)
+	q.Set("destination", addr.Base64())
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := (&http.Client{Transport: transport}).Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("Jump service " + jumpService + " answered " + strconv.Itoa(resp.StatusCode))
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package sam3
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_ServiceAnnouncer(t *testing.T) {
+	got := make(chan string, 2)
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.URL.Query().Get("hostname") + " " + r.URL.Query().Get("destination") + " " + r.URL.Query().Get("lang")
+	}))
+	defer ok.Close()
+	full := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "registrations closed", http.StatusServiceUnavailable)
+	}))
+	defer full.Close()
+
+	var a ServiceAnnouncer
+	if err := a.Announce(context.Background(), "example.i2p", testDest, http.DefaultTransport); err == nil {
+		t.Fatal("announced without jump services")
+	}
+	a.WithJumpServices([]string{ok.URL + "/add?lang=en", full.URL + "/add"})
+	if err := a.Announce(context.Background(), "example.i2p", testDest, http.DefaultTransport); err == nil {
+		t.Fatal("error of the second jump service was lost")
+	}
+	if req := <-got; req != "example.i2p "+string(testDest)+" en" {
+		t.Fatalf("unexpected registration %q", req)
+	}
+
+	results := a.AnnounceAll(context.Background(), "example.i2p", testDest, http.DefaultTransport)
+	if len(results) != 2 || results[ok.URL+"/add?lang=en"] != nil || results[full.URL+"/add"] == nil {
+		t.Fatalf("unexpected results %v", results)
+	}
+	<-got
+}
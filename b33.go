@@ -0,0 +1,114 @@
+package sam3
+
+import (
+	"encoding/base32"
+	"errors"
+	"hash/crc32"
+	"strings"
+)
+
+// A b33 address, the shareable address of a destination publishing an
+// encrypted leaseset (LS2). Unlike a b32 address, which is a hash of the
+// destination, it holds the unblinded signing public key of the destination,
+// from which clients derive the blinded key, and so the leaseset to look up,
+// of the day. Computing the address needs no secrets: the blinding secret
+// and per-client keys, if any, are handed out separately.
+//
+// The layout follows "Encrypted LeaseSet", section "Base 32 Encoding", of the
+// I2P specifications (https://geti2p.net/spec/encryptedleaseset):
+//
+//	flags            1 byte: bit 0 two-byte signature types, bit 1 secret
+//	                 required, bit 2 per-client authorization required
+//	public sig type  1 or 2 bytes
+//	blinded sig type 1 or 2 bytes
+//	public key       the signing public key, 32 bytes for Ed25519
+//
+// The first three bytes are XORed with the CRC-32 of the rest, and the whole
+// is base32-encoded without padding, followed by ".b32.i2p". Only Ed25519
+// destinations, blinded to RedDSA, are supported, as in the specification.
+type B33Address struct {
+	SigType        int    // of the destination, Sig_EdDSA_SHA512_Ed25519
+	BlindedSigType int    // Sig_RedDSA_SHA512_Ed25519
+	PublicKey      []byte // the signing public key of the destination
+	SecretRequired bool   // clients need a secret to blind the key
+	PerClientAuth  bool   // the leaseset is encrypted per client
+}
+
+// Returned for strings that are not valid b33 addresses.
+var ErrInvalidB33 = errors.New("Invalid b33 address")
+
+// The shortest b33 address, without the ".b32.i2p" suffix: 35 bytes.
+const minB33Len = 56
+
+var i2pB32NoPad = i2pB32enc.WithPadding(base32.NoPadding)
+
+// Returns the b33 address of the destination, for an encrypted leaseset that
+// requires a secret and/or per-client authorization as given. Only Ed25519
+// destinations can be blinded; others give ErrIncompatibleKeyType.
+func (addr I2PAddr) B33(secretRequired, perClientAuth bool) (string, error) {
+	pub, err := ed25519PublicKey(addr)
+	if err != nil {
+		return "", err
+	}
+	b := B33Address{
+		SigType:        Sig_EdDSA_SHA512_Ed25519,
+		BlindedSigType: Sig_RedDSA_SHA512_Ed25519,
+		PublicKey:      pub,
+		SecretRequired: secretRequired,
+		PerClientAuth:  perClientAuth,
+	}
+	return b.String(), nil
+}
+
+// Returns the address, such as "...56 characters....b32.i2p".
+func (b B33Address) String() string {
+	var flags byte
+	if b.SecretRequired {
+		flags |= 0x02
+	}
+	if b.PerClientAuth {
+		flags |= 0x04
+	}
+	data := append([]byte{flags, byte(b.SigType), byte(b.BlindedSigType)}, b.PublicKey...)
+	crc := crc32.ChecksumIEEE(data[3:])
+	data[0] ^= byte(crc)
+	data[1] ^= byte(crc >> 8)
+	data[2] ^= byte(crc >> 16)
+	return i2pB32NoPad.EncodeToString(data) + ".b32.i2p"
+}
+
+// Parses and validates a b33 address, with or without the ".b32.i2p" suffix.
+// Plain b32 addresses, which are shorter, give ErrInvalidB33.
+func ParseB33(s string) (B33Address, error) {
+	s = strings.TrimSuffix(strings.ToLower(s), ".b32.i2p")
+	if len(s) < minB33Len {
+		return B33Address{}, ErrInvalidB33
+	}
+	data, err := i2pB32NoPad.DecodeString(s)
+	if err != nil {
+		return B33Address{}, ErrInvalidB33
+	}
+	crc := crc32.ChecksumIEEE(data[3:])
+	data[0] ^= byte(crc)
+	data[1] ^= byte(crc >> 8)
+	data[2] ^= byte(crc >> 16)
+	flags := data[0]
+	if flags&0x01 != 0 || flags&^0x07 != 0 {
+		// two-byte signature types are not defined for blinding yet
+		return B33Address{}, ErrInvalidB33
+	}
+	b := B33Address{
+		SigType:        int(data[1]),
+		BlindedSigType: int(data[2]),
+		PublicKey:      data[3:],
+		SecretRequired: flags&0x02 != 0,
+		PerClientAuth:  flags&0x04 != 0,
+	}
+	if b.SigType != Sig_EdDSA_SHA512_Ed25519 && b.SigType != Sig_RedDSA_SHA512_Ed25519 {
+		return B33Address{}, ErrInvalidB33
+	}
+	if b.BlindedSigType != Sig_RedDSA_SHA512_Ed25519 || len(b.PublicKey) != 32 {
+		return B33Address{}, ErrInvalidB33
+	}
+	return b, nil
+}
@@ -0,0 +1,48 @@
+package sam3
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func Test_B33(t *testing.T) {
+	keys, err := GenerateKeysFromSeed([]byte("b33"), Sig_EdDSA_SHA512_Ed25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := keys.Addr().B33(true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addr) != minB33Len+len(".b32.i2p") || !strings.HasSuffix(addr, ".b32.i2p") {
+		t.Fatalf("unexpected b33 address %q", addr)
+	}
+	b, err := ParseB33(strings.ToUpper(addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, _ := ed25519PublicKey(keys.Addr())
+	if !bytes.Equal(b.PublicKey, pub) || !b.SecretRequired || b.PerClientAuth || b.BlindedSigType != Sig_RedDSA_SHA512_Ed25519 {
+		t.Fatalf("parsed %+v", b)
+	}
+	if b.String() != addr {
+		t.Fatalf("round trip gave %q, want %q", b.String(), addr)
+	}
+
+	// a changed key no longer matches the checksum
+	c := "a"
+	if addr[40] == 'a' {
+		c = "b"
+	}
+	broken := addr[:40] + c + addr[41:]
+	if _, err := ParseB33(broken); err != ErrInvalidB33 {
+		t.Fatalf("accepted a corrupted address: %v", err)
+	}
+	if _, err := ParseB33(keys.Addr().Base32()); err != ErrInvalidB33 {
+		t.Fatalf("accepted a b32 address: %v", err)
+	}
+	if _, err := I2PAddr(testDest).B33(false, false); err != ErrIncompatibleKeyType {
+		t.Fatalf("blinded a DSA destination: %v", err)
+	}
+}
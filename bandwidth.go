@@ -0,0 +1,125 @@
+package sam3
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Sent by a BandwidthMonitor when the round trip time spikes.
+type ThrottleEvent struct {
+	Timestamp time.Time
+	RTTms     int64   // the round trip time that triggered the event
+	Severity  float64 // how many times the baseline the round trip time was
+}
+
+// Detects when the router throttles tunnel bandwidth (which it does under
+// congestion), by periodically measuring the round trip time to an echo
+// service and comparing it to the median of recent measurements.
+type BandwidthMonitor struct {
+	session *StreamSession
+	echo    I2PAddr
+
+	// How often to measure. Defaults to 30 seconds.
+	Interval time.Duration
+	// How many times the baseline the round trip time has to be for it to
+	// count as throttling. Defaults to 3.
+	Factor float64
+
+	mu        sync.Mutex
+	samples   []time.Duration // the last 20 measurements
+	throttled bool
+	events    chan ThrottleEvent
+}
+
+// The number of measurements the baseline is computed from, and the number
+// needed before throttling is detected at all.
+const (
+	bandwidthSamples    = 20
+	bandwidthMinSamples = 5
+)
+
+// The payload sent to the echo service.
+var bandwidthProbe = []byte("sam3 bandwidth probe\n")
+
+// Creates a BandwidthMonitor that measures using session, against the echo
+// service at echo.
+func NewBandwidthMonitor(session *StreamSession, echo I2PAddr) *BandwidthMonitor {
+	return &BandwidthMonitor{
+		session:  session,
+		echo:     echo,
+		Interval: 30 * time.Second,
+		Factor:   3,
+		events:   make(chan ThrottleEvent, 16),
+	}
+}
+
+// Measures periodically until ctx is done. Failed measurements are skipped.
+func (m *BandwidthMonitor) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(m.Interval)
+		defer ticker.Stop()
+		for {
+			if rtt, err := m.session.MeasureEchoRTT(ctx, m.echo, bandwidthProbe); err == nil {
+				m.record(rtt)
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Returns the channel ThrottleEvents are sent on. One event is sent when
+// throttling starts; events are dropped if the channel is not read.
+func (m *BandwidthMonitor) Events() <-chan ThrottleEvent {
+	return m.events
+}
+
+// Returns the median of the last 20 measurements, or zero if there are none.
+func (m *BandwidthMonitor) BaselineRTT() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.baseline()
+}
+
+// Reports whether the last measurement was above the throttling threshold.
+func (m *BandwidthMonitor) IsThrottled() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.throttled
+}
+
+func (m *BandwidthMonitor) record(rtt time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	baseline := m.baseline()
+	m.samples = append(m.samples, rtt)
+	if len(m.samples) > bandwidthSamples {
+		m.samples = m.samples[1:]
+	}
+	if len(m.samples) <= bandwidthMinSamples || baseline == 0 {
+		return
+	}
+	severity := float64(rtt) / float64(baseline)
+	wasThrottled := m.throttled
+	m.throttled = severity >= m.Factor
+	if m.throttled && !wasThrottled {
+		select {
+		case m.events <- ThrottleEvent{time.Now(), int64(rtt / time.Millisecond), severity}:
+		default:
+		}
+	}
+}
+
+func (m *BandwidthMonitor) baseline() time.Duration {
+	if len(m.samples) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), m.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)/2]
+}
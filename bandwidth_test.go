@@ -0,0 +1,32 @@
+package sam3
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_BandwidthMonitor(t *testing.T) {
+	m := NewBandwidthMonitor(nil, testDest)
+	for i := 0; i < 10; i++ {
+		m.record(time.Second)
+	}
+	if m.BaselineRTT() != time.Second || m.IsThrottled() {
+		t.Fatalf("unexpected baseline %v or throttling", m.BaselineRTT())
+	}
+	m.record(5 * time.Second)
+	if !m.IsThrottled() {
+		t.Fatal("a 5x spike was not detected")
+	}
+	select {
+	case ev := <-m.Events():
+		if ev.RTTms != 5000 || ev.Severity != 5 {
+			t.Fatalf("unexpected event %+v", ev)
+		}
+	default:
+		t.Fatal("no ThrottleEvent was sent")
+	}
+	m.record(time.Second)
+	if m.IsThrottled() {
+		t.Fatal("still throttled after the round trip time recovered")
+	}
+}
@@ -0,0 +1,45 @@
+package sam3
+
+import "sync"
+
+// Pools of buffers for reading replies from the bridge, by size, so that busy
+// programs do not allocate a new buffer for every command. The largest fits a
+// raw datagram of the default maximum size with its header, see ReadRawFull.
+var bufPools = []struct {
+	size int
+	pool sync.Pool
+}{
+	{size: 256},
+	{size: 4096},
+	{size: 8192},
+	{size: defaultMaxRawSize + rawHeaderSize},
+}
+
+// Returns a buffer of length size, from the smallest pool with large enough
+// buffers, or a new one if size is larger than any of them. Give it back with
+// putBuffer once nothing refers to it anymore.
+func getBuffer(size int) []byte {
+	for i := range bufPools {
+		p := &bufPools[i]
+		if size > p.size {
+			continue
+		}
+		if b, ok := p.pool.Get().(*[]byte); ok {
+			return (*b)[:size]
+		}
+		return make([]byte, size, p.size)
+	}
+	return make([]byte, size)
+}
+
+// Returns b, from getBuffer, to its pool.
+func putBuffer(b []byte) {
+	for i := range bufPools {
+		p := &bufPools[i]
+		if cap(b) == p.size {
+			b = b[:0]
+			p.pool.Put(&b)
+			return
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package sam3
+
+import (
+	"testing"
+)
+
+func Test_BufferPool(t *testing.T) {
+	for _, size := range []int{1, 256, 257, 4096, 8000, 8192, 10000, defaultMaxRawSize + rawHeaderSize, 40000} {
+		b := getBuffer(size)
+		if len(b) != size {
+			t.Fatalf("asked for %d bytes, got %d", size, len(b))
+		}
+		putBuffer(b)
+	}
+	// a raw datagram of the default size is read into a pooled buffer
+	b := getBuffer(defaultMaxRawSize + rawHeaderSize)
+	if cap(b) != bufPools[len(bufPools)-1].size {
+		t.Fatalf("raw datagram buffer of %d bytes is not pooled", cap(b))
+	}
+	putBuffer(b)
+}
+
+func BenchmarkNewKeysAllocs(b *testing.B) {
+	mock := newMockSAM(b, mockOK)
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer sam.Close()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := sam.NewKeys(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
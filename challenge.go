@@ -0,0 +1,145 @@
+package sam3
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// The wire format of a destination challenge: the verifier connects and sends
+// challengeSize random bytes; the prover answers with a two byte big-endian
+// length followed by its signature over challengeContext, the challenge and
+// the destination of the verifier. Signing the verifiers destination keeps a
+// man in the middle from relaying somebody elses challenge.
+const (
+	challengeSize    = 32
+	challengeContext = "sam3 destination challenge v1\n"
+	challengeTimeout = time.Minute
+)
+
+// Verifies that whoever answers at target controls its private keys: connects
+// with s, sends a random challenge and checks the signature that comes back
+// against the signing key in target. The other end has to run a
+// ChallengeHandler. Returns false, without an error, if the signature is
+// wrong. Only Ed25519 destinations are supported; other signature types return
+// ErrIncompatibleKeyType.
+func (s *StreamSession) VerifyDestination(ctx context.Context, target I2PAddr) (bool, error) {
+	pub, err := ed25519PublicKey(target)
+	if err != nil {
+		return false, err
+	}
+	var challenge [challengeSize]byte
+	if _, err := rand.Read(challenge[:]); err != nil {
+		return false, err
+	}
+	conn, err := s.DialContextI2P(ctx, target)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	stop := watchContext(ctx, conn.conn)
+	sig, err := exchangeChallenge(conn, challenge[:])
+	if stop() {
+		return false, ctx.Err()
+	}
+	if err != nil {
+		return false, err
+	}
+	return ed25519.Verify(pub, challengeMessage(challenge[:], s.keys.Addr()), sig), nil
+}
+
+func exchangeChallenge(conn net.Conn, challenge []byte) ([]byte, error) {
+	if _, err := conn.Write(challenge); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint16(length[:])
+	if n > 512 {
+		return nil, errors.New("Challenge signature too long")
+	}
+	sig := make([]byte, n)
+	if _, err := io.ReadFull(conn, sig); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+// Returns the message signed in answer to challenge from verifier.
+func challengeMessage(challenge []byte, verifier I2PAddr) []byte {
+	dest, _ := verifier.ToBytes()
+	msg := append([]byte(challengeContext), challenge...)
+	return append(msg, dest...)
+}
+
+// Returns a ConnHandler answering the challenges of VerifyDestination, proving
+// that this end holds keys. Connections are closed after one challenge. Only
+// Ed25519 keys are supported; with other keys, connections are closed without
+// an answer.
+func ChallengeHandler(keys I2PKeys) ConnHandler {
+	priv, err := keys.ed25519PrivateKey()
+	return ConnHandlerFunc(func(conn net.Conn) {
+		defer conn.Close()
+		if err != nil {
+			return
+		}
+		conn.SetDeadline(time.Now().Add(challengeTimeout))
+		challenge := make([]byte, challengeSize)
+		if _, err := io.ReadFull(conn, challenge); err != nil {
+			return
+		}
+		sig := ed25519.Sign(priv, challengeMessage(challenge, I2PAddr(conn.RemoteAddr().String())))
+		var length [2]byte
+		binary.BigEndian.PutUint16(length[:], uint16(len(sig)))
+		conn.Write(append(length[:], sig...))
+	})
+}
+
+// Returns the Ed25519 signing key of a destination, which is right-aligned in
+// its signing public key field.
+func ed25519PublicKey(addr I2PAddr) (ed25519.PublicKey, error) {
+	b, err := addr.ToBytes()
+	if err != nil {
+		return nil, err
+	}
+	_, sigType, _, err := parseDestination(b)
+	if err != nil {
+		return nil, err
+	}
+	if sigType != Sig_EdDSA_SHA512_Ed25519 {
+		return nil, ErrIncompatibleKeyType
+	}
+	return ed25519.PublicKey(b[destCertOffset-ed25519.PublicKeySize : destCertOffset]), nil
+}
+
+// Returns the Ed25519 signing private key of keys. It follows the encryption
+// private key.
+func (k I2PKeys) ed25519PrivateKey() (ed25519.PrivateKey, error) {
+	b, err := i2pB64enc.DecodeString(k.both)
+	if err != nil {
+		return nil, errors.New("Keys are not base64-encoded")
+	}
+	n, sigType, cryptoType, err := parseDestination(b)
+	if err != nil {
+		return nil, err
+	}
+	if sigType != Sig_EdDSA_SHA512_Ed25519 {
+		return nil, ErrIncompatibleKeyType
+	}
+	cryptoLen, ok := cryptoPrivKeyLen[cryptoType]
+	if !ok {
+		return nil, ErrIncompatibleKeyType
+	}
+	n += cryptoLen
+	if len(b) < n+ed25519.SeedSize {
+		return nil, errors.New("Private keys too short")
+	}
+	return ed25519.NewKeyFromSeed(b[n : n+ed25519.SeedSize]), nil
+}
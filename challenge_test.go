@@ -0,0 +1,43 @@
+package sam3
+
+import (
+	"crypto/ed25519"
+	"net"
+	"testing"
+)
+
+// A net.Conn that appears to come from a given destination.
+type fromConn struct {
+	net.Conn
+	from I2PAddr
+}
+
+func (c fromConn) RemoteAddr() net.Addr {
+	return c.from
+}
+
+func Test_ChallengeHandler(t *testing.T) {
+	prover, _ := GenerateKeysFromSeed([]byte("prover"), Sig_EdDSA_SHA512_Ed25519)
+	verifier, _ := GenerateKeysFromSeed([]byte("verifier"), Sig_EdDSA_SHA512_Ed25519)
+	pub, err := ed25519PublicKey(prover.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := net.Pipe()
+	go ChallengeHandler(prover).ServeConn(fromConn{b, verifier.Addr()})
+	challenge := make([]byte, challengeSize)
+	challenge[0] = 1
+	sig, err := exchangeChallenge(a, challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ed25519.Verify(pub, challengeMessage(challenge, verifier.Addr()), sig) {
+		t.Fatal("signature does not verify")
+	}
+	if ed25519.Verify(pub, challengeMessage(challenge, prover.Addr()), sig) {
+		t.Fatal("signature verifies for another verifier")
+	}
+	if _, err := ed25519PublicKey(testDest); err != ErrIncompatibleKeyType {
+		t.Fatalf("expected ErrIncompatibleKeyType for a DSA destination, got %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package sam3
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+)
+
+// A stream session for making outbound I2P connections, with the SAM bridge
+// and the session behind it set up by NewClient. The lower-level APIs remain
+// available through Session.
+type Client struct {
+	session *StreamSession
+}
+
+// Makes NewClient create its session with keys, so it has a known destination,
+// instead of with a new transient one. Only NewClient uses it, the other
+// constructors take their keys as an argument.
+func WithKeys(keys I2PKeys) Option {
+	return func(so *sessionOptions) error {
+		so.keys = &keys
+		return nil
+	}
+}
+
+// Makes NewClient and NewServer name their session id, instead of a random
+// name. Only they use it, the other constructors take the id as an argument.
+func WithID(id string) Option {
+	return func(so *sessionOptions) error {
+		so.id = id
+		return nil
+	}
+}
+
+// Connects to the SAM bridge at samAddr and creates a stream session on it,
+// ready to dial. The session has a transient destination, unless WithKeys is
+// given, and the tunnels of Options_Small, which opts can change.
+func NewClient(samAddr string, opts ...Option) (*Client, error) {
+	so, err := applyOptions(nil, opts)
+	if err != nil {
+		return nil, err
+	}
+	keys := TransientKeys()
+	if so.keys != nil {
+		keys = *so.keys
+	}
+	id, err := sessionID(so, "sam3client")
+	if err != nil {
+		return nil, err
+	}
+	sam, err := NewSAM(samAddr)
+	if err != nil {
+		return nil, err
+	}
+	// the session has a connection of its own
+	defer sam.Close()
+	session, err := sam.NewStreamSession(id, keys, Options_Small, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{session: session}, nil
+}
+
+// Returns the id set with WithID, or else a random one starting with prefix.
+func sessionID(so *sessionOptions, prefix string) (string, error) {
+	if so.id != "" {
+		return so.id, nil
+	}
+	return randomID(prefix)
+}
+
+// Returns prefix followed by random characters, an id unlikely to be taken on
+// the bridge.
+func randomID(prefix string) (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(b), nil
+}
+
+// Connects to addr, which is a .i2p or .b32.i2p name, or a base64 destination,
+// optionally followed by a port, which is ignored. The network is ignored too.
+func (c *Client) Dial(network, addr string) (net.Conn, error) {
+	return c.DialContext(context.Background(), network, addr)
+}
+
+// Like Dial, but gives up once ctx is done.
+func (c *Client) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return c.session.dialHost(ctx, network, addr)
+}
+
+// Resolves name, a .i2p or .b32.i2p name, to a destination. A base64
+// destination is returned as it is.
+func (c *Client) Resolve(name string) (I2PAddr, error) {
+	if dest, err := NewI2PAddrFromString(name); err == nil {
+		return dest, nil
+	}
+	return c.session.Lookup(name)
+}
+
+// Returns the destination connections are made from.
+func (c *Client) Addr() I2PAddr {
+	return c.session.Addr()
+}
+
+// Returns the session of the client, for what Client does not wrap.
+func (c *Client) Session() *StreamSession {
+	return c.session
+}
+
+// Closes the session, and with it the connection to the bridge.
+func (c *Client) Close() error {
+	return c.session.Close()
+}
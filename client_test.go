@@ -0,0 +1,39 @@
+package sam3
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_Client(t *testing.T) {
+	mock := newMockSAM(t, func(cmd string) string {
+		if reply := mockLookup(cmd); reply != "" {
+			return reply
+		}
+		return mockOK(cmd)
+	})
+	defer mock.Close()
+	c, err := NewClient(mock.Addr(), WithTunnelLength(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.Addr() != testDest {
+		t.Fatalf("expected the transient destination, got %q", c.Addr())
+	}
+	if dest, err := c.Resolve("known.i2p"); err != nil || dest != testDest {
+		t.Fatalf("expected known.i2p to resolve, got %q, %v", dest, err)
+	}
+	conn, err := c.Dial("tcp", "known.i2p:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	cmds := mock.Commands()
+	if !strings.HasPrefix(cmds[2], "SESSION CREATE STYLE=STREAM ID=sam3client") || !strings.Contains(cmds[2], "DESTINATION=TRANSIENT") || !strings.Contains(cmds[2], "OPTION=inbound.length=1") {
+		t.Fatalf("unexpected SESSION CREATE %q", cmds[2])
+	}
+	if last := cmds[len(cmds)-1]; !strings.HasPrefix(last, "STREAM CONNECT") || mockField(last, "DESTINATION") != string(testDest) {
+		t.Fatalf("unexpected STREAM CONNECT %q", last)
+	}
+}
@@ -0,0 +1,122 @@
+package sam3
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+)
+
+// How long the Close() of a session waits for the bridge to acknowledge the
+// teardown.
+const closeTimeout = time.Second
+
+// Closes conn, a connection to a SAM bridge, gracefully: everything written is
+// flushed, then the writing side is shut down, telling the bridge we are done,
+// and then we wait (until ctx is done) for the bridge to close its side, which
+// it does after it has torn down whatever was tied to the connection. An
+// abrupt close, in contrast, leaves the bridge to notice the lost connection
+// on its own, which may leave tunnels lingering for a while. If quit is set,
+// it is sent first, see SAM.CloseContext.
+func closeGracefully(ctx context.Context, conn net.Conn, quit []byte) error {
+	if quit != nil {
+		conn.Write(quit)
+	}
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		if err := cw.CloseWrite(); err == nil {
+			stop := watchContext(ctx, conn)
+			io.Copy(ioutil.Discard, conn)
+			stop()
+		}
+	}
+	return conn.Close()
+}
+
+// Closes the connection to SAM gracefully, waiting until ctx is done at most
+// for the bridge to hang up. Close, in contrast, closes it at once.
+func (sam *SAM) CloseContext(ctx context.Context) error {
+	sam.markClosed()
+	var quit []byte
+	if sam.Features().Quit {
+		// QUIT (SAM 3.2); if the middleware refuses it, just close
+		quit, _ = sam.cfg.build(commandOf(CmdQuit))
+	}
+	return closeGracefully(ctx, sam.conn, quit)
+}
+
+// Closes the stream session gracefully, waiting until ctx is done at most for
+// the bridge to tear down the tunnels. See Close().
+func (s *StreamSession) CloseContext(ctx context.Context) error {
+	if !s.state.close() {
+		return nil
+	}
+	defer s.state.closed()
+	if s.master != nil {
+		return s.master.RemoveSubsession(s.id)
+	}
+	return closeGracefully(ctx, s.controlConn(), nil)
+}
+
+// Closes the DatagramSession gracefully, waiting until ctx is done at most for
+// the bridge to tear down the tunnels. See Close().
+func (s *DatagramSession) CloseContext(ctx context.Context) error {
+	if !s.state.close() {
+		return nil
+	}
+	defer s.state.closed()
+	var err error
+	if s.master != nil {
+		err = s.master.RemoveSubsession(s.id)
+	} else {
+		if s.closeAck > 0 {
+			s.sendClose(ctx)
+		}
+		err = closeGracefully(ctx, s.conn, nil)
+	}
+	err2 := s.udpconn.Close()
+	if err != nil {
+		return err
+	}
+	return err2
+}
+
+// Sends DATAGRAM CLOSE and waits for the reply, until s.closeAck has passed or
+// ctx is done. Whatever the bridge answers, the session is closed afterwards,
+// so errors are ignored.
+func (s *DatagramSession) sendClose(ctx context.Context) {
+	deadline := time.Now().Add(s.closeAck)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	s.conn.SetDeadline(deadline)
+	defer s.conn.SetDeadline(time.Time{})
+	cmd, err := s.cfg.build(commandOf(CmdDatagramClose).Set("ID", s.id))
+	if err != nil {
+		return
+	}
+	if _, err := s.conn.Write(cmd); err != nil {
+		return
+	}
+	readLine(s.conn)
+}
+
+// Closes the RawSession gracefully, waiting until ctx is done at most for the
+// bridge to tear down the tunnels. See Close().
+func (s *RawSession) CloseContext(ctx context.Context) error {
+	if !s.state.close() {
+		return nil
+	}
+	defer s.state.closed()
+	var err error
+	if s.master != nil {
+		err = s.master.RemoveSubsession(s.id)
+	} else {
+		err = closeGracefully(ctx, s.conn, nil)
+	}
+	err2 := s.udpconn.Close()
+	if err != nil {
+		return err
+	}
+	return err2
+}
@@ -0,0 +1,25 @@
+// Command debugproxy sits between an application and a SAM bridge and logs all
+// protocol traffic to stderr:
+//
+//	debugproxy :7657 127.0.0.1:7656
+//
+// and then configure the application to use :7657 as its SAM bridge.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dajohi/sam3/debugproxy"
+)
+
+func main() {
+	if len(os.Args) != 3 {
+		fmt.Fprintln(os.Stderr, "usage: debugproxy listen-address sam-address")
+		os.Exit(2)
+	}
+	if err := debugproxy.DebugProxy(os.Args[1], os.Args[2]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,185 @@
+// Command sam3 talks to the SAM bridge of an I2P router from the command line.
+//
+//	sam3 [flags] keygen         print new keys, and save them to -key-file if given
+//	sam3 [flags] lookup <name>  print the destination of name
+//	sam3 [flags] ping <dest>    send "PING\n" over a stream and wait for "PONG\n"
+//	sam3 [flags] info           print the SAM version and what it supports
+//
+// Flags:
+//
+//	-sam-addr   address of the SAM bridge (default: $SAM_ADDRESS, $I2P_SAM_HOST or 127.0.0.1:7656)
+//	-timeout    how long to wait for the bridge and the network (default 2m)
+//	-key-file   keys to save (keygen) or to ping from (default: new keys)
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dajohi/sam3"
+)
+
+var (
+	samAddr = flag.String("sam-addr", sam3.ResolveSAMAddress(), "address of the SAM bridge")
+	timeout = flag.Duration("timeout", 2*time.Minute, "how long to wait for the bridge and the network")
+	keyFile = flag.String("key-file", "", "keys to save (keygen) or to ping from")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: sam3 [flags] keygen | lookup <name> | ping <dest> | info")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	var err error
+	switch {
+	case args[0] == "keygen" && len(args) == 1:
+		err = keygen()
+	case args[0] == "lookup" && len(args) == 2:
+		err = lookup(args[1])
+	case args[0] == "ping" && len(args) == 2:
+		err = ping(ctx, args[1])
+	case args[0] == "info" && len(args) == 1:
+		err = info()
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "sam3:", err)
+		os.Exit(1)
+	}
+}
+
+func connect(maxVersion string) (*sam3.SAM, error) {
+	return sam3.NewSAMConfig(sam3.Config{
+		Address:          *samAddr,
+		MaxVersion:       maxVersion,
+		HandshakeTimeout: *timeout,
+	})
+}
+
+func keygen() error {
+	sam, err := connect("")
+	if err != nil {
+		return err
+	}
+	defer sam.Close()
+	keys, err := sam.NewKeys()
+	if err != nil {
+		return err
+	}
+	if *keyFile != "" {
+		if err := sam3.SaveKeysToFile(*keyFile, keys); err != nil {
+			return err
+		}
+	}
+	fmt.Println("address:", keys.Addr().Base32())
+	fmt.Println("destination:", keys.Addr().Base64())
+	fmt.Println("keys:", keys.String())
+	return nil
+}
+
+func lookup(name string) error {
+	sam, err := connect("")
+	if err != nil {
+		return err
+	}
+	defer sam.Close()
+	addr, err := sam.Lookup(name)
+	if err != nil {
+		return err
+	}
+	fmt.Println(addr.Base64())
+	return nil
+}
+
+func ping(ctx context.Context, dest string) error {
+	sam, err := connect("")
+	if err != nil {
+		return err
+	}
+	defer sam.Close()
+	var keys sam3.I2PKeys
+	if *keyFile != "" {
+		keys, err = sam3.LoadKeysFromFile(*keyFile)
+	} else {
+		keys, err = sam.NewKeys()
+	}
+	if err != nil {
+		return err
+	}
+	ss, err := sam.NewStreamSession(fmt.Sprintf("sam3ping%d", os.Getpid()), keys, sam3.Options_Small)
+	if err != nil {
+		return err
+	}
+	defer ss.Close()
+	addr, err := sam3.NewI2PAddrFromString(dest)
+	if err != nil {
+		if addr, err = ss.Lookup(dest); err != nil {
+			return err
+		}
+	}
+	start := time.Now()
+	conn, err := ss.DialContextI2P(ctx, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write([]byte("PING\n")); err != nil {
+		return err
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if reply != "PONG\n" {
+		return errors.New("unexpected reply " + strings.TrimSpace(reply))
+	}
+	fmt.Printf("PONG from %s in %v\n", addr.Base32(), time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// What each SAM version added, for info.
+var capabilities = []struct {
+	version string
+	what    string
+}{
+	{"3.0", "STREAM, DATAGRAM and RAW sessions, NAMING LOOKUP, DEST GENERATE"},
+	{"3.1", "SIGNATURE_TYPE"},
+	{"3.2", "ports, authentication, QUIT, PING, HELLO with a version range"},
+	{"3.3", "MASTER sessions with subsessions"},
+}
+
+func info() error {
+	sam, err := connect("3.3")
+	if err != nil {
+		return err
+	}
+	defer sam.Close()
+	fmt.Println("bridge:", *samAddr)
+	fmt.Println("version:", sam.Version())
+	for _, c := range capabilities {
+		// versions are 3.x, so comparing the strings compares them
+		if c.version <= sam.Version() {
+			fmt.Printf("%s: %s\n", c.version, c.what)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,398 @@
+package sam3
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// A command to the SAM bridge, such as
+//
+//	NAMING LOOKUP NAME=example.i2p
+//
+// with the Topic "NAMING", the Type "LOOKUP" and the field NAME. Commands are
+// built and checked by the middleware of Config.Middleware before they are
+// sent.
+type Command struct {
+	Topic  string
+	Type   string
+	fields []commandField // in the order they are sent
+}
+
+type commandField struct {
+	key, value string
+	bare       bool // a key without a value, such as SILENT in some replies
+}
+
+// Creates a command without any fields.
+func NewCommand(topic, typ string) *Command {
+	return &Command{Topic: topic, Type: typ}
+}
+
+// Creates a command of kind, one of the Cmd constants, such as
+// CmdNamingLookup, without any fields.
+func commandOf(kind string) *Command {
+	topic, typ := kind, ""
+	if i := strings.IndexByte(kind, ' '); i >= 0 {
+		topic, typ = kind[:i], kind[i+1:]
+	}
+	return NewCommand(topic, typ)
+}
+
+// Parses a single command line, with or without the trailing newline. Words
+// are separated by spaces or tabs, and quotes around values are removed.
+func ParseCommand(line string) (*Command, error) {
+	raw := line
+	line = strings.TrimSuffix(line, "\n")
+	if strings.Contains(line, "\n") {
+		return nil, newParseError("", []byte(raw))
+	}
+	c := &Command{}
+	for i := 0; line != ""; i++ {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" {
+			break
+		}
+		if i < 2 {
+			end := strings.IndexAny(line, " \t")
+			if end < 0 {
+				end = len(line)
+			}
+			if i == 0 {
+				c.Topic = line[:end]
+			} else {
+				c.Type = line[:end]
+			}
+			line = line[end:]
+			continue
+		}
+		eq := strings.IndexAny(line, "= \t")
+		if eq < 0 || line[eq] != '=' {
+			// a key without a value
+			if eq < 0 {
+				eq = len(line)
+			}
+			c.fields = append(c.fields, commandField{key: line[:eq], bare: true})
+			line = line[eq:]
+			continue
+		}
+		key := line[:eq]
+		line = line[eq+1:]
+		if strings.HasPrefix(line, "\"") {
+			end := strings.IndexByte(line[1:], '"')
+			if end < 0 {
+				return nil, newParseError("", []byte(raw))
+			}
+			c.fields = append(c.fields, commandField{key: key, value: line[1 : end+1]})
+			line = line[end+2:]
+			continue
+		}
+		end := strings.IndexAny(line, " \t")
+		if end < 0 {
+			end = len(line)
+		}
+		c.fields = append(c.fields, commandField{key: key, value: line[:end]})
+		line = line[end:]
+	}
+	if c.Topic == "" || c.Type == "" {
+		return nil, newParseError("", []byte(raw))
+	}
+	return c, nil
+}
+
+// Returns the value of the field key, the last one if there are several (as
+// there are of OPTION), and whether there is one at all.
+func (c *Command) Get(key string) (string, bool) {
+	for i := len(c.fields) - 1; i >= 0; i-- {
+		if c.fields[i].key == key {
+			return c.fields[i].value, true
+		}
+	}
+	return "", false
+}
+
+// Returns the values of every field key, in order.
+func (c *Command) Values(key string) []string {
+	var values []string
+	for _, f := range c.fields {
+		if f.key == key {
+			values = append(values, f.value)
+		}
+	}
+	return values
+}
+
+// Sets the field key to value, replacing any fields key there were. Returns c,
+// so calls can be chained.
+func (c *Command) Set(key, value string) *Command {
+	c.Del(key)
+	return c.Add(key, value)
+}
+
+// Adds a field, after any there are with the same key. Returns c.
+func (c *Command) Add(key, value string) *Command {
+	c.fields = append(c.fields, commandField{key: key, value: value})
+	return c
+}
+
+// Adds an OPTION field for every one of options, and a field for every one of
+// extras, such as "SIGNATURE_TYPE=7" (see WithExtras), whose value loses any
+// quotes around it. Returns c.
+func (c *Command) addOptions(options, extras []string) *Command {
+	for _, opt := range options {
+		c.Add("OPTION", opt)
+	}
+	for _, extra := range extras {
+		key, value := extra, ""
+		if i := strings.IndexByte(extra, '='); i >= 0 {
+			key, value = extra[:i], extra[i+1:]
+		}
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		c.Add(key, value)
+	}
+	return c
+}
+
+// Removes every field key.
+func (c *Command) Del(key string) {
+	fields := c.fields[:0]
+	for _, f := range c.fields {
+		if f.key != key {
+			fields = append(fields, f)
+		}
+	}
+	c.fields = fields
+}
+
+// Returns all fields of the command as a map, for middleware to inspect. Of
+// repeated fields, such as OPTION, only the last value is in it, see Values.
+// Changing the map does not change the command.
+func (c *Command) Fields() map[string]string {
+	fields := make(map[string]string, len(c.fields))
+	for _, f := range c.fields {
+		fields[f.key] = f.value
+	}
+	return fields
+}
+
+// Returns the command line as sent to the bridge, with the trailing newline.
+// Values containing spaces are quoted. An empty Type is left out, for commands
+// of a single word, such as QUIT.
+func (c *Command) String() string {
+	s := c.Topic
+	if c.Type != "" {
+		s += " " + c.Type
+	}
+	for _, f := range c.fields {
+		s += " " + f.key
+		if f.bare {
+			continue
+		}
+		if strings.ContainsAny(f.value, " \t") {
+			s += "=\"" + f.value + "\""
+		} else {
+			s += "=" + f.value
+		}
+	}
+	return s + "\n"
+}
+
+// Checks or changes commands before they are sent to the bridge. Returning an
+// error stops the command from being sent, the error is returned instead.
+type CommandMiddleware interface {
+	Process(cmd *Command) error
+}
+
+// Makes a function into a CommandMiddleware.
+type CommandMiddlewareFunc func(cmd *Command) error
+
+// Calls f(cmd).
+func (f CommandMiddlewareFunc) Process(cmd *Command) error {
+	return f(cmd)
+}
+
+// Middleware applied in the order it was added. The zero value is an empty
+// chain, ready to use. A Chain is itself a CommandMiddleware.
+type Chain struct {
+	middleware []CommandMiddleware
+}
+
+// Creates a chain of the middleware given.
+func NewChain(m ...CommandMiddleware) *Chain {
+	return &Chain{middleware: m}
+}
+
+// Adds m to the end of the chain. Returns c, so calls can be chained. Not safe
+// to call while the chain is in use.
+func (c *Chain) Use(m CommandMiddleware) *Chain {
+	c.middleware = append(c.middleware, m)
+	return c
+}
+
+// Applies the middleware of the chain to cmd, stopping at the first error.
+func (c *Chain) Process(cmd *Command) error {
+	for _, m := range c.middleware {
+		if err := m.Process(cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Removes control characters, such as newlines, from every part of the
+// command, so that user-supplied strings, such as names to look up, cannot
+// smuggle in commands of their own.
+type SanitizeMiddleware struct{}
+
+func (SanitizeMiddleware) Process(cmd *Command) error {
+	cmd.Topic = stripControl(cmd.Topic)
+	cmd.Type = stripControl(cmd.Type)
+	for i := range cmd.fields {
+		cmd.fields[i].key = stripControl(cmd.fields[i].key)
+		cmd.fields[i].value = stripControl(cmd.fields[i].value)
+	}
+	return nil
+}
+
+func stripControl(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// The longest command LengthLimitMiddleware lets through by default, the
+// longest line the bridges are sure to read.
+const maxCommandLen = 65536
+
+// Returned by LengthLimitMiddleware for commands that are too long.
+var ErrCommandTooLong = errors.New("Command is too long")
+
+// Refuses commands longer than Max bytes, newline included, with
+// ErrCommandTooLong. A Max of zero means 65536.
+type LengthLimitMiddleware struct {
+	Max int
+}
+
+func (m LengthLimitMiddleware) Process(cmd *Command) error {
+	max := m.Max
+	if max == 0 {
+		max = maxCommandLen
+	}
+	if len(cmd.String()) > max {
+		return ErrCommandTooLong
+	}
+	return nil
+}
+
+// The fields the SAM specification requires, by "TOPIC TYPE".
+var requiredFields = map[string][]string{
+	CmdHelloVersion:  {},
+	CmdSessionCreate: {"STYLE", "ID", "DESTINATION"},
+	CmdSessionAdd:    {"STYLE", "ID"},
+	CmdSessionRemove: {"ID"},
+	CmdStreamConnect: {"ID", "DESTINATION"},
+	CmdStreamAccept:  {"ID"},
+	CmdStreamForward: {"ID", "PORT"},
+	CmdNamingLookup:  {"NAME"},
+	CmdDatagramSend:  {"ID", "DESTINATION", "SIZE"},
+	CmdRawSend:       {"ID", "DESTINATION", "SIZE"},
+	CmdDestGenerate:  {},
+	CmdAuthAdd:       {"USER", "PASSWORD"},
+	CmdAuthRemove:    {"USER"},
+}
+
+// Refuses commands that lack a field they require, such as SESSION CREATE
+// without an ID. Required maps "TOPIC TYPE", such as "NAMING LOOKUP", to the
+// fields required; if nil, the requirements of the SAM specification are
+// used. Commands not in it pass unchecked.
+type ValidationMiddleware struct {
+	Required map[string][]string
+}
+
+func (m ValidationMiddleware) Process(cmd *Command) error {
+	required := m.Required
+	if required == nil {
+		required = requiredFields
+	}
+	for _, key := range required[cmd.Topic+" "+cmd.Type] {
+		if _, ok := cmd.Get(key); !ok {
+			return errors.New("Command " + cmd.Topic + " " + cmd.Type + " is missing " + key)
+		}
+	}
+	return nil
+}
+
+// Makes every command sent to the bridge, including those of sessions, pass
+// through the middleware given, see Config.Middleware.
+func WithMiddleware(m ...CommandMiddleware) SAMOption {
+	return func(cfg *Config) {
+		cfg.Middleware = NewChain(m...)
+	}
+}
+
+// Applies the middleware of cfg to cmd, one or more newline-terminated
+// command lines, and returns what to send. Without middleware, cmd is sent as
+// it is. Only for commands without user-supplied values, which could contain
+// newlines of their own; those are built with build.
+func (cfg Config) command(cmd string) ([]byte, error) {
+	if cfg.Middleware == nil {
+		return []byte(cmd), nil
+	}
+	var out []byte
+	for _, line := range strings.SplitAfter(cmd, "\n") {
+		if line == "" {
+			continue
+		}
+		c, err := ParseCommand(line)
+		if err != nil {
+			return nil, err
+		}
+		b, err := cfg.build(c)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b...)
+	}
+	return out, nil
+}
+
+// Applies the middleware of cfg to c and returns what to send. Commands with
+// user-supplied values are built this way, rather than as strings, so that a
+// newline in a value reaches the middleware as part of the value. A newline
+// left in the command after that is refused, rather than sent as a command of
+// its own, as are quotes in values, see check.
+func (cfg Config) build(c *Command) ([]byte, error) {
+	if cfg.Middleware != nil {
+		if err := cfg.Middleware.Process(c); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+	line := c.String()
+	if strings.ContainsAny(line[:len(line)-1], "\r\n") {
+		return nil, errors.New("Command may not contain newlines")
+	}
+	return []byte(line), nil
+}
+
+// Refuses fields that would not read back as they are: values with a quote,
+// which could end the quoting of String early and add fields of their own, and
+// keys that are empty or have a quote, an "=" or whitespace.
+func (c *Command) check() error {
+	for _, f := range c.fields {
+		if f.key == "" || strings.ContainsAny(f.key, "\"= \t") {
+			return errors.New("Invalid command key " + strconv.Quote(f.key))
+		}
+		if strings.ContainsRune(f.value, '"') {
+			return errors.New("Command value of " + f.key + " may not contain quotes")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func Test_CommandRoundTrip(t *testing.T) {
+	line := "SESSION CREATE STYLE=STREAM ID=tun DESTINATION=TRANSIENT OPTION=inbound.length=0 OPTION=outbound.length=0 MESSAGE=\"two words\"\n"
+	c, err := ParseCommand(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Topic != "SESSION" || c.Type != "CREATE" || c.Fields()["ID"] != "tun" {
+		t.Fatalf("unexpected command %+v", c)
+	}
+	if opts := c.Values("OPTION"); len(opts) != 2 || opts[1] != "outbound.length=0" {
+		t.Fatalf("unexpected options %q", opts)
+	}
+	if c.String() != line {
+		t.Fatalf("expected %q, got %q", line, c.String())
+	}
+	c.Set("ID", "other")
+	if v, _ := c.Get("ID"); v != "other" {
+		t.Fatalf("expected ID=other, got %q", v)
+	}
+}
+
+func Test_CommandMiddleware(t *testing.T) {
+	chain := NewChain(SanitizeMiddleware{}, ValidationMiddleware{}).Use(LengthLimitMiddleware{Max: 64})
+	c := NewCommand("NAMING", "LOOKUP").Set("NAME", "evil.i2p\nSESSION CREATE STYLE=STREAM")
+	if err := chain.Process(c); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(c.String(), "\n") != 1 {
+		t.Fatalf("expected a single line, got %q", c.String())
+	}
+	if err := chain.Process(NewCommand("STREAM", "CONNECT").Set("ID", "tun")); err == nil {
+		t.Fatal("expected a missing DESTINATION to be refused")
+	}
+	if err := chain.Process(NewCommand("NAMING", "LOOKUP").Set("NAME", strings.Repeat("a", 64))); err != ErrCommandTooLong {
+		t.Fatalf("expected ErrCommandTooLong, got %v", err)
+	}
+}
+
+func Test_CommandMiddlewareSAM(t *testing.T) {
+	mock := newMockSAM(t, mockLookup)
+	defer mock.Close()
+	refused := errors.New("refused")
+	sam, err := NewSAM(mock.Addr(), WithMiddleware(SanitizeMiddleware{}, CommandMiddlewareFunc(func(c *Command) error {
+		if name, _ := c.Get("NAME"); strings.HasPrefix(name, "blocked") {
+			return refused
+		}
+		return nil
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	if _, err := sam.Lookup("known.i2p"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sam.Lookup("blocked.i2p"); err != refused {
+		t.Fatalf("expected the middleware's error, got %v", err)
+	}
+	cmds := mock.Commands()
+	if len(cmds) != 2 || cmds[1] != "NAMING LOOKUP NAME=known.i2p" {
+		t.Fatalf("unexpected commands %q", cmds)
+	}
+}
+
+func Test_CommandInjection(t *testing.T) {
+	mock := newMockSAM(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "NAMING LOOKUP") {
+			return mockLookup(cmd)
+		}
+		return mockOK(cmd)
+	})
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr(), WithMiddleware(SanitizeMiddleware{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	if _, _, err := sam.LookupMany(context.Background(), []string{"known.i2p\nDEST GENERATE SIGNATURE_TYPE=7"}); err != nil {
+		t.Fatal(err)
+	}
+	ss, err := sam.NewStreamSession("tun\nDEST GENERATE", NewKeys(I2PAddr("pub"), "pubpriv"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	for _, cmd := range mock.Commands() {
+		if strings.HasPrefix(cmd, "DEST GENERATE") {
+			t.Fatalf("injected command sent: %q", mock.Commands())
+		}
+	}
+
+	// without middleware to remove them, newlines are refused
+	plain, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	if _, err := plain.NewStreamSession("tun\nDEST GENERATE", NewKeys(I2PAddr("pub"), "pubpriv"), nil); err == nil {
+		t.Fatal("session id with a newline accepted")
+	}
+	if _, err := plain.NewStreamSession("tun", NewKeys(I2PAddr("pub"), "pubpriv"), []string{"inbound.length=1\nDEST GENERATE"}); err == nil {
+		t.Fatal("option with a newline accepted")
+	}
+
+	// nor can a quote end the quoting of a value early, to add fields
+	for _, s := range []*SAM{sam, plain} {
+		before := len(mock.Commands())
+		if _, err := s.Lookup("x\" DESTINATION=evil \""); err == nil {
+			t.Fatal("name with quotes accepted")
+		}
+		if _, err := s.NewStreamSession("tun\" SIGNATURE_TYPE=\"1", NewKeys(I2PAddr("pub"), "pubpriv"), nil); err == nil {
+			t.Fatal("session id with quotes accepted")
+		}
+		for _, cmd := range mock.Commands()[before:] {
+			if strings.Contains(cmd, "\"") {
+				t.Fatalf("command with quotes sent: %q", cmd)
+			}
+		}
+	}
+	if _, err := (Config{}).build(NewCommand("NAMING", "LOOKUP").Set("NAME x", "y")); err == nil {
+		t.Fatal("key with a space accepted")
+	}
+	if _, err := (Config{}).build(NewCommand("NAMING", "LOOKUP").Set("NAME=x", "y")); err == nil {
+		t.Fatal("key with an = accepted")
+	}
+}
@@ -0,0 +1,181 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+)
+
+// Anything that can log, such as a *log.Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Describes how to connect to a SAM bridge. Only Address is required, the
+// zero value of every other field picks a sensible default.
+type Config struct {
+	Address string // host:port of the SAM bridge
+	Network string // network to dial Address on, defaults to "tcp4"
+
+	// The range of SAM versions to accept, from "3.0" to "3.3" by default.
+	// The bridge picks the latest it supports, which features such as
+	// Sig_Best, WithPorts and subsessions depend on.
+	MinVersion string
+	MaxVersion string
+
+	// Credentials, for bridges that require authentication (SAM 3.2).
+	User     string
+	Password string
+
+	Dialer           *net.Dialer   // used to connect to the bridge
+	HandshakeTimeout time.Duration // time limit for the HELLO, none if zero
+	Logger           Logger        // receives diagnostic messages, if set
+
+	// Used instead of Dialer to connect to the bridge, if set. Every
+	// connection, including those of sessions, is opened with it.
+	DialFunc func(network, address string) (net.Conn, error)
+	// Opens the connections to the bridge, unless DialFunc is set. Defaults
+	// to a PlainTCPTransport with Dialer and Network.
+	Transport Transport
+
+	// Applied to every command sent to the bridge, if set, see WithMiddleware.
+	Middleware *Chain
+
+	// Commands beyond the SAM specification the bridge is known to support,
+	// such as ExtRouterInfo. The methods using others return an
+	// *ExtensionError without asking the bridge.
+	Extensions []string
+
+	conn net.Conn // used for the first connection instead of dialing, see WithConn
+}
+
+// Changes the Config of NewSAM.
+type SAMOption func(*Config)
+
+// Makes NewSAM talk to the bridge over conn, which must be fresh, instead of
+// connecting to address. Only the connection of the SAM itself is affected;
+// sessions open connections of their own, see WithDialFunc. Meant for tests,
+// with a scripted conn.
+func WithConn(conn net.Conn) SAMOption {
+	return func(cfg *Config) {
+		cfg.conn = conn
+	}
+}
+
+// Makes every connection to the bridge, including those of sessions, be opened
+// with dial, see Config.DialFunc.
+func WithDialFunc(dial func(network, address string) (net.Conn, error)) SAMOption {
+	return func(cfg *Config) {
+		cfg.DialFunc = dial
+	}
+}
+
+// Returns cfg with defaults filled in.
+func (cfg Config) withDefaults() Config {
+	if cfg.Network == "" {
+		cfg.Network = "tcp4"
+	}
+	if cfg.MinVersion == "" {
+		cfg.MinVersion = "3.0"
+	}
+	if cfg.MaxVersion == "" {
+		cfg.MaxVersion = "3.3"
+	}
+	if cfg.Dialer == nil {
+		cfg.Dialer = &net.Dialer{}
+	}
+	if cfg.Transport == nil {
+		cfg.Transport = PlainTCPTransport{Dialer: cfg.Dialer, Network: cfg.Network}
+	}
+	return cfg
+}
+
+// Creates a new controller for the I2P routers SAM bridge, connected as
+// described by cfg.
+func NewSAMConfig(cfg Config) (*SAM, error) {
+	cfg = cfg.withDefaults()
+	if strings.ContainsAny(cfg.User+cfg.Password, " \n") {
+		return nil, errors.New("User and password may not contain spaces or newlines")
+	}
+	conn, err := cfg.dial(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	cfg.conn = nil
+	if cfg.HandshakeTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(cfg.HandshakeTimeout))
+	}
+	cmd, err := cfg.build(cfg.hello())
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if _, err := conn.Write(cmd); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	reply, err := readHelloReply(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	version, err := cfg.parseHello(reply)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	sam := &SAM{address: cfg.Address, cfg: cfg, conn: conn, version: version, closed: make(chan struct{})}
+	sam.logf("sam3: connected to %s, SAM version %s", cfg.Address, version)
+	return sam, nil
+}
+
+// Returns the HELLO VERSION command for cfg, with its range of versions and
+// credentials.
+func (cfg Config) hello() *Command {
+	hello := commandOf(CmdHelloVersion).Set("MIN", cfg.MinVersion).Set("MAX", cfg.MaxVersion)
+	if cfg.User != "" {
+		hello.Set("USER", cfg.User).Set("PASSWORD", cfg.Password)
+	}
+	return hello
+}
+
+// Parses the reply to the HELLO VERSION of cfg, returning the version the
+// bridge chose. Credentials need SAM 3.2.
+func (cfg Config) parseHello(reply []byte) (string, error) {
+	version, err := parseHelloReply(reply)
+	if err == nil && cfg.User != "" {
+		err = requireVersion(version, "3.2", "user and password")
+	}
+	return version, err
+}
+
+// Opens a connection to the bridge, as described by cfg. A DialFunc can not
+// be stopped by ctx.
+func (cfg Config) dial(ctx context.Context) (net.Conn, error) {
+	if cfg.conn != nil {
+		return cfg.conn, nil
+	}
+	if cfg.DialFunc != nil {
+		return cfg.DialFunc(cfg.Network, cfg.Address)
+	}
+	return cfg.Transport.Dial(ctx, cfg.Address)
+}
+
+// Returns the configuration the SAM was created with, with defaults filled in.
+func (sam *SAM) Config() Config {
+	return sam.cfg
+}
+
+// Returns the SAM version negotiated with the bridge, such as "3.0".
+func (sam *SAM) Version() string {
+	return sam.version
+}
+
+func (sam *SAM) logf(format string, v ...interface{}) {
+	if sam.cfg.Logger != nil {
+		sam.cfg.Logger.Printf(format, v...)
+	}
+}
@@ -0,0 +1,159 @@
+package sam3
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Watches a configuration file and updates the options of the sessions of a
+// SessionManager when it changes, so tunnels can be reconfigured without
+// restarting. The file lists the options of each session under its id:
+//
+//	# comments and empty lines are ignored
+//	[myTunnel]
+//	inbound.length=2
+//	outbound.length=2
+//
+// Changes are detected by polling the modification time and size of the file.
+// Sessions that are not in the file, and ids in the file that are not managed,
+// are left alone.
+type ConfigWatcher struct {
+	manager *SessionManager
+	logger  Logger
+
+	mu      sync.Mutex
+	onError func(error)
+}
+
+// Creates a ConfigWatcher updating the sessions of manager. Reloads are logged
+// to logger, which may be nil.
+func NewConfigWatcher(manager *SessionManager, logger Logger) *ConfigWatcher {
+	return &ConfigWatcher{manager: manager, logger: logger}
+}
+
+// Sets a function that is called with every error that occurs when reloading,
+// such as a malformed file or a session that could not be recreated. Errors
+// are otherwise only logged.
+func (w *ConfigWatcher) OnReloadError(handler func(error)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.onError = handler
+}
+
+// Starts checking the file at path for changes every interval, until ctx is
+// done. The file is applied once right away. Returns an error if the file can
+// not be read.
+func (w *ConfigWatcher) Start(ctx context.Context, path string, interval time.Duration) error {
+	if interval <= 0 {
+		return errors.New("Interval needs to be positive")
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	w.reload(path)
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			nfi, err := os.Stat(path)
+			if err != nil {
+				w.fail(err)
+				continue
+			}
+			if nfi.ModTime().Equal(fi.ModTime()) && nfi.Size() == fi.Size() {
+				continue
+			}
+			fi = nfi
+			w.reload(path)
+		}
+	}()
+	return nil
+}
+
+// Reads the file and updates every managed session whose options changed.
+func (w *ConfigWatcher) reload(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		w.fail(err)
+		return
+	}
+	sessions, err := parseSessionConfig(f)
+	f.Close()
+	if err != nil {
+		w.fail(err)
+		return
+	}
+	for id, options := range sessions {
+		current, err := w.manager.Options(id)
+		if err != nil {
+			continue
+		}
+		changed, removed := CompareOptions(current, options)
+		if len(changed) == 0 && len(removed) == 0 {
+			continue
+		}
+		w.logf("sam3: INFO reloading options of %s: set %v, removed %v", id, changed, removed)
+		if err := w.manager.UpdateOptions(id, options); err != nil {
+			w.fail(errors.New("Unable to update session " + id + ": " + err.Error()))
+		}
+	}
+}
+
+func (w *ConfigWatcher) fail(err error) {
+	w.logf("sam3: reloading options failed: %v", err)
+	w.mu.Lock()
+	handler := w.onError
+	w.mu.Unlock()
+	if handler != nil {
+		handler(err)
+	}
+}
+
+func (w *ConfigWatcher) logf(format string, v ...interface{}) {
+	if w.logger != nil {
+		w.logger.Printf(format, v...)
+	}
+}
+
+// Parses the configuration file format of ConfigWatcher, returning the
+// options of each session id.
+func parseSessionConfig(r io.Reader) (map[string][]string, error) {
+	sessions := make(map[string][]string)
+	id := ""
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			id = strings.TrimSpace(line[1 : len(line)-1])
+			if !validToken(id) {
+				return nil, errors.New("Invalid session id on line " + strconv.Itoa(n))
+			}
+			if _, ok := sessions[id]; !ok {
+				sessions[id] = []string{}
+			}
+		case id == "":
+			return nil, errors.New("Option outside of a session on line " + strconv.Itoa(n))
+		default:
+			if i := strings.Index(line, "="); i <= 0 || !validToken(line) {
+				return nil, errors.New("Invalid option on line " + strconv.Itoa(n))
+			}
+			sessions[id] = append(sessions[id], line)
+		}
+	}
+	return sessions, s.Err()
+}
@@ -0,0 +1,115 @@
+package sam3
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_CompareOptions(t *testing.T) {
+	changed, removed := CompareOptions(
+		[]string{"inbound.length=1", "outbound.length=1", "inbound.quantity=2"},
+		[]string{"inbound.length=2", "outbound.length=1", "outbound.quantity=3"})
+	if !reflect.DeepEqual(changed, []string{"inbound.length=2", "outbound.quantity=3"}) {
+		t.Fatalf("unexpected changed options %v", changed)
+	}
+	if !reflect.DeepEqual(removed, []string{"inbound.quantity"}) {
+		t.Fatalf("unexpected removed options %v", removed)
+	}
+}
+
+func Test_ParseSessionConfig(t *testing.T) {
+	sessions, err := parseSessionConfig(strings.NewReader("# tunnels\n[a]\ninbound.length=1\n\n[b]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sessions, map[string][]string{"a": {"inbound.length=1"}, "b": {}}) {
+		t.Fatalf("unexpected sessions %v", sessions)
+	}
+	for _, bad := range []string{"inbound.length=1\n", "[a]\nnoequals\n", "[a b]\n"} {
+		if _, err := parseSessionConfig(strings.NewReader(bad)); err == nil {
+			t.Errorf("accepted %q", bad)
+		}
+	}
+}
+
+func Test_ConfigWatcher(t *testing.T) {
+	mock := newMockSAM(t, mockOK)
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	ss, err := sam.NewStreamSession("watched", NewKeys(I2PAddr("pub"), "pubpriv"), []string{"inbound.length=1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	manager := NewSessionManager()
+	manager.Add(ss)
+
+	dir, err := ioutil.TempDir("", "sam3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tunnels.conf")
+	if err := ioutil.WriteFile(path, []byte("[watched]\ninbound.length=1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 10)
+	w := NewConfigWatcher(manager, nil)
+	w.OnReloadError(func(err error) { errs <- err })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := w.Start(ctx, path, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("[watched]\ninbound.length=3\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// make sure the change is noticed even if the clock is coarse
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		options, _ := manager.Options("watched")
+		if reflect.DeepEqual(options, []string{"inbound.length=3"}) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("options were not reloaded, still %v", options)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	var creates int
+	for _, cmd := range mock.Commands() {
+		if strings.HasPrefix(cmd, "SESSION CREATE") {
+			creates++
+		}
+	}
+	if creates != 2 {
+		t.Fatalf("expected the session to be created twice, got %d", creates)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("garbage\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, later.Add(time.Minute), later.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-errs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a malformed file was not reported")
+	}
+}
@@ -2,69 +2,170 @@ package sam3
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"net"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// Returned by TryReadFrom when there is no datagram waiting to be read.
+var ErrNoDatagram = errors.New("No datagram available")
+
+// Returned when writing a datagram larger than MaxDatagramSize, which the
+// router would otherwise drop without telling.
+var ErrDatagramTooLarge = errors.New("Datagram too large")
+
+// The largest datagrams allowed by the SAM specification, used unless the
+// bridge says otherwise.
+const (
+	defaultMaxDatagramSize = 31744
+	defaultMaxRawSize      = 32768
+)
+
 // The DatagramSession implements net.PacketConn. It works almost like ordinary
 // UDP, except that datagrams may be at most 31kB large. These datagrams are
 // also end-to-end encrypted, signed and includes replay-protection. And they
 // are also built to be surveillance-resistant (yey!).
 type DatagramSession struct {
-	samAddr  string       // address to the sam bridge (ipv4:port)
-	id       string       // tunnel name
-	conn     net.Conn     // connection to sam bridge
-	udpconn  *net.UDPConn // used to deliver datagrams
-	keys     I2PKeys      // i2p destination keys
-	rUDPAddr *net.UDPAddr // the SAM bridge UDP-port
+	cfg      Config         // how to connect to the sam bridge
+	id       string         // tunnel name
+	conn     net.Conn       // connection to sam bridge
+	udpconn  *net.UDPConn   // used to deliver datagrams
+	keys     I2PKeys        // i2p destination keys
+	rUDPAddr *net.UDPAddr   // the SAM bridge UDP-port
+	maxSize  int            // the largest datagram that may be sent
+	master   *MasterSession // set if this is a subsession
+
+	traffic trafficCounter
+	dropped uint64 // datagrams missing from the sequence, accessed atomically
+	lastSeq uint64 // the highest SEQ= seen, if the bridge sends them, accessed atomically
+
+	state sessionState
+
+	limitMu       sync.Mutex
+	limiter       *rateLimiter // set with SetRateLimit, nil for no limit
+	writeDeadline time.Time    // ends waiting for the limiter
+
+	closeAck time.Duration // how long to wait for DATAGRAM CLOSE, see WithDatagramClose
 }
 
 // Creates a new datagram session. udpPort is the UDP port SAM is listening on,
-// and if you set it to zero, it will use SAMs standard UDP port.
-func (s *SAM) NewDatagramSession(id string, keys I2PKeys, options []string, udpPort int) (*DatagramSession, error) {
+// and if you set it to zero, it will use SAMs standard UDP port. opts are
+// applied after options.
+func (s *SAM) NewDatagramSession(id string, keys I2PKeys, options []string, udpPort int, opts ...Option) (*DatagramSession, error) {
+	so, err := applyOptions(options, opts)
+	if err != nil {
+		return nil, err
+	}
+	udpconn, rUDPAddr, fwd, err := listenUDP(s.conn, udpPort, so)
+	if err != nil {
+		return nil, err
+	}
+	conn, reply, err := s.newGenericSessionReply(s.closed, "DATAGRAM", id, keys, so.options(), so.extras(fwd...))
+	if err != nil {
+		udpconn.Close()
+		return nil, err
+	}
+	if keys, err = sessionKeys(keys, reply); err != nil {
+		conn.Close()
+		udpconn.Close()
+		return nil, err
+	}
+	maxSize := maxDatagramSize(reply, defaultMaxDatagramSize)
+	ds := &DatagramSession{cfg: s.cfg, id: id, conn: conn, udpconn: udpconn, keys: keys, rUDPAddr: rUDPAddr, maxSize: maxSize, closeAck: so.datagramClose}
+	ds.state.start()
+	return ds, nil
+}
+
+// Returns the largest datagram the bridge accepts, as advertised by the
+// MAX_DATAGRAM_SIZE field of its reply to SESSION CREATE, or def if it did
+// not say.
+func maxDatagramSize(reply SAMReply, def int) int {
+	if size, err := strconv.Atoi(reply.Pairs["MAX_DATAGRAM_SIZE"]); err == nil && size > 0 {
+		return size
+	}
+	return def
+}
+
+// Returns the largest datagram that can be sent on the session. This is what
+// the bridge advertised when the session was created, or the limit of the
+// SAM specification (31744 bytes) if it did not.
+func (s *DatagramSession) MaxDatagramSize() int {
+	return s.maxSize
+}
+
+// Opens the local UDP socket datagrams are delivered to, on the same interface
+// as the connection ctrl to the SAM bridge unless so says otherwise (see
+// WithDatagramForward), and resolves the UDP port of the bridge (its standard
+// port if udpPort is zero). Returns the socket, the address of the bridge and
+// the HOST= and PORT= parameters to announce in SESSION CREATE.
+func listenUDP(ctrl net.Conn, udpPort int, so *sessionOptions) (*net.UDPConn, *net.UDPAddr, []string, error) {
 	if udpPort > 65335 || udpPort < 0 {
-		return nil, errors.New("udpPort needs to be in the intervall 0-65335")
+		return nil, nil, nil, errors.New("udpPort needs to be in the intervall 0-65335")
 	}
 	if udpPort == 0 {
 		udpPort = 7655
 	}
-	lhost, _, err := net.SplitHostPort(s.conn.LocalAddr().String())
+	lhost, _, err := net.SplitHostPort(ctrl.LocalAddr().String())
 	if err != nil {
-		s.Close()
-		return nil, err
+		return nil, nil, nil, err
 	}
-	lUDPAddr, err := net.ResolveUDPAddr("udp4", lhost+":0")
-	if err != nil {
-		return nil, err
+	listen := lhost + ":0"
+	if so.udpListen != "" {
+		host, port, _ := net.SplitHostPort(so.udpListen)
+		if host == "" {
+			host = lhost
+		}
+		listen = net.JoinHostPort(host, port)
 	}
-	udpconn, err := net.ListenUDP("udp4", lUDPAddr)
+	lUDPAddr, err := net.ResolveUDPAddr("udp4", listen)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
-	rhost, _, err := net.SplitHostPort(s.conn.RemoteAddr().String())
+	rhost, _, err := net.SplitHostPort(ctrl.RemoteAddr().String())
 	if err != nil {
-		s.Close()
-		return nil, err
+		return nil, nil, nil, err
 	}
 	rUDPAddr, err := net.ResolveUDPAddr("udp4", rhost+":"+strconv.Itoa(udpPort))
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
-	_, lport, err := net.SplitHostPort(udpconn.LocalAddr().String())
-	conn, err := s.newGenericSession("DATAGRAM", id, keys, options, []string{"PORT=" + lport})
+	udpconn, err := net.ListenUDP("udp4", lUDPAddr)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
-	return &DatagramSession{s.address, id, conn, udpconn, keys, rUDPAddr}, nil
+	host, lport, err := net.SplitHostPort(udpconn.LocalAddr().String())
+	if err != nil {
+		udpconn.Close()
+		return nil, nil, nil, err
+	}
+	switch {
+	case so.udpForward != "":
+		fhost, fport, _ := net.SplitHostPort(so.udpForward)
+		if fport == "0" {
+			fport = lport
+		}
+		return udpconn, rUDPAddr, []string{"HOST=" + fhost, "PORT=" + fport}, nil
+	case so.udpListen != "":
+		if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+			// bound to all interfaces, announce the one facing the bridge
+			host = lhost
+		}
+		return udpconn, rUDPAddr, []string{"HOST=" + host, "PORT=" + lport}, nil
+	}
+	return udpconn, rUDPAddr, []string{"PORT=" + lport}, nil
 }
 
 // Reads one datagram sent to the destination of the DatagramSession. Returns
 // the number of bytes read, from what address it was sent, or an error.
 func (s *DatagramSession) ReadFrom(b []byte) (n int, addr I2PAddr, err error) {
 	// extra bytes to read the remote address of incomming datagram
-	buf := make([]byte, len(b)+4096)
+	buf := getBuffer(len(b) + 4096)
+	defer putBuffer(buf)
 
 	for {
 		// very basic protection: only accept incomming UDP messages from the IP of the SAM bridge
@@ -78,14 +179,15 @@ func (s *DatagramSession) ReadFrom(b []byte) (n int, addr I2PAddr, err error) {
 		}
 		break
 	}
-	i := bytes.IndexByte(buf, byte('\n'))
-	if i > 4096 || i > n {
+	i := bytes.IndexByte(buf[:n], byte('\n'))
+	if i < 0 || i > 4096 {
 		return 0, I2PAddr(""), errors.New("Could not parse incomming message remote address.")
 	}
-	raddr, err := NewI2PAddrFromString(string(buf[:i]))
+	raddr, err := s.readHeader(string(buf[:i]))
 	if err != nil {
-		return 0, I2PAddr(""), errors.New("Could not parse incomming message remote address: " + err.Error())
+		return 0, I2PAddr(""), err
 	}
+	s.traffic.addIn(n - (i + 1))
 	// shift out the incomming address to contain only the data received
 	if (n - i + 1) > len(b) {
 		copy(b, buf[i+1:i+1+len(b)])
@@ -96,24 +198,186 @@ func (s *DatagramSession) ReadFrom(b []byte) (n int, addr I2PAddr, err error) {
 	}
 }
 
-// Sends one signed datagram to the destination specified. At the time of
-// writing, maximum size is 31 kilobyte, but this may change in the future.
-// Implements net.PacketConn.
+// Parses the header line the bridge puts in front of a received datagram:
+// the destination it was sent from, optionally followed by fields such as
+// FROM_PORT=. If the bridge numbers datagrams with SEQ=, gaps in the numbering
+// are counted as dropped.
+func (s *DatagramSession) readHeader(header string) (I2PAddr, error) {
+	fields := strings.Fields(header)
+	if len(fields) == 0 {
+		return I2PAddr(""), errors.New("Could not parse incomming message remote address.")
+	}
+	raddr, err := NewI2PAddrFromString(fields[0])
+	if err != nil {
+		return I2PAddr(""), errors.New("Could not parse incomming message remote address: " + err.Error())
+	}
+	for _, f := range fields[1:] {
+		if !strings.HasPrefix(f, "SEQ=") {
+			continue
+		}
+		seq, err := strconv.ParseUint(f[len("SEQ="):], 10, 64)
+		if err != nil {
+			break
+		}
+		s.sawSeq(seq)
+	}
+	return raddr, nil
+}
+
+// Records the SEQ= of a received datagram, counting the gap to the highest
+// one before it as dropped. Datagrams may be read by several goroutines at
+// once, so lastSeq only ever moves up.
+func (s *DatagramSession) sawSeq(seq uint64) {
+	for {
+		last := atomic.LoadUint64(&s.lastSeq)
+		if seq <= last {
+			return
+		}
+		if atomic.CompareAndSwapUint64(&s.lastSeq, last, seq) {
+			if last != 0 && seq > last+1 {
+				atomic.AddUint64(&s.dropped, seq-last-1)
+			}
+			return
+		}
+	}
+}
+
+// Returns how many datagrams the bridge dropped because the receive queue of
+// the session was full, see WithRecvQueueSize. This is only known if the
+// bridge numbers the datagrams it delivers (SEQ=); otherwise it stays zero.
+func (s *DatagramSession) DroppedCount() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Changes i2cp.recvQueueSize of the live session with OPTIONS SET. Returns
+// ErrNotSupported if the bridge does not know that command, in which case the
+// size can only be set when creating the session, with WithRecvQueueSize.
+func (s *DatagramSession) SetRecvQueueSize(n int) error {
+	if n < 1 {
+		return errors.New("Receive queue size needs to be positive")
+	}
+	reply, err := s.command(commandOf(CmdOptionsSet).Set("ID", s.id).Set("i2cp.recvQueueSize", strconv.Itoa(n)))
+	if err != nil {
+		return err
+	}
+	if reply.Topic != "OPTIONS" {
+		return ErrNotSupported
+	}
+	if reply.Result() != ResultOK {
+		return errors.New("Unable to set the receive queue size: " + reply.Pairs["MESSAGE"])
+	}
+	return nil
+}
+
+// Sends cmd on the control connection of the session and reads the reply. A
+// subsession shares the control connection of its master, whose lock is held
+// so that replies to its own commands are not read here, nor the other way
+// around.
+func (s *DatagramSession) command(cmd *Command) (SAMReply, error) {
+	if s.master != nil {
+		s.master.mu.Lock()
+		defer s.master.mu.Unlock()
+	}
+	b, err := s.cfg.build(cmd)
+	if err != nil {
+		return SAMReply{}, err
+	}
+	if _, err := s.conn.Write(b); err != nil {
+		return SAMReply{}, err
+	}
+	line, err := readLine(s.conn)
+	if err != nil {
+		return SAMReply{}, err
+	}
+	return parseSAMReply(line)
+}
+
+// Like ReadFrom, but returns immediately with ErrNoDatagram if no datagram is
+// waiting to be read, instead of blocking. This is done by reading with a
+// deadline that has already passed, so any read deadline previously set on the
+// DatagramSession is cleared when TryReadFrom returns.
+func (s *DatagramSession) TryReadFrom(b []byte) (n int, addr I2PAddr, err error) {
+	if err := s.udpconn.SetReadDeadline(time.Now()); err != nil {
+		return 0, I2PAddr(""), err
+	}
+	defer s.udpconn.SetReadDeadline(time.Time{})
+	n, addr, err = s.ReadFrom(b)
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		return 0, I2PAddr(""), ErrNoDatagram
+	}
+	return n, addr, err
+}
+
+// Like ReadFrom, but gives up and returns ctx.Err() once ctx is done, so a
+// receive loop can be stopped without closing the session. A datagram that
+// arrives too late stays queued for the next read. Any read deadline
+// previously set on the DatagramSession is cleared when ReadFromContext
+// returns.
+func (s *DatagramSession) ReadFromContext(ctx context.Context, b []byte) (n int, addr I2PAddr, err error) {
+	if err := ctx.Err(); err != nil {
+		return 0, I2PAddr(""), err
+	}
+	stop := watchReadContext(ctx, s.udpconn)
+	n, addr, err = s.ReadFrom(b)
+	if stop() {
+		return 0, I2PAddr(""), ctx.Err()
+	}
+	return n, addr, err
+}
+
+// Like watchContext, but interrupts only reads on conn, by setting a read
+// deadline in the past once ctx is done, so conn stays usable. The returned
+// function clears the read deadline either way.
+func watchReadContext(ctx context.Context, conn interface{ SetReadDeadline(time.Time) error }) func() bool {
+	if ctx.Done() == nil {
+		return func() bool { return false }
+	}
+	stop, interrupted := make(chan struct{}), make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetReadDeadline(time.Unix(1, 0))
+			interrupted <- true
+		case <-stop:
+			interrupted <- false
+		}
+	}()
+	return func() bool {
+		close(stop)
+		i := <-interrupted
+		conn.SetReadDeadline(time.Time{})
+		return i
+	}
+}
+
+// Sends one signed datagram to the destination specified. Returns
+// ErrDatagramTooLarge if b is larger than MaxDatagramSize, and ErrRateLimited
+// if sending it would exceed the limit set with SetRateLimit. Implements
+// net.PacketConn.
 func (s *DatagramSession) WriteTo(b []byte, addr I2PAddr) (n int, err error) {
+	if len(b) > s.maxSize {
+		return 0, ErrDatagramTooLarge
+	}
+	if l, deadline := s.rateLimiter(); l != nil {
+		if err := l.wait(len(b), deadline, s.state.closing()); err != nil {
+			return 0, err
+		}
+	}
 	header := []byte("3.0 " + s.id + " " + addr.String() + "\n")
 	msg := append(header, b...)
 	n, err = s.udpconn.WriteToUDP(msg, s.rUDPAddr)
+	if err == nil {
+		s.traffic.addOut(len(b))
+	}
 	return n, err
 }
 
-// Closes the DatagramSession. Implements net.PacketConn
+// Closes the DatagramSession. Implements net.PacketConn. Waits a second at
+// most for the bridge to tear down the tunnels, see CloseContext.
 func (s *DatagramSession) Close() error {
-	err := s.conn.Close()
-	err2 := s.udpconn.Close()
-	if err != nil {
-		return err
-	}
-	return err2
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	return s.CloseContext(ctx)
 }
 
 // Returns the I2P destination of the DatagramSession. Implements net.PacketConn
@@ -125,6 +389,9 @@ func (s *DatagramSession) LocalAddr() I2PAddr {
 // net.PacketConn and does the same thing. Setting write deadlines for datagrams
 // is seldom done.
 func (s *DatagramSession) SetDeadline(t time.Time) error {
+	s.limitMu.Lock()
+	s.writeDeadline = t
+	s.limitMu.Unlock()
 	return s.udpconn.SetDeadline(t)
 }
 
@@ -135,5 +402,8 @@ func (s *DatagramSession) SetReadDeadline(t time.Time) error {
 
 // Sets the write deadline for the DatagramSession. Implements net.Packetconn.
 func (s *DatagramSession) SetWriteDeadline(t time.Time) error {
+	s.limitMu.Lock()
+	s.writeDeadline = t
+	s.limitMu.Unlock()
 	return s.udpconn.SetWriteDeadline(t)
 }
@@ -1,7 +1,13 @@
 package sam3
 
 import (
+	"context"
 	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -128,3 +134,216 @@ func ExampleDatagramSession() {
 	// Output:
 	//Got message: Hello myself!
 }
+
+func Test_DatagramTryReadFrom(t *testing.T) {
+	udpconn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udpconn.Close()
+	ds := &DatagramSession{udpconn: udpconn, rUDPAddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 7655}}
+	buf := make([]byte, 512)
+	if _, _, err := ds.TryReadFrom(buf); err != ErrNoDatagram {
+		t.Fatalf("expected ErrNoDatagram, got %v", err)
+	}
+}
+
+func Test_ReadFromContext(t *testing.T) {
+	udpconn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udpconn.Close()
+	ds := &DatagramSession{udpconn: udpconn, rUDPAddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 7655}}
+	buf := make([]byte, 512)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, _, err := ds.ReadFromContext(ctx, buf); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	// the socket is still usable
+	if _, _, err := ds.TryReadFrom(buf); err != ErrNoDatagram {
+		t.Fatalf("expected ErrNoDatagram, got %v", err)
+	}
+	rs := &RawSession{udpconn: udpconn, rUDPAddr: ds.rUDPAddr}
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if _, err := rs.ReadContext(ctx, buf); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func Test_DatagramMaxSize(t *testing.T) {
+	mock := newMockSAM(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "SESSION CREATE") && mockField(cmd, "ID") == "smallTun" {
+			return "SESSION STATUS RESULT=OK DESTINATION=" + mockField(cmd, "DESTINATION") + " MAX_DATAGRAM_SIZE=1024\n"
+		}
+		return mockOK(cmd)
+	})
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	keys := NewKeys(I2PAddr("pub"), "pubpriv")
+	ds, err := sam.NewDatagramSession("smallTun", keys, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close()
+	if ds.MaxDatagramSize() != 1024 {
+		t.Fatalf("expected the advertised size 1024, got %d", ds.MaxDatagramSize())
+	}
+	if _, err := ds.WriteTo(make([]byte, 1025), testDest); err != ErrDatagramTooLarge {
+		t.Fatalf("expected ErrDatagramTooLarge, got %v", err)
+	}
+	ds2, err := sam.NewDatagramSession("defaultTun", keys, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds2.Close()
+	if ds2.MaxDatagramSize() != defaultMaxDatagramSize {
+		t.Fatalf("expected the default size, got %d", ds2.MaxDatagramSize())
+	}
+}
+
+func Test_DatagramRecvQueueSize(t *testing.T) {
+	mock := newMockSAM(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "OPTIONS SET") {
+			if strings.HasSuffix(cmd, "=32") {
+				return "SESSION STATUS RESULT=I2P_ERROR MESSAGE=\"Unknown command\"\n"
+			}
+			return "OPTIONS STATUS RESULT=OK\n"
+		}
+		return mockOK(cmd)
+	})
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	ds, err := sam.NewDatagramSession("queueTun", NewKeys(I2PAddr("pub"), "pubpriv"), nil, 0, WithRecvQueueSize(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close()
+	if err := ds.SetRecvQueueSize(16); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.SetRecvQueueSize(32); err != ErrNotSupported {
+		t.Fatalf("expected ErrNotSupported, got %v", err)
+	}
+	cmds := mock.Commands()
+	if !strings.Contains(cmds[2], "OPTION=i2cp.recvQueueSize=8 ") || cmds[3] != "OPTIONS SET ID=queueTun i2cp.recvQueueSize=16" {
+		t.Fatalf("unexpected commands %q", cmds)
+	}
+}
+
+func Test_DatagramDroppedCount(t *testing.T) {
+	ds := &DatagramSession{}
+	for _, seq := range []string{"", " SEQ=1", " FROM_PORT=0 SEQ=2", " SEQ=5", " SEQ=6"} {
+		if _, err := ds.readHeader(string(testDest) + seq); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ds.DroppedCount() != 2 {
+		t.Fatalf("expected 2 dropped datagrams, got %d", ds.DroppedCount())
+	}
+
+	// run with -race: datagrams may be read by several goroutines at once
+	ds = &DatagramSession{}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for seq := i + 1; seq <= 400; seq += 4 {
+				ds.readHeader(string(testDest) + " SEQ=" + strconv.Itoa(seq))
+			}
+		}(i)
+	}
+	wg.Wait()
+	if atomic.LoadUint64(&ds.lastSeq) != 400 {
+		t.Fatalf("expected the highest SEQ to be 400, got %d", ds.lastSeq)
+	}
+}
+
+func Test_DatagramForward(t *testing.T) {
+	mock := newMockSAM(t, mockOK)
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+
+	// the same address for binding and forwarding
+	ds, err := sam.NewDatagramSession("fwdTun", NewKeys(I2PAddr("pub"), "pubpriv"), nil, 0, WithDatagramForward("0.0.0.0:0", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(ds.udpconn.LocalAddr().String())
+	ds.Close()
+	cmds := mock.Commands()
+	if !strings.HasSuffix(cmds[2], " HOST=127.0.0.1 PORT="+port) {
+		t.Fatalf("unexpected SESSION CREATE %q", cmds[2])
+	}
+
+	// a different address for the router, keeping the bound port
+	ds, err = sam.NewDatagramSession("natTun", NewKeys(I2PAddr("pub"), "pubpriv"), nil, 0, WithDatagramForward("127.0.0.1:0", "10.0.0.2:0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ = net.SplitHostPort(ds.udpconn.LocalAddr().String())
+	ds.Close()
+	cmds = mock.Commands()
+	if create := cmds[4]; !strings.HasSuffix(create, " HOST=10.0.0.2 PORT="+port) {
+		t.Fatalf("unexpected SESSION CREATE %q", create)
+	}
+
+	for _, bad := range [][2]string{{"nonsense", ""}, {"127.0.0.1:70000", ""}, {"127.0.0.1:0", ":7000"}} {
+		if _, err := sam.NewDatagramSession("badTun", NewKeys(I2PAddr("pub"), "pubpriv"), nil, 0, WithDatagramForward(bad[0], bad[1])); err == nil {
+			t.Errorf("accepted listen %q, forward %q", bad[0], bad[1])
+		}
+	}
+}
+
+func Test_DatagramClose(t *testing.T) {
+	mock := newMockSAM(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "DATAGRAM CLOSE") {
+			return "DATAGRAM STATUS RESULT=OK\n"
+		}
+		return mockOK(cmd)
+	})
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	keys := NewKeys(I2PAddr("pub"), "pubpriv")
+	ds, err := sam.NewDatagramSession("closeTun", keys, nil, 0, WithDatagramClose(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.Close(); err != nil {
+		t.Fatal(err)
+	}
+	cmds := mock.Commands()
+	if cmds[len(cmds)-1] != "DATAGRAM CLOSE ID=closeTun" {
+		t.Fatalf("expected DATAGRAM CLOSE before the connection closed, got %q", cmds)
+	}
+	// without the option, the connection is just closed
+	ds, err = sam.NewDatagramSession("plainTun", keys, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds.Close()
+	for _, cmd := range mock.Commands() {
+		if cmd == "DATAGRAM CLOSE ID=plainTun" {
+			t.Fatal("unexpected DATAGRAM CLOSE")
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// A transparent TCP proxy that logs everything sent to and from a SAM bridge,
+// for seeing what an application actually says to the bridge, and for
+// attaching protocol traces to bug reports.
+package debugproxy
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Forwards connections to the SAM bridge at Remote, byte for byte, and logs
+// the traffic of every connection in both directions to Output.
+type Proxy struct {
+	Remote string    // host:port of the real SAM bridge
+	Output io.Writer // where traffic is logged, defaults to os.Stderr
+
+	mu       sync.Mutex // serializes writes to Output
+	listener net.Listener
+	conns    int
+}
+
+// Listens on localAddr and proxies every connection to the SAM bridge at
+// remoteAddr, logging the traffic to stderr. Only returns on errors.
+func DebugProxy(localAddr, remoteAddr string) error {
+	p := &Proxy{Remote: remoteAddr}
+	return p.ListenAndServe(localAddr)
+}
+
+// Listens on the TCP address addr and serves connections until Close is
+// called.
+func (p *Proxy) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return p.Serve(l)
+}
+
+// Serves connections to l until Close is called. Always returns a non-nil
+// error.
+func (p *Proxy) Serve(l net.Listener) error {
+	p.mu.Lock()
+	p.listener = l
+	p.mu.Unlock()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		p.mu.Lock()
+		p.conns++
+		id := p.conns
+		p.mu.Unlock()
+		go p.serve(id, conn)
+	}
+}
+
+// Stops accepting connections. Connections already proxied are not affected.
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.listener == nil {
+		return nil
+	}
+	return p.listener.Close()
+}
+
+func (p *Proxy) serve(id int, client net.Conn) {
+	defer client.Close()
+	p.logf(id, "connected from %s", client.RemoteAddr())
+	bridge, err := net.Dial("tcp", p.Remote)
+	if err != nil {
+		p.logf(id, "unable to reach the bridge: %v", err)
+		return
+	}
+	defer bridge.Close()
+	done := make(chan bool, 1)
+	go func() {
+		p.copy(id, "bridge -> client", client, bridge)
+		done <- true
+	}()
+	p.copy(id, "client -> bridge", bridge, client)
+	// let the bridge finish, then make sure both ends are closed
+	if c, ok := bridge.(*net.TCPConn); ok {
+		c.CloseWrite()
+	}
+	<-done
+	p.logf(id, "closed")
+}
+
+// Copies from src to dst until either fails, logging every chunk.
+func (p *Proxy) copy(id int, direction string, dst, src net.Conn) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			p.dump(id, direction, buf[:n])
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (p *Proxy) output() io.Writer {
+	if p.Output == nil {
+		return os.Stderr
+	}
+	return p.Output
+}
+
+func (p *Proxy) dump(id int, direction string, b []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprintf(p.output(), "%s #%d %s, %d bytes\n%s", time.Now().Format(time.RFC3339Nano), id, direction, len(b), hex.Dump(b))
+}
+
+func (p *Proxy) logf(id int, format string, v ...interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprintf(p.output(), "%s #%d %s\n", time.Now().Format(time.RFC3339Nano), id, fmt.Sprintf(format, v...))
+}
@@ -0,0 +1,63 @@
+package sam3
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// Passed to the functions set with OnDisconnect when the bridge closed the
+// control connection of a session.
+var ErrDisconnected = errors.New("Disconnected from the SAM bridge")
+
+// Makes the session call f, in a goroutine of its own, when it notices that
+// the bridge has gone away, such as when the router restarts: the bridge
+// closed the control connection of the session, which tears down the session
+// and every connection opened on it. The session is then SessionFailed, see
+// StateEvents, and f gets an error matching ErrDisconnected. This is the one
+// place to rebuild sessions and advertise the address again, rather than
+// reacting to the errors of every call that fails afterwards.
+//
+// Closing or recreating the session (UpdateOptions, self-healing) is not a
+// disconnect. Subsessions share the control connection of their
+// MasterSession, on which commands are answered, so they are not watched.
+func (s *StreamSession) OnDisconnect(f func(error)) {
+	s.discMu.Lock()
+	s.onDisconnect = append(s.onDisconnect, f)
+	s.discMu.Unlock()
+	s.watchDisconnect(s.controlConn())
+}
+
+// Watches conn, the control connection of the session, for the bridge closing
+// it, if anyone is interested. Nothing is expected on it once the session is
+// created, so anything read is discarded.
+func (s *StreamSession) watchDisconnect(conn net.Conn) {
+	if s.master != nil {
+		return
+	}
+	s.discMu.Lock()
+	defer s.discMu.Unlock()
+	if len(s.onDisconnect) == 0 || s.watched == conn {
+		return
+	}
+	s.watched = conn
+	go func() {
+		buf := make([]byte, 256)
+		var err error
+		for err == nil {
+			_, err = conn.Read(buf)
+		}
+		// closing and recreating the session close the connection, too,
+		// after leaving SessionActive
+		if s.state.get() != SessionActive || s.state.fail() != nil {
+			return
+		}
+		err = fmt.Errorf("%w: %v", ErrDisconnected, err)
+		s.discMu.Lock()
+		fs := append([]func(error){}, s.onDisconnect...)
+		s.discMu.Unlock()
+		for _, f := range fs {
+			go f(err)
+		}
+	}()
+}
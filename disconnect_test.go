@@ -0,0 +1,47 @@
+package sam3
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_OnDisconnect(t *testing.T) {
+	mock := newMockSAM(t, mockOK)
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	ss, err := sam.NewStreamSession("discTun", NewKeys(I2PAddr("pub"), "pubpriv"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan error, 1)
+	ss.OnDisconnect(func(err error) { got <- err })
+
+	// recreating the session is not a disconnect
+	if err := ss.UpdateOptions([]string{"inbound.length=1"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-got:
+		t.Fatalf("unexpected disconnect %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	mock.CloseConns()
+	select {
+	case err := <-got:
+		if !errors.Is(err, ErrDisconnected) {
+			t.Fatalf("expected ErrDisconnected, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the disconnect was not noticed")
+	}
+	if ss.State() != SessionFailed {
+		t.Fatalf("expected SessionFailed, got %v", ss.State())
+	}
+	ss.Close()
+}
@@ -0,0 +1,96 @@
+package sam3
+
+import (
+	"crypto/ecdh"
+	"encoding/binary"
+	"errors"
+)
+
+// Returned when keys do not have the type an operation needs.
+var ErrIncompatibleKeyType = errors.New("Incompatible key type")
+
+// The sizes of the parts of a destination: the encryption public key field,
+// the signing public key field and the certificate header.
+const (
+	destPubKeyLen  = 256
+	destSignKeyLen = 128
+	destCertOffset = destPubKeyLen + destSignKeyLen
+)
+
+// Returns the length of the destination at the start of b, and its signature
+// and encryption types. Destinations without a key certificate are DSA and
+// ElGamal.
+func parseDestination(b []byte) (length, sigType, cryptoType int, err error) {
+	if len(b) < destCertOffset+3 {
+		return 0, 0, 0, errors.New("Destination too short")
+	}
+	certLen := int(binary.BigEndian.Uint16(b[destCertOffset+1:]))
+	length = destCertOffset + 3 + certLen
+	if len(b) < length {
+		return 0, 0, 0, errors.New("Destination too short")
+	}
+	switch b[destCertOffset] {
+	case cert_KEY:
+		if certLen < 4 {
+			return 0, 0, 0, errors.New("Malformed key certificate")
+		}
+		sigType = int(binary.BigEndian.Uint16(b[destCertOffset+3:]))
+		cryptoType = int(binary.BigEndian.Uint16(b[destCertOffset+5:]))
+	default:
+		sigType, cryptoType = Sig_DSA_SHA1, Crypto_ElGamal
+	}
+	return length, sigType, cryptoType, nil
+}
+
+// Returns the X25519 public key of an ECIES-X25519 destination, which is the
+// start of its encryption public key field.
+func x25519PublicKey(addr I2PAddr) (*ecdh.PublicKey, error) {
+	b, err := addr.ToBytes()
+	if err != nil {
+		return nil, err
+	}
+	_, _, cryptoType, err := parseDestination(b)
+	if err != nil {
+		return nil, err
+	}
+	if cryptoType != Crypto_ECIES_X25519 {
+		return nil, ErrIncompatibleKeyType
+	}
+	return ecdh.X25519().NewPublicKey(b[:32])
+}
+
+// Returns the X25519 private key of ECIES-X25519 keys, which directly follows
+// the destination in the private keys.
+func (k I2PKeys) x25519PrivateKey() (*ecdh.PrivateKey, error) {
+	b, err := i2pB64enc.DecodeString(k.both)
+	if err != nil {
+		return nil, errors.New("Keys are not base64-encoded")
+	}
+	n, _, cryptoType, err := parseDestination(b)
+	if err != nil {
+		return nil, err
+	}
+	if cryptoType != Crypto_ECIES_X25519 {
+		return nil, ErrIncompatibleKeyType
+	}
+	if len(b) < n+32 {
+		return nil, errors.New("Private keys too short")
+	}
+	return ecdh.X25519().NewPrivateKey(b[n : n+32])
+}
+
+// Performs X25519 Diffie-Hellman between the encryption key of k and the one
+// in the destination of peerAddr, and returns the raw shared secret. Both must
+// be ECIES-X25519 (Crypto_ECIES_X25519), otherwise ErrIncompatibleKeyType is
+// returned. The secret should be passed through a KDF before using it as a key.
+func (k I2PKeys) DeriveSharedSecret(peerAddr I2PAddr) ([]byte, error) {
+	priv, err := k.x25519PrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	pub, err := x25519PublicKey(peerAddr)
+	if err != nil {
+		return nil, err
+	}
+	return priv.ECDH(pub)
+}
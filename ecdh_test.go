@@ -0,0 +1,48 @@
+package sam3
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"testing"
+)
+
+// Returns random keys with an ECIES-X25519 encryption key. The signing key is
+// junk, which does not matter here.
+func testX25519Keys(t *testing.T) I2PKeys {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := make([]byte, destCertOffset)
+	copy(dest, priv.PublicKey().Bytes())
+	dest = append(dest, cert_KEY, 0, 4, 0, Sig_EdDSA_SHA512_Ed25519, 0, Crypto_ECIES_X25519)
+	keys := append(append([]byte(nil), dest...), priv.Bytes()...)
+	keys = append(keys, make([]byte, 32)...)
+	return NewKeys(I2PAddr(i2pB64enc.EncodeToString(dest)), i2pB64enc.EncodeToString(keys))
+}
+
+func Test_DeriveSharedSecret(t *testing.T) {
+	alice, bob := testX25519Keys(t), testX25519Keys(t)
+	s1, err := alice.DeriveSharedSecret(bob.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2, err := bob.DeriveSharedSecret(alice.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s1) != 32 || !bytes.Equal(s1, s2) {
+		t.Fatalf("secrets differ: %x %x", s1, s2)
+	}
+	elgamal, err := GenerateKeysFromSeed([]byte("elgamal"), Sig_EdDSA_SHA512_Ed25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alice.DeriveSharedSecret(elgamal.Addr()); err != ErrIncompatibleKeyType {
+		t.Fatalf("expected ErrIncompatibleKeyType for the peer, got %v", err)
+	}
+	if _, err := elgamal.DeriveSharedSecret(alice.Addr()); err != ErrIncompatibleKeyType {
+		t.Fatalf("expected ErrIncompatibleKeyType for the local keys, got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package sam3
+
+import (
+	"crypto/hpke"
+	"errors"
+)
+
+// Returned by I2PKeys.Decrypt when the ciphertext was not encrypted to the
+// keys, or was tampered with.
+var ErrDecryptionFailed = errors.New("Decryption failed")
+
+// Encrypts plaintext so that only the holder of the private keys of recipient
+// can read it, see Decrypt. This is standalone message encryption, for sending
+// a message over any channel without a session between the two; it is not
+// session-based streaming, nor I2P's garlic encryption, and the router can not
+// decrypt it.
+//
+// The message is sealed with HPKE (RFC 9180) in base mode, with
+// DHKEM(X25519, HKDF-SHA256), HKDF-SHA256 and ChaCha20-Poly1305, to the X25519
+// key of the destination, and bound to the destination. This adds 48 bytes.
+// Only ECIES-X25519 destinations can be encrypted to: HPKE has no ElGamal
+// mode, and ElGamal/AES needs session tags shared with the router, so ElGamal
+// destinations give ErrIncompatibleKeyType. The keys themselves are not used;
+// any keys can encrypt to any recipient.
+func (k I2PKeys) EncryptTo(recipient I2PAddr, plaintext []byte) ([]byte, error) {
+	pub, err := x25519PublicKey(recipient)
+	if err != nil {
+		return nil, err
+	}
+	pk, err := hpke.NewDHKEMPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	return hpke.Seal(pk, hpke.HKDFSHA256(), hpke.ChaCha20Poly1305(), messageInfo(recipient), plaintext)
+}
+
+// Decrypts a ciphertext made by EncryptTo for the destination of k. Returns
+// ErrDecryptionFailed if it was encrypted to another destination, or was
+// tampered with, and ErrIncompatibleKeyType if k is not ECIES-X25519.
+func (k I2PKeys) Decrypt(ciphertext []byte) ([]byte, error) {
+	priv, err := k.x25519PrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	sk, err := hpke.NewDHKEMPrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := hpke.Open(sk, hpke.HKDFSHA256(), hpke.ChaCha20Poly1305(), messageInfo(k.Addr()), ciphertext)
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	return plaintext, nil
+}
+
+// Returns the HPKE info of messages to recipient.
+func messageInfo(recipient I2PAddr) []byte {
+	return []byte("sam3 EncryptTo " + string(recipient))
+}
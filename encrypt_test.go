@@ -0,0 +1,43 @@
+package sam3
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_EncryptTo(t *testing.T) {
+	alice, bob := testX25519Keys(t), testX25519Keys(t)
+	msg := []byte("meet me at the eepsite")
+	ciphertext, err := alice.EncryptTo(bob.Addr(), msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ciphertext) != len(msg)+48 {
+		t.Fatalf("expected %d bytes, got %d", len(msg)+48, len(ciphertext))
+	}
+	plaintext, err := bob.Decrypt(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plaintext, msg) {
+		t.Fatalf("expected %q, got %q", msg, plaintext)
+	}
+	if _, err := alice.Decrypt(ciphertext); err != ErrDecryptionFailed {
+		t.Fatalf("decrypted a message to someone else: %v", err)
+	}
+	ciphertext[len(ciphertext)-1] ^= 1
+	if _, err := bob.Decrypt(ciphertext); err != ErrDecryptionFailed {
+		t.Fatalf("decrypted a tampered message: %v", err)
+	}
+
+	elgamal, err := GenerateKeysFromSeed([]byte("elgamal"), Sig_EdDSA_SHA512_Ed25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alice.EncryptTo(elgamal.Addr(), msg); err != ErrIncompatibleKeyType {
+		t.Fatalf("expected ErrIncompatibleKeyType for an ElGamal recipient, got %v", err)
+	}
+	if _, err := elgamal.Decrypt(ciphertext); err != ErrIncompatibleKeyType {
+		t.Fatalf("expected ErrIncompatibleKeyType for ElGamal keys, got %v", err)
+	}
+}
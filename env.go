@@ -0,0 +1,75 @@
+package sam3
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A set of I2CP options as "key=value", such as "inbound.length=2", in the form
+// sessions take them, like Options_Medium. An Options can be passed wherever
+// a []string of options is taken, or with WithOptions(options...).
+type Options []string
+
+// Environment variables read by OptionsFromEnv, without their prefix, and the
+// options they set. The TUNNEL_ variables set both directions, and are
+// overridden by the INBOUND_ and OUTBOUND_ ones.
+var envOptions = []struct {
+	name     string
+	options  []string
+	min, max int // the valid range, unless the value is a name
+}{
+	{"TUNNEL_LENGTH", []string{"inbound.length", "outbound.length"}, 0, 7},
+	{"TUNNEL_QUANTITY", []string{"inbound.quantity", "outbound.quantity"}, 1, 16},
+	{"TUNNEL_BACKUP_QUANTITY", []string{"inbound.backupQuantity", "outbound.backupQuantity"}, 0, 16},
+	{"INBOUND_LENGTH", []string{"inbound.length"}, 0, 7},
+	{"OUTBOUND_LENGTH", []string{"outbound.length"}, 0, 7},
+	{"INBOUND_QUANTITY", []string{"inbound.quantity"}, 1, 16},
+	{"OUTBOUND_QUANTITY", []string{"outbound.quantity"}, 1, 16},
+	{"INBOUND_BACKUP_QUANTITY", []string{"inbound.backupQuantity"}, 0, 16},
+	{"OUTBOUND_BACKUP_QUANTITY", []string{"outbound.backupQuantity"}, 0, 16},
+	{"NICKNAME", []string{"inbound.nickname", "outbound.nickname"}, 0, 0},
+}
+
+// Reads tunnel options from environment variables, so operators can tune
+// sessions without changing code. With the prefix "MYAPP", these are read:
+//
+//	MYAPP_TUNNEL_LENGTH, MYAPP_INBOUND_LENGTH, MYAPP_OUTBOUND_LENGTH (0-7)
+//	MYAPP_TUNNEL_QUANTITY, MYAPP_INBOUND_QUANTITY, MYAPP_OUTBOUND_QUANTITY (1-16)
+//	MYAPP_TUNNEL_BACKUP_QUANTITY, MYAPP_INBOUND_BACKUP_QUANTITY,
+//	MYAPP_OUTBOUND_BACKUP_QUANTITY (0-16)
+//	MYAPP_NICKNAME
+//
+// The TUNNEL_ variables set both directions, the others override them for
+// one. Unset and empty variables are ignored. Returns the options sorted by
+// key, or an error naming the first invalid variable. To let them override
+// defaults, pass them with WithOptions:
+//
+//	env, err := sam3.OptionsFromEnv("MYAPP")
+//	...
+//	sam.NewStreamSession(id, keys, sam3.Options_Medium, sam3.WithOptions(env...))
+func OptionsFromEnv(prefix string) (Options, error) {
+	if prefix != "" && !strings.HasSuffix(prefix, "_") {
+		prefix += "_"
+	}
+	set := make(map[string]string)
+	for _, env := range envOptions {
+		name := prefix + env.name
+		value := strings.TrimSpace(os.Getenv(name))
+		if value == "" {
+			continue
+		}
+		if env.min == env.max {
+			if !validToken(value) {
+				return nil, errors.New("Invalid value of " + name + ": " + value)
+			}
+		} else if n, err := strconv.Atoi(value); err != nil || n < env.min || n > env.max {
+			return nil, errors.New(name + " needs to be in the interval " + strconv.Itoa(env.min) + "-" + strconv.Itoa(env.max))
+		}
+		for _, opt := range env.options {
+			set[opt] = value
+		}
+	}
+	return Options(sortedPairs(set)), nil
+}
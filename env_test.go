@@ -0,0 +1,29 @@
+package sam3
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_OptionsFromEnv(t *testing.T) {
+	t.Setenv("MYAPP_TUNNEL_LENGTH", "2")
+	t.Setenv("MYAPP_OUTBOUND_LENGTH", "1")
+	t.Setenv("MYAPP_TUNNEL_QUANTITY", "")
+	t.Setenv("MYAPP_NICKNAME", "myapp")
+	options, err := OptionsFromEnv("MYAPP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Options{"inbound.length=2", "inbound.nickname=myapp", "outbound.length=1", "outbound.nickname=myapp"}
+	if !reflect.DeepEqual(options, want) {
+		t.Fatalf("got %v, want %v", options, want)
+	}
+	for name, value := range map[string]string{"MYAPP_INBOUND_QUANTITY": "17", "MYAPP_TUNNEL_LENGTH": "two", "MYAPP_NICKNAME": "my app"} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if _, err := OptionsFromEnv("MYAPP_"); err == nil {
+				t.Errorf("accepted %s=%q", name, value)
+			}
+		})
+	}
+}
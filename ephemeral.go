@@ -0,0 +1,33 @@
+package sam3
+
+// Guesses whether the destination is ephemeral, generated to be used for a
+// while and thrown away, rather than the lasting address of a service.
+//
+// This is a heuristic, and often wrong: I2P has no marker for ephemeral
+// destinations, and nothing stops anyone from keeping any destination
+// forever. It only looks at the signature type. DSA-SHA1 is what SAM 3.0
+// bridges give TRANSIENT destinations, and what clients that never ask for a
+// type get, while services have long been told to move to Ed25519; RedDSA
+// keys are used for blinded and short-lived keys. So it reports true for
+// DSA-SHA1 and RedDSA destinations, which includes old services that are
+// anything but ephemeral, and false for everything else, which includes most
+// throwaway destinations created by current software. Use it for statistics
+// or as a hint, never to make security decisions. Malformed addresses give
+// false.
+func (addr I2PAddr) IsLikelyEphemeral() bool {
+	b, err := addr.ToBytes()
+	if err != nil {
+		return false
+	}
+	_, sigType, _, err := parseDestination(b)
+	if err != nil {
+		return false
+	}
+	return sigType == Sig_DSA_SHA1 || sigType == Sig_RedDSA_SHA512_Ed25519
+}
+
+// Reports whether the session was created with WithEphemeral.
+func (s *StreamSession) IsEphemeral() bool {
+	so := s.sessionOpts()
+	return so != nil && so.ephemeral
+}
@@ -0,0 +1,57 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_IsLikelyEphemeral(t *testing.T) {
+	if !I2PAddr(testDest).IsLikelyEphemeral() {
+		t.Error("DSA-SHA1 destination not reported as likely ephemeral")
+	}
+	keys, _ := GenerateKeysFromSeed([]byte("service"), Sig_EdDSA_SHA512_Ed25519)
+	if keys.Addr().IsLikelyEphemeral() {
+		t.Error("Ed25519 destination reported as likely ephemeral")
+	}
+	if I2PAddr("garbage").IsLikelyEphemeral() {
+		t.Error("malformed destination reported as likely ephemeral")
+	}
+}
+
+func Test_EphemeralSessionFromFile(t *testing.T) {
+	mock := newMockSAM(t, mockOK)
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	dir, err := ioutil.TempDir("", "sam3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "throwaway.keys")
+
+	ss, err := sam.NewStreamSessionFromFile(context.Background(), path, "ephTun", nil, WithEphemeral())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	if !ss.IsEphemeral() {
+		t.Fatal("session not marked ephemeral")
+	}
+	if _, err := LoadKeysFromFile(path); !errors.Is(err, ErrKeyFileNotFound) {
+		t.Fatalf("keys of an ephemeral session were saved: %v", err)
+	}
+	if err := ss.UpdateOptions([]string{"inbound.length=1"}); err != nil {
+		t.Fatal(err)
+	}
+	if !ss.IsEphemeral() {
+		t.Fatal("session no longer ephemeral after UpdateOptions")
+	}
+}
@@ -0,0 +1,33 @@
+package sam3
+
+// Commands beyond the SAM specification. No released bridge is known to
+// support them, so they are only sent if listed in Config.Extensions: a
+// bridge may answer an unknown command by closing the connection.
+const (
+	ExtRouterInfo  = "ROUTER INFO"  // see SAM.GetRouterInfo
+	ExtRoutingInfo = "ROUTING_INFO" // see SAM.RoutingInfo
+)
+
+// Returned when a method needs an extension of the SAM protocol that is not
+// listed in Config.Extensions. It matches ErrNotSupported with errors.Is.
+type ExtensionError struct {
+	Extension string // such as ExtRouterInfo
+}
+
+func (e *ExtensionError) Error() string {
+	return "SAM extension " + e.Extension + " is not enabled"
+}
+
+func (e *ExtensionError) Is(target error) bool {
+	return target == ErrNotSupported
+}
+
+// Returns an *ExtensionError unless ext is listed in cfg.Extensions.
+func (cfg Config) requireExtension(ext string) error {
+	for _, e := range cfg.Extensions {
+		if e == ext {
+			return nil
+		}
+	}
+	return &ExtensionError{Extension: ext}
+}
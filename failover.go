@@ -0,0 +1,75 @@
+package sam3
+
+import (
+	"context"
+	"math/rand"
+	"sync/atomic"
+)
+
+// Returns the destinations a service is published under, for client-side
+// failover, see WithCandidates. A nil or empty list means the name has no
+// candidates, and is looked up as usual.
+type CandidateFunc func(ctx context.Context, name string) ([]I2PAddr, error)
+
+// Returns a CandidateFunc that looks names up in a fixed table, such as one
+// read from a configuration file.
+func StaticCandidates(candidates map[string][]I2PAddr) CandidateFunc {
+	return func(ctx context.Context, name string) ([]I2PAddr, error) {
+		return candidates[name], nil
+	}
+}
+
+// In what order a UniversalDialer tries the candidates of a name.
+type CandidateOrder int
+
+const (
+	// Try the candidates in the order they were given, so the first one is
+	// used whenever it is up. This is the default.
+	CandidatesInOrder CandidateOrder = iota
+	// Start with the next candidate on every dial, spreading the load.
+	CandidatesRoundRobin
+	// Try the candidates in random order.
+	CandidatesRandom
+)
+
+// Makes the dialer ask candidates for the destinations of every I2P name it
+// dials, and try them in the given order until one connects. This is for
+// services published under several destinations for redundancy. Names
+// without candidates are looked up as usual.
+func WithCandidates(candidates CandidateFunc, order CandidateOrder) UniversalDialerOption {
+	return func(d *UniversalDialer) {
+		d.candidates = candidates
+		d.order = order
+	}
+}
+
+// Dials the candidates of name in turn, and returns the first connection
+// made, or the error of the last candidate tried if none could be reached.
+// Returns ok false if name has no candidates.
+func (d *UniversalDialer) dialCandidates(ctx context.Context, name string) (conn *SAMConn, ok bool, err error) {
+	dests, err := d.candidates(ctx, name)
+	if err != nil {
+		return nil, true, err
+	}
+	if len(dests) == 0 {
+		return nil, false, nil
+	}
+	dests = append([]I2PAddr(nil), dests...)
+	switch d.order {
+	case CandidatesRoundRobin:
+		n := int(atomic.AddUint64(&d.next, 1)-1) % len(dests)
+		dests = append(dests[n:], dests[:n]...)
+	case CandidatesRandom:
+		rand.Shuffle(len(dests), func(i, j int) { dests[i], dests[j] = dests[j], dests[i] })
+	}
+	for _, dest := range dests {
+		conn, err = d.session.DialContextI2P(ctx, dest)
+		if err == nil {
+			return conn, true, nil
+		}
+		if ctx.Err() != nil {
+			return nil, true, ctx.Err()
+		}
+	}
+	return nil, true, err
+}
@@ -0,0 +1,49 @@
+package sam3
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func Test_DialCandidates(t *testing.T) {
+	down, _ := GenerateKeysFromSeed([]byte("down"), Sig_EdDSA_SHA512_Ed25519)
+	up, _ := GenerateKeysFromSeed([]byte("up"), Sig_EdDSA_SHA512_Ed25519)
+	mock := newMockSAM(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "STREAM CONNECT") && mockField(cmd, "DESTINATION") == string(down.Addr()) {
+			return "STREAM STATUS RESULT=CANT_REACH_PEER\n"
+		}
+		return mockOK(cmd)
+	})
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	ss, err := sam.NewStreamSession("failoverTun", NewKeys(I2PAddr("pub"), "pubpriv"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+
+	candidates := StaticCandidates(map[string][]I2PAddr{"service.i2p": {down.Addr(), up.Addr()}})
+	for _, order := range []CandidateOrder{CandidatesInOrder, CandidatesRoundRobin, CandidatesRandom} {
+		d := NewUniversalDialer(ss, WithCandidates(candidates, order))
+		for i := 0; i < 3; i++ {
+			conn, err := d.DialContext(context.Background(), "tcp", "service.i2p:80")
+			if err != nil {
+				t.Fatalf("order %d: %v", order, err)
+			}
+			if remote := conn.RemoteAddr().(I2PAddr); remote != up.Addr() {
+				t.Fatalf("order %d: connected to %s", order, remote.Base32())
+			}
+			conn.Close()
+		}
+	}
+
+	d := NewUniversalDialer(ss, WithCandidates(StaticCandidates(map[string][]I2PAddr{"dead.i2p": {down.Addr()}}), CandidatesInOrder))
+	if _, err := d.DialContext(context.Background(), "tcp", "dead.i2p:80"); err != ErrCantReachPeer {
+		t.Fatalf("expected ErrCantReachPeer, got %v", err)
+	}
+}
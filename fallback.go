@@ -0,0 +1,110 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+// Decides when a FallbackDialer gives up on I2P and dials over clearnet.
+type FallbackPolicy int
+
+const (
+	// Fall back to clearnet only if the name could not be found in I2P. This
+	// is the default.
+	FallbackOnNotFound FallbackPolicy = iota
+	// Fall back to clearnet whenever dialing over I2P fails, for any reason.
+	AlwaysFallback
+	// Never fall back to clearnet.
+	NeverFallback
+)
+
+// A dialer which prefers I2P, but falls back to an ordinary TCP connection
+// when the address can not be reached over I2P, as decided by its
+// FallbackPolicy. This is meant for applications that can work without I2P,
+// for example while the router is still bootstrapping. Note that falling back
+// reveals your IP address to the other end.
+type FallbackDialer struct {
+	session *StreamSession
+	dialer  net.Dialer
+
+	mu     sync.Mutex
+	policy FallbackPolicy
+	last   string
+}
+
+// Creates a new FallbackDialer, which dials I2P addresses using session.
+func NewFallbackDialer(session *StreamSession) *FallbackDialer {
+	return &FallbackDialer{session: session}
+}
+
+// Sets when to fall back to clearnet. Defaults to FallbackOnNotFound.
+func (d *FallbackDialer) SetFallbackPolicy(p FallbackPolicy) {
+	d.mu.Lock()
+	d.policy = p
+	d.mu.Unlock()
+}
+
+// Returns the protocol used by the last successful dial, "i2p" or "tcp", or ""
+// if nothing has been dialed yet.
+func (d *FallbackDialer) LastDialProtocol() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.last
+}
+
+// Dials addr, which is either a name (such as "zzz.i2p" or "example.com:80")
+// or a base64 I2P destination. Any port is ignored when dialing over I2P.
+func (d *FallbackDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// Like Dial, but gives up when ctx is done.
+func (d *FallbackDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d.mu.Lock()
+	policy := d.policy
+	d.mu.Unlock()
+
+	conn, err := d.dialI2P(ctx, addr)
+	if err == nil {
+		d.setLast("i2p")
+		return conn, nil
+	}
+	if policy == NeverFallback || ctx.Err() != nil {
+		return nil, err
+	}
+	if policy == FallbackOnNotFound && !errors.Is(err, ErrNameNotFound) {
+		return nil, err
+	}
+	conn, err = d.dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	d.setLast("tcp")
+	return conn, nil
+}
+
+func (d *FallbackDialer) dialI2P(ctx context.Context, addr string) (net.Conn, error) {
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+	dest, err := NewI2PAddrFromString(host)
+	if err != nil {
+		if dest, err = d.session.Lookup(host); err != nil {
+			return nil, err
+		}
+	}
+	conn, err := d.session.DialContextI2P(ctx, dest)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (d *FallbackDialer) setLast(protocol string) {
+	d.mu.Lock()
+	d.last = protocol
+	d.mu.Unlock()
+}
@@ -0,0 +1,42 @@
+package sam3
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func Test_FallbackDialer(t *testing.T) {
+	mock := newMockSAM(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "NAMING LOOKUP") {
+			return "NAMING REPLY RESULT=KEY_NOT_FOUND NAME=" + strings.TrimPrefix(cmd, "NAMING LOOKUP NAME=") + "\n"
+		}
+		return ""
+	})
+	defer mock.Close()
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+
+	d := NewFallbackDialer(&StreamSession{cfg: Config{Address: mock.Addr()}})
+	d.SetFallbackPolicy(NeverFallback)
+	if _, err := d.Dial("tcp4", l.Addr().String()); err == nil {
+		t.Fatal("NeverFallback dialed over clearnet")
+	}
+	d.SetFallbackPolicy(FallbackOnNotFound)
+	conn, err := d.Dial("tcp4", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if p := d.LastDialProtocol(); p != "tcp" {
+		t.Fatalf("expected LastDialProtocol() to be tcp, got %q", p)
+	}
+}
@@ -0,0 +1,46 @@
+package sam3
+
+// What a SAM bridge supports. SAM bridges do not advertise their features, so
+// they are derived from the SAM version negotiated in HELLO, which each
+// feature is listed with.
+type Features struct {
+	// SAM 3.1: SIGNATURE_TYPE in DEST GENERATE and SESSION CREATE, so
+	// destinations can have other signature types than DSA-SHA1.
+	SignatureTypes bool
+	// SAM 3.2: FROM_PORT and TO_PORT of streams and datagrams (WithPorts),
+	// and PROTOCOL of raw datagrams.
+	Ports bool
+	// SAM 3.2: HEADER=true of RAW sessions (WithRawHeader).
+	RawHeader bool
+	// SAM 3.2: PING and PONG keep-alives.
+	Ping bool
+	// SAM 3.2: QUIT, so the bridge tears everything down on Close.
+	Quit bool
+	// SAM 3.3: MASTER sessions with subsessions (NewMasterSession), and
+	// LISTEN_PORT and LISTEN_PROTOCOL, which only subsessions take
+	// (WithListenPort, WithListenProtocol).
+	Subsessions bool
+	// SAM 3.3: DATAGRAM SEND and RAW SEND on the control connection, rather
+	// than over UDP.
+	ControlSend bool
+}
+
+// Returns the features of the SAM bridge, as far as the SAM version negotiated
+// with it tells.
+func (sam *SAM) Features() Features {
+	return featuresOf(sam.version)
+}
+
+// Returns the features that come with the SAM version v.
+func featuresOf(v string) Features {
+	v31, v32, v33 := versionAtLeast(v, "3.1"), versionAtLeast(v, "3.2"), versionAtLeast(v, "3.3")
+	return Features{
+		SignatureTypes: v31,
+		Ports:          v32,
+		RawHeader:      v32,
+		Ping:           v32,
+		Quit:           v32,
+		Subsessions:    v33,
+		ControlSend:    v33,
+	}
+}
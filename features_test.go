@@ -0,0 +1,40 @@
+package sam3
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_Features(t *testing.T) {
+	tests := []struct {
+		version string
+		want    Features
+	}{
+		{"3.0", Features{}},
+		{"3.1", Features{SignatureTypes: true}},
+		{"3.2", Features{SignatureTypes: true, Ports: true, RawHeader: true, Ping: true, Quit: true}},
+		{"3.3", Features{SignatureTypes: true, Ports: true, RawHeader: true, Ping: true, Quit: true, Subsessions: true, ControlSend: true}},
+		{"3.10", Features{SignatureTypes: true, Ports: true, RawHeader: true, Ping: true, Quit: true, Subsessions: true, ControlSend: true}},
+	}
+	for _, test := range tests {
+		if got := featuresOf(test.version); got != test.want {
+			t.Errorf("features of %s: got %+v, want %+v", test.version, got, test.want)
+		}
+	}
+
+	mock := newMockSAM(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "HELLO") {
+			return "HELLO REPLY RESULT=OK VERSION=3.2\n"
+		}
+		return ""
+	})
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	if f := sam.Features(); !f.Quit || f.Subsessions {
+		t.Fatalf("unexpected features %+v for SAM 3.2", f)
+	}
+}
@@ -0,0 +1,64 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// The size of the buffer I2PFileReceiver copies through, the largest kept in
+// the buffer pools.
+const fileReceiveBufSize = 8192
+
+// Connects to dest and sends everything read from r, at most chunkSize bytes
+// per write, so no more than one chunk is ever held in memory; the streaming
+// library's window then keeps writes from outrunning the network. Returns
+// the number of bytes sent once r is exhausted (and the stream closed), or
+// when the connection fails or ctx is done.
+func I2PFileSender(ctx context.Context, sess *StreamSession, dest I2PAddr, r io.Reader, chunkSize int) (int64, error) {
+	if chunkSize <= 0 {
+		return 0, errors.New("Chunk size must be positive")
+	}
+	conn, err := sess.DialContextI2P(ctx, dest)
+	if err != nil {
+		return 0, err
+	}
+	buf := getBuffer(chunkSize)
+	defer putBuffer(buf)
+	stop := watchContext(ctx, conn)
+	// hide any WriterTo of r, which would bypass buf and its chunk size
+	n, err := io.CopyBuffer(conn, struct{ io.Reader }{r}, buf)
+	if stop() {
+		conn.Close()
+		return n, ctx.Err()
+	}
+	if cerr := conn.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// Accepts a single connection to sess, such as one from I2PFileSender, and
+// writes everything received on it to w. Returns the number of bytes written
+// once the sender closes the stream, or when the connection fails or ctx is
+// done.
+func I2PFileReceiver(ctx context.Context, sess *StreamSession, w io.Writer) (int64, error) {
+	l, err := sess.Listen()
+	if err != nil {
+		return 0, err
+	}
+	conn, err := l.AcceptContext(ctx)
+	l.Close()
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	buf := getBuffer(fileReceiveBufSize)
+	defer putBuffer(buf)
+	stop := watchContext(ctx, conn)
+	n, err := io.CopyBuffer(struct{ io.Writer }{w}, conn, buf)
+	if stop() {
+		return n, ctx.Err()
+	}
+	return n, err
+}
@@ -0,0 +1,67 @@
+package sam3
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_I2PFileTransfer(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 10000)
+	mock := newMockSAM(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "STREAM FORWARD") {
+			// play the sending side: push a connection carrying data
+			go func(port string) {
+				conn, err := net.Dial("tcp4", "127.0.0.1:"+port)
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				conn.Write([]byte(testDest + "\n"))
+				conn.Write(data)
+			}(mockField(cmd, "PORT"))
+			return "STREAM STATUS RESULT=OK\n"
+		}
+		return mockOK(cmd)
+	})
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	ss, err := sam.NewStreamSession("fileTun", NewKeys(I2PAddr("pub"), "pubpriv"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+
+	var out bytes.Buffer
+	n, err := I2PFileReceiver(context.Background(), ss, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(data)) || !bytes.Equal(out.Bytes(), data) {
+		t.Fatalf("received %d bytes, want %d", n, len(data))
+	}
+
+	text := []byte("line one\nline two\n")
+	n, err = I2PFileSender(context.Background(), ss, I2PAddr(testDest), bytes.NewReader(text), 5)
+	if err != nil || n != int64(len(text)) {
+		t.Fatalf("sent %d bytes, %v", n, err)
+	}
+	if _, err := I2PFileSender(context.Background(), ss, I2PAddr(testDest), bytes.NewReader(text), 0); err == nil {
+		t.Fatal("accepted a zero chunk size")
+	}
+	// the mock records the lines sent after STREAM CONNECT as commands
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(strings.Join(mock.Commands(), "\n"), "line one\nline two") {
+		if time.Now().After(deadline) {
+			t.Fatalf("bridge received %q", mock.Commands())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
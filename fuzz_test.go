@@ -0,0 +1,90 @@
+package sam3
+
+import (
+	"strings"
+	"testing"
+)
+
+// Replies a bridge might send, well-formed or not, used as seed corpus for
+// all the fuzz targets.
+var fuzzReplies = []string{
+	"",
+	"\n",
+	" ",
+	"\x00\xff\xfe\x01garbage",
+	"HELLO REPLY RESULT=OK VERSION=3.0\n",
+	"HELLO REPLY RESULT=NOVERSION\n",
+	"HELLO REPLY RESULT=I2P_ERROR MESSAGE=\"oops\"\n",
+	"HELLO REPLY",
+	"DEST REPLY PUB=abc PRIV=abcdef\n",
+	"DEST REPLY PUB=",
+	"DEST REPLY PUB=" + strings.Repeat("A", 8192) + " PRIV=" + strings.Repeat("B", 8192) + "\n",
+	"NAMING REPLY RESULT=OK NAME=zzz.i2p VALUE=abc\n",
+	"NAMING REPLY RESULT=KEY_NOT_FOUND NAME=zzz.i2p\n",
+	"NAMING REPLY RESULT=INVALID_KEY NAME=zzz.i2p MESSAGE=bad\n",
+	"NAMING REPLY ",
+	"NAMING REPLY RESULT=OK NAME=zzz.i2p VALUE=" + strings.Repeat("~", 4096) + "\n",
+	"SESSION STATUS RESULT=OK DESTINATION=keys\n",
+	"SESSION STATUS RESULT=OK DESTINATION=",
+	"SESSION STATUS RESULT=DUPLICATED_ID\n",
+	"SESSION STATUS RESULT=DUPLICATED_DEST\n",
+	"SESSION STATUS RESULT=INVALID_KEY\n",
+	"SESSION STATUS RESULT=I2P_ERROR MESSAGE=\"Router busy\"\n",
+	"SESSION STATUS RESULT=I2P_ERROR MESSAGE=",
+	"STREAM STATUS RESULT=OK\n",
+	"STREAM STATUS RESULT=CANT_REACH_PEER\n",
+	"STREAM STATUS RESULT=I2P_ERROR MESSAGE=\"x\"\n",
+	"STREAM STATUS RESULT=INVALID_KEY\n",
+	"STREAM STATUS RESULT=INVALID_ID\n",
+	"STREAM STATUS RESULT=TIMEOUT\n",
+	"STREAM STATUS",
+}
+
+func addFuzzReplies(f *testing.F) {
+	for _, reply := range fuzzReplies {
+		f.Add([]byte(reply))
+	}
+}
+
+// Feeds every reply parser with the same input. None of them may panic.
+func FuzzParseSAMResponse(f *testing.F) {
+	addFuzzReplies(f)
+	f.Fuzz(func(t *testing.T, reply []byte) {
+		parseHelloReply(reply)
+		parseDestReply(reply)
+		parseLookupReply("zzz.i2p", reply)
+		parseSessionReply(reply, NewKeys(I2PAddr("keys"), "keys"))
+		parseStreamStatus(reply)
+		parseSAMReply(string(reply))
+	})
+}
+
+func FuzzLookupReply(f *testing.F) {
+	addFuzzReplies(f)
+	f.Fuzz(func(t *testing.T, reply []byte) {
+		addr, err := parseLookupReply("zzz.i2p", reply)
+		if err != nil && addr != "" {
+			t.Fatalf("returned both an address and an error: %v", err)
+		}
+	})
+}
+
+func FuzzSessionReply(f *testing.F) {
+	addFuzzReplies(f)
+	f.Fuzz(func(t *testing.T, reply []byte) {
+		err := parseSessionReply(reply, NewKeys(I2PAddr("keys"), "keys"))
+		if err == nil && !strings.HasPrefix(string(reply), session_OK) {
+			t.Fatalf("accepted a reply that is not a success: %q", reply)
+		}
+	})
+}
+
+func FuzzHelloReply(f *testing.F) {
+	addFuzzReplies(f)
+	f.Fuzz(func(t *testing.T, reply []byte) {
+		_, err := parseHelloReply(reply)
+		if err == nil && !strings.HasPrefix(string(reply), "HELLO REPLY RESULT=OK") {
+			t.Fatalf("accepted a reply that is not a success: %q", reply)
+		}
+	})
+}
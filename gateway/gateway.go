@@ -0,0 +1,126 @@
+// Serves the same HTTP service over I2P and on a local address, for services
+// such as APIs and status pages that should also be reachable without I2P,
+// for example while developing them.
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/dajohi/sam3"
+)
+
+// The header carrying the I2P destination (base64) a request came from.
+// Requests on the local address never have it, whatever the client sent.
+const SourceHeader = "X-I2P-Source"
+
+// Counts of the requests served by an I2PGateway.
+type GatewayStats struct {
+	Local    int64                  // requests on the local address
+	BySource map[sam3.I2PAddr]int64 // requests over I2P, by sender
+}
+
+// Serves one http.Handler both to I2P, on a StreamListener, and locally, on a
+// TCP address. Set the handler with Handler before calling Start.
+type I2PGateway struct {
+	mu       sync.Mutex
+	handler  http.Handler
+	local    int64
+	bySource map[sam3.I2PAddr]int64
+	servers  []*http.Server
+}
+
+// Sets the handler serving all requests, http.DefaultServeMux if never set.
+// Takes effect for requests arriving after the call.
+func (g *I2PGateway) Handler(h http.Handler) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.handler = h
+}
+
+// Starts serving HTTP on i2pListener and on the TCP address localAddr, such
+// as "127.0.0.1:8080", in the background. Returns once both are being
+// served, or an error if localAddr could not be listened on. Stop with
+// Shutdown.
+func (g *I2PGateway) Start(i2pListener *sam3.StreamListener, localAddr string) error {
+	l, err := net.Listen("tcp", localAddr)
+	if err != nil {
+		return err
+	}
+	i2pServer := &http.Server{Handler: http.HandlerFunc(g.serveI2P)}
+	localServer := &http.Server{Handler: http.HandlerFunc(g.serveLocal)}
+	g.mu.Lock()
+	if g.servers != nil {
+		g.mu.Unlock()
+		l.Close()
+		return errors.New("Gateway already started")
+	}
+	g.servers = []*http.Server{i2pServer, localServer}
+	g.mu.Unlock()
+	go i2pServer.Serve(&sam3.ForwardListener{StreamListener: i2pListener})
+	go localServer.Serve(l)
+	return nil
+}
+
+// Stops accepting requests on both sides and waits, until ctx is done at most,
+// for the requests being served to finish. Closes the I2P listener, but not
+// the session it belongs to.
+func (g *I2PGateway) Shutdown(ctx context.Context) error {
+	g.mu.Lock()
+	servers := g.servers
+	g.mu.Unlock()
+	var err error
+	for _, s := range servers {
+		if err2 := s.Shutdown(ctx); err2 != nil && err == nil {
+			err = err2
+		}
+	}
+	return err
+}
+
+// Returns the request counts so far.
+func (g *I2PGateway) Stats() GatewayStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	stats := GatewayStats{Local: g.local, BySource: make(map[sam3.I2PAddr]int64, len(g.bySource))}
+	for src, n := range g.bySource {
+		stats.BySource[src] = n
+	}
+	return stats
+}
+
+func (g *I2PGateway) serveI2P(w http.ResponseWriter, r *http.Request) {
+	// the forwarded connections report the destination of the peer
+	src := sam3.I2PAddr(r.RemoteAddr)
+	r.Header.Del(SourceHeader)
+	if src != "" {
+		r.Header.Set(SourceHeader, string(src))
+	}
+	g.mu.Lock()
+	if g.bySource == nil {
+		g.bySource = make(map[sam3.I2PAddr]int64)
+	}
+	g.bySource[src]++
+	h := g.handler
+	g.mu.Unlock()
+	serve(h, w, r)
+}
+
+func (g *I2PGateway) serveLocal(w http.ResponseWriter, r *http.Request) {
+	r.Header.Del(SourceHeader)
+	g.mu.Lock()
+	g.local++
+	h := g.handler
+	g.mu.Unlock()
+	serve(h, w, r)
+}
+
+func serve(h http.Handler, w http.ResponseWriter, r *http.Request) {
+	if h == nil {
+		h = http.DefaultServeMux
+	}
+	h.ServeHTTP(w, r)
+}
@@ -0,0 +1,133 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/dajohi/sam3"
+)
+
+// A destination of 387 zero bytes.
+var testDest = strings.Repeat("A", 516)
+
+// Listens like a SAM bridge that accepts any session, and answers STREAM
+// FORWARD by sending request over a connection from testDest. The response
+// is sent on responses.
+func fakeBridge(t *testing.T, request string, responses chan<- string) net.Listener {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					fields := strings.Fields(line)
+					switch {
+					case strings.HasPrefix(line, "HELLO"):
+						fmt.Fprint(conn, "HELLO REPLY RESULT=OK VERSION=3.0\n")
+					case strings.HasPrefix(line, "SESSION CREATE"):
+						fmt.Fprintf(conn, "SESSION STATUS RESULT=OK %s\n", fields[4])
+					case strings.HasPrefix(line, "STREAM FORWARD"):
+						fmt.Fprint(conn, "STREAM STATUS RESULT=OK\n")
+						go forward(strings.TrimPrefix(fields[3], "PORT="), request, responses)
+					}
+				}
+			}()
+		}
+	}()
+	return l
+}
+
+func forward(port, request string, responses chan<- string) {
+	conn, err := net.Dial("tcp4", "127.0.0.1:"+port)
+	if err != nil {
+		responses <- err.Error()
+		return
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "%s\n%s", testDest, request)
+	resp, err := ioutil.ReadAll(conn)
+	if err != nil {
+		responses <- err.Error()
+		return
+	}
+	responses <- string(resp)
+}
+
+func Test_I2PGateway(t *testing.T) {
+	responses := make(chan string, 1)
+	bridge := fakeBridge(t, "GET /hello HTTP/1.1\r\nHost: x.i2p\r\nX-I2P-Source: forged\r\nConnection: close\r\n\r\n", responses)
+	defer bridge.Close()
+	sam, err := sam3.NewSAM(bridge.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	ss, err := sam.NewStreamSession("gwTun", sam3.NewKeys(sam3.I2PAddr("pub"), "pubpriv"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	l, err := ss.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var g I2PGateway
+	g.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "source=%q", r.Header.Get(SourceHeader))
+	}))
+	local, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	localAddr := local.Addr().String()
+	local.Close()
+	if err := g.Start(l, localAddr); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Shutdown(context.Background())
+
+	if resp := <-responses; !strings.Contains(resp, fmt.Sprintf("source=%q", testDest)) {
+		t.Fatalf("unexpected response over I2P: %q", resp)
+	}
+	req, _ := http.NewRequest("GET", "http://"+localAddr+"/hello", nil)
+	req.Header.Set(SourceHeader, "forged")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != `source=""` {
+		t.Fatalf("unexpected local response %q", body)
+	}
+
+	stats := g.Stats()
+	if stats.Local != 1 || len(stats.BySource) != 1 || stats.BySource[sam3.I2PAddr(testDest)] != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if err := g.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := http.Get("http://" + localAddr + "/hello"); err == nil {
+		t.Fatal("served after Shutdown")
+	}
+}
@@ -0,0 +1,164 @@
+package sam3
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// The headers I2PHTTPProxy uses to tell the local service who the client is,
+// the same ones the HTTP server tunnels of the Java router add.
+const (
+	HeaderDestHash = "X-I2P-DestHash" // base64 of the SHA-256 hash of the destination
+	HeaderDestB32  = "X-I2P-DestB32"  // the b32.i2p address
+	HeaderDestB64  = "X-I2P-DestB64"  // the full base64 destination
+)
+
+// A reverse proxy in front of a local HTTP service, which serves the HTTP/1.1
+// requests of I2P clients, and adds X-I2P-DestHash, X-I2P-DestB32 and
+// X-I2P-DestB64 headers naming the client's destination, the I2P analogue of
+// X-Forwarded-For. Headers of those names sent by the client are always
+// removed, so they can not be spoofed.
+//
+// Whatever is sent through a CONNECT tunnel reaches the local service as it
+// is, so the headers of the requests in it can be neither removed nor added.
+// CONNECT is therefore refused with 405 while the headers are injected, since
+// the local service could not tell real ones from spoofed ones. Otherwise it
+// is answered with 200, and the connection is passed through.
+type I2PHTTPProxy struct {
+	target string // the local service
+
+	mu       sync.Mutex
+	inject   bool
+	listener net.Listener
+}
+
+// Creates an I2PHTTPProxy forwarding to the HTTP service at the TCP address
+// target, which adds the X-I2P headers.
+func NewI2PHTTPProxy(target string) *I2PHTTPProxy {
+	return &I2PHTTPProxy{target: target, inject: true}
+}
+
+// Sets whether the X-I2P headers are added to forwarded requests. They are
+// by default.
+func (p *I2PHTTPProxy) InjectHeaders(inject bool) {
+	p.mu.Lock()
+	p.inject = inject
+	p.mu.Unlock()
+}
+
+// Serves the connections accepted by l, such as a StreamListener, until Close
+// is called. Always returns a non-nil error.
+func (p *I2PHTTPProxy) Serve(l net.Listener) error {
+	p.mu.Lock()
+	p.listener = l
+	p.mu.Unlock()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go p.ServeConn(conn)
+	}
+}
+
+// Stops accepting connections. Connections already proxied are not affected.
+func (p *I2PHTTPProxy) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.listener == nil {
+		return nil
+	}
+	return p.listener.Close()
+}
+
+// Serves the requests of one client connection, and closes it. This makes
+// the proxy a ConnHandler, so it can be registered with an I2PMux.
+func (p *I2PHTTPProxy) ServeConn(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	var local net.Conn
+	var lr *bufio.Reader
+	defer func() {
+		if local != nil {
+			local.Close()
+		}
+	}()
+	for {
+		req, err := http.ReadRequest(r)
+		if err != nil {
+			return
+		}
+		connect := req.Method == http.MethodConnect
+		if connect && p.injecting() {
+			writeStatus(conn, http.StatusMethodNotAllowed)
+			return
+		}
+		if local == nil {
+			if local, err = net.Dial("tcp", p.target); err != nil {
+				writeStatus(conn, http.StatusBadGateway)
+				return
+			}
+			lr = bufio.NewReader(local)
+		}
+		if connect {
+			if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+				return
+			}
+			go func() {
+				io.Copy(local, r)
+				local.Close()
+			}()
+			io.Copy(conn, lr)
+			return
+		}
+		p.setHeaders(req.Header, conn.RemoteAddr())
+		if err := req.Write(local); err != nil {
+			writeStatus(conn, http.StatusBadGateway)
+			return
+		}
+		resp, err := http.ReadResponse(lr, req)
+		if err != nil {
+			writeStatus(conn, http.StatusBadGateway)
+			return
+		}
+		err = resp.Write(conn)
+		resp.Body.Close()
+		if err != nil || req.Close || resp.Close {
+			return
+		}
+	}
+}
+
+// Removes any X-I2P headers from h, and adds those for the client at addr if
+// enabled.
+func (p *I2PHTTPProxy) setHeaders(h http.Header, addr net.Addr) {
+	h.Del(HeaderDestHash)
+	h.Del(HeaderDestB32)
+	h.Del(HeaderDestB64)
+	dest, ok := addr.(I2PAddr)
+	if !p.injecting() || !ok {
+		return
+	}
+	hash := sha256.Sum256([]byte(string(dest)))
+	h.Set(HeaderDestHash, i2pB64enc.EncodeToString(hash[:]))
+	h.Set(HeaderDestB32, dest.Base32())
+	h.Set(HeaderDestB64, dest.String())
+}
+
+// Reports whether the X-I2P headers are added, see InjectHeaders.
+func (p *I2PHTTPProxy) injecting() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.inject
+}
+
+// Answers the client with an empty response of the status code, such as 502
+// when the local service could not be reached, and closes the connection.
+func writeStatus(w io.Writer, code int) {
+	resp := &http.Response{StatusCode: code, ProtoMajor: 1, ProtoMinor: 1, Close: true}
+	resp.Write(w)
+}
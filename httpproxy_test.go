@@ -0,0 +1,96 @@
+package sam3
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// A client connection that seems to come from an I2P destination.
+type i2pClientConn struct {
+	net.Conn
+	from I2PAddr
+}
+
+func (c i2pClientConn) RemoteAddr() net.Addr {
+	return c.from
+}
+
+func Test_I2PHTTPProxy(t *testing.T) {
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s|%s|%s", r.Header.Get(HeaderDestHash), r.Header.Get(HeaderDestB32), r.Header.Get(HeaderDestB64))
+	}))
+	defer local.Close()
+	proxy := NewI2PHTTPProxy(strings.TrimPrefix(local.URL, "http://"))
+
+	get := func() string {
+		client, server := net.Pipe()
+		defer client.Close()
+		go proxy.ServeConn(i2pClientConn{Conn: server, from: testDest})
+		// a spoofed header has to be replaced
+		fmt.Fprint(client, "GET / HTTP/1.1\r\nHost: example.i2p\r\nX-I2P-DestB32: spoofed.b32.i2p\r\n\r\n")
+		resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body)
+	}
+
+	hash := sha256.Sum256([]byte(string(testDest)))
+	want := i2pB64enc.EncodeToString(hash[:]) + "|" + testDest.Base32() + "|" + string(testDest)
+	if got := get(); got != want {
+		t.Fatalf("expected headers %q, got %q", want, got)
+	}
+	proxy.InjectHeaders(false)
+	if got := get(); got != "||" {
+		t.Fatalf("expected no headers, got %q", got)
+	}
+
+	// a CONNECT tunnel would carry requests with spoofed headers
+	connect := func() *http.Response {
+		client, server := net.Pipe()
+		go proxy.ServeConn(i2pClientConn{Conn: server, from: testDest})
+		fmt.Fprint(client, "CONNECT example.i2p:80 HTTP/1.1\r\nHost: example.i2p:80\r\n\r\n")
+		br := bufio.NewReader(client)
+		resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			client.Close()
+			return resp
+		}
+		go fmt.Fprint(client, "GET / HTTP/1.1\r\nHost: example.i2p\r\nX-I2P-DestB32: spoofed.b32.i2p\r\n\r\n")
+		resp, err = http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{resp.Body, client}
+		return resp
+	}
+	resp := connect()
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "|spoofed.b32.i2p|" {
+		t.Fatalf("expected the tunnel to pass the request as it is, got %q", body)
+	}
+	proxy.InjectHeaders(true)
+	if resp := connect(); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("expected CONNECT to be refused, got %s", resp.Status)
+	}
+}
@@ -0,0 +1,51 @@
+package sam3
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Returns an http.Transport fetching http:// URLs of .i2p hosts (names,
+// *.b32.i2p names or base64 destinations) over streams of the session, for
+// use in an http.Client. Ports in URLs are ignored, I2P does not need them.
+//
+// Opening a stream takes a round trip through four tunnels, and often a
+// leaseset lookup, so it can take seconds, while a request on a stream that is
+// already open takes a single round trip. The transport therefore keeps idle
+// streams open for reuse (HTTP keep-alive) for five minutes, and up to eight
+// per host, rather than the two of http.DefaultTransport. Raise
+// MaxIdleConnsPerHost further when making many concurrent requests to the
+// same eepsite; idle streams cost the router little, while every new stream
+// costs the client a connect.
+func (s *StreamSession) HTTPTransport() *http.Transport {
+	return &http.Transport{
+		DialContext:           s.dialHost,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   8,
+		IdleConnTimeout:       5 * time.Minute,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+// Dials the host of addr, a "host:port" as passed by http.Transport, or a
+// host without a port.
+func (s *StreamSession) dialHost(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	dest, err := NewI2PAddrFromString(host)
+	if err != nil {
+		if dest, err = s.Lookup(strings.ToLower(host)); err != nil {
+			return nil, err
+		}
+	}
+	conn, err := s.DialContextI2P(ctx, dest)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
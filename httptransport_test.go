@@ -0,0 +1,55 @@
+package sam3
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func Test_HTTPTransportKeepAlive(t *testing.T) {
+	mock := newMockSAM(t, func(cmd string) string {
+		if cmd == "\r" {
+			// the end of the headers of a request on a stream
+			return "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"
+		}
+		if reply := mockLookup(cmd); reply != "" {
+			return reply
+		}
+		return mockOK(cmd)
+	})
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	ss, err := sam.NewStreamSession("httpTun", NewKeys(I2PAddr("pub"), "pubpriv"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	transport := ss.HTTPTransport()
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get("http://known.i2p/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || string(body) != "ok" {
+			t.Fatalf("unexpected body %q, %v", body, err)
+		}
+	}
+	var connects int
+	for _, cmd := range mock.Commands() {
+		if strings.HasPrefix(cmd, "STREAM CONNECT") {
+			connects++
+		}
+	}
+	if connects != 1 {
+		t.Fatalf("expected the stream to be reused, got %d STREAM CONNECTs", connects)
+	}
+}
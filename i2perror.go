@@ -0,0 +1,67 @@
+package sam3
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Normalized codes of I2PError, for the messages that are recognized.
+const (
+	I2PErrorRouterBusy   = "ROUTER_BUSY"
+	I2PErrorShuttingDown = "SHUTTING_DOWN"
+	I2PErrorTimeout      = "TIMEOUT"
+)
+
+// Returned when the SAM bridge answers with RESULT=I2P_ERROR. The MESSAGE of
+// the bridge is free text, but some routers embed hints in it, such as
+// "Router busy, try again after 5s", which are extracted.
+type I2PError struct {
+	Message string // the MESSAGE of the reply, without quotes
+	Code    string // one of the I2PError* constants, or "" if not recognized
+
+	retryAfter *time.Duration
+}
+
+func (e *I2PError) Error() string {
+	return "I2P error " + e.Message
+}
+
+// Returns how long the router asked to wait before trying again, or nil if it
+// did not say.
+func (e *I2PError) RetryAfter() *time.Duration {
+	return e.retryAfter
+}
+
+var retryAfterRe = regexp.MustCompile(`(?i)(?:retry|try again)[ -]?(?:after|in)[: ]*([0-9]+(?:\.[0-9]+)?) *(ms|milliseconds?|s|secs?|seconds?|m|mins?|minutes?)?\b`)
+
+// Parses the MESSAGE of an I2P_ERROR reply, with or without its quotes.
+func parseI2PErrorMessage(msg string) I2PError {
+	msg = strings.Trim(strings.TrimSpace(msg), "\"")
+	e := I2PError{Message: msg}
+	lower := strings.ToLower(msg)
+	switch {
+	case strings.Contains(lower, "busy"):
+		e.Code = I2PErrorRouterBusy
+	case strings.Contains(lower, "shutting down"), strings.Contains(lower, "shutdown"):
+		e.Code = I2PErrorShuttingDown
+	case strings.Contains(lower, "timeout"), strings.Contains(lower, "timed out"):
+		e.Code = I2PErrorTimeout
+	}
+	if m := retryAfterRe.FindStringSubmatch(msg); m != nil {
+		n, err := strconv.ParseFloat(m[1], 64)
+		if err == nil {
+			unit := time.Second
+			switch strings.ToLower(m[2]) {
+			case "ms", "millisecond", "milliseconds":
+				unit = time.Millisecond
+			case "m", "min", "mins", "minute", "minutes":
+				unit = time.Minute
+			}
+			d := time.Duration(n * float64(unit))
+			e.retryAfter = &d
+		}
+	}
+	return e
+}
@@ -0,0 +1,98 @@
+package sam3
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_ParseI2PErrorMessage(t *testing.T) {
+	tests := []struct {
+		msg   string
+		code  string
+		retry time.Duration // zero if there should be no hint
+	}{
+		{"\"Router busy, try again after 5s\"\n", I2PErrorRouterBusy, 5 * time.Second},
+		{"Router busy", I2PErrorRouterBusy, 0},
+		{"shutting down, retry in 2 minutes", I2PErrorShuttingDown, 2 * time.Minute},
+		{"Retry-After: 250ms", "", 250 * time.Millisecond},
+		{"something else", "", 0},
+	}
+	for _, test := range tests {
+		e := parseI2PErrorMessage(test.msg)
+		if e.Code != test.code {
+			t.Errorf("%q: expected code %q, got %q", test.msg, test.code, e.Code)
+		}
+		if test.retry == 0 && e.RetryAfter() != nil {
+			t.Errorf("%q: unexpected retry hint %v", test.msg, *e.RetryAfter())
+		} else if test.retry != 0 && (e.RetryAfter() == nil || *e.RetryAfter() != test.retry) {
+			t.Errorf("%q: expected retry hint %v, got %v", test.msg, test.retry, e.RetryAfter())
+		}
+	}
+}
+
+func Test_SessionRetryAfter(t *testing.T) {
+	creates := 0
+	mock := newMockSAM(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "SESSION CREATE") {
+			creates++
+			if creates == 1 {
+				return "SESSION STATUS RESULT=I2P_ERROR MESSAGE=\"Router busy, try again after 10ms\"\n"
+			}
+			if mockField(cmd, "ID") == "busyTun" {
+				return "SESSION STATUS RESULT=I2P_ERROR MESSAGE=\"Router busy\"\n"
+			}
+		}
+		return mockOK(cmd)
+	})
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	keys := NewKeys(I2PAddr("pub"), "pubpriv")
+	ss, err := sam.NewStreamSession("retryTun", keys, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss.Close()
+	if creates != 2 {
+		t.Fatalf("expected 2 SESSION CREATEs, got %d", creates)
+	}
+	_, err = sam.NewStreamSession("busyTun", keys, nil)
+	var ierr *I2PError
+	if !errors.As(err, &ierr) || ierr.Code != I2PErrorRouterBusy {
+		t.Fatalf("expected a busy I2PError, got %v", err)
+	}
+}
+
+func Test_SessionRetryClosed(t *testing.T) {
+	asked := make(chan struct{}, 1)
+	mock := newMockSAM(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "SESSION CREATE") {
+			asked <- struct{}{}
+			return "SESSION STATUS RESULT=I2P_ERROR MESSAGE=\"Router busy, try again after 20s\"\n"
+		}
+		return mockOK(cmd)
+	})
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		<-asked
+		sam.Close()
+	}()
+	start := time.Now()
+	_, err = sam.NewStreamSession("retryTun", NewKeys(I2PAddr("pub"), "pubpriv"), nil)
+	if !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed, got %v", err)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Fatalf("waited %v to retry on a closed SAM", d)
+	}
+}
@@ -0,0 +1,14 @@
+// Package integrationtest holds tests of sam3 against a real I2P router. They
+// are only built with the "integration" build tag, and need the SAM bridge
+// started by docker-compose.yml in this directory (or any other bridge, named
+// by SAM_ADDRESS):
+//
+//	docker compose -f integrationtest/docker-compose.yml up -d
+//	go test -tags integration ./integrationtest
+//
+// or simply "make integration". A fresh router needs a few minutes to
+// integrate into the network; the tests wait for it, within their timeouts.
+//
+// The tests were written against i2pd 2.50.2, the version pinned in
+// docker-compose.yml, whose SAM bridge speaks SAM 3.0 to 3.3.
+package integrationtest
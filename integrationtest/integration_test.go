@@ -0,0 +1,119 @@
+//go:build integration
+
+package integrationtest
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dajohi/sam3"
+)
+
+// How long a test may take, including waiting for tunnels to be built.
+const testTimeout = 5 * time.Minute
+
+func newSAM(t *testing.T, maxVersion string) *sam3.SAM {
+	sam, err := sam3.NewSAMConfig(sam3.Config{Address: sam3.ResolveSAMAddress(), MaxVersion: maxVersion})
+	if err != nil {
+		t.Fatalf("no SAM bridge at %s (see docker-compose.yml): %v", sam3.ResolveSAMAddress(), err)
+	}
+	return sam
+}
+
+func TestRealLookup(t *testing.T) {
+	sam := newSAM(t, "3.0")
+	defer sam.Close()
+	addr, err := sam.Lookup("stats.i2p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sam3.NewI2PAddrFromString(string(addr)); err != nil {
+		t.Fatalf("stats.i2p resolved to a malformed destination: %v", err)
+	}
+}
+
+func TestRealKeyGeneration(t *testing.T) {
+	sam := newSAM(t, "3.1")
+	defer sam.Close()
+	keys, err := sam.NewKeys(sam3.Sig_EdDSA_SHA512_Ed25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sam3.NewI2PKeys(keys.Addr(), keys.Private()); err != nil {
+		t.Fatalf("the bridge generated invalid keys: %v", err)
+	}
+	dest, err := keys.Addr().ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a key certificate (5) naming signature type 7
+	if len(dest) < 391 || dest[384] != 5 || dest[387] != 0 || dest[388] != sam3.Sig_EdDSA_SHA512_Ed25519 {
+		t.Fatalf("not an Ed25519 destination: % x", dest[384:])
+	}
+}
+
+func TestRealEphemeralSession(t *testing.T) {
+	sam := newSAM(t, "3.0")
+	defer sam.Close()
+	keys, err := sam.NewKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss, err := sam.NewStreamSession("integration-ephemeral", keys, sam3.Options_Small)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ss.Addr() != keys.Addr() {
+		t.Fatal("the session has another destination than its keys")
+	}
+	if err := ss.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRealStreamDial(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	sam := newSAM(t, "3.0")
+	defer sam.Close()
+	keys, err := sam.NewKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss, err := sam.NewStreamSession("integration-dial", keys, sam3.Options_Small)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	addr, err := ss.Lookup("stats.i2p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var conn *sam3.SAMConn
+	for {
+		// the first attempts fail while the tunnels are being built
+		conn, err = ss.DialContextI2P(ctx, addr)
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+		t.Logf("dial failed, retrying: %v", err)
+		time.Sleep(10 * time.Second)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "HEAD / HTTP/1.0\r\nHost: stats.i2p\r\n\r\n")
+	conn.SetReadDeadline(time.Now().Add(time.Minute))
+	status, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(status, "HTTP/") {
+		t.Fatalf("unexpected reply %q", status)
+	}
+}
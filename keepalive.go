@@ -0,0 +1,109 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Returned by CheckAlive when the bridge answered the keepalive with something
+// unexpected.
+var ErrBadKeepaliveReply = errors.New("Unexpected reply to keepalive")
+
+// How a SAM checks that its connection to the bridge is alive, see CheckAlive.
+// Implementations send something cheap with SAM.Command and return an error
+// unless the bridge answers as expected.
+type KeepaliveStrategy interface {
+	Check(sam *SAM) error
+}
+
+// Checks with PING, which the bridge answers with PONG. Requires SAM 3.2.
+type PingKeepalive struct{}
+
+func (PingKeepalive) Check(sam *SAM) error {
+	r, err := sam.Command(CmdPing + " sam3")
+	if err != nil {
+		return err
+	}
+	if r.Topic != CmdPong {
+		return ErrBadKeepaliveReply
+	}
+	return nil
+}
+
+// Checks with a lookup of ME, the destination of the connection, which every
+// bridge answers with a NAMING REPLY, whether or not the name resolves. For
+// bridges without PING.
+type LookupKeepalive struct{}
+
+func (LookupKeepalive) Check(sam *SAM) error {
+	r, err := sam.Command(CmdNamingLookup + " NAME=ME")
+	if err != nil {
+		return err
+	}
+	if r.Topic+" "+r.Type != CmdNamingReply {
+		return ErrBadKeepaliveReply
+	}
+	return nil
+}
+
+// Returns the keepalive strategy the bridge supports: PingKeepalive if the
+// negotiated version has PING, otherwise LookupKeepalive. Used by CheckAlive
+// unless SetKeepaliveStrategy chose another one.
+func (sam *SAM) KeepaliveStrategy() KeepaliveStrategy {
+	if sam.keepalive != nil {
+		return sam.keepalive
+	}
+	if sam.Features().Ping {
+		return PingKeepalive{}
+	}
+	return LookupKeepalive{}
+}
+
+// Makes CheckAlive and KeepAlive use k, or the default of KeepaliveStrategy
+// again if k is nil.
+func (sam *SAM) SetKeepaliveStrategy(k KeepaliveStrategy) {
+	sam.keepalive = k
+}
+
+// Checks once that the connection to the bridge is alive, giving up after
+// timeout (if not zero). Returns nil if the bridge answered. Not safe to call
+// while another command is sent on the SAM.
+func (sam *SAM) CheckAlive(timeout time.Duration) error {
+	return sam.checkAlive(sam.KeepaliveStrategy(), timeout)
+}
+
+func (sam *SAM) checkAlive(k KeepaliveStrategy, timeout time.Duration) error {
+	if timeout > 0 {
+		sam.conn.SetDeadline(time.Now().Add(timeout))
+		defer sam.conn.SetDeadline(time.Time{})
+	}
+	return k.Check(sam)
+}
+
+// Checks that the connection to the bridge is alive every interval, which
+// keeps it from being closed as idle, until ctx is done or a check fails.
+// Returns ctx.Err() or the error of the failed check; the connection is then
+// unusable and the SAM should be closed. Each check has interval to complete.
+//
+// KeepAlive blocks, and nothing else may be sent on the SAM while it runs, so
+// it is meant for a SAM that is kept open only to watch the bridge, run in a
+// goroutine of its own.
+func (sam *SAM) KeepAlive(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return errors.New("Keepalive interval must be positive")
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+		if err := sam.CheckAlive(interval); err != nil {
+			sam.logf("sam3: keepalive to %s failed: %v", sam.cfg.Address, err)
+			return err
+		}
+	}
+}
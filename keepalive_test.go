@@ -0,0 +1,61 @@
+package sam3
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_KeepaliveStrategy(t *testing.T) {
+	mock := newMockSAM(t, mockLookup)
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	if _, ok := sam.KeepaliveStrategy().(LookupKeepalive); !ok {
+		t.Fatalf("expected lookups without PING, got %T", sam.KeepaliveStrategy())
+	}
+	if err := sam.CheckAlive(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	sam.SetKeepaliveStrategy(PingKeepalive{})
+	// the mock does not answer PING
+	if err := sam.CheckAlive(50 * time.Millisecond); err == nil {
+		t.Fatal("expected an unanswered PING to fail")
+	}
+	cmds := mock.Commands()
+	if cmds[1] != "NAMING LOOKUP NAME=ME" || cmds[2] != "PING sam3" {
+		t.Fatalf("unexpected commands %q", cmds)
+	}
+}
+
+func Test_KeepAlivePing(t *testing.T) {
+	mock := newMockSAM(t, func(cmd string) string {
+		switch cmd {
+		case "HELLO VERSION MIN=3.2 MAX=3.2":
+			return "HELLO REPLY RESULT=OK VERSION=3.2\n"
+		case "PING sam3":
+			return "PONG sam3\n"
+		}
+		return ""
+	})
+	defer mock.Close()
+	sam, err := NewSAMConfig(Config{Address: mock.Addr(), MinVersion: "3.2", MaxVersion: "3.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	if _, ok := sam.KeepaliveStrategy().(PingKeepalive); !ok {
+		t.Fatalf("expected PING with SAM 3.2, got %T", sam.KeepaliveStrategy())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := sam.KeepAlive(ctx, 20*time.Millisecond); err != context.DeadlineExceeded {
+		t.Fatalf("expected the keepalive to run until the deadline, got %v", err)
+	}
+	if n := len(mock.Commands()); n < 3 {
+		t.Fatalf("expected several PINGs, got %d commands", n)
+	}
+}
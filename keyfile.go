@@ -0,0 +1,111 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Errors returned (wrapped) when keys can not be loaded from a file, or a
+// session created with them.
+var (
+	ErrKeyFileNotFound  = errors.New("Key file not found")
+	ErrKeyFileMalformed = errors.New("Key file malformed")
+	ErrSessionCreate    = errors.New("Unable to create session")
+)
+
+// Loads keys saved by SaveKeysToFile. Returns an error wrapping
+// ErrKeyFileNotFound if there is no file at path, or ErrKeyFileMalformed if
+// it does not hold valid keys.
+func LoadKeysFromFile(path string) (I2PKeys, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return I2PKeys{}, fmt.Errorf("%w: %s", ErrKeyFileNotFound, path)
+	}
+	if err != nil {
+		return I2PKeys{}, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 {
+		return I2PKeys{}, fmt.Errorf("%w: %s: expected 2 lines, got %d", ErrKeyFileMalformed, path, len(lines))
+	}
+	addr, err := NewI2PAddrFromString(strings.TrimSpace(lines[0]))
+	if err != nil {
+		return I2PKeys{}, fmt.Errorf("%w: %s: %v", ErrKeyFileMalformed, path, err)
+	}
+	keys, err := NewI2PKeys(addr, strings.TrimSpace(lines[1]))
+	if err != nil {
+		return I2PKeys{}, fmt.Errorf("%w: %s: %v", ErrKeyFileMalformed, path, err)
+	}
+	return keys, nil
+}
+
+// Saves keys to the file at path, readable only by its owner, as two lines:
+// the destination and the private keys, both in I2Ps base64. The file is
+// replaced atomically, so a crash can not leave half of the keys behind.
+func SaveKeysToFile(path string, keys I2PKeys) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.WriteString(keys.Addr().Base64() + "\n" + keys.String() + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Creates a StreamSession with the keys in the file at path, so the I2P
+// address of a service stays the same across restarts. If there is no file,
+// new keys are generated, and saved to path once the session has been
+// created. ctx bounds generating the keys. Errors wrap ErrKeyFileMalformed if
+// the file could not be used, and ErrSessionCreate if the session could not
+// be created. With WithEphemeral, generated keys are not saved.
+func (sam *SAM) NewStreamSessionFromFile(ctx context.Context, path, id string, options []string, opts ...Option) (*StreamSession, error) {
+	so, err := applyOptions(options, opts)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := LoadKeysFromFile(path)
+	generated := errors.Is(err, ErrKeyFileNotFound)
+	if generated {
+		stop := watchContext(ctx, sam.conn)
+		keys, err = sam.NewKeys()
+		if stop() {
+			return nil, ctx.Err()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ss, err := sam.NewStreamSession(id, keys, options, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("%w %s: %v", ErrSessionCreate, id, err)
+	}
+	if generated && !so.ephemeral {
+		if err := SaveKeysToFile(path, keys); err != nil {
+			ss.Close()
+			return nil, err
+		}
+	}
+	return ss, nil
+}
@@ -0,0 +1,64 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_NewStreamSessionFromFile(t *testing.T) {
+	mock := newMockSAM(t, mockOK)
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	dir, err := ioutil.TempDir("", "sam3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "service.keys")
+
+	if _, err := LoadKeysFromFile(path); !errors.Is(err, ErrKeyFileNotFound) {
+		t.Fatalf("expected ErrKeyFileNotFound, got %v", err)
+	}
+	ss, err := sam.NewStreamSessionFromFile(context.Background(), path, "fileTun", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss.Close()
+	keys, err := LoadKeysFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys.Addr() != testDest || keys.String() != testPrivKeys {
+		t.Fatal("saved keys differ from the generated ones")
+	}
+	ss, err = sam.NewStreamSessionFromFile(context.Background(), path, "fileTun", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss.Close()
+	var generates int
+	for _, cmd := range mock.Commands() {
+		if cmd == "DEST GENERATE" {
+			generates++
+		}
+	}
+	if generates != 1 {
+		t.Fatalf("expected keys to be generated once, got %d", generates)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(string(testDest)+"\n"+strings.Repeat("B", 900)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sam.NewStreamSessionFromFile(context.Background(), path, "fileTun", nil); !errors.Is(err, ErrKeyFileMalformed) {
+		t.Fatalf("expected ErrKeyFileMalformed, got %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Returned by KeyGenPool.Get after the pool has been closed.
+var ErrKeyGenPoolClosed = errors.New("Key generation pool is closed")
+
+// Generates keys ahead of time, at a limited rate, on a connection of its own.
+// DEST GENERATE is expensive for the router, and applications starting many
+// sessions in quick succession (such as tests) can overload the bridge with
+// it; a KeyGenPool spreads the cost out and hands keys out without waiting.
+type KeyGenPool struct {
+	cfg      Config
+	interval time.Duration
+	sigType  int // of the keys generated, see NewKeys
+	keys     chan I2PKeys
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+
+	mu      sync.Mutex
+	lastErr error
+}
+
+// Starts a KeyGenPool holding up to size keys, generated at most one per
+// interval (one per second if interval is zero) on a new connection to the
+// bridge of sam. Failed generations are retried on a new connection at the
+// next interval. The keys have the signature type sigType, if given, or else
+// Sig_Best, as with NewKeys.
+func (sam *SAM) NewKeyGenPool(size int, interval time.Duration, sigType ...int) (*KeyGenPool, error) {
+	if size < 1 {
+		return nil, errors.New("Key generation pool size needs to be positive")
+	}
+	if interval <= 0 {
+		interval = time.Second
+	}
+	gen, err := NewSAMConfig(sam.cfg)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t := Sig_Best
+	if len(sigType) > 0 {
+		t = sigType[0]
+	}
+	p := &KeyGenPool{
+		cfg:      sam.cfg,
+		interval: interval,
+		sigType:  t,
+		keys:     make(chan I2PKeys, size),
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	go p.generate(gen)
+	return p, nil
+}
+
+// Returns pre-generated keys, waiting for the next ones if the pool is empty,
+// until ctx is done.
+func (p *KeyGenPool) Get(ctx context.Context) (I2PKeys, error) {
+	if p.ctx.Err() != nil {
+		return I2PKeys{}, ErrKeyGenPoolClosed
+	}
+	select {
+	case keys := <-p.keys:
+		return keys, nil
+	case <-p.ctx.Done():
+		return I2PKeys{}, ErrKeyGenPoolClosed
+	case <-ctx.Done():
+		return I2PKeys{}, ctx.Err()
+	}
+}
+
+// Returns pre-generated keys if there are any, without waiting.
+func (p *KeyGenPool) TryGet() (I2PKeys, bool) {
+	select {
+	case keys := <-p.keys:
+		return keys, true
+	default:
+		return I2PKeys{}, false
+	}
+}
+
+// Returns the signature type of the keys of the pool.
+func (p *KeyGenPool) SignatureType() int {
+	return p.sigType
+}
+
+// Returns the error of the last failed generation, or nil if it succeeded.
+func (p *KeyGenPool) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastErr
+}
+
+// Stops generating keys and closes the connection of the pool. Keys that were
+// not taken are discarded.
+func (p *KeyGenPool) Close() error {
+	p.cancel()
+	<-p.done
+	return nil
+}
+
+// Makes NewKeys of sam take keys from p, when there are any and they have the
+// signature type asked for, rather than generate them while the caller waits.
+// Sessions starting many short-lived identities then start faster, while p
+// refills in the background. A nil p stops it.
+func (sam *SAM) SetKeyGenPool(p *KeyGenPool) {
+	sam.keyPool = p
+}
+
+func (p *KeyGenPool) generate(sam *SAM) {
+	defer close(p.done)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		if sam == nil {
+			sam, _ = NewSAMConfig(p.cfg)
+		}
+		if sam != nil {
+			stop := watchContext(p.ctx, sam.conn)
+			keys, err := sam.NewKeys(p.sigType)
+			if stop() {
+				sam.Close()
+				return
+			}
+			p.mu.Lock()
+			p.lastErr = err
+			p.mu.Unlock()
+			if err != nil {
+				sam.Close()
+				sam = nil
+			} else {
+				select {
+				case p.keys <- keys:
+				case <-p.ctx.Done():
+					sam.Close()
+					return
+				}
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-p.ctx.Done():
+			if sam != nil {
+				sam.Close()
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package sam3
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_KeyGenPool(t *testing.T) {
+	mock := newMockSAM(t, mockOK)
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	pool, err := sam.NewKeyGenPool(2, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		keys, err := pool.Get(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if keys.Addr() != testDest {
+			t.Fatalf("unexpected keys %v", keys.Addr())
+		}
+	}
+	pool.Close()
+	if _, err := pool.Get(ctx); err != ErrKeyGenPoolClosed {
+		t.Fatalf("expected ErrKeyGenPoolClosed, got %v", err)
+	}
+}
+
+func Test_KeyGenPoolNewKeys(t *testing.T) {
+	mock := newMockSAM(t, mockOK)
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	pool, err := sam.NewKeyGenPool(1, 10*time.Millisecond, Sig_DSA_SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	sam.SetKeyGenPool(pool)
+	deadline := time.Now().Add(2 * time.Second)
+	for len(pool.keys) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the pool did not fill")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	// NewKeys can only succeed without the connection of the SAM by taking
+	// the keys from the pool
+	sam.conn.Close()
+	keys, err := sam.NewKeys(Sig_DSA_SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys.Addr() != testDest {
+		t.Fatalf("unexpected keys %v", keys.Addr())
+	}
+	if _, err := sam.NewKeys(Sig_EdDSA_SHA512_Ed25519); err == nil {
+		t.Fatal("expected keys of another type not to come from the pool")
+	}
+}
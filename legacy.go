@@ -0,0 +1,45 @@
+package sam3
+
+// Returns a warning if the destination uses legacy cryptography: a null
+// certificate, which means DSA-SHA1 signatures and ElGamal encryption, or a
+// key certificate that still asks for DSA-SHA1 signatures. Such destinations
+// are slow to reach and have been deprecated for years. Returns "" for modern
+// and for malformed destinations.
+func (addr I2PAddr) LegacyWarning() string {
+	b, err := addr.ToBytes()
+	if err != nil {
+		return ""
+	}
+	_, sigType, _, err := parseDestination(b)
+	if err != nil {
+		return ""
+	}
+	if b[destCertOffset] == cert_NULL {
+		return "destination has a null certificate (DSA-SHA1 and ElGamal)"
+	}
+	if sigType == Sig_DSA_SHA1 {
+		return "destination uses DSA-SHA1 signatures"
+	}
+	return ""
+}
+
+// Makes StreamSession.DialI2P stay quiet about legacy destinations, see
+// I2PAddr.LegacyWarning. By default dialing one logs a warning to
+// Config.Logger. Nothing is sent to the bridge.
+func AllowLegacy(allow bool) Option {
+	return func(so *sessionOptions) error {
+		so.allowLegacy = allow
+		return nil
+	}
+}
+
+// Logs a warning if addr is a legacy destination, unless the session was
+// created with AllowLegacy.
+func (s *StreamSession) warnLegacy(addr I2PAddr) {
+	if so := s.sessionOpts(); s.cfg.Logger == nil || (so != nil && so.allowLegacy) {
+		return
+	}
+	if warning := addr.LegacyWarning(); warning != "" {
+		s.cfg.Logger.Printf("sam3: WARN dialing legacy destination %s: %s", addr.Base32(), warning)
+	}
+}
@@ -0,0 +1,51 @@
+package sam3
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_LegacyWarning(t *testing.T) {
+	if I2PAddr(testDest).LegacyWarning() == "" {
+		t.Error("no warning for a destination with a null certificate")
+	}
+	dsa := make([]byte, destCertOffset)
+	dsa = append(dsa, cert_KEY, 0, 4, 0, Sig_DSA_SHA1, 0, Crypto_ElGamal)
+	if I2PAddr(i2pB64enc.EncodeToString(dsa)).LegacyWarning() == "" {
+		t.Error("no warning for a DSA-SHA1 key certificate")
+	}
+	keys, _ := GenerateKeysFromSeed([]byte("modern"), Sig_EdDSA_SHA512_Ed25519)
+	if w := keys.Addr().LegacyWarning(); w != "" {
+		t.Errorf("warning for an Ed25519 destination: %s", w)
+	}
+	if w := I2PAddr("garbage").LegacyWarning(); w != "" {
+		t.Errorf("warning for a malformed destination: %s", w)
+	}
+}
+
+func Test_DialLegacy(t *testing.T) {
+	mock := newMockSAM(t, mockOK)
+	defer mock.Close()
+	for _, allow := range []bool{false, true} {
+		logger := &testLogger{}
+		sam, err := NewSAM(mock.Addr(), func(c *Config) { c.Logger = logger })
+		if err != nil {
+			t.Fatal(err)
+		}
+		ss, err := sam.NewStreamSession("legacyTun", NewKeys(I2PAddr("pub"), "pubpriv"), nil, AllowLegacy(allow))
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := ss.DialI2P(testDest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+		ss.Close()
+		sam.Close()
+		warned := strings.Contains(logger.String(), "WARN dialing legacy destination")
+		if warned == allow {
+			t.Errorf("AllowLegacy(%v): warned %v, log: %q", allow, warned, logger.String())
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package sam3
+
+import (
+	"bufio"
+	"context"
+	"strings"
+)
+
+// Looks up many names at once. All NAMING LOOKUP commands are written to the
+// bridge before any reply is read, so bridges that process lookups
+// concurrently answer them in parallel. Replies are matched to names by their
+// NAME= field, so it does not matter in what order they arrive; replies without
+// one are assumed to be in the order the lookups were sent. Names that could
+// not be matched to a reply are looked up one by one afterwards. Returns the
+// resolved addresses and the per-name errors, or an error if talking to the
+// bridge failed.
+func (sam *SAM) LookupMany(ctx context.Context, names []string) (map[string]I2PAddr, map[string]error, error) {
+	addrs, errs := make(map[string]I2PAddr), make(map[string]error)
+	if len(names) == 0 {
+		return addrs, errs, nil
+	}
+	stop := watchContext(ctx, sam.conn)
+	err := sam.lookupMany(names, addrs, errs)
+	if stop() {
+		return addrs, errs, ctx.Err()
+	}
+	if err != nil {
+		return addrs, errs, err
+	}
+	for _, name := range names {
+		if _, ok := addrs[name]; ok {
+			continue
+		}
+		if _, ok := errs[name]; ok {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return addrs, errs, err
+		}
+		addr, err := sam.Lookup(name)
+		if err != nil {
+			errs[name] = err
+		} else {
+			addrs[name] = addr
+		}
+	}
+	return addrs, errs, nil
+}
+
+func (sam *SAM) lookupMany(names []string, addrs map[string]I2PAddr, errs map[string]error) error {
+	var b []byte
+	for _, name := range names {
+		cmd, err := sam.cfg.build(commandOf(CmdNamingLookup).Set("NAME", name))
+		if err != nil {
+			return err
+		}
+		b = append(b, cmd...)
+	}
+	if _, err := sam.conn.Write(b); err != nil {
+		return err
+	}
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+	// Only replies are read, so nothing but them can end up in the buffer.
+	r := bufio.NewReader(sam.conn)
+	for i := range names {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		name := names[i]
+		for _, field := range strings.Fields(line) {
+			if strings.HasPrefix(field, "NAME=") {
+				name = field[5:]
+				break
+			}
+		}
+		if !wanted[name] {
+			continue
+		}
+		addr, err := parseLookupReply(name, []byte(line))
+		if err != nil {
+			errs[name] = err
+		} else {
+			addrs[name] = addr
+		}
+	}
+	return nil
+}
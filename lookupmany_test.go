@@ -0,0 +1,74 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Resolves names starting with "known" to testDest, and nothing else.
+func mockLookup(cmd string) string {
+	if !strings.HasPrefix(cmd, "NAMING LOOKUP NAME=") {
+		return ""
+	}
+	name := cmd[len("NAMING LOOKUP NAME="):]
+	if strings.HasPrefix(name, "known") {
+		return "NAMING REPLY RESULT=OK NAME=" + name + " VALUE=" + string(testDest) + "\n"
+	}
+	return "NAMING REPLY RESULT=KEY_NOT_FOUND NAME=" + name + "\n"
+}
+
+func Test_LookupMany(t *testing.T) {
+	mock := newMockSAM(t, mockLookup)
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	addrs, errs, err := sam.LookupMany(context.Background(), []string{"known1.i2p", "unknown.i2p", "known2.i2p"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addrs["known1.i2p"] != testDest || addrs["known2.i2p"] != testDest || len(addrs) != 2 {
+		t.Fatalf("unexpected addresses %v", addrs)
+	}
+	if !errors.Is(errs["unknown.i2p"], ErrNameNotFound) || len(errs) != 1 {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+}
+
+var benchNames = []string{"known1.i2p", "known2.i2p", "known3.i2p", "known4.i2p", "known5.i2p", "known6.i2p", "known7.i2p", "known8.i2p"}
+
+func BenchmarkLookupMany(b *testing.B) {
+	mock := newMockSAM(b, mockLookup)
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer sam.Close()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := sam.LookupMany(context.Background(), benchNames); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLookupSequential(b *testing.B) {
+	mock := newMockSAM(b, mockLookup)
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer sam.Close()
+	for i := 0; i < b.N; i++ {
+		for _, name := range benchNames {
+			if _, err := sam.Lookup(name); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// A SAM for applications that only resolve names (NAMING LOOKUP) and
+// generate keys (DEST GENERATE). It can not create sessions, so it never
+// makes the router build tunnels, and holds nothing in the router but a
+// control connection. Even that is only open while in use: it is opened by
+// the first call, reopened after it broke, and closed once it has been idle
+// for IdleTimeout. Safe for concurrent use; calls are sent one after another.
+type LookupOnlySAM struct {
+	cfg Config
+
+	// How long the connection is kept open after the last call. Defaults to
+	// one minute; zero or less keeps it open until Close.
+	IdleTimeout time.Duration
+
+	mu     sync.Mutex
+	sam    *SAM        // nil while not connected
+	idle   *time.Timer // closes sam when it fires
+	closed bool
+}
+
+// Creates a LookupOnlySAM for the bridge at address. Does not connect yet.
+func NewLookupOnlySAM(address string, opts ...SAMOption) *LookupOnlySAM {
+	cfg := Config{Address: address}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return &LookupOnlySAM{cfg: cfg, IdleTimeout: time.Minute}
+}
+
+// Resolves name to an I2P destination, see SAM.Lookup.
+func (s *LookupOnlySAM) Lookup(name string) (I2PAddr, error) {
+	return s.LookupContext(context.Background(), name)
+}
+
+// Like Lookup, but gives up and returns ctx.Err() once ctx is done.
+func (s *LookupOnlySAM) LookupContext(ctx context.Context, name string) (I2PAddr, error) {
+	var addr I2PAddr
+	err := s.do(ctx, func(sam *SAM) (err error) {
+		addr, err = sam.Lookup(name)
+		return err
+	})
+	return addr, err
+}
+
+// Generates new keys, see SAM.NewKeys.
+func (s *LookupOnlySAM) NewKeys(sigType ...int) (I2PKeys, error) {
+	var keys I2PKeys
+	err := s.do(context.Background(), func(sam *SAM) (err error) {
+		keys, err = sam.NewKeys(sigType...)
+		return err
+	})
+	return keys, err
+}
+
+// Closes the connection, if open. Later calls fail.
+func (s *LookupOnlySAM) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return s.disconnect()
+}
+
+// Runs f on the connection, connecting first if needed.
+func (s *LookupOnlySAM) do(ctx context.Context, f func(sam *SAM) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errors.New("SAM is closed")
+	}
+	if s.sam == nil {
+		sam, err := NewSAMConfig(s.cfg)
+		// a conn given with WithConn can only be used once
+		s.cfg.conn = nil
+		if err != nil {
+			return err
+		}
+		s.sam = sam
+	}
+	if s.idle != nil {
+		s.idle.Stop()
+		s.idle = nil
+	}
+	stop := watchContext(ctx, s.sam.conn)
+	err := f(s.sam)
+	if stop() {
+		s.disconnect()
+		return ctx.Err()
+	}
+	if err != nil && !errors.Is(err, ErrNameNotFound) {
+		// the reply might not have been read, so the connection can not be
+		// trusted anymore
+		s.disconnect()
+		return err
+	}
+	if s.IdleTimeout > 0 {
+		var t *time.Timer
+		t = time.AfterFunc(s.IdleTimeout, func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if s.idle == t {
+				s.disconnect()
+			}
+		})
+		s.idle = t
+	}
+	return err
+}
+
+// Closes the connection, if open. s.mu must be held.
+func (s *LookupOnlySAM) disconnect() error {
+	if s.idle != nil {
+		s.idle.Stop()
+		s.idle = nil
+	}
+	if s.sam == nil {
+		return nil
+	}
+	err := s.sam.Close()
+	s.sam = nil
+	return err
+}
@@ -0,0 +1,63 @@
+package sam3
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_LookupOnlySAM(t *testing.T) {
+	mock := newMockSAM(t, func(cmd string) string {
+		if reply := mockLookup(cmd); reply != "" {
+			return reply
+		}
+		return mockOK(cmd)
+	})
+	defer mock.Close()
+	s := NewLookupOnlySAM(mock.Addr())
+	defer s.Close()
+	s.IdleTimeout = 50 * time.Millisecond
+	if n := len(mock.Commands()); n != 0 {
+		t.Fatalf("connected before use, %d commands", n)
+	}
+	if addr, err := s.Lookup("known.i2p"); err != nil || addr != testDest {
+		t.Fatalf("lookup returned %q, %v", addr, err)
+	}
+	if _, err := s.Lookup("unknown.i2p"); !errors.Is(err, ErrNameNotFound) {
+		t.Fatalf("expected ErrNameNotFound, got %v", err)
+	}
+	if keys, err := s.NewKeys(Sig_DSA_SHA1); err != nil || keys.Addr() != testDest {
+		t.Fatalf("generated %q, %v", keys.Addr(), err)
+	}
+	hellos := func() (n int) {
+		for _, cmd := range mock.Commands() {
+			if strings.HasPrefix(cmd, "HELLO") {
+				n++
+			}
+		}
+		return n
+	}
+	if n := hellos(); n != 1 {
+		t.Fatalf("expected 1 connection, got %d", n)
+	}
+	// the idle connection is closed, and reopened by the next call
+	time.Sleep(150 * time.Millisecond)
+	if _, err := s.Lookup("known.i2p"); err != nil {
+		t.Fatal(err)
+	}
+	if n := hellos(); n != 2 {
+		t.Fatalf("expected 2 connections, got %d", n)
+	}
+	for _, cmd := range mock.Commands() {
+		if strings.HasPrefix(cmd, "SESSION") {
+			t.Fatalf("sent %q", cmd)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Lookup("known.i2p"); err == nil {
+		t.Fatal("looked up after Close")
+	}
+}
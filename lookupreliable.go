@@ -0,0 +1,68 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// Like SAM.Lookup, but tries again, up to maxRetries times, when the lookup
+// fails with a transient network error, such as no reply within
+// p.LookupTimeout. Every attempt takes a fresh connection from the pool, since
+// the one that failed may be in a bad state. A name that does not exist, or an
+// invalid one, is not retried.
+func (p *PriorityPool) LookupReliable(ctx context.Context, name string, maxRetries int) (I2PAddr, error) {
+	for attempt := 0; ; attempt++ {
+		sam, err := p.Get(ctx, PriorityNormal)
+		if err != nil {
+			return I2PAddr(""), err
+		}
+		if p.LookupTimeout > 0 {
+			sam.conn.SetReadDeadline(time.Now().Add(p.LookupTimeout))
+		}
+		stop := watchContext(ctx, sam.conn)
+		addr, err := sam.Lookup(name)
+		if stop() {
+			p.Discard(sam)
+			return I2PAddr(""), ctx.Err()
+		}
+		sam.conn.SetReadDeadline(time.Time{})
+		if err == nil {
+			p.Put(sam)
+			return addr, nil
+		}
+		if !isConnError(err) {
+			// the bridge answered, with an error
+			p.Put(sam)
+			return I2PAddr(""), err
+		}
+		p.Discard(sam)
+		if !isTransient(err) || attempt >= maxRetries {
+			return I2PAddr(""), err
+		}
+		p.logf("sam3: lookup of %s failed: %v, retrying (%d of %d)", name, err, attempt+1, maxRetries)
+	}
+}
+
+func (p *PriorityPool) logf(format string, v ...interface{}) {
+	if p.Logger != nil {
+		p.Logger.Printf(format, v...)
+	}
+}
+
+// Reports whether err comes from the connection to the bridge, rather than
+// from its reply.
+func isConnError(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// Reports whether err is a network error that may well not happen again, such
+// as a timeout.
+func isTransient(err error) bool {
+	var ne net.Error
+	//lint:ignore SA1019 Temporary is what net reports for overflowing buffers
+	return errors.As(err, &ne) && (ne.Timeout() || ne.Temporary())
+}
@@ -0,0 +1,66 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_LookupReliable(t *testing.T) {
+	var lookups int32
+	mock := newMockSAM(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "NAMING LOOKUP") && strings.Contains(cmd, "known") {
+			// the first two lookups get no reply, and time out
+			if atomic.AddInt32(&lookups, 1) < 3 {
+				return ""
+			}
+		}
+		return mockLookup(cmd)
+	})
+	defer mock.Close()
+	pool, err := NewPriorityPool(mock.Addr(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	pool.LookupTimeout = 50 * time.Millisecond
+	logger := &testLogger{}
+	pool.Logger = logger
+
+	addr, err := pool.LookupReliable(context.Background(), "known.i2p", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != I2PAddr(testDest) || atomic.LoadInt32(&lookups) != 3 {
+		t.Fatalf("resolved %q after %d lookups", addr, lookups)
+	}
+	if n := strings.Count(logger.String(), "retrying"); n != 2 {
+		t.Fatalf("logged %d retries, want 2:\n%s", n, logger)
+	}
+	// the two timed out connections were replaced
+	var hellos int
+	for _, cmd := range mock.Commands() {
+		if strings.HasPrefix(cmd, "HELLO") {
+			hellos++
+		}
+	}
+	if hellos != 3 {
+		t.Fatalf("%d connections were opened, want 3", hellos)
+	}
+
+	before := len(mock.Commands())
+	if _, err := pool.LookupReliable(context.Background(), "unknown.i2p", 5); !errors.Is(err, ErrNameNotFound) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if n := len(mock.Commands()) - before; n != 1 {
+		t.Fatalf("unknown name was looked up %d times", n)
+	}
+
+	atomic.StoreInt32(&lookups, 0)
+	if _, err := pool.LookupReliable(context.Background(), "known.i2p", 1); !isTransient(err) {
+		t.Fatalf("expected a timeout after one retry, got %v", err)
+	}
+}
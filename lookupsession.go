@@ -0,0 +1,80 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// A dedicated control connection for name lookups. Lookups on it are sent one
+// after another, without opening a connection for each, and without waiting
+// behind session creation and other traffic on the connection of a SAM.
+// Lighter than a PriorityPool when lookups are all that is needed. Safe for
+// concurrent use; if the connection breaks, the next lookup reconnects.
+type LookupSession struct {
+	cfg Config
+
+	mu     sync.Mutex
+	sam    *SAM // nil after the connection broke
+	closed bool
+}
+
+// Opens a LookupSession to the bridge of sam.
+func (sam *SAM) NewLookupSession() (*LookupSession, error) {
+	sam2, err := NewSAMConfig(sam.cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &LookupSession{cfg: sam.cfg, sam: sam2}, nil
+}
+
+// Resolves name to an I2P destination, see SAM.Lookup.
+func (s *LookupSession) Lookup(name string) (I2PAddr, error) {
+	return s.LookupContext(context.Background(), name)
+}
+
+// Like Lookup, but gives up and returns ctx.Err() once ctx is done.
+func (s *LookupSession) LookupContext(ctx context.Context, name string) (I2PAddr, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return I2PAddr(""), errors.New("Lookup session is closed")
+	}
+	if s.sam == nil {
+		sam, err := NewSAMConfig(s.cfg)
+		if err != nil {
+			return I2PAddr(""), err
+		}
+		s.sam = sam
+	}
+	stop := watchContext(ctx, s.sam.conn)
+	addr, err := s.sam.Lookup(name)
+	if stop() {
+		s.broken()
+		return I2PAddr(""), ctx.Err()
+	}
+	if err != nil && !errors.Is(err, ErrNameNotFound) {
+		// the reply might not have been read, so the connection can not be
+		// trusted anymore
+		s.broken()
+	}
+	return addr, err
+}
+
+func (s *LookupSession) broken() {
+	s.sam.conn.Close()
+	s.sam = nil
+}
+
+// Closes the connection of the LookupSession.
+func (s *LookupSession) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.sam == nil {
+		return nil
+	}
+	err := s.sam.Close()
+	s.sam = nil
+	return err
+}
@@ -0,0 +1,52 @@
+package sam3
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func Test_LookupSession(t *testing.T) {
+	mock := newMockSAM(t, mockLookup)
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	ls, err := sam.NewLookupSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if addr, err := ls.Lookup("known.i2p"); err != nil || addr != testDest {
+				t.Errorf("lookup returned %q, %v", addr, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if _, err := ls.Lookup("unknown.i2p"); !errors.Is(err, ErrNameNotFound) {
+		t.Fatalf("expected ErrNameNotFound, got %v", err)
+	}
+	var hellos int
+	for _, cmd := range mock.Commands() {
+		if strings.HasPrefix(cmd, "HELLO") {
+			hellos++
+		}
+	}
+	// one for sam, one for the lookup session
+	if hellos != 2 {
+		t.Fatalf("expected 2 connections, got %d", hellos)
+	}
+	if err := ls.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ls.Lookup("known.i2p"); err == nil {
+		t.Fatal("looked up on a closed session")
+	}
+}
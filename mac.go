@@ -0,0 +1,103 @@
+package sam3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"time"
+)
+
+// Returned by MACDatagramSession.Read for datagrams whose MAC does not match,
+// because they were tampered with or sent with another key.
+var ErrAuthenticationFailed = errors.New("Datagram authentication failed")
+
+// Wraps a RawSession, which carries no information about the sender, so that
+// every datagram is authenticated with HMAC-SHA256 under a key shared by the
+// peers. Datagrams are sent as the 32 byte MAC of the payload, followed by the
+// payload. This only proves that the sender knows the key; it does not stop
+// a datagram from being replayed.
+type MACDatagramSession struct {
+	raw *RawSession
+	key []byte
+}
+
+// Wraps sess, authenticating datagrams with sharedKey, which should be at
+// least 32 random bytes.
+func NewMACDatagramSession(sess *RawSession, sharedKey []byte) (*MACDatagramSession, error) {
+	if len(sharedKey) == 0 {
+		return nil, errors.New("Empty MAC key")
+	}
+	return &MACDatagramSession{raw: sess, key: append([]byte(nil), sharedKey...)}, nil
+}
+
+// Returns the largest payload that can be sent, which is the size of the MAC
+// less than the limit of the raw session.
+func (s *MACDatagramSession) MaxDatagramSize() int {
+	return s.raw.MaxDatagramSize() - sha256.Size
+}
+
+// Sends b, with its MAC, to addr. Returns the length of b.
+func (s *MACDatagramSession) WriteTo(b []byte, addr I2PAddr) (int, error) {
+	if len(b) > s.MaxDatagramSize() {
+		return 0, ErrDatagramTooLarge
+	}
+	if _, err := s.raw.WriteTo(s.seal(b), addr); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Reads one datagram and checks its MAC. Returns the length of the payload,
+// or ErrAuthenticationFailed if the MAC is wrong.
+func (s *MACDatagramSession) Read(b []byte) (int, error) {
+	buf := make([]byte, len(b)+sha256.Size)
+	n, err := s.raw.Read(buf)
+	if err != nil {
+		return 0, err
+	}
+	payload, err := s.open(buf[:n])
+	if err != nil {
+		return 0, err
+	}
+	return copy(b, payload), nil
+}
+
+// Closes the wrapped RawSession.
+func (s *MACDatagramSession) Close() error {
+	return s.raw.Close()
+}
+
+// Returns the local I2P destination of the wrapped RawSession.
+func (s *MACDatagramSession) LocalAddr() I2PAddr {
+	return s.raw.LocalAddr()
+}
+
+// Sets the read deadline of the wrapped RawSession.
+func (s *MACDatagramSession) SetReadDeadline(t time.Time) error {
+	return s.raw.SetReadDeadline(t)
+}
+
+// Sets the write deadline of the wrapped RawSession.
+func (s *MACDatagramSession) SetWriteDeadline(t time.Time) error {
+	return s.raw.SetWriteDeadline(t)
+}
+
+// Returns the MAC of payload followed by payload.
+func (s *MACDatagramSession) seal(payload []byte) []byte {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(payload)
+	return append(mac.Sum(make([]byte, 0, sha256.Size+len(payload))), payload...)
+}
+
+// Checks the MAC of a datagram made by seal, and returns its payload.
+func (s *MACDatagramSession) open(datagram []byte) ([]byte, error) {
+	if len(datagram) < sha256.Size {
+		return nil, ErrAuthenticationFailed
+	}
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(datagram[sha256.Size:])
+	if !hmac.Equal(mac.Sum(nil), datagram[:sha256.Size]) {
+		return nil, ErrAuthenticationFailed
+	}
+	return datagram[sha256.Size:], nil
+}
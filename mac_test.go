@@ -0,0 +1,34 @@
+package sam3
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_MACDatagramSession(t *testing.T) {
+	rs := &RawSession{maxSize: defaultMaxRawSize}
+	s, err := NewMACDatagramSession(rs, []byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := NewMACDatagramSession(rs, []byte("another key"))
+	datagram := s.seal([]byte("hello"))
+	payload, err := s.open(datagram)
+	if err != nil || !bytes.Equal(payload, []byte("hello")) {
+		t.Fatalf("valid datagram rejected: %q, %v", payload, err)
+	}
+	tampered := append([]byte(nil), datagram...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := s.open(tampered); err != ErrAuthenticationFailed {
+		t.Fatalf("expected ErrAuthenticationFailed for a tampered payload, got %v", err)
+	}
+	if _, err := other.open(datagram); err != ErrAuthenticationFailed {
+		t.Fatalf("expected ErrAuthenticationFailed for another key, got %v", err)
+	}
+	if _, err := s.open(datagram[:10]); err != ErrAuthenticationFailed {
+		t.Fatalf("expected ErrAuthenticationFailed for a short datagram, got %v", err)
+	}
+	if _, err := s.WriteTo(make([]byte, s.MaxDatagramSize()+1), testDest); err != ErrDatagramTooLarge {
+		t.Fatalf("expected ErrDatagramTooLarge, got %v", err)
+	}
+}
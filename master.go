@@ -0,0 +1,220 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// A MASTER session (SAM 3.3): a destination with one set of tunnels, shared by
+// any number of subsessions of different styles that are added to it later.
+// This uses far fewer resources than creating a full session per style.
+type MasterSession struct {
+	cfg  Config
+	id   string
+	keys I2PKeys
+	sam  *SAM // controls the master session and its subsessions
+
+	mu   sync.Mutex
+	subs map[string]*sessionState // the states of the subsessions, by id
+
+	state sessionState
+}
+
+// Creates a MASTER session. Requires a bridge supporting SAM 3.3, otherwise a
+// *VersionError, matching ErrNotSupported, is returned.
+func (sam *SAM) NewMasterSession(ctx context.Context, id string, keys I2PKeys, options []string, opts ...Option) (*MasterSession, error) {
+	so, err := applyOptions(options, opts)
+	if err != nil {
+		return nil, err
+	}
+	cfg := sam.cfg
+	if !versionAtLeast(cfg.MaxVersion, "3.3") {
+		cfg.MaxVersion = "3.3"
+	}
+	sam2, err := NewSAMConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := requireVersion(sam2.version, "3.3", "subsessions"); err != nil {
+		sam2.conn.Close()
+		return nil, err
+	}
+	stop := watchContext(ctx, sam2.conn)
+	reply, err := sam2.createSession("MASTER", id, keys, so.options(), so.extras())
+	if stop() {
+		sam2.conn.Close()
+		return nil, ctx.Err()
+	}
+	if err == nil {
+		keys, err = sessionKeys(keys, reply)
+	}
+	if err != nil {
+		sam2.conn.Close()
+		return nil, err
+	}
+	ms := &MasterSession{cfg: sam.cfg, id: id, keys: keys, sam: sam2, subs: make(map[string]*sessionState)}
+	ms.state.start()
+	return ms, nil
+}
+
+// Returns the local tunnel name of the master session.
+func (m *MasterSession) ID() string {
+	return m.id
+}
+
+// Returns the I2P destination shared by the master session and all of its
+// subsessions.
+func (m *MasterSession) Addr() I2PAddr {
+	return m.keys.Addr()
+}
+
+// Adds a STREAM subsession. Closing the returned StreamSession removes the
+// subsession.
+func (m *MasterSession) AddStream(subID string, options []string, opts ...Option) (*StreamSession, error) {
+	so, err := applyOptions(options, opts)
+	if err != nil {
+		return nil, err
+	}
+	ss := &StreamSession{cfg: m.cfg, id: subID, conn: m.sam.conn, keys: m.keys, opts: so, master: m}
+	if err := m.add("STREAM", subID, so.options(), so.extras(), &ss.state); err != nil {
+		return nil, err
+	}
+	ss.state.start()
+	return ss, nil
+}
+
+// Adds a DATAGRAM subsession. udpPort is the UDP port SAM is listening on, zero
+// for its standard port. Closing the returned DatagramSession removes the
+// subsession.
+func (m *MasterSession) AddDatagram(subID string, options []string, udpPort int, opts ...Option) (*DatagramSession, error) {
+	so, err := applyOptions(options, opts)
+	if err != nil {
+		return nil, err
+	}
+	udpconn, rUDPAddr, fwd, err := listenUDP(m.sam.conn, udpPort, so)
+	if err != nil {
+		return nil, err
+	}
+	ds := &DatagramSession{cfg: m.cfg, id: subID, conn: m.sam.conn, udpconn: udpconn, keys: m.keys, rUDPAddr: rUDPAddr, maxSize: defaultMaxDatagramSize, master: m}
+	if err := m.add("DATAGRAM", subID, so.options(), so.extras(fwd...), &ds.state); err != nil {
+		udpconn.Close()
+		return nil, err
+	}
+	ds.state.start()
+	return ds, nil
+}
+
+// Adds a RAW subsession. udpPort is the UDP port SAM is listening on, zero for
+// its standard port. Use WithListenProtocol and WithListenPort to have several
+// raw subsessions each receive only their own protocol or port. Closing the
+// returned RawSession removes the subsession.
+func (m *MasterSession) AddRaw(subID string, options []string, udpPort int, opts ...Option) (*RawSession, error) {
+	so, err := applyOptions(options, opts)
+	if err != nil {
+		return nil, err
+	}
+	udpconn, rUDPAddr, fwd, err := listenUDP(m.sam.conn, udpPort, so)
+	if err != nil {
+		return nil, err
+	}
+	rs := &RawSession{cfg: m.cfg, id: subID, conn: m.sam.conn, udpconn: udpconn, keys: m.keys, rUDPAddr: rUDPAddr, maxSize: defaultMaxRawSize, header: so.params["HEADER"] == "true", master: m}
+	if err := m.add("RAW", subID, so.options(), so.extras(fwd...), &rs.state); err != nil {
+		udpconn.Close()
+		return nil, err
+	}
+	rs.state.start()
+	return rs, nil
+}
+
+// Removes a subsession. The master session and its other subsessions are not
+// affected.
+func (m *MasterSession) RemoveSubsession(subID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.subs[subID] == nil {
+		return errors.New("No subsession " + subID)
+	}
+	if err := m.command(commandOf(CmdSessionRemove).Set("ID", subID)); err != nil {
+		return err
+	}
+	delete(m.subs, subID)
+	return nil
+}
+
+// Closes the master session, and with it all of its subsessions, which are
+// then SessionClosed. Closing it again is a no-op.
+func (m *MasterSession) Close() error {
+	if !m.state.close() {
+		return nil
+	}
+	defer m.state.closed()
+	err := m.sam.Close()
+	m.mu.Lock()
+	subs := m.subs
+	m.subs = make(map[string]*sessionState)
+	m.mu.Unlock()
+	for _, st := range subs {
+		if st.close() {
+			st.closed()
+		}
+	}
+	return err
+}
+
+// Sends SESSION ADD, and keeps track of the subsession by its state st.
+func (m *MasterSession) add(style, subID string, options []string, extras []string, st *sessionState) error {
+	if strings.ContainsAny(subID, " \n") || subID == "" {
+		return errors.New("Invalid subsession ID")
+	}
+	if err := checkExtras(extras); err != nil {
+		return err
+	}
+	if err := requireParams(m.sam.version, extras); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cmd := commandOf(CmdSessionAdd).Set("STYLE", style).Set("ID", subID)
+	if err := m.command(cmd.addOptions(options, extras)); err != nil {
+		return err
+	}
+	m.subs[subID] = st
+	return nil
+}
+
+// Sends cmd on the control connection and parses the SESSION STATUS reply.
+// m.mu must be held.
+func (m *MasterSession) command(cmd *Command) error {
+	b, err := m.sam.cfg.build(cmd)
+	if err != nil {
+		return err
+	}
+	if _, err := m.sam.conn.Write(b); err != nil {
+		return err
+	}
+	buf := getBuffer(4096)
+	defer putBuffer(buf)
+	n, err := m.sam.conn.Read(buf)
+	if err != nil {
+		return err
+	}
+	return parseSubsessionReply(buf[:n])
+}
+
+// Parses the reply to SESSION ADD or SESSION REMOVE.
+func parseSubsessionReply(reply []byte) error {
+	text := string(reply)
+	if strings.HasPrefix(text, CmdSessionStatus+" RESULT="+ResultOK) {
+		return nil
+	} else if strings.HasPrefix(text, CmdSessionStatus+" RESULT="+ResultDuplicatedID) {
+		return ErrDuplicateID
+	} else if strings.HasPrefix(text, CmdSessionStatus+" RESULT="+ResultInvalidID) {
+		return errors.New("Invalid tunnel ID")
+	} else if strings.HasPrefix(text, session_I2P_ERROR) {
+		e := parseI2PErrorMessage(text[len(session_I2P_ERROR):])
+		return &e
+	}
+	return newParseError("SESSION", reply)
+}
@@ -0,0 +1,179 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func Test_MasterSession(t *testing.T) {
+	mock := newMockSAM(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "HELLO") && mockField(cmd, "MAX") == "3.3" {
+			return "HELLO REPLY RESULT=OK VERSION=3.3\n"
+		}
+		return mockOK(cmd)
+	})
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	keys := NewKeys(I2PAddr("pub"), "pubpriv")
+	master, err := sam.NewMasterSession(context.Background(), "masterTun", keys, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer master.Close()
+	ss, err := master.AddStream("sub1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ss.ID() != "sub1" || ss.Addr() != master.Addr() {
+		t.Fatalf("unexpected subsession %q with address %q", ss.ID(), ss.Addr())
+	}
+	ds, err := master.AddDatagram("sub2", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := master.RemoveSubsession("sub1"); err == nil {
+		t.Fatal("removed a subsession twice")
+	}
+	var removes int
+	for _, cmd := range mock.Commands() {
+		if strings.HasPrefix(cmd, "SESSION REMOVE") {
+			removes++
+		}
+	}
+	if removes != 2 {
+		t.Fatalf("expected 2 SESSION REMOVEs, got %d", removes)
+	}
+
+	// closing the master closes the subsessions it still has
+	ss, err = master.AddStream("sub3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := master.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if ss.State() != SessionClosed {
+		t.Fatalf("subsession of a closed master is %v", ss.State())
+	}
+	if err := ss.Close(); err != nil {
+		t.Fatalf("closed a subsession of a closed master: %v", err)
+	}
+	if err := master.Close(); err != nil {
+		t.Fatalf("closed twice: %v", err)
+	}
+}
+
+func Test_MasterSessionNotSupported(t *testing.T) {
+	mock := newMockSAM(t, mockOK)
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	_, err = sam.NewMasterSession(context.Background(), "masterTun", NewKeys(I2PAddr("pub"), "pubpriv"), nil)
+	if !errors.Is(err, ErrNotSupported) || err.Error() != "subsessions require SAM bridge version 3.3, connected to 3.0" {
+		t.Fatalf("expected ErrNotSupported, got %v", err)
+	}
+}
+
+func Test_MasterSessionRawListen(t *testing.T) {
+	mock := newMockSAM(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "HELLO") && mockField(cmd, "MAX") == "3.3" {
+			return "HELLO REPLY RESULT=OK VERSION=3.3\n"
+		}
+		return mockOK(cmd)
+	})
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	master, err := sam.NewMasterSession(context.Background(), "rawMaster", NewKeys(I2PAddr("pub"), "pubpriv"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer master.Close()
+	rs, err := master.AddRaw("raw18", nil, 0, WithListenProtocol(18), WithListenPort(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Close()
+	if _, err := master.AddRaw("raw17", nil, 0, WithListenProtocol(17)); err == nil {
+		t.Fatal("accepted the reserved protocol 17")
+	}
+	var add string
+	for _, cmd := range mock.Commands() {
+		if strings.HasPrefix(cmd, "SESSION ADD STYLE=RAW") {
+			add = cmd
+		}
+	}
+	if mockField(add, "LISTEN_PROTOCOL") != "18" || mockField(add, "LISTEN_PORT") != "1000" {
+		t.Fatalf("unexpected SESSION ADD %q", add)
+	}
+}
+
+func Test_MasterSessionSharedConn(t *testing.T) {
+	mock := newMockSAM(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "HELLO") {
+			return "HELLO REPLY RESULT=OK VERSION=3.3\n"
+		}
+		if strings.HasPrefix(cmd, "OPTIONS SET") {
+			return "OPTIONS STATUS RESULT=OK\n"
+		}
+		return mockOK(cmd)
+	})
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	master, err := sam.NewMasterSession(context.Background(), "masterTun", NewKeys(I2PAddr("pub"), "pubpriv"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer master.Close()
+	ds, err := master.AddDatagram("dgram", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the replies to commands of the subsession and of the master, on the
+	// same connection, must not be mixed up
+	var wg sync.WaitGroup
+	errs := make(chan error, 40)
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			errs <- ds.SetRecvQueueSize(16)
+		}()
+		go func(i int) {
+			defer wg.Done()
+			_, err := master.AddStream("sub"+strconv.Itoa(i), nil)
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package sam3
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// A tiny scripted SAM bridge, for tests that should not need a running router.
+// Every command is answered with whatever reply returns (nothing, if it returns
+// ""), except that HELLO defaults to agreeing on version 3.0.
+type mockSAM struct {
+	listener net.Listener
+	reply    func(cmd string) string
+
+	mu    sync.Mutex
+	cmds  []string   // every command received, without the trailing newline
+	conns []net.Conn // every connection accepted
+}
+
+func newMockSAM(t testing.TB, reply func(cmd string) string) *mockSAM {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockSAM{listener: l, reply: reply}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go m.serve(conn)
+		}
+	}()
+	return m
+}
+
+func (m *mockSAM) serve(conn net.Conn) {
+	defer conn.Close()
+	m.mu.Lock()
+	m.conns = append(m.conns, conn)
+	m.mu.Unlock()
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimRight(line, "\n")
+		m.mu.Lock()
+		m.cmds = append(m.cmds, cmd)
+		m.mu.Unlock()
+		var reply string
+		if m.reply != nil {
+			reply = m.reply(cmd)
+		}
+		if reply == "" && strings.HasPrefix(cmd, "HELLO VERSION") {
+			reply = "HELLO REPLY RESULT=OK VERSION=3.0\n"
+		}
+		if reply != "" {
+			if _, err := conn.Write([]byte(reply)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// The address to pass to NewSAM.
+func (m *mockSAM) Addr() string {
+	return m.listener.Addr().String()
+}
+
+// Returns the commands received so far.
+func (m *mockSAM) Commands() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.cmds...)
+}
+
+func (m *mockSAM) Close() {
+	m.listener.Close()
+}
+
+// Closes every connection accepted so far, like a restarting router does.
+func (m *mockSAM) CloseConns() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, conn := range m.conns {
+		conn.Close()
+	}
+}
+
+// Made up keys, as returned by a bridge: a destination with a null certificate
+// followed by 256 bytes of encryption and 20 bytes of signing private key.
+var testPrivKeys = i2pB64enc.EncodeToString(make([]byte, 387+256+20))
+
+// A reply function for newMockSAM that behaves like a well-working bridge:
+// sessions are created with the keys asked for, every STREAM CONNECT succeeds
+// and DEST GENERATE returns testPrivKeys.
+func mockOK(cmd string) string {
+	switch {
+	case strings.HasPrefix(cmd, "SESSION CREATE"):
+		if dest := mockField(cmd, "DESTINATION"); dest != "TRANSIENT" {
+			return "SESSION STATUS RESULT=OK DESTINATION=" + dest + "\n"
+		}
+		return "SESSION STATUS RESULT=OK DESTINATION=" + testPrivKeys + "\n"
+	case strings.HasPrefix(cmd, "SESSION ADD"), strings.HasPrefix(cmd, "SESSION REMOVE"):
+		return "SESSION STATUS RESULT=OK ID=" + mockField(cmd, "ID") + "\n"
+	case strings.HasPrefix(cmd, "STREAM CONNECT"):
+		return "STREAM STATUS RESULT=OK\n"
+	case cmd == "DEST GENERATE":
+		return "DEST REPLY PUB=" + string(testDest) + " PRIV=" + testPrivKeys + "\n"
+	}
+	return ""
+}
+
+// Returns the value of key in the command cmd.
+func mockField(cmd, key string) string {
+	for _, f := range strings.Fields(cmd) {
+		if strings.HasPrefix(f, key+"=") {
+			return f[len(key)+1:]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,98 @@
+package sam3
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+// Serves connections accepted by an I2PMux.
+type ConnHandler interface {
+	ServeConn(conn net.Conn)
+}
+
+// Lets an ordinary function be used as a ConnHandler.
+type ConnHandlerFunc func(conn net.Conn)
+
+// Calls f(conn).
+func (f ConnHandlerFunc) ServeConn(conn net.Conn) {
+	f(conn)
+}
+
+// Routes incoming connections to handlers by the I2P port they were made to
+// (TO_PORT, SAM 3.2), so one destination can offer several services, like
+// http.ServeMux does with paths. Connections to ports without a handler go to
+// the default handler; if there is none, they are closed and a warning is
+// logged to Logger.
+type I2PMux struct {
+	Logger Logger // receives warnings, if set
+
+	mu       sync.Mutex
+	handlers map[int]ConnHandler
+	fallback ConnHandler
+}
+
+// Creates a new I2PMux without any handlers.
+func NewI2PMux() *I2PMux {
+	return &I2PMux{handlers: make(map[int]ConnHandler)}
+}
+
+// Registers handler for connections to port, replacing any handler already
+// registered for it.
+func (m *I2PMux) Handle(port int, handler ConnHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers[port] = handler
+}
+
+// Registers f for connections to port, see Handle.
+func (m *I2PMux) HandleFunc(port int, f func(conn net.Conn)) {
+	m.Handle(port, ConnHandlerFunc(f))
+}
+
+// Sets the handler of connections to ports without one of their own.
+func (m *I2PMux) DefaultHandler(handler ConnHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fallback = handler
+}
+
+// Returns the handler for connections to port, or nil.
+func (m *I2PMux) handler(port int) ConnHandler {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if h, ok := m.handlers[port]; ok {
+		return h
+	}
+	return m.fallback
+}
+
+// Accepts connections from l and serves each with the handler for its port,
+// in a goroutine of its own, until l fails or is closed. Connections that fail
+// before they are routed do not stop it. Returns the error of l.
+func (m *I2PMux) Serve(l *StreamListener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			var herr handshakeError
+			if errors.As(err, &herr) {
+				m.logf("sam3: dropped incoming connection: %v", err)
+				continue
+			}
+			return err
+		}
+		h := m.handler(conn.ToPort())
+		if h == nil {
+			m.logf("sam3: no handler for I2P port %d, closing connection from %s", conn.ToPort(), conn.RemoteAddr().(I2PAddr).Base32())
+			conn.Close()
+			continue
+		}
+		go h.ServeConn(conn)
+	}
+}
+
+func (m *I2PMux) logf(format string, v ...interface{}) {
+	if m.Logger != nil {
+		m.Logger.Printf(format, v...)
+	}
+}
@@ -0,0 +1,100 @@
+package sam3
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Collects what is logged.
+type testLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *testLogger) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func (l *testLogger) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return strings.Join(l.lines, "\n")
+}
+
+func Test_I2PMux(t *testing.T) {
+	forwarded := make(chan string, 1)
+	mock := newMockSAM(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "STREAM FORWARD") {
+			forwarded <- mockField(cmd, "PORT")
+			return "STREAM STATUS RESULT=OK\n"
+		}
+		return mockOK(cmd)
+	})
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	ss := &StreamSession{cfg: sam.cfg, id: "muxTun", conn: sam.conn, keys: NewKeys(testDest, testPrivKeys)}
+	l, err := ss.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := <-forwarded
+
+	var logger testLogger
+	mux := NewI2PMux()
+	mux.Logger = &logger
+	mux.HandleFunc(80, func(conn net.Conn) {
+		conn.Write([]byte("web"))
+		conn.Close()
+	})
+	mux.Handle(22, ConnHandlerFunc(func(conn net.Conn) {
+		conn.Write([]byte("ssh"))
+		conn.Close()
+	}))
+	served := make(chan error, 1)
+	go func() { served <- mux.Serve(l) }()
+
+	connect := func(toPort int) string {
+		peer, err := net.Dial("tcp4", "127.0.0.1:"+port)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer peer.Close()
+		fmt.Fprintf(peer, "%s FROM_PORT=1234 TO_PORT=%d\n", testDest, toPort)
+		b, _ := io.ReadAll(peer)
+		return string(b)
+	}
+	if got := connect(80); got != "web" {
+		t.Fatalf("port 80 got %q", got)
+	}
+	if got := connect(22); got != "ssh" {
+		t.Fatalf("port 22 got %q", got)
+	}
+	if got := connect(443); got != "" {
+		t.Fatalf("unhandled port got %q", got)
+	}
+	if !strings.Contains(logger.String(), "no handler for I2P port 443") {
+		t.Fatalf("missing warning, logged %q", logger.String())
+	}
+	mux.DefaultHandler(ConnHandlerFunc(func(conn net.Conn) {
+		conn.Write([]byte(fmt.Sprint(conn.(*SAMConn).FromPort())))
+		conn.Close()
+	}))
+	if got := connect(443); got != "1234" {
+		t.Fatalf("default handler got %q", got)
+	}
+	l.Close()
+	if err := <-served; err == nil {
+		t.Fatal("Serve returned nil")
+	}
+}
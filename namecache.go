@@ -0,0 +1,83 @@
+package sam3
+
+import (
+	"sync"
+	"time"
+)
+
+// Caches resolved names, so that looking them up again is instant. Resolved
+// destinations stay valid when the connection to the bridge is lost, or the
+// router restarts, so the cache is kept apart from any SAM or session: create
+// one and share it between them, for as long as the program runs. Failed
+// lookups are not cached.
+type NameCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]nameCacheEntry
+}
+
+type nameCacheEntry struct {
+	addr    I2PAddr
+	expires time.Time
+}
+
+// Creates a NameCache keeping names for ttl, or forever if ttl is zero.
+func NewNameCache(ttl time.Duration) *NameCache {
+	return &NameCache{ttl: ttl, entries: make(map[string]nameCacheEntry)}
+}
+
+// Returns the cached destination of name, if any.
+func (c *NameCache) Get(name string) (I2PAddr, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[name]
+	if !ok {
+		return I2PAddr(""), false
+	}
+	if c.ttl > 0 && time.Now().After(e.expires) {
+		delete(c.entries, name)
+		return I2PAddr(""), false
+	}
+	return e.addr, true
+}
+
+// Caches addr as the destination of name.
+func (c *NameCache) Put(name string, addr I2PAddr) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[name] = nameCacheEntry{addr, time.Now().Add(c.ttl)}
+}
+
+// Removes name from the cache, such as when its destination is known to have
+// changed.
+func (c *NameCache) Forget(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, name)
+}
+
+// Returns the destination of name from the cache, or else looks it up with
+// sam and caches the result.
+func (c *NameCache) Lookup(sam *SAM, name string) (I2PAddr, error) {
+	return c.lookup(name, sam.Lookup)
+}
+
+func (c *NameCache) lookup(name string, lookup func(string) (I2PAddr, error)) (I2PAddr, error) {
+	if addr, ok := c.Get(name); ok {
+		return addr, nil
+	}
+	addr, err := lookup(name)
+	if err != nil {
+		return I2PAddr(""), err
+	}
+	c.Put(name, addr)
+	return addr, nil
+}
+
+// Makes Lookup on the session answer from cache when it can. The cache is
+// kept when the session is recreated by self-healing, and may be shared with
+// other sessions. nil turns caching off again.
+func (s *StreamSession) SetNameCache(cache *NameCache) {
+	s.names = cache
+}
@@ -0,0 +1,38 @@
+package sam3
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_NameCache(t *testing.T) {
+	mock := newMockSAM(t, mockLookup)
+	ss := &StreamSession{cfg: Config{Address: mock.Addr()}, id: "cacheTun"}
+	cache := NewNameCache(time.Hour)
+	ss.SetNameCache(cache)
+	if addr, err := ss.Lookup("known.i2p"); err != nil || addr != testDest {
+		t.Fatalf("lookup returned %q, %v", addr, err)
+	}
+	if _, err := ss.Lookup("unknown.i2p"); err == nil {
+		t.Fatal("resolved an unknown name")
+	}
+	// the bridge goes away, the cache stays
+	mock.Close()
+	if addr, err := ss.Lookup("known.i2p"); err != nil || addr != testDest {
+		t.Fatalf("cached lookup returned %q, %v", addr, err)
+	}
+	if _, ok := cache.Get("unknown.i2p"); ok {
+		t.Fatal("cached a failed lookup")
+	}
+	cache.Forget("known.i2p")
+	if _, err := ss.Lookup("known.i2p"); err == nil {
+		t.Fatal("forgotten name resolved without a bridge")
+	}
+
+	expiring := NewNameCache(time.Nanosecond)
+	expiring.Put("known.i2p", testDest)
+	time.Sleep(time.Millisecond)
+	if _, ok := expiring.Get("known.i2p"); ok {
+		t.Fatal("got an expired name")
+	}
+}
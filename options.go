@@ -0,0 +1,450 @@
+package sam3
+
+import (
+	"crypto/sha256"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An Option configures a session when it is created, in addition to the
+// options []string given to the constructor.
+//
+// All options are merged by key: first the options []string, in order, then
+// each Option, in order. When a key is given more than once the last value
+// wins, so an Option always overrides the options []string, and a later Option
+// overrides an earlier one. The merged options are sent sorted by key, so the
+// same settings always give the same SESSION CREATE line.
+//
+// The options []string are taken as they are, like they always were: those
+// that are not "key=value" are sent unchanged, after the others. Only Options
+// such as WithOptions check what they are given.
+type Option func(*sessionOptions) error
+
+// The settings that Options are applied to.
+type sessionOptions struct {
+	i2cp   map[string]string // I2CP- and streaminglib options
+	raw    []string          // options []string that are not "key=value"
+	params map[string]string // parameters of SESSION CREATE itself
+
+	udpListen   string   // host:port datagrams are received on, see WithDatagramForward
+	udpForward  string   // host:port the router sends datagrams to
+	ephemeral   bool     // see WithEphemeral
+	allowLegacy bool     // see AllowLegacy
+	keys        *I2PKeys // the keys of NewClient, see WithKeys
+	id          string   // the session id of NewClient and NewServer, see WithID
+
+	readBPS, writeBPS float64 // limits of accepted connections, see WithThrottle
+
+	datagramClose time.Duration // see WithDatagramClose, zero if not sent
+}
+
+// Merges options and opts, see Option.
+func applyOptions(options []string, opts []Option) (*sessionOptions, error) {
+	so := &sessionOptions{params: make(map[string]string)}
+	return so, so.apply(options, opts)
+}
+
+// Returns a copy of so with the given I2CP- and streaminglib options instead
+// of its own, and opts applied on top. Everything opts do not set, such as the
+// parameters of SESSION CREATE or WithEphemeral, is kept as it was.
+func (so *sessionOptions) update(options []string, opts []Option) (*sessionOptions, error) {
+	if so == nil {
+		return applyOptions(options, opts)
+	}
+	up := *so
+	up.params = make(map[string]string, len(so.params))
+	for k, v := range so.params {
+		up.params[k] = v
+	}
+	return &up, up.apply(options, opts)
+}
+
+// Sets the I2CP- and streaminglib options of so to options, and applies opts.
+func (so *sessionOptions) apply(options []string, opts []Option) error {
+	so.i2cp, so.raw = make(map[string]string), nil
+	for _, opt := range options {
+		if i := strings.Index(opt, "="); i > 0 {
+			so.i2cp[opt[:i]] = opt[i+1:]
+		} else if opt != "" {
+			so.raw = append(so.raw, opt)
+		}
+	}
+	for _, opt := range opts {
+		if err := opt(so); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the I2CP- and streaminglib options as "key=value", sorted by key,
+// followed by the options []string that are not.
+func (so *sessionOptions) options() []string {
+	return append(sortedPairs(so.i2cp), so.raw...)
+}
+
+// Returns the SESSION CREATE parameters as "KEY=VALUE", sorted by key,
+// followed by more.
+func (so *sessionOptions) extras(more ...string) []string {
+	return append(sortedPairs(so.params), more...)
+}
+
+func sortedPairs(m map[string]string) []string {
+	pairs := make([]string, 0, len(m))
+	for k, v := range m {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+// Checks that s can be used as an option key or value without breaking the
+// command it is sent in.
+func validToken(s string) bool {
+	return s != "" && !strings.ContainsAny(s, " \t\r\n")
+}
+
+// Checks that every extra argument of SESSION CREATE or SESSION ADD is a
+// single KEY=VALUE token: a KEY of at least one character, and a VALUE
+// without spaces, tabs, newlines or quotes, or else wrapped in double quotes,
+// in which case it may contain spaces and tabs, but no newlines or further
+// quotes. Anything else could end the command early or smuggle in another.
+func checkExtras(extras []string) error {
+	for _, extra := range extras {
+		if !validExtra(extra) {
+			return errors.New("Invalid session argument: " + strconv.Quote(extra))
+		}
+	}
+	return nil
+}
+
+func validExtra(extra string) bool {
+	i := strings.Index(extra, "=")
+	if i <= 0 || strings.ContainsAny(extra[:i], " \t\r\n\"") {
+		return false
+	}
+	v := extra[i+1:]
+	if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+		return !strings.ContainsAny(v[1:len(v)-1], "\r\n\"")
+	}
+	return !strings.ContainsAny(v, " \t\r\n\"")
+}
+
+// Adds arguments to SESSION CREATE (or SESSION ADD) that no other Option
+// covers, such as those of newer SAM versions, as KEY=VALUE tokens; see
+// checkExtras for what is accepted. This is an escape hatch: the arguments are
+// sent as they are, and the bridge decides what they mean.
+func WithExtras(extras ...string) Option {
+	return func(so *sessionOptions) error {
+		if err := checkExtras(extras); err != nil {
+			return err
+		}
+		for _, extra := range extras {
+			i := strings.Index(extra, "=")
+			so.params[extra[:i]] = extra[i+1:]
+		}
+		return nil
+	}
+}
+
+// Adds I2CP- or streaminglib options in the "key=value" format, such as
+// Options_Small.
+func WithOptions(options ...string) Option {
+	return func(so *sessionOptions) error {
+		for _, opt := range options {
+			i := strings.Index(opt, "=")
+			if i <= 0 || !validToken(opt) {
+				return errors.New("Invalid option: " + opt)
+			}
+			so.i2cp[opt[:i]] = opt[i+1:]
+		}
+		return nil
+	}
+}
+
+// Sets the number of hops of both inbound and outbound tunnels, 0-7. Every hop
+// adds anonymity, and latency.
+func WithTunnelLength(hops int) Option {
+	return func(so *sessionOptions) error {
+		if hops < 0 || hops > 7 {
+			return errors.New("Tunnel length needs to be in the interval 0-7")
+		}
+		so.i2cp["inbound.length"] = strconv.Itoa(hops)
+		so.i2cp["outbound.length"] = strconv.Itoa(hops)
+		return nil
+	}
+}
+
+// Sets the number of both inbound and outbound tunnels, 1-16. More tunnels
+// give more bandwidth and redundancy, at the cost of router resources.
+func WithTunnelQuantity(n int) Option {
+	return func(so *sessionOptions) error {
+		if n < 1 || n > 16 {
+			return errors.New("Tunnel quantity needs to be in the interval 1-16")
+		}
+		so.i2cp["inbound.quantity"] = strconv.Itoa(n)
+		so.i2cp["outbound.quantity"] = strconv.Itoa(n)
+		return nil
+	}
+}
+
+// Sets the name of the tunnels, as shown in the router console.
+func WithNickname(name string) Option {
+	return func(so *sessionOptions) error {
+		if !validToken(name) {
+			return errors.New("Invalid nickname: " + name)
+		}
+		so.i2cp["inbound.nickname"] = name
+		so.i2cp["outbound.nickname"] = name
+		return nil
+	}
+}
+
+// Sets the signature type (one of the Sig_* constants) of the destination. It
+// only has an effect when the bridge generates the destination, such as for
+// TRANSIENT destinations; otherwise it is decided by the keys. Sig_Best picks
+// the strongest type when the session is created.
+func WithSignatureType(sigType int) Option {
+	return func(so *sessionOptions) error {
+		if sigType < Sig_Best || sigType > 65535 {
+			return errors.New("Invalid signature type")
+		}
+		so.params["SIGNATURE_TYPE"] = strconv.Itoa(sigType)
+		return nil
+	}
+}
+
+// Sets the default I2P ports (SAM 3.2) that traffic is sent from and to.
+func WithPorts(from, to int) Option {
+	return func(so *sessionOptions) error {
+		if from < 0 || from > 65535 || to < 0 || to > 65535 {
+			return errors.New("Ports need to be in the interval 0-65535")
+		}
+		so.params["FROM_PORT"] = strconv.Itoa(from)
+		so.params["TO_PORT"] = strconv.Itoa(to)
+		return nil
+	}
+}
+
+// Makes the bridge put a header line, "FROM_PORT=n TO_PORT=n PROTOCOL=n", in
+// front of every datagram of a raw session (HEADER=true, SAM 3.2), so the
+// ports and protocol can be read with RawSession.ReadRawFull.
+func WithRawHeader() Option {
+	return func(so *sessionOptions) error {
+		so.params["HEADER"] = "true"
+		return nil
+	}
+}
+
+// Marks the session as ephemeral: its destination is meant to be used for a
+// while and then discarded. NewStreamSessionFromFile then never saves keys it
+// generated, and StreamSession.IsEphemeral reports it. Nothing is sent to the
+// bridge.
+func WithEphemeral() Option {
+	return func(so *sessionOptions) error {
+		so.ephemeral = true
+		return nil
+	}
+}
+
+// Makes a DatagramSession send DATAGRAM CLOSE on its control connection when
+// it is closed, and wait up to timeout (a second if zero) for the bridge to
+// acknowledge it, before closing the connection. This is for bridges that
+// expect it: the SAM specification defines no such command, so no SAM version
+// implies it, and bridges that do not know it answer with an error, which is
+// ignored, or not at all, which costs timeout. Either way the session is then
+// closed as usual.
+func WithDatagramClose(timeout time.Duration) Option {
+	return func(so *sessionOptions) error {
+		if timeout <= 0 {
+			timeout = closeTimeout
+		}
+		so.datagramClose = timeout
+		return nil
+	}
+}
+
+// Sets where the datagrams of a DATAGRAM or RAW session are delivered: the
+// session binds its UDP socket to listen, and the router is told to send
+// to forward (HOST= and PORT= of SESSION CREATE). Both are "host:port".
+//
+// In the common case forward is empty, and the router sends to the socket
+// bound to listen. A port of 0 in listen binds any free port, and an empty
+// host the interface the bridge is reached on, which is the default without
+// this option. A forward address is for when the router can not reach the
+// socket at its own address, such as with container networking, where listen
+// is the address inside the container and forward the one published to the
+// router; a port of 0 in forward stands for the bound port. Whether the router
+// can actually reach forward can not be checked from here: datagrams sent to
+// an unreachable address are silently lost.
+func WithDatagramForward(listen, forward string) Option {
+	return func(so *sessionOptions) error {
+		if _, err := splitUDPAddr(listen); err != nil {
+			return err
+		}
+		if forward != "" {
+			host, err := splitUDPAddr(forward)
+			if err != nil {
+				return err
+			}
+			if !validToken(host) {
+				return errors.New("Forward address needs a host")
+			}
+		}
+		so.udpListen, so.udpForward = listen, forward
+		return nil
+	}
+}
+
+// Splits a "host:port" of WithDatagramForward and validates the port.
+func splitUDPAddr(addr string) (host string, err error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return "", errors.New("Port needs to be in the interval 0-65535")
+	}
+	return host, nil
+}
+
+// Sets the I2P port a subsession receives on (LISTEN_PORT, SAM 3.3), 0-65535,
+// where 0 means any port. Subsessions of a MasterSession share its
+// destination; each incoming message goes to the subsession whose LISTEN_PORT
+// (and, for raw subsessions, LISTEN_PROTOCOL) matches it. Defaults to the
+// FROM_PORT of the subsession, see WithPorts. Stream subsessions only allow
+// their FROM_PORT or 0.
+func WithListenPort(port int) Option {
+	return func(so *sessionOptions) error {
+		if port < 0 || port > 65535 {
+			return errors.New("Listen port needs to be in the interval 0-65535")
+		}
+		so.params["LISTEN_PORT"] = strconv.Itoa(port)
+		return nil
+	}
+}
+
+// Sets the I2P protocol a raw subsession receives (LISTEN_PROTOCOL, SAM 3.3),
+// so several raw subsessions on one MasterSession each get only their own
+// protocol, see WithListenPort. 0 means any protocol; 6, 17, 19 and 20 are
+// used by streaming and datagrams and can not be listened to.
+func WithListenProtocol(proto int) Option {
+	return func(so *sessionOptions) error {
+		switch {
+		case proto < 0 || proto > 255:
+			return errors.New("Listen protocol needs to be in the interval 0-255")
+		case proto == 6 || proto == 17 || proto == 19 || proto == 20:
+			return errors.New("Listen protocol " + strconv.Itoa(proto) + " is reserved")
+		}
+		so.params["LISTEN_PROTOCOL"] = strconv.Itoa(proto)
+		return nil
+	}
+}
+
+// How hard the router tries to deliver messages, see WithMessageReliability.
+type MessageReliability int
+
+const (
+	// Send each message once and do not wait for it to be acknowledged. This
+	// is the default of the router.
+	ReliabilityBestEffort MessageReliability = iota
+	// Have the router wait for messages to be acknowledged end-to-end before
+	// telling the session they were sent.
+	ReliabilityGuaranteed
+	// Have the router not tell the session about the fate of messages at all.
+	ReliabilityNone
+)
+
+// Sets i2cp.messageReliability, for datagram and raw sessions. BestEffort has
+// the lowest latency and puts the least load on the tunnels, which suits fire
+// and forget traffic that the application retransmits by itself, if at all.
+// Guaranteed improves the odds of delivery, at the cost of latency and
+// tunnel capacity; note that recent Java routers treat it like BestEffort.
+// None also saves the router from reporting the status of every message.
+func WithMessageReliability(mode MessageReliability) Option {
+	return func(so *sessionOptions) error {
+		switch mode {
+		case ReliabilityBestEffort:
+			so.i2cp["i2cp.messageReliability"] = "BestEffort"
+		case ReliabilityGuaranteed:
+			so.i2cp["i2cp.messageReliability"] = "Guaranteed"
+		case ReliabilityNone:
+			so.i2cp["i2cp.messageReliability"] = "None"
+		default:
+			return errors.New("Unknown message reliability")
+		}
+		return nil
+	}
+}
+
+// Sets i2cp.recvQueueSize, for datagram sessions: how many inbound datagrams
+// the bridge buffers for the session before it starts dropping them. Real-time
+// applications may prefer a small queue, dropping stale datagrams early.
+func WithRecvQueueSize(n int) Option {
+	return func(so *sessionOptions) error {
+		if n < 1 {
+			return errors.New("Receive queue size needs to be positive")
+		}
+		so.i2cp["i2cp.recvQueueSize"] = strconv.Itoa(n)
+		return nil
+	}
+}
+
+// Sets i2cp.gzip, whether the router compresses the payloads of the session
+// at the I2CP layer. Routers do so by default. That helps with text such as
+// HTTP, but costs CPU for nothing, and adds latency, for data that is already
+// compressed or encrypted, such as media, archives or TLS; disable it for
+// sessions that mostly carry that.
+func WithGzip(enable bool) Option {
+	return func(so *sessionOptions) error {
+		so.i2cp["i2cp.gzip"] = strconv.FormatBool(enable)
+		return nil
+	}
+}
+
+// Whether the destinations of an access list are the only ones allowed to
+// connect, or the ones that are refused.
+type AccessListMode int
+
+const (
+	// Only the listed destinations may connect (i2cp.enableAccessList).
+	AccessListAllow AccessListMode = iota
+	// The listed destinations may not connect (i2cp.enableBlackList).
+	AccessListDeny
+)
+
+// Makes the router enforce an access list for incoming connections, which is
+// cheaper than accepting and then dropping connections yourself. The
+// destinations are hashed and sent as i2cp.accessList.
+func WithAccessList(dests []I2PAddr, mode AccessListMode) Option {
+	return func(so *sessionOptions) error {
+		hashes := make([]string, 0, len(dests))
+		for _, dest := range dests {
+			if _, err := NewI2PAddrFromString(string(dest)); err != nil {
+				return errors.New("Invalid destination in access list: " + err.Error())
+			}
+			b, err := dest.ToBytes()
+			if err != nil {
+				return err
+			}
+			hash := sha256.Sum256(b)
+			hashes = append(hashes, i2pB64enc.EncodeToString(hash[:]))
+		}
+		switch mode {
+		case AccessListAllow:
+			so.i2cp["i2cp.enableAccessList"] = "true"
+			delete(so.i2cp, "i2cp.enableBlackList")
+		case AccessListDeny:
+			so.i2cp["i2cp.enableBlackList"] = "true"
+			delete(so.i2cp, "i2cp.enableAccessList")
+		default:
+			return errors.New("Unknown access list mode")
+		}
+		so.i2cp["i2cp.accessList"] = strings.Join(hashes, ",")
+		return nil
+	}
+}
@@ -0,0 +1,125 @@
+package sam3
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// A syntactically valid, made up, destination.
+var testDest = I2PAddr(i2pB64enc.EncodeToString(make([]byte, 387)))
+
+func Test_WithAccessList(t *testing.T) {
+	so, err := applyOptions([]string{"inbound.length=1"}, []Option{WithAccessList([]I2PAddr{testDest, testDest}, AccessListDeny)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := so.options()
+	if len(options) != 3 || options[1] != "i2cp.enableBlackList=true" || options[2] != "inbound.length=1" {
+		t.Fatalf("unexpected options %v", options)
+	}
+	hashes := strings.Split(strings.TrimPrefix(options[0], "i2cp.accessList="), ",")
+	if len(hashes) != 2 || len(hashes[0]) != 44 {
+		t.Fatalf("unexpected access list %q", options[0])
+	}
+	if _, err := applyOptions(nil, []Option{WithAccessList([]I2PAddr{"nope"}, AccessListAllow)}); err == nil {
+		t.Fatal("accepted an invalid destination")
+	}
+}
+
+func Test_OptionsLastWins(t *testing.T) {
+	so, err := applyOptions(Options_Small, []Option{
+		WithTunnelLength(1),
+		WithNickname("test"),
+		WithTunnelLength(2),
+		WithPorts(80, 0),
+		WithSignatureType(Sig_EdDSA_SHA512_Ed25519),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(so.options(), " ")
+	want := "inbound.backupQuantity=0 inbound.length=2 inbound.lengthVariance=1 inbound.nickname=test inbound.quantity=1 " +
+		"outbound.backupQuantity=0 outbound.length=2 outbound.lengthVariance=1 outbound.nickname=test outbound.quantity=1"
+	if got != want {
+		t.Fatalf("got options\n%s\nwant\n%s", got, want)
+	}
+	if got := strings.Join(so.extras("PORT=1"), " "); got != "FROM_PORT=80 SIGNATURE_TYPE=7 TO_PORT=0 PORT=1" {
+		t.Fatalf("unexpected parameters %q", got)
+	}
+	for _, bad := range []Option{WithTunnelLength(8), WithTunnelQuantity(0), WithNickname("a b"), WithPorts(-1, 0), WithOptions("noequals"), WithOptions("a=b\nc")} {
+		if _, err := applyOptions(nil, []Option{bad}); err == nil {
+			t.Error("accepted an invalid option")
+		}
+	}
+	// the options []string are taken as they are
+	so, err = applyOptions([]string{"inbound.nickname=my tunnel", "noequals", "a=1"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(so.options(), ","); got != "a=1,inbound.nickname=my tunnel,noequals" {
+		t.Fatalf("unexpected options %q", got)
+	}
+}
+
+func Test_WithMessageReliability(t *testing.T) {
+	so, err := applyOptions(nil, []Option{WithMessageReliability(ReliabilityNone)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options := so.options(); len(options) != 1 || options[0] != "i2cp.messageReliability=None" {
+		t.Fatalf("unexpected options %v", options)
+	}
+	if _, err := applyOptions(nil, []Option{WithMessageReliability(MessageReliability(7))}); err == nil {
+		t.Fatal("accepted an unknown reliability")
+	}
+}
+
+func Test_WithGzip(t *testing.T) {
+	for _, enable := range []bool{true, false} {
+		so, err := applyOptions(nil, []Option{WithGzip(enable)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "i2cp.gzip=" + strconv.FormatBool(enable)
+		if options := so.options(); len(options) != 1 || options[0] != want {
+			t.Fatalf("expected [%s], got %v", want, options)
+		}
+	}
+}
+
+func Test_Extras(t *testing.T) {
+	for _, extra := range []string{"KEY=value", "KEY=", `KEY="two words"`, "i2cp.x=1=2"} {
+		if err := checkExtras([]string{extra}); err != nil {
+			t.Fatalf("expected %q to be accepted, got %v", extra, err)
+		}
+	}
+	for _, extra := range []string{"", "KEY", "=value", "KEY=two words", "KEY=a\nSESSION", `KEY="a"b"`, `KEY="a`, "K Y=1"} {
+		if err := checkExtras([]string{extra}); err == nil {
+			t.Fatalf("expected %q to be refused", extra)
+		}
+	}
+	mock := newMockSAM(t, mockOK)
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	if _, err := sam.newGenericSession(sam.closed, "STREAM", "extrasTun", NewKeys(I2PAddr("pub"), "pubpriv"), nil, []string{"KEY=a\nQUIT"}); err == nil {
+		t.Fatal("expected an injected extra to be refused")
+	}
+	ss, err := sam.NewStreamSession("extrasTun", NewKeys(I2PAddr("pub"), "pubpriv"), nil, WithExtras(`NICK="my tun"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	for _, cmd := range mock.Commands() {
+		if strings.Contains(cmd, "QUIT") {
+			t.Fatalf("the refused extra was sent: %q", cmd)
+		}
+	}
+	if cmds := mock.Commands(); !strings.HasSuffix(cmds[len(cmds)-1], ` NICK="my tun"`) {
+		t.Fatalf("unexpected SESSION CREATE %q", cmds[len(cmds)-1])
+	}
+}
@@ -0,0 +1,189 @@
+package sam3
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The payloads of loss probes and their acknowledgements, followed by the
+// sequence number of the probe.
+const (
+	probePrefix = "sam3 probe "
+	ackPrefix   = "sam3 ack "
+)
+
+// Estimates the packet loss, and the round trip time, of the path to a peer,
+// by sending it numbered probe datagrams and counting the acknowledgements.
+// The peer has to run AnswerProbes. The estimator reads every datagram
+// arriving at its session, so the session must be dedicated to it.
+type PacketLossEstimator struct {
+	session *DatagramSession
+
+	// How often to send a probe. Defaults to one second.
+	Interval time.Duration
+	// How long to wait for an acknowledgement before counting a probe as
+	// lost. Defaults to 30 seconds.
+	Timeout time.Duration
+	// How many of the latest probes the loss rate is computed from.
+	// Defaults to 100.
+	Window int
+
+	mu     sync.Mutex
+	seq    uint64
+	probes []probe // the latest probes, oldest first
+	srtt   time.Duration
+}
+
+type probe struct {
+	seq   uint64
+	sent  time.Time
+	acked bool
+}
+
+// Creates a PacketLossEstimator probing with session.
+func NewPacketLossEstimator(session *DatagramSession) *PacketLossEstimator {
+	return &PacketLossEstimator{
+		session:  session,
+		Interval: time.Second,
+		Timeout:  30 * time.Second,
+		Window:   100,
+	}
+}
+
+// Sends probes to peer, and reads the acknowledgements, until ctx is done or
+// reading from the session fails, such as when it is closed.
+func (e *PacketLossEstimator) Start(ctx context.Context, peer I2PAddr) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		defer cancel()
+		buf := make([]byte, 64)
+		for ctx.Err() == nil {
+			e.session.SetReadDeadline(time.Now().Add(e.Interval))
+			n, from, err := e.session.ReadFrom(buf)
+			if nerr, ok := err.(interface{ Timeout() bool }); ok && nerr.Timeout() {
+				continue
+			}
+			if err != nil {
+				return
+			}
+			if from != peer {
+				continue
+			}
+			if seq, ok := parseProbe(buf[:n], ackPrefix); ok {
+				e.acked(seq, time.Now())
+			}
+		}
+	}()
+	go func() {
+		ticker := time.NewTicker(e.Interval)
+		defer ticker.Stop()
+		for {
+			seq := e.sent(time.Now())
+			e.session.WriteTo([]byte(probePrefix+strconv.FormatUint(seq, 10)), peer)
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Returns the percentage (0-100) of the probes in the window that were not
+// acknowledged within Timeout. Probes still within Timeout are not counted.
+func (e *PacketLossEstimator) LossRate() float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	var settled, lost int
+	for _, p := range e.probes {
+		if !p.acked && now.Sub(p.sent) < e.Timeout {
+			continue
+		}
+		settled++
+		if !p.acked {
+			lost++
+		}
+	}
+	if settled == 0 {
+		return 0
+	}
+	return 100 * float64(lost) / float64(settled)
+}
+
+// Returns the smoothed round trip time of the probes, or zero if none has
+// been acknowledged yet.
+func (e *PacketLossEstimator) RTT() time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.srtt
+}
+
+// Records a probe sent at t, and returns its sequence number.
+func (e *PacketLossEstimator) sent(t time.Time) uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.seq++
+	e.probes = append(e.probes, probe{seq: e.seq, sent: t})
+	if len(e.probes) > e.Window {
+		e.probes = e.probes[len(e.probes)-e.Window:]
+	}
+	return e.seq
+}
+
+// Records the acknowledgement of probe seq, received at t.
+func (e *PacketLossEstimator) acked(seq uint64, t time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i := range e.probes {
+		p := &e.probes[i]
+		if p.seq != seq || p.acked {
+			continue
+		}
+		rtt := t.Sub(p.sent)
+		if rtt > e.Timeout {
+			return
+		}
+		p.acked = true
+		// like the smoothed round trip time of TCP
+		if e.srtt == 0 {
+			e.srtt = rtt
+		} else {
+			e.srtt += (rtt - e.srtt) / 8
+		}
+		return
+	}
+}
+
+// Answers the probes of PacketLossEstimators arriving at session, until ctx is
+// done or reading fails. Other datagrams are ignored.
+func AnswerProbes(ctx context.Context, session *DatagramSession) error {
+	buf := make([]byte, 64)
+	for ctx.Err() == nil {
+		session.SetReadDeadline(time.Now().Add(time.Second))
+		n, from, err := session.ReadFrom(buf)
+		if nerr, ok := err.(interface{ Timeout() bool }); ok && nerr.Timeout() {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if seq, ok := parseProbe(buf[:n], probePrefix); ok {
+			session.WriteTo([]byte(ackPrefix+strconv.FormatUint(seq, 10)), from)
+		}
+	}
+	return ctx.Err()
+}
+
+// Parses a probe or acknowledgement with the given prefix.
+func parseProbe(b []byte, prefix string) (uint64, bool) {
+	s := string(b)
+	if !strings.HasPrefix(s, prefix) {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(s[len(prefix):], 10, 64)
+	return seq, err == nil
+}
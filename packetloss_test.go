@@ -0,0 +1,102 @@
+package sam3
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_PacketLossEstimator(t *testing.T) {
+	e := NewPacketLossEstimator(nil)
+	e.Timeout = time.Minute
+	start := time.Now().Add(-2 * time.Minute)
+	for i := 0; i < 10; i++ {
+		seq := e.sent(start)
+		if i%5 != 0 {
+			e.acked(seq, start.Add(100*time.Millisecond))
+		}
+	}
+	// still within Timeout, so not counted as lost yet
+	e.sent(time.Now())
+	if rate := e.LossRate(); rate != 20 {
+		t.Fatalf("expected 20%% loss, got %v", rate)
+	}
+	if e.RTT() != 100*time.Millisecond {
+		t.Fatalf("unexpected round trip time %v", e.RTT())
+	}
+	if seq, ok := parseProbe([]byte(ackPrefix+"42"), ackPrefix); !ok || seq != 42 {
+		t.Fatalf("could not parse an acknowledgement: %d, %v", seq, ok)
+	}
+	if _, ok := parseProbe([]byte(probePrefix+"42"), ackPrefix); ok {
+		t.Fatal("took a probe for an acknowledgement")
+	}
+}
+
+func Test_PacketLossProbes(t *testing.T) {
+	loopback := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	bridge, err := net.ListenUDP("udp4", loopback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bridge.Close()
+	session := func(id string) *DatagramSession {
+		udpconn, err := net.ListenUDP("udp4", loopback)
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := bridge.LocalAddr().(*net.UDPAddr).Port
+		return &DatagramSession{id: id, udpconn: udpconn, rUDPAddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}, maxSize: 1024}
+	}
+	prober, answerer := session("prober"), session("answerer")
+	defer answerer.udpconn.Close()
+	// delivers what one session sends to the other, as sent from testDest
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, _, err := bridge.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			i := bytes.IndexByte(buf[:n], '\n')
+			to := prober
+			if bytes.HasPrefix(buf, []byte("3.0 prober ")) {
+				to = answerer
+			}
+			msg := append([]byte(string(testDest)+"\n"), buf[i+1:n]...)
+			bridge.WriteToUDP(msg, to.udpconn.LocalAddr().(*net.UDPAddr))
+		}
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	answered := make(chan error, 1)
+	go func() { answered <- AnswerProbes(ctx, answerer) }()
+
+	e := NewPacketLossEstimator(prober)
+	e.Interval = 10 * time.Millisecond
+	e.Start(ctx, testDest)
+	deadline := time.Now().Add(5 * time.Second)
+	for e.RTT() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no probe was acknowledged")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// the estimator stops once its session is closed, rather than spinning
+	prober.udpconn.Close()
+	time.Sleep(50 * time.Millisecond)
+	e.mu.Lock()
+	seq := e.seq
+	e.mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.seq != seq {
+		t.Fatalf("still probing after the session was closed: %d, then %d probes", seq, e.seq)
+	}
+	answerer.udpconn.Close()
+	if err := <-answered; err == nil || err == context.Canceled {
+		t.Fatalf("expected AnswerProbes to fail on the closed session, got %v", err)
+	}
+}
@@ -0,0 +1,23 @@
+package sam3
+
+import "strconv"
+
+// Returned when a reply of the SAM bridge could not be understood. Holds the
+// reply exactly as it was received, for debugging and bug reports against
+// bridges with quirky output.
+type ParseError struct {
+	Command string // the command that was answered, such as "NAMING LOOKUP"
+	Raw     []byte // the reply
+}
+
+// Returns a ParseError with a copy of raw, which may be a pooled buffer.
+func newParseError(command string, raw []byte) *ParseError {
+	return &ParseError{Command: command, Raw: append([]byte(nil), raw...)}
+}
+
+func (e *ParseError) Error() string {
+	if e.Command == "" {
+		return "Unable to parse SAMv3 reply: " + strconv.Quote(string(e.Raw))
+	}
+	return "Unable to parse SAMv3 reply to " + e.Command + ": " + strconv.Quote(string(e.Raw))
+}
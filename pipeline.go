@@ -0,0 +1,138 @@
+package sam3
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+)
+
+// Creates sessions by writing several commands to the SAM bridge at once, and
+// then reading the replies in order, instead of waiting for each reply before
+// sending the next command. This saves round trips, which matters when the
+// bridge is not on the local machine.
+type PipelinedSAM struct {
+	cfg Config
+}
+
+// Creates a new PipelinedSAM for the SAM bridge at address, connecting as
+// NewSAM does with the same opts.
+func NewPipelinedSAM(address string, opts ...SAMOption) *PipelinedSAM {
+	cfg := Config{Address: address}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return &PipelinedSAM{cfg: cfg.withDefaults()}
+}
+
+// Creates a session of the given style ("STREAM", "DATAGRAM" or "RAW") with a
+// brand new destination, in a single round trip: HELLO and SESSION CREATE are
+// sent together. Rather than DEST GENERATE, which can not be pipelined since
+// SESSION CREATE needs its reply, the session is created with a TRANSIENT
+// destination, whose keys the bridge returns in its reply. Returns the keys and
+// the connection controlling the session, which closes the session when closed.
+//
+// As SESSION CREATE is sent before the bridge has picked a SAM version, it is
+// built for the latest version asked for, Config.MaxVersion. If the bridge
+// picks one that does not know a parameter asked for, such as those of
+// WithPorts, a *VersionError is returned.
+func (p *PipelinedSAM) GenerateAndCreate(ctx context.Context, style, id string, options []string, opts ...Option) (I2PKeys, net.Conn, error) {
+	so, err := applyOptions(options, opts)
+	if err != nil {
+		return I2PKeys{}, nil, err
+	}
+	if strings.ContainsAny(p.cfg.User+p.cfg.Password, " \n") {
+		return I2PKeys{}, nil, errors.New("User and password may not contain spaces or newlines")
+	}
+	conn, err := p.cfg.dial(ctx)
+	if err != nil {
+		return I2PKeys{}, nil, err
+	}
+	stop := watchContext(ctx, conn)
+	keys, err := p.generateAndCreate(conn, style, id, so)
+	if stop() {
+		conn.Close()
+		return I2PKeys{}, nil, ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return I2PKeys{}, nil, err
+	}
+	return keys, conn, nil
+}
+
+func (p *PipelinedSAM) generateAndCreate(conn net.Conn, style, id string, so *sessionOptions) (I2PKeys, error) {
+	if err := checkExtras(so.extras()); err != nil {
+		return I2PKeys{}, err
+	}
+	hello, err := p.cfg.build(p.cfg.hello())
+	if err != nil {
+		return I2PKeys{}, err
+	}
+	create := commandOf(CmdSessionCreate).Set("STYLE", style).Set("ID", id).Set("DESTINATION", "TRANSIENT")
+	cmd, err := p.cfg.build(create.addOptions(so.options(), signatureParams(p.cfg.MaxVersion, so.extras(), true)))
+	if err != nil {
+		return I2PKeys{}, err
+	}
+	if _, err := conn.Write(append(hello, cmd...)); err != nil {
+		return I2PKeys{}, err
+	}
+	r := bufio.NewReader(conn)
+	var line string
+	for i := 0; i <= maxBannerLines && !isHelloReply(line); i++ {
+		if line, err = r.ReadString('\n'); err != nil {
+			return I2PKeys{}, err
+		}
+	}
+	version, err := p.cfg.parseHello([]byte(line))
+	if err != nil {
+		return I2PKeys{}, err
+	}
+	// the best signature type of an older version is what it uses anyway
+	if err := requireParams(version, signatureParams(version, so.extras(), false)); err != nil {
+		return I2PKeys{}, err
+	}
+	reply, err := r.ReadString('\n')
+	if err != nil {
+		return I2PKeys{}, err
+	}
+	if err := parseSessionReply([]byte(reply), TransientKeys()); err != nil {
+		return I2PKeys{}, err
+	}
+	// newer bridges may add more fields after the destination
+	parsed, err := parseSAMReply(reply)
+	if err != nil {
+		return I2PKeys{}, err
+	}
+	return sessionKeys(TransientKeys(), parsed)
+}
+
+// Returns the keys of priv, the base64 of a destination followed by its
+// private keys, as returned by the bridge.
+func keysFromPrivate(priv string) (I2PKeys, error) {
+	addr, err := destFromPrivate(priv)
+	if err != nil {
+		return I2PKeys{}, err
+	}
+	return NewKeys(addr, priv), nil
+}
+
+// Extracts the destination (the public part) from the base64 of a destination
+// followed by its private keys, as returned by the bridge. The destination is
+// 387 bytes plus the length of its certificate, which is stored in its last
+// two bytes.
+func destFromPrivate(priv string) (I2PAddr, error) {
+	b, err := i2pB64enc.DecodeString(priv)
+	if err != nil {
+		return I2PAddr(""), errors.New("Private keys are not base64-encoded")
+	}
+	if len(b) < 387 {
+		return I2PAddr(""), errors.New("Private keys are too short")
+	}
+	n := 387 + (int(b[385])<<8 | int(b[386]))
+	if len(b) < n {
+		return I2PAddr(""), errors.New("Private keys are too short")
+	}
+	return I2PAddr(i2pB64enc.EncodeToString(b[:n])), nil
+}
@@ -0,0 +1,115 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func Test_PipelinedGenerateAndCreate(t *testing.T) {
+	mock := newMockSAM(t, mockOK)
+	defer mock.Close()
+	keys, conn, err := NewPipelinedSAM(mock.Addr()).GenerateAndCreate(context.Background(), "STREAM", "pipeTun", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if keys.String() != testPrivKeys {
+		t.Fatal("returned keys differ from the ones the bridge sent")
+	}
+	if len(keys.Addr()) != 516 {
+		t.Fatalf("expected a 516 character destination, got %d", len(keys.Addr()))
+	}
+
+	// newer bridges add fields after the destination
+	mock2 := newMockSAM(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "SESSION CREATE") {
+			return "SESSION STATUS RESULT=OK DESTINATION=" + testPrivKeys + " MESSAGE=\"created\"\n"
+		}
+		return mockOK(cmd)
+	})
+	defer mock2.Close()
+	keys, conn2, err := NewPipelinedSAM(mock2.Addr()).GenerateAndCreate(context.Background(), "STREAM", "pipeTun", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+	if keys.String() != testPrivKeys {
+		t.Fatalf("unexpected keys %.20q", keys.String())
+	}
+}
+
+func Test_PipelinedConfig(t *testing.T) {
+	mock := newMockSAM(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "HELLO") && mockField(cmd, "MAX") == "3.3" {
+			return "HELLO REPLY RESULT=OK VERSION=3.3\n"
+		}
+		return mockOK(cmd)
+	})
+	defer mock.Close()
+	var dials int
+	dial := func(network, address string) (net.Conn, error) {
+		dials++
+		return net.Dial(network, address)
+	}
+	_, conn, err := NewPipelinedSAM(mock.Addr(), WithDialFunc(dial)).GenerateAndCreate(context.Background(), "STREAM", "pipeTun", nil, WithPorts(1, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	cmds := mock.Commands()
+	if dials != 1 || len(cmds) != 2 || cmds[0] != "HELLO VERSION MIN=3.0 MAX=3.3" {
+		t.Fatalf("unexpected dials %d, commands %q", dials, cmds)
+	}
+	if !strings.Contains(cmds[1], " SIGNATURE_TYPE=7") || !strings.Contains(cmds[1], " FROM_PORT=1 ") {
+		t.Fatalf("SESSION CREATE not built for SAM 3.3: %q", cmds[1])
+	}
+
+	// a bridge that picks an older version than the parameters need
+	old := newMockSAM(t, mockOK)
+	defer old.Close()
+	if _, _, err := NewPipelinedSAM(old.Addr()).GenerateAndCreate(context.Background(), "STREAM", "pipeTun", nil, WithPorts(1, 2)); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("expected ports to need SAM 3.2, got %v", err)
+	}
+}
+
+func BenchmarkPipelinedSessionCreate(b *testing.B) {
+	mock := newMockSAM(b, mockOK)
+	defer mock.Close()
+	p := NewPipelinedSAM(mock.Addr())
+	for i := 0; i < b.N; i++ {
+		_, conn, err := p.GenerateAndCreate(context.Background(), "STREAM", "benchTun", nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		conn.Close()
+	}
+}
+
+func BenchmarkSequentialSessionCreate(b *testing.B) {
+	mock := newMockSAM(b, func(cmd string) string {
+		if cmd == "DEST GENERATE" {
+			return "DEST REPLY PUB=pub PRIV=pubpriv\n"
+		}
+		return mockOK(cmd)
+	})
+	defer mock.Close()
+	for i := 0; i < b.N; i++ {
+		sam, err := NewSAM(mock.Addr())
+		if err != nil {
+			b.Fatal(err)
+		}
+		keys, err := sam.NewKeys()
+		if err != nil {
+			b.Fatal(err)
+		}
+		conn, err := sam.newGenericSession(sam.closed, "STREAM", "benchTun", keys, nil, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		conn.Close()
+		sam.Close()
+	}
+}
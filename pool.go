@@ -0,0 +1,270 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// The urgency of a PriorityPool.Get. Latency-sensitive operations (such as a
+// STREAM CONNECT) should use PriorityHigh, while bulk work (such as generating
+// keys) should use PriorityBackground.
+type Priority int
+
+const (
+	// Never waits: if no idle connection is available, a new one is opened
+	// right away, even if that temporarily takes the pool above its size.
+	PriorityHigh Priority = iota
+	// Waits for a connection, but is served before PriorityBackground.
+	PriorityNormal
+	// Waits for a connection behind every other priority.
+	PriorityBackground
+)
+
+// Returned by PriorityPool.Get after the pool has been closed.
+var ErrPoolClosed = errors.New("Pool is closed")
+
+// A pool of connections to the same SAM bridge, with priority lanes so that
+// urgent operations are never queued behind background work. Connections are
+// taken with Get() and must be handed back with Put() when done.
+type PriorityPool struct {
+	cfg Config
+
+	// How long Shrink() waits before closing the idle connections it removes.
+	// Defaults to 5 seconds.
+	DrainPeriod time.Duration
+	// How long each attempt of LookupReliable waits for the reply. Defaults
+	// to 15 seconds.
+	LookupTimeout time.Duration
+	// Receives a message for every retry of LookupReliable, if set.
+	Logger Logger
+	// How often the health monitor of Start checks the idle connections, and
+	// how long each check may take. Default to 30 and 5 seconds, which are
+	// also used if they are zero or negative.
+	HealthInterval time.Duration
+	HealthTimeout  time.Duration
+
+	mu      sync.Mutex
+	idle    []*SAM
+	waiters [2][]chan *SAM // PriorityNormal and PriorityBackground lanes
+	size    int            // the number of connections the pool aims to hold
+	open    int            // the number of connections currently open
+	closed  bool
+
+	healthy   int              // idle connections that passed the last check
+	unhealthy int              // connections that failed the last round
+	events    chan HealthEvent // made by the first call to Events
+}
+
+// Creates a new PriorityPool holding size connections to the SAM bridge at
+// address.
+func NewPriorityPool(address string, size int) (*PriorityPool, error) {
+	p := &PriorityPool{cfg: Config{Address: address}, DrainPeriod: 5 * time.Second, LookupTimeout: 15 * time.Second, HealthInterval: defaultHealthInterval, HealthTimeout: defaultHealthTimeout}
+	if err := p.Grow(size); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// Takes a connection from the pool. PriorityHigh gets a connection at once,
+// the other priorities wait their turn until a connection is free or ctx is
+// done. The connection must be returned with Put().
+func (p *PriorityPool) Get(ctx context.Context, prio Priority) (*SAM, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrPoolClosed
+	}
+	lane := int(prio - PriorityNormal)
+	if len(p.idle) > 0 && (prio == PriorityHigh || p.noWaitersBefore(lane)) {
+		sam := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mu.Unlock()
+		return sam, nil
+	}
+	if prio == PriorityHigh || p.open < p.size {
+		p.open++
+		p.mu.Unlock()
+		return p.dial()
+	}
+	if lane < 0 || lane >= len(p.waiters) {
+		p.mu.Unlock()
+		return nil, errors.New("Unknown priority")
+	}
+	c := make(chan *SAM, 1)
+	p.waiters[lane] = append(p.waiters[lane], c)
+	p.mu.Unlock()
+
+	select {
+	case sam, ok := <-c:
+		if !ok {
+			return nil, ErrPoolClosed
+		}
+		return sam, nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		p.removeWaiter(lane, c)
+		p.mu.Unlock()
+		// Put() might have handed over a connection before we got the lock.
+		select {
+		case sam, ok := <-c:
+			if ok {
+				p.Put(sam)
+			}
+		default:
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// Returns a connection taken with Get() to the pool, handing it to the most
+// urgent waiter, if any.
+func (p *PriorityPool) Put(sam *SAM) {
+	p.mu.Lock()
+	if !p.closed {
+		for lane := range p.waiters {
+			if len(p.waiters[lane]) > 0 {
+				c := p.waiters[lane][0]
+				p.waiters[lane] = p.waiters[lane][1:]
+				c <- sam
+				p.mu.Unlock()
+				return
+			}
+		}
+	}
+	if p.closed || p.open > p.size {
+		p.open--
+		p.mu.Unlock()
+		// closing may block, so not while holding p.mu
+		sam.Close()
+		return
+	}
+	p.idle = append(p.idle, sam)
+	p.mu.Unlock()
+}
+
+// Closes a connection taken with Get() instead of returning it, because it
+// is broken or in an unknown state. If anybody is waiting for a connection, a
+// new one is opened in its place.
+func (p *PriorityPool) Discard(sam *SAM) {
+	sam.Close()
+	p.mu.Lock()
+	p.open--
+	replace := !p.closed && !p.noWaitersBefore(len(p.waiters)-1)
+	if replace {
+		p.open++
+	}
+	p.mu.Unlock()
+	if replace {
+		go func() {
+			if sam, err := p.dial(); err == nil {
+				p.Put(sam)
+			}
+		}()
+	}
+}
+
+// Opens n more connections to the SAM bridge and adds them to the pool.
+func (p *PriorityPool) Grow(n int) error {
+	for i := 0; i < n; i++ {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return ErrPoolClosed
+		}
+		p.size++
+		p.open++
+		p.mu.Unlock()
+		sam, err := p.dial()
+		if err != nil {
+			p.mu.Lock()
+			p.size--
+			p.mu.Unlock()
+			return err
+		}
+		p.Put(sam)
+	}
+	return nil
+}
+
+// Removes n connections from the pool. Idle connections are taken out of the
+// pool at once and closed after DrainPeriod, giving the bridge time to finish
+// anything it was still sending. If there are not enough idle connections, busy
+// ones are closed when they are returned with Put().
+func (p *PriorityPool) Shrink(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if n > p.size {
+		n = p.size
+	}
+	p.size -= n
+	var drained []*SAM
+	for ; n > 0 && len(p.idle) > 0; n-- {
+		drained = append(drained, p.idle[len(p.idle)-1])
+		p.idle = p.idle[:len(p.idle)-1]
+		p.open--
+	}
+	if len(drained) > 0 {
+		time.AfterFunc(p.DrainPeriod, func() {
+			for _, sam := range drained {
+				sam.Close()
+			}
+		})
+	}
+}
+
+// Closes all idle connections and makes the pool unusable. Connections that
+// are in use are closed when they are returned.
+func (p *PriorityPool) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	idle := p.idle
+	p.open -= len(idle)
+	p.idle = nil
+	for lane := range p.waiters {
+		for _, c := range p.waiters[lane] {
+			close(c)
+		}
+		p.waiters[lane] = nil
+	}
+	p.mu.Unlock()
+	var err error
+	for _, sam := range idle {
+		if err2 := sam.Close(); err2 != nil {
+			err = err2
+		}
+	}
+	return err
+}
+
+func (p *PriorityPool) dial() (*SAM, error) {
+	sam, err := NewSAMConfig(p.cfg)
+	if err != nil {
+		p.mu.Lock()
+		p.open--
+		p.mu.Unlock()
+		return nil, err
+	}
+	return sam, nil
+}
+
+// Reports whether nobody is waiting in lane or in any lane more urgent than it.
+func (p *PriorityPool) noWaitersBefore(lane int) bool {
+	for i := 0; i <= lane && i < len(p.waiters); i++ {
+		if len(p.waiters[i]) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *PriorityPool) removeWaiter(lane int, c chan *SAM) {
+	for i, w := range p.waiters[lane] {
+		if w == c {
+			p.waiters[lane] = append(p.waiters[lane][:i], p.waiters[lane][i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package sam3
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_PoolPriority(t *testing.T) {
+	mock := newMockSAM(t, nil)
+	defer mock.Close()
+	pool, err := NewPriorityPool(mock.Addr(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	busy, err := pool.Get(context.Background(), PriorityNormal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	low := make(chan *SAM)
+	go func() {
+		sam, err := pool.Get(context.Background(), PriorityBackground)
+		if err != nil {
+			t.Error(err)
+		}
+		low <- sam
+	}()
+	time.Sleep(50 * time.Millisecond) // let the background Get queue up
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	high, err := pool.Get(ctx, PriorityHigh)
+	if err != nil {
+		t.Fatalf("high priority Get was queued: %v", err)
+	}
+	select {
+	case <-low:
+		t.Fatal("background Get was served before a connection was returned")
+	default:
+	}
+
+	pool.Put(busy)
+	select {
+	case sam := <-low:
+		pool.Put(sam)
+	case <-time.After(time.Second):
+		t.Fatal("background Get was not served after Put")
+	}
+	pool.Put(high)
+}
+
+func Test_PoolGetContext(t *testing.T) {
+	mock := newMockSAM(t, nil)
+	defer mock.Close()
+	pool, err := NewPriorityPool(mock.Addr(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	busy, err := pool.Get(context.Background(), PriorityNormal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Put(busy)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := pool.Get(ctx, PriorityBackground); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func Test_PoolHealth(t *testing.T) {
+	mock := newMockSAM(t, mockLookup)
+	defer mock.Close()
+	p, err := NewPriorityPool(mock.Addr(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	p.HealthInterval = 20 * time.Millisecond
+	p.HealthTimeout = time.Second
+	events := p.Events()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p.Start(ctx)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for p.HealthyCount() != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 2 healthy connections, got %d", p.HealthyCount())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	mock.CloseConns()
+	for i := 0; i < 2; i++ {
+		select {
+		case ev := <-events:
+			if ev.Err == nil {
+				t.Fatal("expected the event to carry the error")
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("dead connections were not reported")
+		}
+	}
+	// the replacements are healthy
+	sam, err := p.Get(ctx, PriorityNormal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Put(sam)
+	if _, err := sam.Lookup("known.i2p"); err != nil {
+		t.Fatalf("expected a working connection from the pool, got %v", err)
+	}
+}
+
+func Test_PoolHealthDefaults(t *testing.T) {
+	mock := newMockSAM(t, mockLookup)
+	defer mock.Close()
+	p, err := NewPriorityPool(mock.Addr(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	// zero or negative settings mean the defaults, rather than a panic or
+	// checks without a timeout
+	p.HealthInterval, p.HealthTimeout = 0, -time.Second
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p.Start(ctx)
+	if !p.checkIdle() || p.HealthyCount() != 1 {
+		t.Fatalf("expected 1 healthy connection, got %d", p.HealthyCount())
+	}
+}
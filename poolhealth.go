@@ -0,0 +1,140 @@
+package sam3
+
+import (
+	"context"
+	"time"
+)
+
+// Sent on PriorityPool.Events when the health monitor found an idle connection
+// dead and removed it from the pool.
+type HealthEvent struct {
+	Timestamp time.Time
+	Err       error // why the check failed
+}
+
+// The defaults of PriorityPool.HealthInterval and PriorityPool.HealthTimeout.
+const (
+	defaultHealthInterval = 30 * time.Second
+	defaultHealthTimeout  = 5 * time.Second
+)
+
+// Starts the health monitor of the pool, which checks every idle connection
+// each HealthInterval with a NAMING LOOKUP NAME=ME, and removes those that do
+// not answer within HealthTimeout, opening new ones in their place. Without
+// it, a connection the bridge dropped while it was idle is only noticed by
+// whoever gets it next, as a failed request. The monitor stops when ctx is
+// done or the pool is closed.
+func (p *PriorityPool) Start(ctx context.Context) {
+	interval := p.HealthInterval
+	if interval <= 0 {
+		interval = defaultHealthInterval
+	}
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			if !p.checkIdle() {
+				return
+			}
+		}
+	}()
+}
+
+// Checks every connection that is idle at the start, one at a time, so the
+// others stay available. Returns false once the pool is closed.
+func (p *PriorityPool) checkIdle() bool {
+	timeout := p.HealthTimeout
+	if timeout <= 0 {
+		timeout = defaultHealthTimeout
+	}
+	p.mu.Lock()
+	n := len(p.idle)
+	p.mu.Unlock()
+	healthy, unhealthy := 0, 0
+	for i := 0; i < n; i++ {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return false
+		}
+		if len(p.idle) == 0 {
+			p.mu.Unlock()
+			break
+		}
+		// the oldest first; Put returns connections to the end
+		sam := p.idle[0]
+		p.idle = p.idle[1:]
+		p.mu.Unlock()
+
+		if err := sam.checkAlive(LookupKeepalive{}, timeout); err != nil {
+			unhealthy++
+			p.logf("sam3: removing dead connection from pool: %v", err)
+			p.emit(HealthEvent{Timestamp: time.Now(), Err: err})
+			p.replace(sam)
+			continue
+		}
+		healthy++
+		p.Put(sam)
+	}
+	p.mu.Lock()
+	p.healthy, p.unhealthy = healthy, unhealthy
+	p.mu.Unlock()
+	return true
+}
+
+// Closes a dead idle connection and opens a new one in its place.
+func (p *PriorityPool) replace(sam *SAM) {
+	sam.Close()
+	p.mu.Lock()
+	if p.closed {
+		p.open--
+		p.mu.Unlock()
+		return
+	}
+	p.mu.Unlock()
+	go func() {
+		// dial takes the connection off open if it fails
+		if sam, err := p.dial(); err == nil {
+			p.Put(sam)
+		}
+	}()
+}
+
+// Returns the number of idle connections that passed the last health check.
+func (p *PriorityPool) HealthyCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.healthy
+}
+
+// Returns the number of connections that failed the last health check.
+func (p *PriorityPool) UnhealthyCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.unhealthy
+}
+
+// Returns the channel HealthEvents are sent on. Events are dropped if the
+// channel is not read.
+func (p *PriorityPool) Events() <-chan HealthEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.events == nil {
+		p.events = make(chan HealthEvent, 16)
+	}
+	return p.events
+}
+
+func (p *PriorityPool) emit(ev HealthEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case p.events <- ev:
+	default:
+	}
+}
@@ -0,0 +1,62 @@
+package sam3
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// The lengths of signing private keys, by signature type.
+var sigPrivKeyLen = map[int]int{
+	Sig_DSA_SHA1:              20,
+	Sig_ECDSA_SHA256_P256:     32,
+	Sig_ECDSA_SHA384_P384:     48,
+	Sig_ECDSA_SHA512_P521:     66,
+	Sig_EdDSA_SHA512_Ed25519:  32,
+	Sig_RedDSA_SHA512_Ed25519: 32,
+}
+
+// The lengths of encryption private keys, by crypto type.
+var cryptoPrivKeyLen = map[int]int{
+	Crypto_ElGamal:      256,
+	Crypto_ECIES_X25519: 32,
+}
+
+// Reads keys in the binary private key file format of the I2P routers (such
+// as eepPriv.dat of the Java router, or the .dat key files of i2pd): the
+// destination, followed by the encryption and the signing private keys. This
+// is the same blob SAM transfers in base64, so anything after the keys, such
+// as an offline signature, is kept.
+func ReadPrivateKeyFile(r io.Reader) (I2PKeys, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return I2PKeys{}, err
+	}
+	n, sigType, cryptoType, err := parseDestination(b)
+	if err != nil {
+		return I2PKeys{}, err
+	}
+	sigLen, ok := sigPrivKeyLen[sigType]
+	if !ok {
+		return I2PKeys{}, errors.New("Unknown signature type in private key file")
+	}
+	cryptoLen, ok := cryptoPrivKeyLen[cryptoType]
+	if !ok {
+		return I2PKeys{}, errors.New("Unknown crypto type in private key file")
+	}
+	if len(b) < n+cryptoLen+sigLen {
+		return I2PKeys{}, errors.New("Private key file too short")
+	}
+	return NewKeys(I2PAddr(i2pB64enc.EncodeToString(b[:n])), i2pB64enc.EncodeToString(b)), nil
+}
+
+// Writes keys to w in the binary private key file format of the I2P routers,
+// see ReadPrivateKeyFile.
+func WritePrivateKeyFile(w io.Writer, keys I2PKeys) error {
+	b, err := i2pB64enc.DecodeString(keys.String())
+	if err != nil {
+		return errors.New("Keys are not base64-encoded")
+	}
+	_, err = w.Write(b)
+	return err
+}
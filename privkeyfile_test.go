@@ -0,0 +1,32 @@
+package sam3
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_PrivateKeyFile(t *testing.T) {
+	keys, err := GenerateKeysFromSeed([]byte("key file"), Sig_EdDSA_SHA512_Ed25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WritePrivateKeyFile(&buf, keys); err != nil {
+		t.Fatal(err)
+	}
+	// destination, ElGamal and Ed25519 private keys
+	if buf.Len() != 391+256+32 {
+		t.Fatalf("unexpected file length %d", buf.Len())
+	}
+	file := buf.Bytes()
+	got, err := ReadPrivateKeyFile(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != keys {
+		t.Fatal("keys changed in the round trip")
+	}
+	if _, err := ReadPrivateKeyFile(bytes.NewReader(file[:len(file)-1])); err == nil {
+		t.Fatal("accepted a truncated file")
+	}
+}
@@ -0,0 +1,252 @@
+package sam3
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Decides what to do with a command a client of a SAMProxy sent, without the
+// trailing newline. Returns the command to forward to the SAM bridge, which
+// may be rewritten. If that is empty, reply is sent to the client instead, as
+// if the bridge had answered. Returns an error to refuse the command; the
+// error is then sent to the client in place of a reply from the bridge.
+type ProxyPolicy func(cmd string) (forward string, reply string, err error)
+
+// A SAM bridge in front of a SAM bridge. Programs connect to a SAMProxy as if
+// it was the bridge, and their commands are checked by a ProxyPolicy before
+// they are forwarded to the real one, so security policies can be enforced on
+// programs that are not written in Go, or not trusted.
+//
+// Once a STREAM CONNECT, ACCEPT or FORWARD command has been forwarded, the
+// connection carries data rather than commands, and is passed through as it
+// is.
+type SAMProxy struct {
+	address string // the real SAM bridge
+
+	mu       sync.Mutex
+	policies []ProxyPolicy
+	listener net.Listener
+}
+
+// Creates a SAMProxy forwarding to the SAM bridge at address. Without a
+// policy, every command is forwarded.
+func NewSAMProxy(address string) *SAMProxy {
+	return &SAMProxy{address: address}
+}
+
+// Adds a policy that commands need to pass. Policies are applied in the order
+// they were added, each seeing the command as rewritten by the previous ones.
+// Returns p.
+func (p *SAMProxy) WithPolicy(policy ProxyPolicy) *SAMProxy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.policies = append(p.policies, policy)
+	return p
+}
+
+// Listens on the TCP address addr and serves clients until Close is called.
+func (p *SAMProxy) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return p.Serve(l)
+}
+
+// Serves clients connecting to l until Close is called. Always returns a
+// non-nil error.
+func (p *SAMProxy) Serve(l net.Listener) error {
+	p.mu.Lock()
+	p.listener = l
+	p.mu.Unlock()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go p.serve(conn)
+	}
+}
+
+// Stops accepting clients. Connections already proxied are not affected.
+func (p *SAMProxy) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.listener == nil {
+		return nil
+	}
+	return p.listener.Close()
+}
+
+func (p *SAMProxy) serve(client net.Conn) {
+	defer client.Close()
+	bridge, err := net.Dial("tcp", p.address)
+	if err != nil {
+		return
+	}
+	defer bridge.Close()
+	// Replies are passed through as they are, but refusals are written by
+	// this side too, so writes to the client need to be serialized.
+	var wmu sync.Mutex
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := bridge.Read(buf)
+			if n > 0 {
+				wmu.Lock()
+				_, werr := client.Write(buf[:n])
+				wmu.Unlock()
+				if werr != nil {
+					break
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+		client.Close()
+	}()
+	r := bufio.NewReader(client)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd, reply, err := p.check(strings.TrimRight(line, "\r\n"))
+		if err != nil {
+			reply = refusal(line, err)
+		}
+		if cmd == "" {
+			wmu.Lock()
+			_, err = client.Write([]byte(reply))
+			wmu.Unlock()
+			if err != nil {
+				return
+			}
+			continue
+		}
+		if _, err := bridge.Write([]byte(cmd + "\n")); err != nil {
+			return
+		}
+		if isStreamCommand(cmd) {
+			// what was buffered already belongs to the stream
+			if _, err := r.WriteTo(bridge); err != nil {
+				return
+			}
+			return
+		}
+	}
+}
+
+// Runs cmd through the policies, returning what to forward or what to reply.
+func (p *SAMProxy) check(cmd string) (string, string, error) {
+	p.mu.Lock()
+	policies := p.policies
+	p.mu.Unlock()
+	for _, policy := range policies {
+		forward, reply, err := policy(cmd)
+		if err != nil {
+			return "", "", err
+		}
+		if forward == "" {
+			if !strings.HasSuffix(reply, "\n") {
+				reply += "\n"
+			}
+			return "", reply, nil
+		}
+		if strings.ContainsAny(forward, "\r\n") {
+			return "", "", errors.New("Policy produced a command with a newline")
+		}
+		cmd = forward
+	}
+	return cmd, "", nil
+}
+
+// Reports whether cmd turns the connection into a stream.
+func isStreamCommand(cmd string) bool {
+	c, _ := policyCommand(cmd, CmdStreamConnect, CmdStreamAccept, CmdStreamForward)
+	return c != nil
+}
+
+// Parses cmd for a policy. Returns the command if its "TOPIC TYPE", in any
+// case, is one of kinds, such as CmdSessionCreate, and nil otherwise. A
+// command that can not be parsed is refused if its first word is the topic of
+// one of kinds, as the bridge may read it differently than the policy would.
+func policyCommand(cmd string, kinds ...string) (*Command, error) {
+	c, err := ParseCommand(cmd)
+	if err != nil {
+		words := strings.Fields(cmd)
+		for _, kind := range kinds {
+			if len(words) > 0 && strings.EqualFold(words[0], strings.Fields(kind)[0]) {
+				return nil, err
+			}
+		}
+		return nil, nil
+	}
+	for _, kind := range kinds {
+		if strings.EqualFold(c.Topic+" "+c.Type, kind) {
+			return c, nil
+		}
+	}
+	return nil, nil
+}
+
+// Returns the reply telling the client that cmd was refused because of err, in
+// the format the reply to cmd would have had.
+func refusal(cmd string, err error) string {
+	fields := strings.Fields(cmd)
+	verb := "SESSION"
+	if len(fields) > 0 {
+		verb = fields[0]
+	}
+	kind := "STATUS"
+	switch verb {
+	case "HELLO", "NAMING", "DEST":
+		kind = "REPLY"
+	}
+	msg := strings.Replace(err.Error(), "\"", "'", -1)
+	return verb + " " + kind + " RESULT=I2P_ERROR MESSAGE=\"" + msg + "\"\n"
+}
+
+// Returns a ProxyPolicy refusing SESSION CREATE and SESSION ADD for the given
+// session ids. Commands are parsed, so quoting the id or spacing the command
+// differently does not get past it.
+func BlockSessionIDs(ids ...string) ProxyPolicy {
+	blocked := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		blocked[id] = true
+	}
+	return func(cmd string) (string, string, error) {
+		c, err := policyCommand(cmd, CmdSessionCreate, CmdSessionAdd)
+		if c == nil {
+			return cmd, "", err
+		}
+		for _, id := range c.Values("ID") {
+			if blocked[id] {
+				return "", "", errors.New("Session id " + id + " is not allowed")
+			}
+		}
+		return cmd, "", nil
+	}
+}
+
+// Returns a ProxyPolicy answering NAMING LOOKUP for the names in rewrites
+// with the destinations they map to, instead of asking the bridge. Other
+// names are looked up as usual.
+func RewriteLookups(rewrites map[string]I2PAddr) ProxyPolicy {
+	return func(cmd string) (string, string, error) {
+		c, err := policyCommand(cmd, CmdNamingLookup)
+		if c == nil {
+			return cmd, "", err
+		}
+		name, _ := c.Get("NAME")
+		if dest, ok := rewrites[name]; ok {
+			reply := commandOf(CmdNamingReply).Set("RESULT", ResultOK).Set("NAME", name).Set("VALUE", string(dest))
+			return "", reply.String(), nil
+		}
+		return cmd, "", nil
+	}
+}
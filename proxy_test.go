@@ -0,0 +1,79 @@
+package sam3
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func Test_SAMProxy(t *testing.T) {
+	mock := newMockSAM(t, func(cmd string) string {
+		if r := mockLookup(cmd); r != "" {
+			return r
+		}
+		return mockOK(cmd)
+	})
+	defer mock.Close()
+	proxy := NewSAMProxy(mock.Addr()).
+		WithPolicy(BlockSessionIDs("forbidden")).
+		WithPolicy(RewriteLookups(map[string]I2PAddr{"alias.i2p": testDest}))
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go proxy.Serve(l)
+	defer proxy.Close()
+
+	sam, err := NewSAM(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	if addr, err := sam.Lookup("alias.i2p"); err != nil || addr != testDest {
+		t.Fatalf("rewritten lookup returned %q, %v", addr, err)
+	}
+	if addr, err := sam.Lookup("known.i2p"); err != nil || addr != testDest {
+		t.Fatalf("forwarded lookup returned %q, %v", addr, err)
+	}
+	keys := NewKeys(I2PAddr("pub"), "pubpriv")
+	if _, err := sam.NewStreamSession("forbidden", keys, nil); err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Fatalf("expected the session to be refused, got %v", err)
+	}
+	for _, cmd := range mock.Commands() {
+		if strings.Contains(cmd, "alias.i2p") || strings.Contains(cmd, "ID=forbidden") {
+			t.Fatalf("%q reached the bridge", cmd)
+		}
+	}
+}
+
+func Test_ProxyPolicyParsing(t *testing.T) {
+	block := BlockSessionIDs("blocked")
+	for _, cmd := range []string{
+		"SESSION CREATE STYLE=STREAM ID=blocked DESTINATION=TRANSIENT",
+		"SESSION CREATE STYLE=STREAM ID=\"blocked\" DESTINATION=TRANSIENT",
+		"SESSION  CREATE  STYLE=STREAM  ID=blocked",
+		"SESSION\tADD STYLE=STREAM\tID=blocked",
+		"session create STYLE=STREAM ID=blocked",
+		"SESSION CREATE STYLE=STREAM ID=\"blocked",
+	} {
+		if _, _, err := block(cmd); err == nil {
+			t.Errorf("%q got past the block", cmd)
+		}
+	}
+	for _, cmd := range []string{"SESSION CREATE STYLE=STREAM ID=allowed DESTINATION=TRANSIENT", "NAMING LOOKUP NAME=blocked"} {
+		if forward, _, err := block(cmd); err != nil || forward != cmd {
+			t.Errorf("%q was not forwarded: %q, %v", cmd, forward, err)
+		}
+	}
+
+	rewrite := RewriteLookups(map[string]I2PAddr{"alias.i2p": testDest})
+	for _, cmd := range []string{"NAMING LOOKUP NAME=\"alias.i2p\"", "NAMING\tLOOKUP  NAME=alias.i2p", "naming lookup NAME=alias.i2p"} {
+		forward, reply, err := rewrite(cmd)
+		if err != nil || forward != "" || reply != "NAMING REPLY RESULT=OK NAME=alias.i2p VALUE="+string(testDest)+"\n" {
+			t.Errorf("%q was not rewritten: %q, %q, %v", cmd, forward, reply, err)
+		}
+	}
+	if !isStreamCommand("stream\tconnect ID=tun DESTINATION=" + string(testDest)) {
+		t.Error("STREAM CONNECT not recognized")
+	}
+}
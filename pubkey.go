@@ -0,0 +1,69 @@
+package sam3
+
+import "errors"
+
+// The lengths of signing public keys, by signature type. Keys up to 128
+// bytes are right-aligned in the signing public key field of a destination;
+// the rest of longer keys follows the types in the key certificate.
+var sigPubKeyLen = map[int]int{
+	Sig_DSA_SHA1:              128,
+	Sig_ECDSA_SHA256_P256:     64,
+	Sig_ECDSA_SHA384_P384:     96,
+	Sig_ECDSA_SHA512_P521:     132,
+	Sig_EdDSA_SHA512_Ed25519:  32,
+	Sig_RedDSA_SHA512_Ed25519: 32,
+}
+
+// The lengths of encryption public keys, by crypto type. They are
+// left-aligned in the encryption public key field of a destination.
+var cryptoPubKeyLen = map[int]int{
+	Crypto_ElGamal:      256,
+	Crypto_ECIES_X25519: 32,
+}
+
+// Returns the raw encryption public key of the destination: 256 bytes for
+// ElGamal, 32 for ECIES-X25519. For applications implementing their own
+// protocols on top of I2P; see DeriveSharedSecret for X25519 key agreement.
+// Other crypto types give ErrIncompatibleKeyType.
+func (addr I2PAddr) EncryptionPublicKey() ([]byte, error) {
+	b, err := addr.ToBytes()
+	if err != nil {
+		return nil, err
+	}
+	_, _, cryptoType, err := parseDestination(b)
+	if err != nil {
+		return nil, err
+	}
+	n, ok := cryptoPubKeyLen[cryptoType]
+	if !ok {
+		return nil, ErrIncompatibleKeyType
+	}
+	return b[:n], nil
+}
+
+// Returns the raw signing public key of the destination, such as 32 bytes for
+// Ed25519 or 128 for DSA-SHA1. RSA keys give ErrIncompatibleKeyType.
+func (addr I2PAddr) SigningPublicKey() ([]byte, error) {
+	b, err := addr.ToBytes()
+	if err != nil {
+		return nil, err
+	}
+	_, sigType, _, err := parseDestination(b)
+	if err != nil {
+		return nil, err
+	}
+	n, ok := sigPubKeyLen[sigType]
+	if !ok {
+		return nil, ErrIncompatibleKeyType
+	}
+	if n <= destSignKeyLen {
+		return b[destCertOffset-n : destCertOffset], nil
+	}
+	// the excess follows the two types in the key certificate
+	excess := n - destSignKeyLen
+	if len(b) < destCertOffset+7+excess {
+		return nil, errors.New("Malformed key certificate")
+	}
+	key := append([]byte(nil), b[destPubKeyLen:destCertOffset]...)
+	return append(key, b[destCertOffset+7:destCertOffset+7+excess]...), nil
+}
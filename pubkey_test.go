@@ -0,0 +1,55 @@
+package sam3
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"testing"
+)
+
+func Test_PublicKeys(t *testing.T) {
+	// a DSA/ElGamal destination, without a key certificate
+	dsa := make([]byte, 387)
+	for i := range dsa[:destCertOffset] {
+		dsa[i] = byte(i)
+	}
+	addr := I2PAddr(i2pB64enc.EncodeToString(dsa))
+	enc, err := addr.EncryptionPublicKey()
+	if err != nil || !bytes.Equal(enc, dsa[:256]) {
+		t.Fatalf("ElGamal key %x, %v", enc, err)
+	}
+	sig, err := addr.SigningPublicKey()
+	if err != nil || !bytes.Equal(sig, dsa[256:384]) {
+		t.Fatalf("DSA key %x, %v", sig, err)
+	}
+
+	// the Ed25519 key derived from the seed by crypto/ed25519
+	keys, _ := GenerateKeysFromSeed([]byte("pubkey"), Sig_EdDSA_SHA512_Ed25519)
+	priv, err := keys.ed25519PrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err = keys.Addr().SigningPublicKey()
+	if err != nil || !bytes.Equal(sig, priv.Public().(ed25519.PublicKey)) {
+		t.Fatalf("Ed25519 key %x, %v", sig, err)
+	}
+
+	// ECIES-X25519 with a P521 signing key, whose last 4 bytes are in the
+	// key certificate
+	x := testX25519Keys(t)
+	b, _ := x.Addr().ToBytes()
+	p521 := append([]byte(nil), b[:destCertOffset]...)
+	p521 = append(p521, cert_KEY, 0, 8)
+	p521 = binary.BigEndian.AppendUint16(p521, Sig_ECDSA_SHA512_P521)
+	p521 = binary.BigEndian.AppendUint16(p521, Crypto_ECIES_X25519)
+	p521 = append(p521, 0xa, 0xb, 0xc, 0xd)
+	addr = I2PAddr(i2pB64enc.EncodeToString(p521))
+	enc, err = addr.EncryptionPublicKey()
+	if err != nil || !bytes.Equal(enc, b[:32]) {
+		t.Fatalf("X25519 key %x, %v", enc, err)
+	}
+	sig, err = addr.SigningPublicKey()
+	if err != nil || len(sig) != 132 || !bytes.Equal(sig[:128], b[256:384]) || !bytes.Equal(sig[128:], []byte{0xa, 0xb, 0xc, 0xd}) {
+		t.Fatalf("P521 key %x, %v", sig, err)
+	}
+}
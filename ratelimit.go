@@ -0,0 +1,181 @@
+package sam3
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Returned by DatagramSession.WriteTo when sending would exceed the rate limit
+// set with SetRateLimit, unless the limit blocks.
+var ErrRateLimited = errors.New("Rate limited")
+
+// A limit on how fast a DatagramSession sends, see SetRateLimit.
+type RateLimit struct {
+	Messages float64 // datagrams per second, zero for no limit
+	Bytes    float64 // bytes of payload per second, zero for no limit
+	// Makes WriteTo wait until the datagram may be sent, rather than
+	// return ErrRateLimited.
+	Block bool
+}
+
+// A token bucket refilled at rate tokens per second, holding a second's worth
+// of them at most, so short bursts are allowed.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	burst := rate
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// Adds the tokens earned since the last call. b.mu must be held.
+func (b *tokenBucket) refill() {
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// Takes n tokens if there are enough. More than the bucket holds counts as a
+// full bucket, so nothing is refused forever.
+func (b *tokenBucket) allow(n float64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	if n > b.burst {
+		n = b.burst
+	}
+	if b.tokens < n {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+// Gives back n tokens taken with allow.
+func (b *tokenBucket) refund(n float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if n > b.burst {
+		n = b.burst
+	}
+	b.tokens += n
+}
+
+// Takes n tokens, running into debt if there are not enough, and returns how
+// long to wait until the debt is paid off.
+func (b *tokenBucket) reserve(n float64) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	if n > b.burst {
+		n = b.burst
+	}
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// Returns how long until n tokens are available, without taking them.
+func (b *tokenBucket) until(n float64) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	if n > b.burst {
+		n = b.burst
+	}
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+// Enforces a RateLimit.
+type rateLimiter struct {
+	msgs  *tokenBucket // nil for no limit
+	bytes *tokenBucket // nil for no limit
+	block bool
+}
+
+func newRateLimiter(l RateLimit) *rateLimiter {
+	r := &rateLimiter{block: l.Block}
+	if l.Messages > 0 {
+		r.msgs = newTokenBucket(l.Messages)
+	}
+	if l.Bytes > 0 {
+		r.bytes = newTokenBucket(l.Bytes)
+	}
+	return r
+}
+
+// Waits until a datagram of n bytes may be sent, or returns ErrRateLimited if
+// it may not be sent now and the limiter does not block. A wait ends early at
+// the deadline, if set, or when closed is closed, see sleepUntil.
+func (r *rateLimiter) wait(n int, deadline time.Time, closed <-chan struct{}) error {
+	if r.block {
+		var d time.Duration
+		if r.msgs != nil {
+			d = r.msgs.reserve(1)
+		}
+		if r.bytes != nil {
+			if d2 := r.bytes.reserve(float64(n)); d2 > d {
+				d = d2
+			}
+		}
+		if err := sleepUntil(d, deadline, closed); err != nil {
+			// not sent after all
+			if r.msgs != nil {
+				r.msgs.refund(1)
+			}
+			if r.bytes != nil {
+				r.bytes.refund(float64(n))
+			}
+			return err
+		}
+		return nil
+	}
+	if r.msgs != nil && !r.msgs.allow(1) {
+		return ErrRateLimited
+	}
+	if r.bytes != nil && !r.bytes.allow(float64(n)) {
+		if r.msgs != nil {
+			r.msgs.refund(1)
+		}
+		return ErrRateLimited
+	}
+	return nil
+}
+
+// Limits how fast WriteTo sends, to keep a runaway sender from flooding the
+// bridge and the router. There is no limit by default; a zero RateLimit
+// removes it again.
+func (s *DatagramSession) SetRateLimit(l RateLimit) {
+	var r *rateLimiter
+	if l.Messages > 0 || l.Bytes > 0 {
+		r = newRateLimiter(l)
+	}
+	s.limitMu.Lock()
+	defer s.limitMu.Unlock()
+	s.limiter = r
+}
+
+// Returns the rate limiter of the session, nil for no limit, and the write
+// deadline, which ends a wait for it.
+func (s *DatagramSession) rateLimiter() (*rateLimiter, time.Time) {
+	s.limitMu.Lock()
+	defer s.limitMu.Unlock()
+	return s.limiter, s.writeDeadline
+}
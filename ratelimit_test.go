@@ -0,0 +1,85 @@
+package sam3
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_RateLimitDatagram(t *testing.T) {
+	mock := newMockSAM(t, mockOK)
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	ds, err := sam.NewDatagramSession("limitTun", NewKeys(I2PAddr("pub"), "pubpriv"), nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close()
+	ds.SetRateLimit(RateLimit{Messages: 2})
+	for i := 0; i < 2; i++ {
+		if _, err := ds.WriteTo([]byte("hi"), testDest); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := ds.WriteTo([]byte("hi"), testDest); err != ErrRateLimited {
+		t.Fatalf("expected ErrRateLimited, got %v", err)
+	}
+	ds.SetRateLimit(RateLimit{})
+	if _, err := ds.WriteTo([]byte("hi"), testDest); err != nil {
+		t.Fatalf("expected no limit after removing it, got %v", err)
+	}
+
+	// a blocking wait ends at the write deadline, and when the session closes
+	ds.SetRateLimit(RateLimit{Messages: 1, Block: true})
+	ds.WriteTo([]byte("hi"), testDest)
+	ds.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	start := time.Now()
+	if _, err := ds.WriteTo([]byte("hi"), testDest); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected the deadline to end the wait, got %v", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("waited past the deadline: %v", d)
+	}
+	ds.SetWriteDeadline(time.Time{})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		ds.Close()
+	}()
+	start = time.Now()
+	if _, err := ds.WriteTo([]byte("hi"), testDest); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected closing the session to end the wait, got %v", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("waited after Close: %v", d)
+	}
+}
+
+func Test_RateLimiterBytes(t *testing.T) {
+	r := newRateLimiter(RateLimit{Messages: 10, Bytes: 100})
+	if err := r.wait(80, time.Time{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.wait(80, time.Time{}, nil); err != ErrRateLimited {
+		t.Fatalf("expected ErrRateLimited, got %v", err)
+	}
+	// the refused datagram must not have used up a message token
+	if tokens := r.msgs.tokens; tokens < 8.9 {
+		t.Fatalf("expected about 9 message tokens left, got %v", tokens)
+	}
+	r = newRateLimiter(RateLimit{Bytes: 1000, Block: true})
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := r.wait(600, time.Time{}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 600*time.Millisecond {
+		t.Fatalf("expected the blocking limiter to wait, took %v", d)
+	}
+}
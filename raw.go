@@ -2,9 +2,11 @@ package sam3
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"net"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -15,57 +17,137 @@ import (
 // that is needed. Raw datagrams may be at most 32 kB in size. There is no
 // overhead of authentication, which is the reason to use this..
 type RawSession struct {
-	samAddr  string       // address to the sam bridge (ipv4:port)
-	id       string       // tunnel name
-	conn     net.Conn     // connection to sam bridge
-	udpconn  *net.UDPConn // used to deliver datagrams
-	keys     I2PKeys      // i2p destination keys
-	rUDPAddr *net.UDPAddr // the SAM bridge UDP-port
+	cfg      Config         // how to connect to the sam bridge
+	id       string         // tunnel name
+	conn     net.Conn       // connection to sam bridge
+	udpconn  *net.UDPConn   // used to deliver datagrams
+	keys     I2PKeys        // i2p destination keys
+	rUDPAddr *net.UDPAddr   // the SAM bridge UDP-port
+	maxSize  int            // the largest datagram that may be sent
+	header   bool           // datagrams are received with a header line
+	master   *MasterSession // set if this is a subsession
+	traffic  trafficCounter
+	state    sessionState
 }
 
 // Creates a new raw session. udpPort is the UDP port SAM is listening on,
-// and if you set it to zero, it will use SAMs standard UDP port.
-func (s *SAM) NewRawSession(id string, keys I2PKeys, options []string, udpPort int) (*RawSession, error) {
-	if udpPort > 65335 || udpPort < 0 {
-		return nil, errors.New("udpPort needs to be in the intervall 0-65335")
-	}
-	if udpPort == 0 {
-		udpPort = 7655
-	}
-	lhost, _, err := net.SplitHostPort(s.conn.LocalAddr().String())
+// and if you set it to zero, it will use SAMs standard UDP port. opts are
+// applied after options.
+func (s *SAM) NewRawSession(id string, keys I2PKeys, options []string, udpPort int, opts ...Option) (*RawSession, error) {
+	so, err := applyOptions(options, opts)
 	if err != nil {
-		s.Close()
 		return nil, err
 	}
-	lUDPAddr, err := net.ResolveUDPAddr("udp4", lhost+":0")
+	udpconn, rUDPAddr, fwd, err := listenUDP(s.conn, udpPort, so)
 	if err != nil {
 		return nil, err
 	}
-	udpconn, err := net.ListenUDP("udp4", lUDPAddr)
+	conn, reply, err := s.newGenericSessionReply(s.closed, "RAW", id, keys, so.options(), so.extras(fwd...))
 	if err != nil {
+		udpconn.Close()
 		return nil, err
 	}
-	rhost, _, err := net.SplitHostPort(s.conn.RemoteAddr().String())
-	if err != nil {
-		s.Close()
+	if keys, err = sessionKeys(keys, reply); err != nil {
+		conn.Close()
+		udpconn.Close()
 		return nil, err
 	}
-	rUDPAddr, err := net.ResolveUDPAddr("udp4", rhost+":"+strconv.Itoa(udpPort))
+	rs := &RawSession{cfg: s.cfg, id: id, conn: conn, udpconn: udpconn, keys: keys, rUDPAddr: rUDPAddr, maxSize: maxDatagramSize(reply, defaultMaxRawSize), header: so.params["HEADER"] == "true"}
+	rs.state.start()
+	return rs, nil
+}
+
+// Returns the largest datagram that can be sent on the session. This is what
+// the bridge advertised when the session was created, or the limit of the
+// SAM specification (32768 bytes) if it did not.
+func (s *RawSession) MaxDatagramSize() int {
+	return s.maxSize
+}
+
+// Reads one raw datagram sent to the destination of the DatagramSession. Returns
+// the number of bytes read. Who sent the raw message can not be determined at
+// this layer - you need to do it (in a secure way!). If the session was created
+// WithRawHeader, the header is dropped; use ReadRawFull to see it.
+func (s *RawSession) Read(b []byte) (n int, err error) {
+	if !s.header {
+		return s.read(b)
+	}
+	data, _, _, _, err := s.ReadRawFull()
 	if err != nil {
-		return nil, err
+		return 0, err
+	}
+	if len(data) > len(b) {
+		return copy(b, data), errors.New("Datagram did not fit into your buffer.")
+	}
+	return copy(b, data), nil
+}
+
+// Like Read, but gives up and returns ctx.Err() once ctx is done, so a
+// receive loop can be stopped without closing the session. Any read deadline
+// previously set on the RawSession is cleared when ReadContext returns.
+func (s *RawSession) ReadContext(ctx context.Context, b []byte) (n int, err error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
 	}
-	_, lport, err := net.SplitHostPort(udpconn.LocalAddr().String())
-	conn, err := s.newGenericSession("RAW", id, keys, options, []string{"PORT=" + lport})
+	stop := watchReadContext(ctx, s.udpconn)
+	n, err = s.Read(b)
+	if stop() {
+		return 0, ctx.Err()
+	}
+	return n, err
+}
+
+// Room for the header line the bridge puts in front of raw datagrams.
+const rawHeaderSize = 256
+
+// Reads one raw datagram of a session created WithRawHeader, and returns it
+// along with the I2P protocol number and the ports it was sent from and to.
+func (s *RawSession) ReadRawFull() (data []byte, proto int, fromPort, toPort int, err error) {
+	if !s.header {
+		return nil, 0, 0, 0, errors.New("Raw session was not created with a header")
+	}
+	buf := getBuffer(s.maxSize + rawHeaderSize)
+	defer putBuffer(buf)
+	n, err := s.read(buf)
 	if err != nil {
-		return nil, err
+		return nil, 0, 0, 0, err
+	}
+	i := bytes.IndexByte(buf[:n], '\n')
+	if i < 0 {
+		return nil, 0, 0, 0, errors.New("Could not parse incomming raw datagram header.")
+	}
+	proto, fromPort, toPort, err = parseRawHeader(string(buf[:i]))
+	if err != nil {
+		return nil, 0, 0, 0, err
 	}
-	return &RawSession{s.address, id, conn, udpconn, keys, rUDPAddr}, nil
+	return append([]byte(nil), buf[i+1:n]...), proto, fromPort, toPort, nil
 }
 
-// Reads one raw datagram sent to the destination of the DatagramSession. Returns
-// the number of bytes read. Who sent the raw message can not be determined at
-// this layer - you need to do it (in a secure way!).
-func (s *RawSession) Read(b []byte) (n int, err error) {
+// Parses the header line the bridge puts in front of raw datagrams when
+// HEADER=true. Fields that are missing are zero.
+func parseRawHeader(header string) (proto, fromPort, toPort int, err error) {
+	for _, f := range strings.Fields(header) {
+		i := strings.IndexByte(f, '=')
+		if i < 0 {
+			continue
+		}
+		v, err := strconv.Atoi(f[i+1:])
+		if err != nil {
+			return 0, 0, 0, errors.New("Could not parse incomming raw datagram header: " + f)
+		}
+		switch f[:i] {
+		case "PROTOCOL":
+			proto = v
+		case "FROM_PORT":
+			fromPort = v
+		case "TO_PORT":
+			toPort = v
+		}
+	}
+	return proto, fromPort, toPort, nil
+}
+
+func (s *RawSession) read(b []byte) (n int, err error) {
 	for {
 		// very basic protection: only accept incomming UDP messages from the IP of the SAM bridge
 		var saddr *net.UDPAddr
@@ -78,26 +160,31 @@ func (s *RawSession) Read(b []byte) (n int, err error) {
 		}
 		break
 	}
+	s.traffic.addIn(n)
 	return n, nil
 }
 
-// Sends one raw datagram to the destination specified. At the time of writing,
-// maximum size is 32 kilobyte, but this may change in the future.
+// Sends one raw datagram to the destination specified. Returns
+// ErrDatagramTooLarge if b is larger than MaxDatagramSize.
 func (s *RawSession) WriteTo(b []byte, addr I2PAddr) (n int, err error) {
+	if len(b) > s.maxSize {
+		return 0, ErrDatagramTooLarge
+	}
 	header := []byte("3.0 " + s.id + " " + addr.String() + "\n")
 	msg := append(header, b...)
 	n, err = s.udpconn.WriteToUDP(msg, s.rUDPAddr)
+	if err == nil {
+		s.traffic.addOut(len(b))
+	}
 	return n, err
 }
 
-// Closes the RawSession.
+// Closes the RawSession. Waits a second at most for the bridge to tear down
+// the tunnels, see CloseContext.
 func (s *RawSession) Close() error {
-	err := s.conn.Close()
-	err2 := s.udpconn.Close()
-	if err != nil {
-		return err
-	}
-	return err2
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	return s.CloseContext(ctx)
 }
 
 // Returns the local I2P destination of the RawSession.
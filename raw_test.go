@@ -0,0 +1,59 @@
+package sam3
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func Test_RawHeader(t *testing.T) {
+	proto, from, to, err := parseRawHeader("FROM_PORT=1234 TO_PORT=80 PROTOCOL=18")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proto != 18 || from != 1234 || to != 80 {
+		t.Fatalf("got protocol %d, ports %d and %d", proto, from, to)
+	}
+	if _, _, _, err := parseRawHeader("PROTOCOL=x"); err == nil {
+		t.Fatal("accepted a malformed protocol")
+	}
+
+	mock := newMockSAM(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "HELLO") && mockField(cmd, "MAX") == "3.2" {
+			return "HELLO REPLY RESULT=OK VERSION=3.2\n"
+		}
+		return mockOK(cmd)
+	})
+	defer mock.Close()
+	keys := NewKeys(I2PAddr("pub"), "pubpriv")
+	old, err := NewSAMConfig(Config{Address: mock.Addr(), MaxVersion: "3.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+	if _, err := old.NewRawSession("headerTun", keys, nil, 0, WithRawHeader()); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("expected HEADER to need SAM 3.2, got %v", err)
+	}
+	sam, err := NewSAMConfig(Config{Address: mock.Addr(), MaxVersion: "3.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	rs, err := sam.NewRawSession("headerTun", keys, nil, 0, WithRawHeader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Close()
+	cmds := mock.Commands()
+	if create := cmds[len(cmds)-1]; !strings.Contains(create, " HEADER=true ") {
+		t.Fatalf("HEADER=true was not sent: %q", create)
+	}
+	plain, err := sam.NewRawSession("plainTun", keys, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	if _, _, _, _, err := plain.ReadRawFull(); err == nil {
+		t.Fatal("ReadRawFull worked without a header")
+	}
+}
@@ -0,0 +1,87 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// Returned by dials of a session with fail-fast enabled while its tunnels are
+// not ready, see SetFailFast.
+var ErrTunnelsNotReady = errors.New("Tunnels not ready")
+
+// Returned by WaitReady, and dials, when the session has failed: it could not
+// be recreated, see State.
+var ErrSessionFailed = errors.New("Session failed")
+
+// Reports whether the tunnels of the session are ready. They are when the
+// session is SessionActive, and are not while it is being recreated, such as
+// by self-healing or UpdateOptions, nor once it has failed or been closed.
+func (s *StreamSession) Ready() bool {
+	s.readyMu.Lock()
+	rebuilding := s.rebuilding
+	s.readyMu.Unlock()
+	return rebuilding == nil && s.state.get() == SessionActive
+}
+
+// Waits until the tunnels of the session are ready, see Ready, or ctx is done.
+// Returns ErrSessionFailed if the session failed, or fails while being
+// recreated, and net.ErrClosed if it was closed, as it will not become ready
+// then.
+func (s *StreamSession) WaitReady(ctx context.Context) error {
+	s.readyMu.Lock()
+	rebuilding := s.rebuilding
+	s.readyMu.Unlock()
+	if rebuilding != nil {
+		select {
+		case <-rebuilding:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return s.unavailable()
+}
+
+// Returns the error of WaitReady if the session failed or was closed, and nil
+// otherwise.
+func (s *StreamSession) unavailable() error {
+	switch s.state.get() {
+	case SessionFailed:
+		return ErrSessionFailed
+	case SessionClosing, SessionClosed:
+		return net.ErrClosed
+	}
+	return nil
+}
+
+// Chooses what dials do while the tunnels of the session are not ready. By
+// default they wait (see WaitReady), and then through the routers own timeout
+// if the peer can not be reached. With failFast set, they return
+// ErrTunnelsNotReady at once instead, for callers that rather try another
+// route, such as load balancers. Either way, dials of a session that failed or
+// was closed return the error of WaitReady.
+func (s *StreamSession) SetFailFast(failFast bool) {
+	s.readyMu.Lock()
+	defer s.readyMu.Unlock()
+	s.failFast = failFast
+}
+
+func (s *StreamSession) isFailFast() bool {
+	s.readyMu.Lock()
+	defer s.readyMu.Unlock()
+	return s.failFast
+}
+
+// Marks the session as not ready until the returned function is called.
+func (s *StreamSession) startRebuilding() func() {
+	c := make(chan struct{})
+	s.readyMu.Lock()
+	s.rebuilding = c
+	s.readyMu.Unlock()
+	return func() {
+		s.readyMu.Lock()
+		s.rebuilding = nil
+		s.readyMu.Unlock()
+		close(c)
+	}
+}
@@ -0,0 +1,86 @@
+package sam3
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_FailFast(t *testing.T) {
+	mock := newMockSAM(t, mockOK)
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	ss, err := sam.NewStreamSession("fastTun", NewKeys(I2PAddr("pub"), "pubpriv"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	if !ss.Ready() {
+		t.Fatal("a new session is not ready")
+	}
+	done := ss.startRebuilding()
+	ss.SetFailFast(true)
+	if _, err := ss.DialI2P(testDest); err != ErrTunnelsNotReady {
+		t.Fatalf("expected ErrTunnelsNotReady, got %v", err)
+	}
+	ss.SetFailFast(false)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := ss.DialContextI2P(ctx, testDest); err != context.DeadlineExceeded {
+		t.Fatalf("expected the dial to wait for the tunnels, got %v", err)
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		done()
+	}()
+	conn, err := ss.DialI2P(testDest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func Test_ReadyDeadSession(t *testing.T) {
+	mock := newMockSAM(t, mockOK)
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	ss, err := sam.NewStreamSession("deadTun", NewKeys(I2PAddr("pub"), "pubpriv"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+
+	// recreating the session fails while WaitReady waits for it
+	done := ss.startRebuilding()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		ss.state.fail()
+		done()
+	}()
+	if err := ss.WaitReady(context.Background()); err != ErrSessionFailed {
+		t.Fatalf("expected ErrSessionFailed, got %v", err)
+	}
+	if ss.Ready() {
+		t.Fatal("a failed session is ready")
+	}
+	ss.SetFailFast(true)
+	if _, err := ss.DialI2P(testDest); err != ErrSessionFailed {
+		t.Fatalf("expected a fail-fast dial to give ErrSessionFailed, got %v", err)
+	}
+	ss.Close()
+	if ss.Ready() {
+		t.Fatal("a closed session is ready")
+	}
+	if err := ss.WaitReady(context.Background()); err != net.ErrClosed {
+		t.Fatalf("expected net.ErrClosed, got %v", err)
+	}
+}
@@ -0,0 +1,249 @@
+package sam3
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The header ReplayProtectedSession puts in front of every datagram: a 64 bit
+// message id and a 32 bit unix timestamp, both big endian.
+const replayHeaderSize = 12
+
+// Wraps a DatagramSession so that replayed datagrams are dropped. Nothing in
+// I2P stops anyone who captured a datagram from sending it again, and
+// datagrams carry no timestamp, so every datagram is sent with a message id,
+// counting up from a random start, and the time it was sent. On receive,
+// datagrams that were sent more than MaxAge ago (or claim to be sent more than
+// MaxAge in the future) are dropped, and so are those repeating an id seen
+// before from the same sender. Both peers have to use a
+// ReplayProtectedSession, and their clocks have to agree to within MaxAge.
+//
+// Like the anti-replay window of IPsec, the highest id received from each
+// sender is kept, along with a bitmap of which of the Window ids below it
+// were seen. Datagrams whose id is further behind than that are dropped, so
+// Window bounds how far datagrams may arrive out of order. At most MaxSenders
+// senders are tracked; when another one shows up, the one heard from least
+// recently is forgotten, and its datagrams that are not older than MaxAge yet
+// could then be replayed.
+type ReplayProtectedSession struct {
+	session *DatagramSession
+
+	// How many ids below the highest one are tracked for each sender,
+	// rounded up to a multiple of 64. Defaults to 1024.
+	Window int
+	// How old a datagram may be before it is dropped as stale. Defaults to
+	// 60 seconds.
+	MaxAge time.Duration
+	// How many senders are tracked at most. Defaults to 1000.
+	MaxSenders int
+
+	next    uint64 // the id of the next datagram sent
+	dropped uint64
+
+	mu      sync.Mutex
+	senders map[I2PAddr]*replayWindow
+}
+
+// The ids received from one sender.
+type replayWindow struct {
+	highest uint64    // the highest id received
+	bitmap  []uint64  // bit i is set if id highest-i was received
+	heard   time.Time // when the last datagram was accepted
+}
+
+// Wraps sess, protecting the datagrams sent and received on it from replays.
+func NewReplayProtectedSession(sess *DatagramSession) (*ReplayProtectedSession, error) {
+	var start [8]byte
+	if _, err := rand.Read(start[:]); err != nil {
+		return nil, err
+	}
+	return &ReplayProtectedSession{
+		session:    sess,
+		Window:     1024,
+		MaxAge:     60 * time.Second,
+		MaxSenders: 1000,
+		next:       binary.BigEndian.Uint64(start[:]),
+		senders:    make(map[I2PAddr]*replayWindow),
+	}, nil
+}
+
+// Returns the largest payload that can be sent, which is the size of the
+// header less than the limit of the DatagramSession.
+func (s *ReplayProtectedSession) MaxDatagramSize() int {
+	return s.session.MaxDatagramSize() - replayHeaderSize
+}
+
+// Sends b, with a new message id and the current time, to addr. Returns the
+// length of b.
+func (s *ReplayProtectedSession) WriteTo(b []byte, addr I2PAddr) (int, error) {
+	if len(b) > s.MaxDatagramSize() {
+		return 0, ErrDatagramTooLarge
+	}
+	if _, err := s.session.WriteTo(s.seal(b, time.Now()), addr); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Reads the next datagram that is neither replayed nor stale. Returns the
+// length of the payload and who sent it. Dropped datagrams are counted, see
+// DroppedReplays.
+func (s *ReplayProtectedSession) ReadFrom(b []byte) (int, I2PAddr, error) {
+	buf := make([]byte, len(b)+replayHeaderSize)
+	for {
+		n, from, err := s.session.ReadFrom(buf)
+		if err != nil {
+			return 0, I2PAddr(""), err
+		}
+		if payload, ok := s.accept(from, buf[:n], time.Now()); ok {
+			return copy(b, payload), from, nil
+		}
+	}
+}
+
+// Returns how many datagrams were dropped because they were replayed, stale,
+// or too short to carry the header.
+func (s *ReplayProtectedSession) DroppedReplays() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Closes the wrapped DatagramSession.
+func (s *ReplayProtectedSession) Close() error {
+	return s.session.Close()
+}
+
+// Returns the local I2P destination of the wrapped DatagramSession.
+func (s *ReplayProtectedSession) LocalAddr() I2PAddr {
+	return s.session.LocalAddr()
+}
+
+// Sets the read deadline of the wrapped DatagramSession.
+func (s *ReplayProtectedSession) SetReadDeadline(t time.Time) error {
+	return s.session.SetReadDeadline(t)
+}
+
+// Sets the write deadline of the wrapped DatagramSession.
+func (s *ReplayProtectedSession) SetWriteDeadline(t time.Time) error {
+	return s.session.SetWriteDeadline(t)
+}
+
+// Returns the header for a datagram sent at now followed by payload.
+func (s *ReplayProtectedSession) seal(payload []byte, now time.Time) []byte {
+	datagram := make([]byte, replayHeaderSize, replayHeaderSize+len(payload))
+	binary.BigEndian.PutUint64(datagram, atomic.AddUint64(&s.next, 1)-1)
+	binary.BigEndian.PutUint32(datagram[8:], uint32(now.Unix()))
+	return append(datagram, payload...)
+}
+
+// Checks a datagram made by seal, received from from at now, and returns its
+// payload, or false if it has to be dropped, which is counted.
+func (s *ReplayProtectedSession) accept(from I2PAddr, datagram []byte, now time.Time) ([]byte, bool) {
+	payload, ok := s.check(from, datagram, now)
+	if !ok {
+		atomic.AddUint64(&s.dropped, 1)
+	}
+	return payload, ok
+}
+
+func (s *ReplayProtectedSession) check(from I2PAddr, datagram []byte, now time.Time) ([]byte, bool) {
+	if len(datagram) < replayHeaderSize {
+		return nil, false
+	}
+	id := binary.BigEndian.Uint64(datagram)
+	sent := time.Unix(int64(binary.BigEndian.Uint32(datagram[8:])), 0)
+	// the timestamp has a resolution of one second
+	if now.Sub(sent) > s.MaxAge+time.Second || sent.Sub(now) > s.MaxAge {
+		return nil, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, ok := s.senders[from]
+	if !ok {
+		s.makeRoom(now)
+		words := (s.Window + 63) / 64
+		if words < 1 {
+			words = 1
+		}
+		w = &replayWindow{highest: id, bitmap: make([]uint64, words)}
+		w.bitmap[0] = 1
+		w.heard = now
+		s.senders[from] = w
+		return datagram[replayHeaderSize:], true
+	}
+	if !w.receive(id) {
+		return nil, false
+	}
+	w.heard = now
+	return datagram[replayHeaderSize:], true
+}
+
+// Forgets senders until another one can be tracked: first those not heard
+// from for longer than MaxAge, whose datagrams are all stale by now, then the
+// one heard from least recently.
+func (s *ReplayProtectedSession) makeRoom(now time.Time) {
+	if s.MaxSenders <= 0 || len(s.senders) < s.MaxSenders {
+		return
+	}
+	var oldest I2PAddr
+	var oldestHeard time.Time
+	for from, w := range s.senders {
+		if now.Sub(w.heard) > s.MaxAge+time.Second {
+			delete(s.senders, from)
+			continue
+		}
+		if oldest == "" || w.heard.Before(oldestHeard) {
+			oldest, oldestHeard = from, w.heard
+		}
+	}
+	if len(s.senders) >= s.MaxSenders {
+		delete(s.senders, oldest)
+	}
+}
+
+// Records id, and reports whether it is new and within the window. Ids are
+// compared like serial numbers, so counting up past the largest uint64 is
+// fine.
+func (w *replayWindow) receive(id uint64) bool {
+	size := uint64(len(w.bitmap)) * 64
+	if ahead := id - w.highest; ahead != 0 && ahead < 1<<63 {
+		w.advance(ahead)
+		w.highest = id
+		w.bitmap[0] |= 1
+		return true
+	}
+	behind := w.highest - id
+	if behind >= size {
+		return false
+	}
+	word, bit := behind/64, uint64(1)<<(behind%64)
+	if w.bitmap[word]&bit != 0 {
+		return false
+	}
+	w.bitmap[word] |= bit
+	return true
+}
+
+// Moves the window up by n ids.
+func (w *replayWindow) advance(n uint64) {
+	if n >= uint64(len(w.bitmap))*64 {
+		for i := range w.bitmap {
+			w.bitmap[i] = 0
+		}
+		return
+	}
+	words, bits := int(n/64), n%64
+	for i := len(w.bitmap) - 1; i >= 0; i-- {
+		var v uint64
+		if j := i - words; j >= 0 {
+			v = w.bitmap[j] << bits
+			if bits > 0 && j > 0 {
+				v |= w.bitmap[j-1] >> (64 - bits)
+			}
+		}
+		w.bitmap[i] = v
+	}
+}
@@ -0,0 +1,83 @@
+package sam3
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func Test_ReplayProtectedSession(t *testing.T) {
+	s, err := NewReplayProtectedSession(&DatagramSession{maxSize: defaultMaxDatagramSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Window = 128
+	s.MaxSenders = 2
+	now := time.Now()
+	other, _ := GenerateKeysFromSeed([]byte("other"), Sig_EdDSA_SHA512_Ed25519)
+
+	datagram := s.seal([]byte("hello"), now)
+	if payload, ok := s.accept(testDest, datagram, now); !ok || !bytes.Equal(payload, []byte("hello")) {
+		t.Fatalf("fresh datagram dropped: %q", payload)
+	}
+	if _, ok := s.accept(testDest, datagram, now.Add(time.Second)); ok {
+		t.Fatal("replayed datagram accepted")
+	}
+	if _, ok := s.accept(other.Addr(), datagram, now.Add(time.Second)); !ok {
+		t.Fatal("the same id from another sender dropped")
+	}
+	if _, ok := s.accept(testDest, s.seal(nil, now.Add(-2*time.Minute)), now); ok {
+		t.Fatal("stale datagram accepted")
+	}
+	if _, ok := s.accept(testDest, s.seal(nil, now.Add(2*time.Minute)), now); ok {
+		t.Fatal("datagram from the future accepted")
+	}
+	if _, ok := s.accept(testDest, []byte("short"), now); ok {
+		t.Fatal("datagram without header accepted")
+	}
+	if s.DroppedReplays() != 4 {
+		t.Fatalf("expected 4 dropped datagrams, got %d", s.DroppedReplays())
+	}
+
+	// out of order within the window is fine, behind it is not
+	from, _ := GenerateKeysFromSeed([]byte("reorder"), Sig_EdDSA_SHA512_Ed25519)
+	at := func(id uint64) []byte {
+		s.next = id
+		return s.seal(nil, now)
+	}
+	for _, id := range []uint64{100, 98, 220, 99, 223} {
+		if _, ok := s.accept(from.Addr(), at(id), now); !ok {
+			t.Fatalf("id %d dropped", id)
+		}
+	}
+	for _, id := range []uint64{220, 100, 99, 223 - 128} {
+		if _, ok := s.accept(from.Addr(), at(id), now); ok {
+			t.Fatalf("id %d accepted", id)
+		}
+	}
+	if _, ok := s.accept(from.Addr(), at(164), now); !ok {
+		t.Fatal("id 164 dropped")
+	}
+	// every sender has a window of its own, and the number of senders is
+	// capped, forgetting the one heard from least recently
+	if len(s.senders) != 2 {
+		t.Fatalf("expected 2 senders to be tracked, got %d", len(s.senders))
+	}
+	if _, ok := s.senders[testDest]; ok {
+		t.Fatal("the sender heard from least recently was not forgotten")
+	}
+	s.next = ^uint64(0)
+	wrapped := s.seal(nil, now)
+	if _, ok := s.accept(testDest, wrapped, now); !ok {
+		t.Fatal("fresh datagram dropped")
+	}
+	if _, ok := s.accept(testDest, s.seal(nil, now), now); !ok {
+		t.Fatal("id wrapping around to 0 dropped")
+	}
+	if _, ok := s.accept(testDest, wrapped, now); ok {
+		t.Fatal("replayed datagram accepted after the id wrapped around")
+	}
+	if _, err := s.WriteTo(make([]byte, s.MaxDatagramSize()+1), testDest); err != ErrDatagramTooLarge {
+		t.Fatalf("expected ErrDatagramTooLarge, got %v", err)
+	}
+}
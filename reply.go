@@ -0,0 +1,124 @@
+package sam3
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// A reply from the SAM bridge, such as
+//
+//	SESSION STATUS RESULT=I2P_ERROR MESSAGE="Router busy"
+//
+// which has the Topic "SESSION", the Type "STATUS" and the Pairs RESULT and
+// MESSAGE. Quotes around values are removed.
+type SAMReply struct {
+	Topic string
+	Type  string
+	Pairs map[string]string
+}
+
+// Returns the RESULT of the reply, such as "OK", or "" if there is none.
+func (r SAMReply) Result() string {
+	return r.Pairs["RESULT"]
+}
+
+// Parses a single line of reply from the SAM bridge, with or without the
+// trailing newline.
+func parseSAMReply(line string) (SAMReply, error) {
+	// replies and commands have the same syntax
+	c, err := ParseCommand(line)
+	if err != nil {
+		return SAMReply{}, err
+	}
+	return SAMReply{Topic: c.Topic, Type: c.Type, Pairs: c.Fields()}, nil
+}
+
+// Sends the command line to the SAM bridge and returns its parsed reply. This
+// is an escape hatch for commands the library does not wrap (yet), and is not
+// needed for anything it does. The trailing newline may be left out, but line
+// may not contain any others. Commands that are not answered with a single
+// line, or that take over the connection, such as STREAM CONNECT, should not
+// be sent this way.
+func (sam *SAM) Command(line string) (SAMReply, error) {
+	line = strings.TrimSuffix(line, "\n")
+	if strings.ContainsAny(line, "\r\n") {
+		return SAMReply{}, errors.New("Command may not contain newlines")
+	}
+	cmd, err := sam.cfg.command(line + "\n")
+	if err != nil {
+		return SAMReply{}, err
+	}
+	if _, err := sam.conn.Write(cmd); err != nil {
+		return SAMReply{}, err
+	}
+	reply, err := readLine(sam.conn)
+	if err != nil {
+		return SAMReply{}, err
+	}
+	r, err := parseSAMReply(reply)
+	if perr, ok := err.(*ParseError); ok {
+		perr.Command = line
+	}
+	return r, err
+}
+
+// Like Command, but for commands whose reply spans several lines: reads the
+// reply up to and including terminator, such as a line of its own
+// ("\nEND\n"), and returns it unparsed. A terminator of "\n" reads a single
+// line, like Command.
+func (sam *SAM) CommandUntil(line, terminator string) (string, error) {
+	line = strings.TrimSuffix(line, "\n")
+	if strings.ContainsAny(line, "\r\n") {
+		return "", errors.New("Command may not contain newlines")
+	}
+	if terminator == "" {
+		return "", errors.New("Empty terminator")
+	}
+	cmd, err := sam.cfg.command(line + "\n")
+	if err != nil {
+		return "", err
+	}
+	if _, err := sam.conn.Write(cmd); err != nil {
+		return "", err
+	}
+	return readUntil(sam.conn, terminator)
+}
+
+// The longest reply read by readUntil.
+const maxReplyLen = 65536
+
+// Reads up to and including the next newline from conn. Reads one byte at a
+// time, so nothing after the newline is consumed.
+func readLine(conn net.Conn) (string, error) {
+	return readUntil(conn, "\n")
+}
+
+// Reads up to and including the next occurrence of terminator from conn, one
+// byte at a time, so nothing after it is consumed.
+func readUntil(conn net.Conn, terminator string) (string, error) {
+	var reply []byte
+	b := make([]byte, 1)
+	for len(reply) < maxReplyLen {
+		if _, err := conn.Read(b); err != nil {
+			return "", err
+		}
+		reply = append(reply, b[0])
+		if b[0] == terminator[len(terminator)-1] && bytes.HasSuffix(reply, []byte(terminator)) {
+			return string(reply), nil
+		}
+	}
+	return "", errors.New("Reply is too long")
+}
+
+// Returns the STREAM CONNECT command (with the trailing newline) connecting
+// the session id to dest, for use with Command-like low-level code.
+func StreamConnectCommand(id string, dest I2PAddr, silent bool) string {
+	return streamConnect(id, dest, silent).String()
+}
+
+func streamConnect(id string, dest I2PAddr, silent bool) *Command {
+	return commandOf(CmdStreamConnect).Set("ID", id).Set("DESTINATION", dest.Base64()).Set("SILENT", strconv.FormatBool(silent))
+}
@@ -0,0 +1,85 @@
+package sam3
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func Test_ParseSAMReply(t *testing.T) {
+	r, err := parseSAMReply("SESSION STATUS RESULT=I2P_ERROR MESSAGE=\"Router busy\" FLAG\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := SAMReply{"SESSION", "STATUS", map[string]string{"RESULT": "I2P_ERROR", "MESSAGE": "Router busy", "FLAG": ""}}
+	if !reflect.DeepEqual(r, want) {
+		t.Fatalf("got %+v, want %+v", r, want)
+	}
+	for _, bad := range []string{"", "HELLO", "A B MESSAGE=\"open", "A B\nC D"} {
+		if _, err := parseSAMReply(bad); err == nil {
+			t.Errorf("accepted %q", bad)
+		}
+	}
+}
+
+func Test_Command(t *testing.T) {
+	mock := newMockSAM(t, mockLookup)
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	r, err := sam.Command("NAMING LOOKUP NAME=known.i2p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Result() != "OK" || r.Pairs["VALUE"] != string(testDest) {
+		t.Fatalf("unexpected reply %+v", r)
+	}
+	if _, err := sam.Command("NAMING LOOKUP NAME=a\nQUIT"); err == nil {
+		t.Fatal("sent a command with an embedded newline")
+	}
+}
+
+func Test_ParseError(t *testing.T) {
+	raw := []byte("NAMING REPLY RESULT=OK NAME=a.i2p GARBAGE\n")
+	_, err := parseLookupReply("a.i2p", raw)
+	var perr *ParseError
+	if !errors.As(err, &perr) {
+		t.Fatalf("expected a ParseError, got %v", err)
+	}
+	if perr.Command != "NAMING LOOKUP" || string(perr.Raw) != string(raw) {
+		t.Fatalf("got %+v", perr)
+	}
+	raw[0] = 'X'
+	if perr.Raw[0] != 'N' {
+		t.Fatal("ParseError shares the reply buffer")
+	}
+}
+
+func Test_CommandUntil(t *testing.T) {
+	mock := newMockSAM(t, func(cmd string) string {
+		if cmd == "LIST" {
+			return "LIST REPLY RESULT=OK\na.i2p\nb.i2p\nEND\n"
+		}
+		return mockOK(cmd)
+	})
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	reply, err := sam.CommandUntil("LIST", "\nEND\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "LIST REPLY RESULT=OK\na.i2p\nb.i2p\nEND\n" {
+		t.Fatalf("unexpected reply %q", reply)
+	}
+	// a single line, leaving the rest of the reply unread
+	if reply, err := sam.CommandUntil("LIST", "\n"); err != nil || reply != "LIST REPLY RESULT=OK\n" {
+		t.Fatalf("unexpected reply %q, %v", reply, err)
+	}
+}
@@ -0,0 +1,68 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+)
+
+// What the router tells about itself, see SAM.GetRouterInfo.
+type RouterInfo struct {
+	RouterVersion string    // such as "0.9.62"
+	Capabilities  string    // the caps of the RouterInfo, such as "XfR"
+	Published     time.Time // when the RouterInfo was last published
+}
+
+// Asks the router about itself. No SAM version defines a command for this, so
+// it uses the extension ExtRouterInfo, "ROUTER INFO", answered by
+//
+//	ROUTER REPLY RESULT=OK VERSION=$version CAPS=$caps PUBLISHED=$ms
+//
+// where PUBLISHED is in milliseconds since the epoch. Neither the bridge of
+// the Java router nor that of i2pd knows it at the time of writing, so unless
+// ExtRouterInfo is listed in Config.Extensions, an *ExtensionError is returned
+// without asking the bridge. Bridges that turn out not to know it return
+// ErrNotSupported. The command is sent on a connection of its own, since a
+// bridge may close the connection over an unknown command.
+func (sam *SAM) GetRouterInfo(ctx context.Context) (RouterInfo, error) {
+	if err := sam.cfg.requireExtension(ExtRouterInfo); err != nil {
+		return RouterInfo{}, err
+	}
+	sam2, err := NewSAMConfig(sam.cfg)
+	if err != nil {
+		return RouterInfo{}, err
+	}
+	defer sam2.Close()
+	stop := watchContext(ctx, sam2.conn)
+	reply, err := sam2.Command(ExtRouterInfo)
+	if stop() {
+		return RouterInfo{}, ctx.Err()
+	}
+	if err == io.EOF {
+		return RouterInfo{}, ErrNotSupported
+	}
+	if err != nil {
+		return RouterInfo{}, err
+	}
+	return parseRouterInfoReply(reply)
+}
+
+func parseRouterInfoReply(reply SAMReply) (RouterInfo, error) {
+	if reply.Topic != "ROUTER" || reply.Type != "REPLY" {
+		return RouterInfo{}, ErrNotSupported
+	}
+	if reply.Result() != ResultOK {
+		return RouterInfo{}, errors.New("Router info not available: " + reply.Pairs["MESSAGE"])
+	}
+	info := RouterInfo{RouterVersion: reply.Pairs["VERSION"], Capabilities: reply.Pairs["CAPS"]}
+	if published, ok := reply.Pairs["PUBLISHED"]; ok {
+		ms, err := strconv.ParseInt(published, 10, 64)
+		if err != nil {
+			return RouterInfo{}, errors.New("Malformed PUBLISHED in router info: " + published)
+		}
+		info.Published = time.Unix(0, ms*int64(time.Millisecond))
+	}
+	return info, nil
+}
@@ -0,0 +1,62 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_GetRouterInfo(t *testing.T) {
+	supported := newMockSAM(t, func(cmd string) string {
+		if cmd == "ROUTER INFO" {
+			return "ROUTER REPLY RESULT=OK VERSION=0.9.62 CAPS=XfR PUBLISHED=1700000000000\n"
+		}
+		return mockOK(cmd)
+	})
+	defer supported.Close()
+	disabled, err := NewSAM(supported.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer disabled.Close()
+	var extErr *ExtensionError
+	if _, err := disabled.GetRouterInfo(context.Background()); !errors.As(err, &extErr) || !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("expected an *ExtensionError, got %v", err)
+	}
+	for _, cmd := range supported.Commands() {
+		if cmd == ExtRouterInfo {
+			t.Fatal("sent ROUTER INFO without the extension enabled")
+		}
+	}
+
+	sam, err := NewSAMConfig(Config{Address: supported.Addr(), Extensions: []string{ExtRouterInfo}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	info, err := sam.GetRouterInfo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.RouterVersion != "0.9.62" || info.Capabilities != "XfR" || !info.Published.Equal(time.Unix(1700000000, 0)) {
+		t.Fatalf("unexpected router info %+v", info)
+	}
+
+	unsupported := newMockSAM(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "HELLO") {
+			return "HELLO REPLY RESULT=OK VERSION=3.0\n"
+		}
+		return "SESSION STATUS RESULT=I2P_ERROR MESSAGE=\"Unknown command\"\n"
+	})
+	defer unsupported.Close()
+	sam2, err := NewSAMConfig(Config{Address: unsupported.Addr(), Extensions: []string{ExtRouterInfo}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam2.Close()
+	if _, err := sam2.GetRouterInfo(context.Background()); err != ErrNotSupported {
+		t.Fatalf("expected ErrNotSupported, got %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+)
+
+// How the router would reach a destination, see SAM.RoutingInfo.
+type RoutingInfo struct {
+	NumberOfHops int       // hops of the outbound tunnel that would be used
+	TunnelCount  int       // inbound tunnels in the LeaseSet of the destination
+	ExpiresAt    time.Time // when the LeaseSet of the destination expires
+}
+
+// Asks the router how it would reach addr, to find out whether a peer is
+// still reachable before waiting for a connection to it to time out. No SAM
+// version defines a command for this, so it uses the extension ExtRoutingInfo,
+// "ROUTING_INFO DESTINATION=$dest", answered by
+//
+//	ROUTING_INFO REPLY RESULT=OK HOPS=$hops TUNNELS=$tunnels EXPIRES=$ms
+//
+// where EXPIRES is in milliseconds since the epoch. Neither the bridge of the
+// Java router nor that of i2pd knows it at the time of writing, so unless
+// ExtRoutingInfo is listed in Config.Extensions, an *ExtensionError is
+// returned without asking the bridge; use Lookup or a dial with a deadline
+// there. Bridges that turn out not to know it return ErrNotSupported. The
+// command is sent on a connection of its own, since a bridge may close the
+// connection over an unknown command.
+func (sam *SAM) RoutingInfo(ctx context.Context, addr I2PAddr) (RoutingInfo, error) {
+	if err := sam.cfg.requireExtension(ExtRoutingInfo); err != nil {
+		return RoutingInfo{}, err
+	}
+	sam2, err := NewSAMConfig(sam.cfg)
+	if err != nil {
+		return RoutingInfo{}, err
+	}
+	defer sam2.Close()
+	stop := watchContext(ctx, sam2.conn)
+	reply, err := sam2.Command(ExtRoutingInfo + " DESTINATION=" + addr.String())
+	if stop() {
+		return RoutingInfo{}, ctx.Err()
+	}
+	if err == io.EOF {
+		return RoutingInfo{}, ErrNotSupported
+	}
+	if err != nil {
+		return RoutingInfo{}, err
+	}
+	return parseRoutingInfoReply(reply)
+}
+
+func parseRoutingInfoReply(reply SAMReply) (RoutingInfo, error) {
+	if reply.Topic != ExtRoutingInfo || reply.Type != "REPLY" {
+		return RoutingInfo{}, ErrNotSupported
+	}
+	if reply.Result() != ResultOK {
+		return RoutingInfo{}, errors.New("Routing info not available: " + reply.Result() + " " + reply.Pairs["MESSAGE"])
+	}
+	var info RoutingInfo
+	var err error
+	if info.NumberOfHops, err = strconv.Atoi(reply.Pairs["HOPS"]); err != nil {
+		return RoutingInfo{}, errors.New("Malformed HOPS in routing info: " + reply.Pairs["HOPS"])
+	}
+	if info.TunnelCount, err = strconv.Atoi(reply.Pairs["TUNNELS"]); err != nil {
+		return RoutingInfo{}, errors.New("Malformed TUNNELS in routing info: " + reply.Pairs["TUNNELS"])
+	}
+	ms, err := strconv.ParseInt(reply.Pairs["EXPIRES"], 10, 64)
+	if err != nil {
+		return RoutingInfo{}, errors.New("Malformed EXPIRES in routing info: " + reply.Pairs["EXPIRES"])
+	}
+	info.ExpiresAt = time.Unix(0, ms*int64(time.Millisecond))
+	return info, nil
+}
@@ -0,0 +1,66 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_RoutingInfo(t *testing.T) {
+	supported := newMockSAM(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "ROUTING_INFO ") {
+			return "ROUTING_INFO REPLY RESULT=OK HOPS=3 TUNNELS=2 EXPIRES=1700000000000\n"
+		}
+		return mockOK(cmd)
+	})
+	defer supported.Close()
+	disabled, err := NewSAM(supported.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer disabled.Close()
+	var extErr *ExtensionError
+	if _, err := disabled.RoutingInfo(context.Background(), testDest); !errors.As(err, &extErr) || !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("expected an *ExtensionError, got %v", err)
+	}
+	for _, cmd := range supported.Commands() {
+		if strings.HasPrefix(cmd, ExtRoutingInfo) {
+			t.Fatal("sent ROUTING_INFO without the extension enabled")
+		}
+	}
+
+	sam, err := NewSAMConfig(Config{Address: supported.Addr(), Extensions: []string{ExtRoutingInfo}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	info, err := sam.RoutingInfo(context.Background(), testDest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.NumberOfHops != 3 || info.TunnelCount != 2 || !info.ExpiresAt.Equal(time.Unix(1700000000, 0)) {
+		t.Fatalf("unexpected routing info %+v", info)
+	}
+	cmds := supported.Commands()
+	if last := cmds[len(cmds)-1]; last != "ROUTING_INFO DESTINATION="+string(testDest) {
+		t.Fatalf("unexpected command %q", last)
+	}
+
+	unsupported := newMockSAM(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "HELLO") {
+			return "HELLO REPLY RESULT=OK VERSION=3.0\n"
+		}
+		return "SESSION STATUS RESULT=I2P_ERROR MESSAGE=\"Unknown command\"\n"
+	})
+	defer unsupported.Close()
+	sam2, err := NewSAMConfig(Config{Address: unsupported.Addr(), Extensions: []string{ExtRoutingInfo}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam2.Close()
+	if _, err := sam2.RoutingInfo(context.Background(), testDest); err != ErrNotSupported {
+		t.Fatalf("expected ErrNotSupported, got %v", err)
+	}
+}
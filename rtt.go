@@ -0,0 +1,52 @@
+package sam3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// Measures how long it takes to connect to addr. This is the time of the STREAM
+// CONNECT handshake, which includes a round trip through both your outbound and
+// the peers inbound tunnels, plus looking up the peers leaseset if the router
+// does not know it yet. It says nothing about how fast the application on the
+// other end responds; use MeasureEchoRTT for that, if the peer runs an echo
+// service.
+func (s *StreamSession) MeasureRTT(ctx context.Context, addr I2PAddr) (time.Duration, error) {
+	start := time.Now()
+	if err := s.Probe(ctx, addr); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
+// Measures the application round trip time to addr, which must run a service
+// that echoes back whatever is sent to it. Connects, then times sending payload
+// and reading it back; the time spent connecting is not included.
+func (s *StreamSession) MeasureEchoRTT(ctx context.Context, addr I2PAddr, payload []byte) (time.Duration, error) {
+	conn, err := s.DialContextI2P(ctx, addr)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	stop := watchContext(ctx, conn.conn)
+	start := time.Now()
+	buf := make([]byte, len(payload))
+	_, err = conn.Write(payload)
+	if err == nil {
+		_, err = io.ReadFull(conn, buf)
+	}
+	rtt := time.Since(start)
+	if stop() {
+		return 0, ctx.Err()
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(buf, payload) {
+		return 0, errors.New("Peer did not echo what was sent")
+	}
+	return rtt, nil
+}
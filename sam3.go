@@ -4,61 +4,193 @@ package sam3
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"net"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 // Used for controlling I2Ps SAMv3.
 type SAM struct {
 	address string // ipv4:port
+	cfg     Config
 	conn    net.Conn
+	version string // the negotiated SAM version
+
+	keepalive KeepaliveStrategy // set with SetKeepaliveStrategy
+	keyPool   *KeyGenPool       // set with SetKeyGenPool
+
+	abMu       sync.Mutex
+	abNames    map[string]bool // watched by SubscribeAddressBook
+	abInterval time.Duration   // how often SubscribeAddressBook polls
+
+	closeOnce sync.Once
+	closed    chan struct{} // closed by Close, to end waits such as retries
 }
 
 const (
-	session_OK             = "SESSION STATUS RESULT=OK DESTINATION="
-	session_DUPLICATE_ID   = "SESSION STATUS RESULT=DUPLICATED_ID\n"
-	session_DUPLICATE_DEST = "SESSION STATUS RESULT=DUPLICATED_DEST\n"
-	session_INVALID_KEY    = "SESSION STATUS RESULT=INVALID_KEY\n"
-	session_I2P_ERROR      = "SESSION STATUS RESULT=I2P_ERROR MESSAGE="
+	session_OK             = CmdSessionStatus + " RESULT=" + ResultOK + " DESTINATION="
+	session_DUPLICATE_ID   = CmdSessionStatus + " RESULT=" + ResultDuplicatedID + "\n"
+	session_DUPLICATE_DEST = CmdSessionStatus + " RESULT=" + ResultDuplicatedDest + "\n"
+	session_INVALID_KEY    = CmdSessionStatus + " RESULT=" + ResultInvalidKey + "\n"
+	session_I2P_ERROR      = CmdSessionStatus + " RESULT=" + ResultI2PError + " MESSAGE="
+
+	hello_OK        = CmdHelloReply + " RESULT=" + ResultOK + " VERSION="
+	hello_NOVERSION = CmdHelloReply + " RESULT=" + ResultNoVersion + "\n"
+	naming_REPLY    = CmdNamingReply + " "
+)
+
+var (
+	// Returned (wrapped) by Lookup when the name could not be resolved.
+	ErrNameNotFound = errors.New("Name not found")
+	// Returned when the SAM bridge does not support what was asked of it.
+	ErrNotSupported = errors.New("Not supported by the SAM bridge")
+	// Returned when a session with the same id exists on the bridge.
+	ErrDuplicateID = errors.New("Duplicate tunnel name")
 )
 
-// Creates a new controller for the I2P routers SAM bridge.
-func NewSAM(address string) (*SAM, error) {
-	conn, err := net.Dial("tcp4", address)
+// Creates a new controller for the I2P routers SAM bridge. See NewSAMConfig for
+// more control over how to connect.
+func NewSAM(address string, opts ...SAMOption) (*SAM, error) {
+	cfg := Config{Address: address}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return NewSAMConfig(cfg)
+}
+
+// The most lines a bridge may send before its reply to HELLO VERSION.
+const maxBannerLines = 16
+
+// Reads the reply to HELLO VERSION from conn. Some bridges greet clients with
+// a banner, or send other informational lines, before it; those are skipped.
+func readHelloReply(conn net.Conn) ([]byte, error) {
+	for i := 0; i <= maxBannerLines; i++ {
+		line, err := readLine(conn)
+		if err != nil {
+			return nil, err
+		}
+		if isHelloReply(line) {
+			return []byte(line), nil
+		}
+	}
+	return nil, errors.New("No reply to HELLO VERSION")
+}
+
+// Reports whether line is the reply to HELLO VERSION, rather than a banner.
+func isHelloReply(line string) bool {
+	return strings.HasPrefix(line, CmdHelloReply)
+}
+
+// Parses the reply to HELLO VERSION, returning the version the bridge chose.
+func parseHelloReply(reply []byte) (string, error) {
+	text := string(reply)
+	if strings.HasPrefix(text, hello_OK) && strings.HasSuffix(text, "\n") {
+		version := text[len(hello_OK) : len(text)-1]
+		if !strings.ContainsAny(version, " \n") {
+			return version, nil
+		}
+	}
+	if text == hello_NOVERSION {
+		return "", errors.New("That SAM bridge does not support SAMv3.")
+	}
+	return "", newParseError(CmdHelloVersion, reply)
+}
+
+// Negotiates the SAM version again, by sending a new HELLO VERSION on the
+// existing connection, so features of later versions can be used without
+// reconnecting (supported by i2pd 2.35 and later). Returns the new version, or
+// ErrNotSupported if the bridge refuses to renegotiate, in which case the old
+// version still applies.
+func (sam *SAM) UpgradeVersion(ctx context.Context, min, max string) (string, error) {
+	if strings.ContainsAny(min+max, " \n") {
+		return "", errors.New("Versions may not contain spaces or newlines")
+	}
+	stop := watchContext(ctx, sam.conn)
+	version, err := sam.hello(min, max)
+	if stop() {
+		return "", ctx.Err()
+	}
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	if _, err := conn.Write([]byte("HELLO VERSION MIN=3.0 MAX=3.0\n")); err != nil {
-		return nil, err
+	sam.version = version
+	sam.cfg.MinVersion, sam.cfg.MaxVersion = min, max
+	sam.logf("sam3: upgraded %s to SAM version %s", sam.cfg.Address, version)
+	return version, nil
+}
+
+func (sam *SAM) hello(min, max string) (string, error) {
+	cmd, err := sam.cfg.build(commandOf(CmdHelloVersion).Set("MIN", min).Set("MAX", max))
+	if err != nil {
+		return "", err
 	}
-	buf := make([]byte, 256)
-	n, err := conn.Read(buf)
+	if _, err := sam.conn.Write(cmd); err != nil {
+		return "", err
+	}
+	reply, err := readHelloReply(sam.conn)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	if string(buf[:n]) == "HELLO REPLY RESULT=OK VERSION=3.0\n" {
-		return &SAM{address, conn}, nil
-	} else if string(buf[:n]) == "HELLO REPLY RESULT=NOVERSION\n" {
-		return nil, errors.New("That SAM bridge does not support SAMv3.")
-	} else {
-		return nil, errors.New(string(buf[:n]))
+	version, err := parseHelloReply(reply)
+	if err != nil {
+		return "", ErrNotSupported
 	}
+	return version, nil
 }
 
 // Creates the I2P-equivalent of an IP address, that is unique and only the one
 // who has the private keys can send messages from. The public keys are the I2P
 // desination (the address) that anyone can send messages to.
-func (sam *SAM) NewKeys() (I2PKeys, error) {
-	if _, err := sam.conn.Write([]byte("DEST GENERATE\n")); err != nil {
+//
+// The keys have the signature type sigType, if given, or else Sig_Best, see
+// BestSignatureType. Asking for a type other than Sig_DSA_SHA1 requires SAM
+// 3.1, otherwise a *VersionError, matching ErrNotSupported, is returned.
+//
+// With a KeyGenPool set (see SetKeyGenPool), keys it generated ahead of time
+// are returned without asking the bridge, if it has any of the type.
+func (sam *SAM) NewKeys(sigType ...int) (I2PKeys, error) {
+	cmd := commandOf(CmdDestGenerate)
+	t := Sig_Best
+	if len(sigType) > 0 {
+		t = sigType[0]
+	}
+	if p := sam.keyPool; p != nil && p.sigType == t {
+		if keys, ok := p.TryGet(); ok {
+			return keys, nil
+		}
+	}
+	if t == Sig_Best {
+		t = sam.BestSignatureType()
+	}
+	if sam.Features().SignatureTypes {
+		cmd.Set("SIGNATURE_TYPE", strconv.Itoa(t))
+	} else if t != Sig_DSA_SHA1 {
+		return I2PKeys{}, requireVersion(sam.version, "3.1", "signature types other than DSA-SHA1")
+	}
+	b, err := sam.cfg.build(cmd)
+	if err != nil {
+		return I2PKeys{}, err
+	}
+	if _, err := sam.conn.Write(b); err != nil {
 		return I2PKeys{}, err
 	}
-	buf := make([]byte, 8192)
+	buf := getBuffer(8192)
+	defer putBuffer(buf)
 	n, err := sam.conn.Read(buf)
 	if err != nil {
 		return I2PKeys{}, err
 	}
-	s := bufio.NewScanner(bytes.NewReader(buf[:n]))
+	return parseDestReply(buf[:n])
+}
+
+// Parses the reply to DEST GENERATE.
+func parseDestReply(reply []byte) (I2PKeys, error) {
+	s := bufio.NewScanner(bytes.NewReader(reply))
 	s.Split(bufio.ScanWords)
 
 	var pub, priv string
@@ -73,7 +205,7 @@ func (sam *SAM) NewKeys() (I2PKeys, error) {
 		} else if strings.HasPrefix(text, "PRIV=") {
 			priv = text[5:]
 		} else {
-			return I2PKeys{}, errors.New("Failed to parse keys.")
+			return I2PKeys{}, newParseError(CmdDestGenerate, reply)
 		}
 	}
 	return I2PKeys{I2PAddr(pub), priv}, nil
@@ -82,29 +214,39 @@ func (sam *SAM) NewKeys() (I2PKeys, error) {
 // Performs a lookup, probably this order: 1) routers known addresses, cached
 // addresses, 3) by asking peers in the I2P network.
 func (sam *SAM) Lookup(name string) (I2PAddr, error) {
-	if _, err := sam.conn.Write([]byte("NAMING LOOKUP NAME=" + name + "\n")); err != nil {
+	cmd, err := sam.cfg.build(commandOf(CmdNamingLookup).Set("NAME", name))
+	if err != nil {
+		return I2PAddr(""), err
+	}
+	if _, err := sam.conn.Write(cmd); err != nil {
 		return I2PAddr(""), err
 	}
-	buf := make([]byte, 4096)
+	buf := getBuffer(4096)
+	defer putBuffer(buf)
 	n, err := sam.conn.Read(buf)
 	if err != nil {
 		return I2PAddr(""), err
 	}
-	if n <= 13 || !strings.HasPrefix(string(buf[:n]), "NAMING REPLY ") {
-		return I2PAddr(""), errors.New("Failed to parse.")
+	return parseLookupReply(name, buf[:n])
+}
+
+// Parses the reply to NAMING LOOKUP NAME=name.
+func parseLookupReply(name string, reply []byte) (I2PAddr, error) {
+	if len(reply) <= len(naming_REPLY) || !strings.HasPrefix(string(reply), naming_REPLY) {
+		return I2PAddr(""), newParseError(CmdNamingLookup, reply)
 	}
-	s := bufio.NewScanner(bytes.NewReader(buf[13:n]))
+	s := bufio.NewScanner(bytes.NewReader(reply[len(naming_REPLY):]))
 	s.Split(bufio.ScanWords)
 
 	errStr := ""
 	for s.Scan() {
 		text := s.Text()
-		if text == "RESULT=OK" {
+		if text == "RESULT="+ResultOK {
 			continue
-		} else if text == "RESULT=INVALID_KEY" {
+		} else if text == "RESULT="+ResultInvalidKey {
 			errStr += "Invalid key."
-		} else if text == "RESULT=KEY_NOT_FOUND" {
-			errStr += "Unable to resolve " + name
+		} else if text == "RESULT="+ResultKeyNotFound {
+			return I2PAddr(""), fmt.Errorf("Unable to resolve %s: %w", name, ErrNameNotFound)
 		} else if text == "NAME="+name {
 			continue
 		} else if strings.HasPrefix(text, "VALUE=") {
@@ -112,77 +254,182 @@ func (sam *SAM) Lookup(name string) (I2PAddr, error) {
 		} else if strings.HasPrefix(text, "MESSAGE=") {
 			errStr += " " + text[8:]
 		} else {
-			return I2PAddr(""), errors.New("Failed to parse lookup reply.")
+			return I2PAddr(""), newParseError(CmdNamingLookup, reply)
 		}
 	}
 	return I2PAddr(""), errors.New(errStr)
 }
 
+// How many times, and how long at most, newGenericSession waits and tries
+// again when the router asks it to.
+const (
+	sessionRetries  = 3
+	maxSessionRetry = 30 * time.Second
+)
+
 // Creates a new session with the style of either "STREAM", "DATAGRAM" or "RAW",
 // for a new I2P tunnel with name id, using the cypher keys specified, with the
-// I2CP/streaminglib-options as specified. Extra arguments can be specified by
-// setting extra to something else than []string{}. Returns the connection used
+// I2CP/streaminglib-options as specified. Extra arguments of SESSION CREATE
+// can be given in extras, as KEY=VALUE tokens, see checkExtras; malformed ones
+// are refused before SESSION CREATE is sent. Returns the connection used
 // to control the SAMv3 bridge. The SAM-object should be treated as destroyed
-// after calling this function on it.
-func (sam *SAM) newGenericSession(style, id string, keys I2PKeys, options []string, extras []string) (net.Conn, error) {
-	sam2, err := NewSAM(sam.address)
-	if err != nil {
-		return nil, errors.New("Unable to create new streaming tunnel.")
+// after calling this function on it. If the router refuses with an I2PError
+// that says when to try again, it is tried again after that long, unless done
+// is closed in the meantime, which returns net.ErrClosed.
+func (sam *SAM) newGenericSession(done <-chan struct{}, style, id string, keys I2PKeys, options []string, extras []string) (net.Conn, error) {
+	conn, _, err := sam.newGenericSessionReply(done, style, id, keys, options, extras)
+	return conn, err
+}
+
+// Like newGenericSession, but also returns the reply to SESSION CREATE.
+func (sam *SAM) newGenericSessionReply(done <-chan struct{}, style, id string, keys I2PKeys, options []string, extras []string) (net.Conn, SAMReply, error) {
+	for attempt := 1; ; attempt++ {
+		sam2, err := NewSAMConfig(sam.cfg)
+		if err != nil {
+			return nil, SAMReply{}, errors.New("Unable to create new streaming tunnel.")
+		}
+		reply, err := sam2.createSession(style, id, keys, options, extras)
+		if err == nil {
+			return sam2.conn, reply, nil
+		}
+		sam2.conn.Close()
+		var ierr *I2PError
+		if !errors.As(err, &ierr) || ierr.RetryAfter() == nil || attempt == sessionRetries {
+			return nil, SAMReply{}, err
+		}
+		delay := *ierr.RetryAfter()
+		if delay > maxSessionRetry {
+			delay = maxSessionRetry
+		}
+		sam.logf("sam3: creating session %s failed (%v), trying again in %v", id, err, delay)
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-done:
+			t.Stop()
+			return nil, SAMReply{}, net.ErrClosed
+		}
 	}
-	optStr := ""
-	for _, opt := range options {
-		optStr += "OPTION=" + opt + " "
+}
+
+// Returns the keys of a session created with keys: keys itself, unless they
+// are TRANSIENT, in which case the bridge generated them, and returned them,
+// private keys included, as the DESTINATION of its reply.
+func sessionKeys(keys I2PKeys, reply SAMReply) (I2PKeys, error) {
+	if keys.String() != "TRANSIENT" {
+		return keys, nil
 	}
+	return keysFromPrivate(reply.Pairs["DESTINATION"])
+}
 
-	conn := sam2.conn
-	scmsg := []byte("SESSION CREATE STYLE=" + style + " ID=" + id + " DESTINATION=" + keys.String() + " " + optStr + strings.Join(extras, " ") + "\n")
+// Sends SESSION CREATE on the connection of sam, which then controls the
+// session. Returns the reply of the bridge.
+func (sam *SAM) createSession(style, id string, keys I2PKeys, options []string, extras []string) (SAMReply, error) {
+	conn := sam.conn
+	if err := checkExtras(extras); err != nil {
+		return SAMReply{}, err
+	}
+	extras = signatureParams(sam.version, extras, keys.String() == "TRANSIENT")
+	if err := requireParams(sam.version, extras); err != nil {
+		return SAMReply{}, err
+	}
+	cmd := commandOf(CmdSessionCreate).Set("STYLE", style).Set("ID", id).Set("DESTINATION", keys.String())
+	scmsg, err := sam.cfg.build(cmd.addOptions(options, extras))
+	if err != nil {
+		return SAMReply{}, err
+	}
 	for m, i := 0, 0; m != len(scmsg); i++ {
 		if i == 15 {
-			conn.Close()
-			return nil, errors.New("writing to SAM failed")
+			return SAMReply{}, errors.New("writing to SAM failed")
 		}
 		n, err := conn.Write(scmsg[m:])
 		if err != nil {
-			conn.Close()
-			return nil, err
+			return SAMReply{}, err
 		}
 		m += n
 	}
-	buf := make([]byte, 4096)
+	buf := getBuffer(4096)
+	defer putBuffer(buf)
 	n, err := conn.Read(buf)
 	if err != nil {
-		conn.Close()
-		return nil, err
+		return SAMReply{}, err
+	}
+	if err := parseSessionReply(buf[:n], keys); err != nil {
+		return SAMReply{}, err
 	}
-	text := string(buf[:n])
+	return parseSAMReply(string(buf[:n]))
+}
+
+// Parses the reply to SESSION CREATE, which was sent with keys.
+func parseSessionReply(reply []byte, keys I2PKeys) error {
+	text := string(reply)
 	if strings.HasPrefix(text, session_OK) {
-		if keys.String() != text[len(session_OK):len(text)-1] {
-			return nil, errors.New("SAMv3 created a tunnel with keys other than the ones we asked it for")
+		dest := strings.TrimSuffix(text[len(session_OK):], "\n")
+		// newer bridges may add more fields after the destination
+		if i := strings.IndexByte(dest, ' '); i >= 0 {
+			dest = dest[:i]
 		}
-		return conn, nil //&StreamSession{id, conn, keys, nil, sync.RWMutex{}, nil}, nil
+		// TRANSIENT keys are replaced by the ones the bridge generated
+		if keys.String() != "TRANSIENT" && keys.String() != dest {
+			return errors.New("SAMv3 created a tunnel with keys other than the ones we asked it for")
+		}
+		return nil
 	} else if text == session_DUPLICATE_ID {
-		conn.Close()
-		return nil, errors.New("Duplicate tunnel name")
+		return ErrDuplicateID
 	} else if text == session_DUPLICATE_DEST {
-		conn.Close()
-		return nil, errors.New("Duplicate destination")
+		return errors.New("Duplicate destination")
 	} else if text == session_INVALID_KEY {
-		conn.Close()
-		return nil, errors.New("Invalid key")
+		return errors.New("Invalid key")
 	} else if strings.HasPrefix(text, session_I2P_ERROR) {
-		conn.Close()
-		return nil, errors.New("I2P error " + text[len(session_I2P_ERROR):])
+		e := parseI2PErrorMessage(text[len(session_I2P_ERROR):])
+		return &e
 	} else {
-		conn.Close()
-		return nil, errors.New("Unable to parse SAMv3 reply: " + text)
+		return newParseError(CmdSessionCreate, reply)
 	}
 }
 
 // Closes the connection to SAM. Does not affect sessions or listeners created,
-// they need to be closed separately.
+// they need to be closed separately. The connection is closed at once; use
+// CloseContext to close it gracefully.
 func (sam *SAM) Close() error {
+	sam.markClosed()
 	if err := sam.conn.Close(); err != nil {
 		return err
 	}
 	return nil
 }
+
+// Closes sam.closed, if sam has one, ending the waits of sam.
+func (sam *SAM) markClosed() {
+	if sam.closed != nil {
+		sam.closeOnce.Do(func() { close(sam.closed) })
+	}
+}
+
+// Makes blocking I/O on conn return once ctx is done, by moving the deadline of
+// conn into the past. The returned function must be called when the I/O is
+// over; it reports whether ctx interrupted conn, and otherwise clears the
+// deadline again.
+func watchContext(ctx context.Context, conn net.Conn) func() bool {
+	if ctx.Done() == nil {
+		return func() bool { return false }
+	}
+	stop, interrupted := make(chan struct{}), make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+			interrupted <- true
+		case <-stop:
+			interrupted <- false
+		}
+	}()
+	return func() bool {
+		close(stop)
+		if <-interrupted {
+			return true
+		}
+		conn.SetDeadline(time.Time{})
+		return false
+	}
+}
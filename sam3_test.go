@@ -1,7 +1,10 @@
 package sam3
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 )
@@ -59,21 +62,21 @@ func Test_GenericSession(t *testing.T) {
 		fmt.Println(err.Error())
 		t.Fail()
 	} else {
-		conn1, err := sam.newGenericSession("STREAM", "testTun", keys, []string{})
+		conn1, err := sam.newGenericSession(sam.closed, "STREAM", "testTun", keys, []string{})
 		if err != nil {
 			fmt.Println(err.Error())
 			t.Fail()
 		} else {
 			conn1.Close()
 		}
-		conn2, err := sam.newGenericSession("STREAM", "testTun", keys, []string{"inbound.length=1", "outbound.length=1", "inbound.lengthVariance=1", "outbound.lengthVariance=1", "inbound.quantity=1", "outbound.quantity=1"})
+		conn2, err := sam.newGenericSession(sam.closed, "STREAM", "testTun", keys, []string{"inbound.length=1", "outbound.length=1", "inbound.lengthVariance=1", "outbound.lengthVariance=1", "inbound.quantity=1", "outbound.quantity=1"})
 		if err != nil {
 			fmt.Println(err.Error())
 			t.Fail()
 		} else {
 			conn2.Close()
 		}
-		conn3, err := sam.newGenericSession("DATAGRAM", "testTun", keys, []string{"inbound.length=1", "outbound.length=1", "inbound.lengthVariance=1", "outbound.lengthVariance=1", "inbound.quantity=1", "outbound.quantity=1"})
+		conn3, err := sam.newGenericSession(sam.closed, "DATAGRAM", "testTun", keys, []string{"inbound.length=1", "outbound.length=1", "inbound.lengthVariance=1", "outbound.lengthVariance=1", "inbound.quantity=1", "outbound.quantity=1"})
 		if err != nil {
 			fmt.Println(err.Error())
 			t.Fail()
@@ -162,3 +165,121 @@ func Test_RawServerClient(t *testing.T) {
 	fmt.Println("\tServer: Received datagram: " + string(buf[:n]))
 	//	fmt.Println("\tServer: Senders address was: " + saddr.Base32())
 }
+
+func Test_NewSAMConfig(t *testing.T) {
+	mock := newMockSAM(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "HELLO") && mockField(cmd, "MAX") == "3.2" {
+			return "HELLO REPLY RESULT=OK VERSION=3.2\n"
+		}
+		return ""
+	})
+	defer mock.Close()
+	sam, err := NewSAMConfig(Config{Address: mock.Addr(), MaxVersion: "3.2", User: "user", Password: "secret", HandshakeTimeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	if sam.Version() != "3.2" {
+		t.Fatalf("expected version 3.2, got %q", sam.Version())
+	}
+	if cmds := mock.Commands(); len(cmds) != 1 || cmds[0] != "HELLO VERSION MIN=3.0 MAX=3.2 USER=user PASSWORD=secret" {
+		t.Fatalf("unexpected HELLO %q", cmds)
+	}
+	if _, err := NewSAMConfig(Config{Address: mock.Addr(), User: "bad user"}); err == nil {
+		t.Fatal("accepted a user name with a space")
+	}
+	if _, err := NewSAMConfig(Config{Address: mock.Addr(), MaxVersion: "3.1", User: "user", Password: "secret"}); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("expected a user and password to need SAM 3.2, got %v", err)
+	}
+}
+
+func Test_HelloBanner(t *testing.T) {
+	mock := newMockSAM(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "HELLO") {
+			return "Welcome to the SAM bridge\nHELLO REPLY RESULT=OK VERSION=3.1\n"
+		}
+		return mockLookup(cmd)
+	})
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	if sam.Version() != "3.1" {
+		t.Fatalf("expected version 3.1, got %q", sam.Version())
+	}
+	// nothing of the reply may be left to be mistaken for the next one
+	if _, err := sam.Lookup("known.i2p"); err != nil {
+		t.Fatal(err)
+	}
+
+	chatty := newMockSAM(t, func(cmd string) string {
+		return strings.Repeat("Welcome\n", maxBannerLines+1) + "HELLO REPLY RESULT=OK VERSION=3.1\n"
+	})
+	defer chatty.Close()
+	if _, err := NewSAM(chatty.Addr()); err == nil {
+		t.Fatal("accepted a reply after too many banner lines")
+	}
+}
+
+func Test_UpgradeVersion(t *testing.T) {
+	hellos := 0
+	mock := newMockSAM(t, func(cmd string) string {
+		if !strings.HasPrefix(cmd, "HELLO") {
+			return ""
+		}
+		hellos++
+		switch hellos {
+		case 1:
+			return ""
+		case 2:
+			return "HELLO REPLY RESULT=OK VERSION=3.2\n"
+		}
+		return "HELLO REPLY RESULT=I2P_ERROR MESSAGE=\"already said hello\"\n"
+	})
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	version, err := sam.UpgradeVersion(context.Background(), "3.0", "3.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != "3.2" || sam.Version() != "3.2" {
+		t.Fatalf("expected version 3.2, got %q and %q", version, sam.Version())
+	}
+	if _, err := sam.UpgradeVersion(context.Background(), "3.0", "3.3"); err != ErrNotSupported {
+		t.Fatalf("expected ErrNotSupported, got %v", err)
+	}
+	if sam.Version() != "3.2" {
+		t.Fatalf("a refused upgrade changed the version to %q", sam.Version())
+	}
+}
+
+func Test_CloseContext(t *testing.T) {
+	mock := newMockSAM(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "HELLO") {
+			return "HELLO REPLY RESULT=OK VERSION=3.2\n"
+		}
+		return ""
+	})
+	defer mock.Close()
+	sam, err := NewSAMConfig(Config{Address: mock.Addr(), MaxVersion: "3.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := sam.CloseContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("CloseContext waited for the deadline, although the bridge hung up")
+	}
+	if cmds := mock.Commands(); len(cmds) != 2 || cmds[1] != "QUIT" {
+		t.Fatalf("expected QUIT to be sent, got %q", cmds)
+	}
+}
@@ -0,0 +1,58 @@
+package sam3
+
+// The results SAM bridges answer commands with, the RESULT= field of a reply,
+// see SAMReply.Result.
+const (
+	ResultOK             = "OK"
+	ResultCantReachPeer  = "CANT_REACH_PEER"
+	ResultDuplicatedDest = "DUPLICATED_DEST"
+	ResultDuplicatedID   = "DUPLICATED_ID"
+	ResultI2PError       = "I2P_ERROR"
+	ResultInvalidID      = "INVALID_ID"
+	ResultInvalidKey     = "INVALID_KEY"
+	ResultKeyNotFound    = "KEY_NOT_FOUND"
+	ResultPeerNotFound   = "PEER_NOT_FOUND"
+	ResultTimeout        = "TIMEOUT"
+	ResultNoVersion      = "NOVERSION" // only in reply to HELLO VERSION
+)
+
+// The commands of the SAM protocol and the replies to them, as "TOPIC TYPE",
+// the first two words of the line, see Command and SAMReply.
+const (
+	CmdHelloVersion = "HELLO VERSION"
+	CmdHelloReply   = "HELLO REPLY"
+
+	CmdSessionCreate = "SESSION CREATE"
+	CmdSessionAdd    = "SESSION ADD"    // SAM 3.3
+	CmdSessionRemove = "SESSION REMOVE" // SAM 3.3
+	CmdSessionStatus = "SESSION STATUS"
+
+	CmdStreamConnect = "STREAM CONNECT"
+	CmdStreamAccept  = "STREAM ACCEPT"
+	CmdStreamForward = "STREAM FORWARD"
+	CmdStreamStatus  = "STREAM STATUS"
+
+	CmdDatagramSend     = "DATAGRAM SEND"
+	CmdDatagramReceived = "DATAGRAM RECEIVED"
+	CmdDatagramClose    = "DATAGRAM CLOSE" // not in all bridges, see WithDatagramClose
+	CmdRawSend          = "RAW SEND"
+	CmdRawReceived      = "RAW RECEIVED"
+
+	CmdNamingLookup = "NAMING LOOKUP"
+	CmdNamingReply  = "NAMING REPLY"
+
+	CmdDestGenerate = "DEST GENERATE"
+	CmdDestReply    = "DEST REPLY"
+
+	// Not part of the SAM specification; bridges that do not know it answer
+	// with an error, see DatagramSession.SetRecvQueueSize.
+	CmdOptionsSet = "OPTIONS SET"
+
+	CmdAuthAdd    = "AUTH ADD"    // SAM 3.2
+	CmdAuthRemove = "AUTH REMOVE" // SAM 3.2
+
+	// Single words, without a type (SAM 3.2).
+	CmdPing = "PING"
+	CmdPong = "PONG"
+	CmdQuit = "QUIT"
+)
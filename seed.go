@@ -0,0 +1,82 @@
+package sam3
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/big"
+)
+
+// The 2048 bit MODP group of RFC 3526, which I2P uses for ElGamal, with
+// generator 2.
+var elgamalP, _ = new(big.Int).SetString(
+	"FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74"+
+		"020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F1437"+
+		"4FE1356D6D51C245E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED"+
+		"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE45B3DC2007CB8A163BF05"+
+		"98DA48361C55D39A69163FA8FD24CF5F83655D23DCA3AD961C62F356208552BB"+
+		"9ED529077096966D670C354E4ABC9804F1746C08CA18217C32905E462E36CE3B"+
+		"E39E772C180E86039B2783A2EC07A28FB5C55DF06F4C52C9DE2BCBF695581718"+
+		"3995497CEA956AE515D2261898FA051015728E5A8AACAA68FFFFFFFFFFFFFFFF", 16)
+
+// Derives I2PKeys from seed, without asking the router: the same seed always
+// gives the same destination. The destination has an Ed25519 signing key and
+// an ElGamal encryption key; sigType must be Sig_EdDSA_SHA512_Ed25519, no other
+// type is supported.
+//
+// This is meant for tests and development environments that want stable
+// addresses without storing key files. Anyone who knows the seed has the
+// private keys, so never use it for identities that matter, and never with a
+// guessable seed.
+func GenerateKeysFromSeed(seed []byte, sigType int) (I2PKeys, error) {
+	if sigType != Sig_EdDSA_SHA512_Ed25519 {
+		return I2PKeys{}, errors.New("Only Ed25519 keys can be derived from a seed")
+	}
+	if len(seed) == 0 {
+		return I2PKeys{}, errors.New("Empty seed")
+	}
+	signPriv := ed25519.NewKeyFromSeed(deriveSeedBytes(seed, "ed25519", ed25519.SeedSize))
+	signPub := signPriv.Public().(ed25519.PublicKey)
+
+	// x in [1, p-2]
+	x := new(big.Int).SetBytes(deriveSeedBytes(seed, "elgamal", 256))
+	x.Mod(x, new(big.Int).Sub(elgamalP, big.NewInt(2)))
+	x.Add(x, big.NewInt(1))
+	y := new(big.Int).Exp(big.NewInt(2), x, elgamalP)
+
+	dest := make([]byte, 0, 391)
+	dest = append(dest, leftPad(y.Bytes(), 256)...)
+	dest = append(dest, deriveSeedBytes(seed, "padding", 128-ed25519.PublicKeySize)...)
+	dest = append(dest, signPub...)
+	dest = append(dest, cert_KEY, 0, 4, 0, Sig_EdDSA_SHA512_Ed25519, 0, Crypto_ElGamal)
+
+	priv := append([]byte(nil), dest...)
+	priv = append(priv, leftPad(x.Bytes(), 256)...)
+	priv = append(priv, signPriv.Seed()...)
+
+	return NewKeys(I2PAddr(i2pB64enc.EncodeToString(dest)), i2pB64enc.EncodeToString(priv)), nil
+}
+
+// Expands seed into n bytes for the given purpose.
+func deriveSeedBytes(seed []byte, purpose string, n int) []byte {
+	out := make([]byte, 0, n+sha256.Size)
+	var counter [4]byte
+	for i := uint32(0); len(out) < n; i++ {
+		binary.BigEndian.PutUint32(counter[:], i)
+		h := sha256.New()
+		h.Write([]byte("sam3 " + purpose))
+		h.Write(counter[:])
+		h.Write(seed)
+		out = h.Sum(out)
+	}
+	return out[:n]
+}
+
+// Returns b left-padded with zeros to n bytes.
+func leftPad(b []byte, n int) []byte {
+	if len(b) >= n {
+		return b
+	}
+	return append(make([]byte, n-len(b)), b...)
+}
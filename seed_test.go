@@ -0,0 +1,35 @@
+package sam3
+
+import (
+	"testing"
+)
+
+func Test_GenerateKeysFromSeed(t *testing.T) {
+	keys, err := GenerateKeysFromSeed([]byte("test identity"), Sig_EdDSA_SHA512_Ed25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := GenerateKeysFromSeed([]byte("test identity"), Sig_EdDSA_SHA512_Ed25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys != again {
+		t.Fatal("the same seed gave different keys")
+	}
+	other, err := GenerateKeysFromSeed([]byte("other identity"), Sig_EdDSA_SHA512_Ed25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Addr() == keys.Addr() {
+		t.Fatal("different seeds gave the same destination")
+	}
+	if _, err := NewI2PAddrFromString(string(keys.Addr())); err != nil {
+		t.Fatal(err)
+	}
+	if addr, err := destFromPrivate(keys.String()); err != nil || addr != keys.Addr() {
+		t.Fatalf("private keys do not start with the destination: %v", err)
+	}
+	if _, err := GenerateKeysFromSeed([]byte("x"), Sig_DSA_SHA1); err == nil {
+		t.Fatal("accepted DSA-SHA1")
+	}
+}
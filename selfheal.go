@@ -0,0 +1,148 @@
+package sam3
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// Keeps track of tunnel failures for a StreamSession with self-healing enabled.
+type selfHeal struct {
+	mu        sync.Mutex
+	threshold int
+	window    time.Duration
+	failures  []time.Time
+}
+
+// Enables self-healing: if dialing fails with ErrCantReachPeer or
+// ErrI2PInternal threshold times within window, the tunnels of the session are
+// assumed to be broken. The session is then transparently recreated, with the
+// same keys (so the I2P address stays the same) and options, and the dial is
+// retried once.
+//
+// Recreating a session means building new tunnels, which takes several seconds
+// (often more), and the dial that triggered it waits for that. Listeners of the
+// session stop working when it is recreated and have to be created again.
+// Self-healing is off by default; a threshold of zero turns it off again.
+// Subsessions share the tunnels of their MasterSession, so they can not be
+// recreated on their own, and return an error.
+func (s *StreamSession) SetSelfHeal(threshold int, window time.Duration) error {
+	if s.master != nil {
+		return errors.New("Self-healing is not available for subsessions")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if threshold <= 0 {
+		s.heal = nil
+		return nil
+	}
+	s.heal = &selfHeal{threshold: threshold, window: window}
+	return nil
+}
+
+// Records a failed dial. Reports whether the session should be recreated.
+func (h *selfHeal) failed(err error) bool {
+	if err != ErrCantReachPeer && err != ErrI2PInternal {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	recent := h.failures[:0]
+	for _, t := range h.failures {
+		if now.Sub(t) < h.window {
+			recent = append(recent, t)
+		}
+	}
+	h.failures = append(recent, now)
+	if len(h.failures) < h.threshold {
+		return false
+	}
+	h.failures = h.failures[:0]
+	return true
+}
+
+// Tears down the session and creates it again with the same id, keys and
+// options. h is the selfHeal that asked for it, which serializes recreations.
+func (s *StreamSession) recreate(h *selfHeal) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return s.reopen(s.sessionOpts())
+}
+
+// Tears down the session and creates it again with the same id and keys, but
+// with the options so. Subsessions can not be recreated, since their control
+// connection is the one of the MasterSession.
+//
+// Bridges refuse a second session with the id or keys of one that is still
+// open, so the old session has to be torn down before the new one is created.
+// The options are therefore checked first, and if creating the session fails
+// anyway, it is created again with the options it had; only if that fails,
+// too, the session is SessionFailed. The error is the one of the first try.
+func (s *StreamSession) reopen(so *sessionOptions) error {
+	if s.master != nil {
+		return errors.New("A subsession can not be recreated")
+	}
+	id, keys := s.ID(), s.Keys()
+	old := s.sessionOpts()
+	restore := so != old
+	so = s.withPersistentOptions(so)
+	if err := checkExtras(so.extras()); err != nil {
+		return err
+	}
+	if _, err := s.cfg.build(commandOf(CmdSessionCreate).Set("ID", id).addOptions(so.options(), so.extras())); err != nil {
+		return err
+	}
+	if err := s.state.reconnect(); err != nil {
+		return err
+	}
+	done := s.startRebuilding()
+	defer done()
+	s.controlConn().Close()
+	sam := &SAM{address: s.cfg.Address, cfg: s.cfg}
+	conn, err := sam.newGenericSession(s.state.closing(), "STREAM", id, keys, so.options(), so.extras())
+	if err != nil && restore {
+		var rerr error
+		if conn, rerr = sam.newGenericSession(s.state.closing(), "STREAM", id, keys, old.options(), old.extras()); rerr == nil {
+			sam.logf("sam3: recreating session %s failed (%v), restored its old options", id, err)
+			so = old
+		}
+	}
+	if conn == nil {
+		s.state.fail()
+		return err
+	}
+	s.mu.Lock()
+	s.conn, s.opts = conn, so
+	s.mu.Unlock()
+	if err := s.state.established(); err != nil {
+		// closed while it was being recreated
+		conn.Close()
+		return err
+	}
+	s.watchDisconnect(conn)
+	return err
+}
+
+// Returns the control connection of the session. It is replaced when the
+// session is recreated, so it is read under s.mu, like opts and heal.
+func (s *StreamSession) controlConn() net.Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn
+}
+
+// Returns the options the session was last created with.
+func (s *StreamSession) sessionOpts() *sessionOptions {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.opts
+}
+
+// Returns the self-healing state of the session, nil if it is off.
+func (s *StreamSession) selfHealer() *selfHeal {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.heal
+}
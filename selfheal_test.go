@@ -0,0 +1,86 @@
+package sam3
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_SelfHealConcurrent(t *testing.T) {
+	mock := newMockSAM(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "STREAM FORWARD") {
+			return "STREAM STATUS RESULT=OK\n"
+		}
+		return mockOK(cmd)
+	})
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	ss, err := sam.NewStreamSession("healTun", NewKeys(I2PAddr("pub"), "pubpriv"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	// run with -race: recreating the session replaces its control connection
+	// and options while they are read
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			switch i {
+			case 0:
+				ss.SetSelfHeal(1, time.Minute)
+				ss.SetSelfHeal(0, 0)
+			case 1:
+				ss.UpdateOptions([]string{"inbound.length=1"})
+			case 2:
+				if l, err := ss.Listen(); err == nil {
+					l.Close()
+				}
+			case 3:
+				ss.OnDisconnect(func(error) {})
+				ss.IsEphemeral()
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func Test_SelfHealSubsession(t *testing.T) {
+	mock := newMockSAM(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "HELLO") && mockField(cmd, "MAX") == "3.3" {
+			return "HELLO REPLY RESULT=OK VERSION=3.3\n"
+		}
+		return mockOK(cmd)
+	})
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	master, err := sam.NewMasterSession(context.Background(), "masterTun", NewKeys(I2PAddr("pub"), "pubpriv"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer master.Close()
+	ss, err := master.AddStream("sub1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.SetSelfHeal(1, time.Minute); err == nil {
+		t.Fatal("self-healing enabled on a subsession")
+	}
+	if err := ss.reopen(ss.sessionOpts()); err == nil {
+		t.Fatal("recreated a subsession")
+	}
+	if ss.State() != SessionActive {
+		t.Fatalf("subsession left %v", ss.State())
+	}
+}
@@ -0,0 +1,127 @@
+package sam3
+
+import (
+	"errors"
+	"sync"
+)
+
+// Returned by NewServer when the id set with WithID is taken on the bridge.
+// Matches ErrDuplicateID.
+type DuplicateIDError struct {
+	ID        string // the id that was taken
+	Suggested string // a free id, most likely
+}
+
+func (e *DuplicateIDError) Error() string {
+	return "Duplicate tunnel name " + e.ID + ", try a unique one such as " + e.Suggested
+}
+
+func (e *DuplicateIDError) Unwrap() error {
+	return ErrDuplicateID
+}
+
+// A stream session serving connections on a stable I2P address, with the SAM
+// bridge and the session behind it set up by NewServer. The lower-level APIs
+// remain available through Session.
+type Server struct {
+	session *StreamSession
+
+	mu       sync.Mutex
+	listener *StreamListener // created by the first Listen
+}
+
+// Connects to the SAM bridge at samAddr and creates a stream session with
+// keys on it, so the service is reachable at the destination of keys. The
+// session has the tunnels of Options_Small, which opts can change, and a
+// random id, unless WithID is given; if that id is taken, a
+// *DuplicateIDError suggests another.
+func NewServer(samAddr string, keys I2PKeys, opts ...Option) (*Server, error) {
+	so, err := applyOptions(nil, opts)
+	if err != nil {
+		return nil, err
+	}
+	id, err := sessionID(so, "sam3server")
+	if err != nil {
+		return nil, err
+	}
+	sam, err := NewSAM(samAddr)
+	if err != nil {
+		return nil, err
+	}
+	// the session has a connection of its own
+	defer sam.Close()
+	session, err := sam.NewStreamSession(id, keys, Options_Small, opts...)
+	if err == ErrDuplicateID {
+		suggested, err := randomID(id + "-")
+		if err != nil {
+			return nil, err
+		}
+		return nil, &DuplicateIDError{ID: id, Suggested: suggested}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Server{session: session}, nil
+}
+
+// Returns the listener of the server, creating it on the first call.
+func (s *Server) Listen() (*StreamListener, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		l, err := s.session.Listen()
+		if err != nil {
+			return nil, err
+		}
+		s.listener = l
+	}
+	return s.listener, nil
+}
+
+// Accepts connections and serves each with handler, in a goroutine of its
+// own, until the server is closed. Returns the error that stopped accepting.
+func (s *Server) Serve(handler ConnHandler) error {
+	l, err := s.Listen()
+	if err != nil {
+		return err
+	}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			var herr handshakeError
+			if errors.As(err, &herr) {
+				continue
+			}
+			return err
+		}
+		go handler.ServeConn(conn)
+	}
+}
+
+// Returns the b32 address of the server, the short form of its destination,
+// for sharing.
+func (s *Server) Addr() string {
+	return s.session.Addr().Base32()
+}
+
+// Returns the full destination of the server.
+func (s *Server) Destination() I2PAddr {
+	return s.session.Addr()
+}
+
+// Returns the session of the server, for what Server does not wrap.
+func (s *Server) Session() *StreamSession {
+	return s.session
+}
+
+// Closes the listener, if any, and the session, and with it the connection to
+// the bridge.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	l := s.listener
+	s.mu.Unlock()
+	if l != nil {
+		l.Close()
+	}
+	return s.session.Close()
+}
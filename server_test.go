@@ -0,0 +1,55 @@
+package sam3
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func Test_Server(t *testing.T) {
+	mock := newMockSAM(t, func(cmd string) string {
+		switch {
+		case strings.HasPrefix(cmd, "SESSION CREATE") && mockField(cmd, "ID") == "taken":
+			return "SESSION STATUS RESULT=DUPLICATED_ID\n"
+		case strings.HasPrefix(cmd, "STREAM FORWARD"):
+			go func(port string) {
+				conn, err := net.Dial("tcp4", "127.0.0.1:"+port)
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				conn.Write([]byte(testDest + "\nhello"))
+			}(mockField(cmd, "PORT"))
+			return "STREAM STATUS RESULT=OK\n"
+		}
+		return mockOK(cmd)
+	})
+	defer mock.Close()
+	keys := NewKeys(testDest, string(testDest)+"priv")
+
+	_, err := NewServer(mock.Addr(), keys, WithID("taken"))
+	var derr *DuplicateIDError
+	if !errors.As(err, &derr) || !errors.Is(err, ErrDuplicateID) || !strings.HasPrefix(derr.Suggested, "taken-") {
+		t.Fatalf("expected a *DuplicateIDError suggesting an id, got %v", err)
+	}
+
+	s, err := NewServer(mock.Addr(), keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.Addr() != testDest.Base32() || s.Destination() != testDest {
+		t.Fatalf("unexpected address %q", s.Addr())
+	}
+	got := make(chan string, 1)
+	go s.Serve(ConnHandlerFunc(func(conn net.Conn) {
+		defer conn.Close()
+		buf := make([]byte, 5)
+		n, _ := conn.Read(buf)
+		got <- string(buf[:n])
+	}))
+	if msg := <-got; msg != "hello" {
+		t.Fatalf("expected hello, got %q", msg)
+	}
+}
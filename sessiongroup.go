@@ -0,0 +1,294 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Returned by the methods of a SessionGroup after it was closed.
+var ErrSessionGroupClosed = errors.New("Session group is closed")
+
+// A fleet of identical stream sessions, for services that would saturate the
+// tunnels of a single session. Dials are spread over the sessions, and Accept
+// takes connections from all of them, like a pool of sessions.
+//
+// Every session has a destination of its own: bridges refuse to create two
+// sessions with the same keys (DUPLICATED_DEST), so the sessions are created
+// with transient keys, and a service reachable on all of them has to publish
+// all their addresses, see Addrs.
+type SessionGroup struct {
+	sam     *SAM
+	id      string // the sessions are named id0, id1, ...
+	options []string
+	opts    []Option
+
+	scaleMu sync.Mutex // serializes Scale, which creates sessions without mu
+	mu      sync.Mutex
+	members []*groupMember
+	nextID  int  // the number of the next session created
+	next    int  // where the search for the least busy session starts
+	listen  bool // set by the first Accept; new sessions then listen too
+
+	accepted chan net.Conn
+	failed   chan error // the error of the last listener to fail
+	closed   chan struct{}
+}
+
+type groupMember struct {
+	session  *StreamSession
+	listener *StreamListener // nil until the group accepts
+	active   int64           // open connections, dialed and accepted
+}
+
+// Creates a SessionGroup of n stream sessions with the options given, named
+// id followed by a number.
+func (sam *SAM) NewSessionGroup(id string, n int, options []string, opts ...Option) (*SessionGroup, error) {
+	g := &SessionGroup{sam: sam, id: id, options: options, opts: opts, accepted: make(chan net.Conn), failed: make(chan error, 1), closed: make(chan struct{})}
+	if err := g.Scale(n); err != nil {
+		g.Close()
+		return nil, err
+	}
+	return g, nil
+}
+
+// Returns the number of sessions in the group.
+func (g *SessionGroup) Len() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.members)
+}
+
+// Returns the destinations of the sessions in the group.
+func (g *SessionGroup) Addrs() []I2PAddr {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	addrs := make([]I2PAddr, len(g.members))
+	for i, m := range g.members {
+		addrs[i] = m.session.Addr()
+	}
+	return addrs
+}
+
+// Grows or shrinks the group to n sessions. New sessions are created before
+// the group grows, so a failure leaves it as it was. Removed sessions are the
+// newest ones; they are closed, and their connections with them.
+func (g *SessionGroup) Scale(n int) error {
+	if n < 1 {
+		return errors.New("A session group needs at least one session")
+	}
+	g.scaleMu.Lock()
+	defer g.scaleMu.Unlock()
+	select {
+	case <-g.closed:
+		return ErrSessionGroupClosed
+	default:
+	}
+	var added []*groupMember
+	for i := g.Len(); i < n; i++ {
+		m, err := g.newMember()
+		if err != nil {
+			closeMembers(added)
+			return err
+		}
+		added = append(added, m)
+	}
+	g.mu.Lock()
+	if g.listen {
+		for _, m := range added {
+			if err := g.startListening(m); err != nil {
+				g.mu.Unlock()
+				closeMembers(added)
+				return err
+			}
+		}
+	}
+	g.members = append(g.members, added...)
+	var removed []*groupMember
+	if len(g.members) > n {
+		removed = g.members[n:]
+		g.members = g.members[:n:n]
+	}
+	g.mu.Unlock()
+	closeMembers(removed)
+	return nil
+}
+
+// Creates a session for the group.
+func (g *SessionGroup) newMember() (*groupMember, error) {
+	g.mu.Lock()
+	id := g.id + strconv.Itoa(g.nextID)
+	g.nextID++
+	g.mu.Unlock()
+	s, err := g.sam.NewStreamSession(id, TransientKeys(), g.options, g.opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &groupMember{session: s}, nil
+}
+
+func closeMembers(members []*groupMember) {
+	for _, m := range members {
+		if m.listener != nil {
+			m.listener.Close()
+		}
+		m.session.Close()
+	}
+}
+
+// Connects to dest over the session with the fewest open connections.
+func (g *SessionGroup) Dial(ctx context.Context, dest I2PAddr) (net.Conn, error) {
+	m, err := g.leastBusy()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := m.session.DialContextI2P(ctx, dest)
+	if err != nil {
+		atomic.AddInt64(&m.active, -1)
+		return nil, err
+	}
+	return &groupConn{Conn: conn, member: m}, nil
+}
+
+// Returns the session with the fewest open connections, counting the one
+// about to be opened. Ties go round-robin.
+func (g *SessionGroup) leastBusy() (*groupMember, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.members) == 0 {
+		return nil, ErrSessionGroupClosed
+	}
+	var best *groupMember
+	for i := range g.members {
+		m := g.members[(g.next+i)%len(g.members)]
+		if best == nil || atomic.LoadInt64(&m.active) < atomic.LoadInt64(&best.active) {
+			best = m
+		}
+	}
+	g.next = (g.next + 1) % len(g.members)
+	atomic.AddInt64(&best.active, 1)
+	return best, nil
+}
+
+// Accepts the next connection to any session of the group. The first call
+// makes every session listen. If the listeners of all sessions fail, such as
+// when the bridge went away, the error of the last one is returned, and the
+// next call makes the sessions listen again.
+func (g *SessionGroup) Accept() (net.Conn, error) {
+	g.mu.Lock()
+	if !g.listen {
+		g.listen = true
+		for _, m := range g.members {
+			if err := g.startListening(m); err != nil {
+				g.listen = false
+				g.mu.Unlock()
+				return nil, err
+			}
+		}
+	}
+	g.mu.Unlock()
+	select {
+	case conn := <-g.accepted:
+		return conn, nil
+	case err := <-g.failed:
+		return nil, err
+	case <-g.closed:
+		return nil, ErrSessionGroupClosed
+	}
+}
+
+// Makes the session of m listen, handing accepted connections to Accept.
+// g.mu must be held.
+func (g *SessionGroup) startListening(m *groupMember) error {
+	if m.listener != nil {
+		return nil
+	}
+	l, err := m.session.Listen()
+	if err != nil {
+		return err
+	}
+	m.listener = l
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				if _, ok := err.(handshakeError); ok {
+					continue
+				}
+				g.listenerFailed(m, err)
+				return
+			}
+			atomic.AddInt64(&m.active, 1)
+			select {
+			case g.accepted <- &groupConn{Conn: conn, member: m}:
+			case <-g.closed:
+				conn.Close()
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Records that the listener of m failed with err. Once no session of the
+// group is listening anymore, err is handed to Accept. Listeners of sessions
+// that were removed from the group, or closed with it, do not count.
+func (g *SessionGroup) listenerFailed(m *groupMember, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var member bool
+	for _, other := range g.members {
+		if other == m {
+			member = true
+		}
+	}
+	if !member {
+		return
+	}
+	m.listener.Close()
+	m.listener = nil
+	for _, other := range g.members {
+		if other.listener != nil {
+			return
+		}
+	}
+	g.listen = false
+	select {
+	case g.failed <- err:
+	default:
+	}
+}
+
+// Closes every session of the group.
+func (g *SessionGroup) Close() error {
+	g.scaleMu.Lock()
+	defer g.scaleMu.Unlock()
+	g.mu.Lock()
+	select {
+	case <-g.closed:
+		g.mu.Unlock()
+		return nil
+	default:
+	}
+	close(g.closed)
+	members := g.members
+	g.members = nil
+	g.mu.Unlock()
+	closeMembers(members)
+	return nil
+}
+
+// A connection of a session in a SessionGroup, counted as open until closed.
+type groupConn struct {
+	net.Conn
+	member *groupMember
+	once   sync.Once
+}
+
+func (c *groupConn) Close() error {
+	c.once.Do(func() { atomic.AddInt64(&c.member.active, -1) })
+	return c.Conn.Close()
+}
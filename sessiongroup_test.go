@@ -0,0 +1,99 @@
+package sam3
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+)
+
+func Test_SessionGroup(t *testing.T) {
+	mock := newMockSAM(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "STREAM FORWARD") && mockField(cmd, "ID") == "grp1" {
+			// a peer connecting to the second session
+			go func(port string) {
+				conn, err := net.Dial("tcp4", "127.0.0.1:"+port)
+				if err != nil {
+					return
+				}
+				conn.Write([]byte(testDest + "\n"))
+			}(mockField(cmd, "PORT"))
+		}
+		if strings.HasPrefix(cmd, "STREAM FORWARD") {
+			return "STREAM STATUS RESULT=OK\n"
+		}
+		return mockOK(cmd)
+	})
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	g, err := sam.NewSessionGroup("grp", 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	// the first dial holds a connection on one session, so the second one
+	// goes to the other
+	c1, err := g.Dial(context.Background(), testDest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := g.Dial(context.Background(), testDest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+	c1.Close()
+	var ids []string
+	for _, cmd := range mock.Commands() {
+		if strings.HasPrefix(cmd, "STREAM CONNECT") {
+			ids = append(ids, mockField(cmd, "ID"))
+		}
+	}
+	if len(ids) != 2 || ids[0] == ids[1] {
+		t.Fatalf("expected the dials on different sessions, got %q", ids)
+	}
+
+	conn, err := g.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if conn.RemoteAddr().String() != string(testDest) {
+		t.Fatalf("unexpected peer %q", conn.RemoteAddr())
+	}
+
+	if err := g.Scale(3); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Scale(1); err != nil {
+		t.Fatal(err)
+	}
+	if g.Len() != 1 || len(g.Addrs()) != 1 {
+		t.Fatalf("expected a single session, got %d", g.Len())
+	}
+	var forwards int
+	for _, cmd := range mock.Commands() {
+		if strings.HasPrefix(cmd, "STREAM FORWARD") {
+			forwards++
+		}
+	}
+	if forwards != 3 {
+		t.Fatalf("expected every session to listen once the group accepts, got %d STREAM FORWARDs", forwards)
+	}
+	// the listener of the last session fails
+	g.mu.Lock()
+	g.members[0].listener.listener.Close()
+	g.mu.Unlock()
+	if _, err := g.Accept(); err == nil || err == ErrSessionGroupClosed {
+		t.Fatalf("expected the error of the listener, got %v", err)
+	}
+	g.Close()
+	if _, err := g.Accept(); err != ErrSessionGroupClosed {
+		t.Fatalf("expected ErrSessionGroupClosed, got %v", err)
+	}
+}
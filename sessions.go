@@ -0,0 +1,189 @@
+package sam3
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Returned by SetOption for options that are fixed when a session is created.
+var ErrImmutableOption = errors.New("Option can not be changed on a live session")
+
+// Keeps track of stream sessions by their id, so they can be reconfigured by
+// name, such as by a ConfigWatcher.
+type SessionManager struct {
+	mu       sync.Mutex
+	sessions map[string]*StreamSession
+}
+
+// Creates a new, empty, SessionManager.
+func NewSessionManager() *SessionManager {
+	return &SessionManager{sessions: make(map[string]*StreamSession)}
+}
+
+// Adds the session s, replacing any session with the same id.
+func (m *SessionManager) Add(s *StreamSession) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[s.id] = s
+}
+
+// Stops managing the session with the given id. The session is not closed.
+func (m *SessionManager) Remove(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+}
+
+// Returns the session with the given id, or nil.
+func (m *SessionManager) Session(id string) *StreamSession {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sessions[id]
+}
+
+// Returns the I2CP- and streaminglib options of the session with the given id,
+// sorted by key.
+func (m *SessionManager) Options(id string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, errors.New("No session with id " + id)
+	}
+	return s.sessionOpts().options(), nil
+}
+
+// Recreates the session with the given id with new options, see
+// StreamSession.UpdateOptions. The manager is not locked while the session is
+// being recreated.
+func (m *SessionManager) UpdateOptions(id string, options []string) error {
+	s := m.Session(id)
+	if s == nil {
+		return errors.New("No session with id " + id)
+	}
+	return s.UpdateOptions(options)
+}
+
+// Replaces the I2CP- and streaminglib options of the session. The SAM bridge
+// can not change the options of a running session, so the session is torn down
+// and created again, with the same id and keys (so the I2P address stays the
+// same). The parameters of SESSION CREATE, such as set by WithPorts, and other
+// settings are kept, unless opts change them, e.g. AllowLegacy(false).
+// Like when self-healing, building the new tunnels takes several seconds, and
+// listeners of the session stop working and have to be created again.
+// If the session can not be created with the new options, it is restored with
+// the old ones, and the error is returned. Subsessions can not be updated.
+func (s *StreamSession) UpdateOptions(options []string, opts ...Option) error {
+	if s.master != nil {
+		return errors.New("The options of a subsession can not be updated")
+	}
+	so, err := s.sessionOpts().update(options, opts)
+	if err != nil {
+		return err
+	}
+	return s.reopen(so)
+}
+
+// Sets a single I2CP- or streaminglib option, such as "inbound.quantity", on
+// the live session, keeping all other options. This recreates the session,
+// see UpdateOptions.
+//
+// Options that are fixed when the session is created return
+// ErrImmutableOption: the parameters of SESSION CREATE (keys without a dot,
+// such as SIGNATURE_TYPE, FROM_PORT and TO_PORT, which are tied to the
+// destination and its listeners) and every option of a subsession, whose
+// tunnels belong to the master session.
+//
+// The option is remembered, and applied again whenever the session is
+// recreated, such as by self-healing or UpdateOptions, until it is cleared
+// with ClearPersistentOption.
+func (s *StreamSession) SetOption(key, value string) error {
+	if s.master != nil || !strings.Contains(key, ".") {
+		return ErrImmutableOption
+	}
+	if _, err := applyOptions(nil, []Option{WithOptions(key + "=" + value)}); err != nil {
+		return err
+	}
+	s.persistMu.Lock()
+	if s.persistent == nil {
+		s.persistent = make(map[string]string)
+	}
+	s.persistent[key] = value
+	s.persistMu.Unlock()
+	return s.UpdateOptions(s.sessionOpts().options())
+}
+
+// Returns the options set with SetOption, which are applied again whenever
+// the session is recreated.
+func (s *StreamSession) PersistentOptions() map[string]string {
+	s.persistMu.Lock()
+	defer s.persistMu.Unlock()
+	m := make(map[string]string, len(s.persistent))
+	for k, v := range s.persistent {
+		m[k] = v
+	}
+	return m
+}
+
+// Forgets an option set with SetOption. The live session keeps it until it is
+// recreated with options that do not have it, such as by UpdateOptions.
+func (s *StreamSession) ClearPersistentOption(key string) {
+	s.persistMu.Lock()
+	defer s.persistMu.Unlock()
+	delete(s.persistent, key)
+}
+
+// Returns so with the options set with SetOption on top, so that they are
+// all sent in the same SESSION CREATE.
+func (s *StreamSession) withPersistentOptions(so *sessionOptions) *sessionOptions {
+	s.persistMu.Lock()
+	defer s.persistMu.Unlock()
+	if len(s.persistent) == 0 {
+		return so
+	}
+	out := *so
+	out.i2cp = make(map[string]string)
+	for k, v := range so.i2cp {
+		out.i2cp[k] = v
+	}
+	for k, v := range s.persistent {
+		out.i2cp[k] = v
+	}
+	return &out
+}
+
+// Compares two sets of options in the "key=value" format. Returns the options
+// of new that are not in old, including those whose value changed, and the
+// keys of old that are missing in new, both sorted. Both are empty if the
+// options are equivalent.
+func CompareOptions(old, new []string) (changed, removed []string) {
+	oldm, newm := optionMap(old), optionMap(new)
+	for k, v := range newm {
+		if ov, ok := oldm[k]; !ok || ov != v {
+			changed = append(changed, k+"="+v)
+		}
+	}
+	for k := range oldm {
+		if _, ok := newm[k]; !ok {
+			removed = append(removed, k)
+		}
+	}
+	sort.Strings(changed)
+	sort.Strings(removed)
+	return changed, removed
+}
+
+// Returns options as a map, where later values win.
+func optionMap(options []string) map[string]string {
+	m := make(map[string]string, len(options))
+	for _, opt := range options {
+		if i := strings.Index(opt, "="); i >= 0 {
+			m[opt[:i]] = opt[i+1:]
+		} else {
+			m[opt] = ""
+		}
+	}
+	return m
+}
@@ -0,0 +1,128 @@
+package sam3
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_SetOption(t *testing.T) {
+	mock := newMockSAM(t, mockOK)
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	ss, err := sam.NewStreamSession("tuned", NewKeys(I2PAddr("pub"), "pubpriv"), []string{"inbound.length=1", "outbound.length=1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	if err := ss.SetOption("inbound.length", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if got := ss.opts.options(); !reflect.DeepEqual(got, []string{"inbound.length=2", "outbound.length=1"}) {
+		t.Fatalf("unexpected options %v", got)
+	}
+	var creates int
+	for _, cmd := range mock.Commands() {
+		if strings.HasPrefix(cmd, "SESSION CREATE") {
+			creates++
+		}
+	}
+	if creates != 2 {
+		t.Fatalf("expected the session to be recreated, saw %d SESSION CREATEs", creates)
+	}
+	if err := ss.SetOption("SIGNATURE_TYPE", "7"); err != ErrImmutableOption {
+		t.Fatalf("expected ErrImmutableOption, got %v", err)
+	}
+}
+
+func Test_PersistentOptions(t *testing.T) {
+	mock := newMockSAM(t, mockOK)
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	ss, err := sam.NewStreamSession("persisted", NewKeys(I2PAddr("pub"), "pubpriv"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	if err := ss.SetOption("inbound.quantity", "3"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ss.PersistentOptions(), map[string]string{"inbound.quantity": "3"}) {
+		t.Fatalf("unexpected persistent options %v", ss.PersistentOptions())
+	}
+	// as when reloading a configuration without the option
+	if err := ss.UpdateOptions([]string{"inbound.length=2"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := ss.opts.options(); !reflect.DeepEqual(got, []string{"inbound.length=2", "inbound.quantity=3"}) {
+		t.Fatalf("the persistent option was lost: %v", got)
+	}
+	ss.ClearPersistentOption("inbound.quantity")
+	if err := ss.UpdateOptions([]string{"inbound.length=2"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := ss.opts.options(); !reflect.DeepEqual(got, []string{"inbound.length=2"}) {
+		t.Fatalf("the cleared option was applied: %v", got)
+	}
+}
+
+func Test_UpdateOptionsKeepsSettings(t *testing.T) {
+	mock := newMockSAM(t, mockOK)
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	ss, err := sam.NewStreamSession("kept", NewKeys(I2PAddr("pub"), "pubpriv"), nil, WithEphemeral(), AllowLegacy(true), WithThrottle(1000, 2000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	if err := ss.UpdateOptions([]string{"inbound.length=1"}, AllowLegacy(false)); err != nil {
+		t.Fatal(err)
+	}
+	so := ss.sessionOpts()
+	if so.allowLegacy || !so.ephemeral || so.readBPS != 1000 || so.writeBPS != 2000 {
+		t.Fatalf("unexpected settings after UpdateOptions %+v", so)
+	}
+}
+
+func Test_UpdateOptionsRestore(t *testing.T) {
+	mock := newMockSAM(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "SESSION CREATE") && strings.Contains(cmd, "inbound.length=9") {
+			return "SESSION STATUS RESULT=I2P_ERROR MESSAGE=\"bad length\"\n"
+		}
+		return mockOK(cmd)
+	})
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	ss, err := sam.NewStreamSession("restored", NewKeys(I2PAddr("pub"), "pubpriv"), []string{"inbound.length=1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	m := NewSessionManager()
+	m.Add(ss)
+	if err := m.UpdateOptions("restored", []string{"inbound.length=9"}); err == nil {
+		t.Fatal("expected the refused options to fail")
+	}
+	if ss.State() != SessionActive {
+		t.Fatalf("expected the session to be restored, it is %v", ss.State())
+	}
+	if got, _ := m.Options("restored"); !reflect.DeepEqual(got, []string{"inbound.length=1"}) {
+		t.Fatalf("expected the old options, got %v", got)
+	}
+}
@@ -0,0 +1,81 @@
+package sam3
+
+import (
+	"strconv"
+	"strings"
+)
+
+// I2P signature types, as used in the key certificate of a destination and in
+// SIGNATURE_TYPE= of SAM commands.
+const (
+	Sig_DSA_SHA1              = 0 // legacy, the default of old routers
+	Sig_ECDSA_SHA256_P256     = 1
+	Sig_ECDSA_SHA384_P384     = 2
+	Sig_ECDSA_SHA512_P521     = 3
+	Sig_EdDSA_SHA512_Ed25519  = 7 // the recommended type
+	Sig_RedDSA_SHA512_Ed25519 = 11
+
+	// Not a signature type, but asks for the strongest type the SAM version
+	// negotiated with the bridge supports, see SAM.BestSignatureType.
+	Sig_Best = -1
+)
+
+// I2P encryption (crypto) types, as used in the key certificate of a
+// destination.
+const (
+	Crypto_ElGamal      = 0
+	Crypto_ECIES_X25519 = 4
+)
+
+// Returns the strongest signature type that can be asked for with the SAM
+// version negotiated with the bridge. In order of preference:
+//
+//	Sig_EdDSA_SHA512_Ed25519  SAM 3.1 and later
+//	Sig_DSA_SHA1              SAM 3.0, which can not ask for a type at all
+//
+// This is what NewKeys, and sessions with a TRANSIENT destination, use unless
+// told otherwise.
+func (sam *SAM) BestSignatureType() int {
+	if sam.Features().SignatureTypes {
+		return Sig_EdDSA_SHA512_Ed25519
+	}
+	return Sig_DSA_SHA1
+}
+
+// Resolves the SIGNATURE_TYPE parameter of SESSION CREATE for version: Sig_Best
+// becomes the best type, and TRANSIENT destinations get the best type if none
+// was asked for. SAM 3.0 does not know the parameter, so there it is left out
+// if it is DSA-SHA1 anyway; other types are refused by requireParams.
+func signatureParams(version string, params []string, transient bool) []string {
+	best := Sig_EdDSA_SHA512_Ed25519
+	if !featuresOf(version).SignatureTypes {
+		best = Sig_DSA_SHA1
+	}
+	out := make([]string, 0, len(params)+1)
+	found := false
+	for _, p := range params {
+		if strings.HasPrefix(p, "SIGNATURE_TYPE=") {
+			found = true
+			if p == "SIGNATURE_TYPE="+strconv.Itoa(Sig_Best) {
+				if best == Sig_DSA_SHA1 {
+					continue
+				}
+				p = "SIGNATURE_TYPE=" + strconv.Itoa(best)
+			} else if p == "SIGNATURE_TYPE="+strconv.Itoa(Sig_DSA_SHA1) && best == Sig_DSA_SHA1 {
+				// what bridges before SAM 3.1 use anyway
+				continue
+			}
+		}
+		out = append(out, p)
+	}
+	if !found && transient && best != Sig_DSA_SHA1 {
+		out = append(out, "SIGNATURE_TYPE="+strconv.Itoa(best))
+	}
+	return out
+}
+
+// Certificate types of a destination.
+const (
+	cert_NULL = 0
+	cert_KEY  = 5
+)
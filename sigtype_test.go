@@ -0,0 +1,65 @@
+package sam3
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_SignatureParams(t *testing.T) {
+	for _, tt := range []struct {
+		version   string
+		params    []string
+		transient bool
+		want      []string
+	}{
+		{"3.0", []string{"SIGNATURE_TYPE=-1"}, true, []string{}},
+		{"3.0", nil, true, []string{}},
+		{"3.0", []string{"SIGNATURE_TYPE=0"}, false, []string{}},
+		{"3.0", []string{"SIGNATURE_TYPE=7"}, false, []string{"SIGNATURE_TYPE=7"}},
+		{"3.1", []string{"FROM_PORT=1", "SIGNATURE_TYPE=-1"}, false, []string{"FROM_PORT=1", "SIGNATURE_TYPE=7"}},
+		{"3.1", nil, true, []string{"SIGNATURE_TYPE=7"}},
+		{"3.1", nil, false, []string{}},
+		{"3.2", []string{"SIGNATURE_TYPE=1"}, true, []string{"SIGNATURE_TYPE=1"}},
+	} {
+		if got := signatureParams(tt.version, tt.params, tt.transient); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("signatureParams(%q, %q, %v) = %q, want %q", tt.version, tt.params, tt.transient, got, tt.want)
+		}
+	}
+}
+
+func Test_NewKeysBestSignatureType(t *testing.T) {
+	mock := newMockSAM(t, func(cmd string) string {
+		switch {
+		case strings.HasPrefix(cmd, "HELLO"):
+			// a bridge that knows SAM 3.1 at most
+			if versionAtLeast(mockField(cmd, "MAX"), "3.1") {
+				return "HELLO REPLY RESULT=OK VERSION=3.1\n"
+			}
+			return "HELLO REPLY RESULT=OK VERSION=3.0\n"
+		case strings.HasPrefix(cmd, "DEST GENERATE"):
+			return "DEST REPLY PUB=" + string(testDest) + " PRIV=" + testPrivKeys + "\n"
+		}
+		return ""
+	})
+	defer mock.Close()
+	// by default, versions up to 3.3 are asked for
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	if sam.BestSignatureType() != Sig_EdDSA_SHA512_Ed25519 {
+		t.Fatalf("unexpected best signature type %d", sam.BestSignatureType())
+	}
+	if _, err := sam.NewKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sam.NewKeys(Sig_ECDSA_SHA256_P256); err != nil {
+		t.Fatal(err)
+	}
+	cmds := mock.Commands()
+	if cmds[1] != "DEST GENERATE SIGNATURE_TYPE=7" || cmds[2] != "DEST GENERATE SIGNATURE_TYPE=1" {
+		t.Fatalf("unexpected commands %q", cmds)
+	}
+}
@@ -0,0 +1,211 @@
+package sam3
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// The life cycle state of a session.
+type SessionState int
+
+const (
+	SessionInitializing SessionState = iota // not created with the bridge yet
+	SessionConnecting                       // being created with the bridge
+	SessionActive                           // created, and usable
+	SessionReconnecting                     // being recreated, see SetSelfHeal
+	SessionClosing                          // being torn down
+	SessionClosed                           // torn down
+	SessionFailed                           // could not be (re)created, or lost, see OnDisconnect
+)
+
+func (st SessionState) String() string {
+	switch st {
+	case SessionInitializing:
+		return "initializing"
+	case SessionConnecting:
+		return "connecting"
+	case SessionActive:
+		return "active"
+	case SessionReconnecting:
+		return "reconnecting"
+	case SessionClosing:
+		return "closing"
+	case SessionClosed:
+		return "closed"
+	case SessionFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// Returned when a session can not do something in its current state, such as
+// being closed or recreated after it was closed.
+var ErrInvalidStateTransition = errors.New("Invalid session state transition")
+
+// Sent when a session changes its state.
+type StateEvent struct {
+	From      SessionState
+	To        SessionState
+	Timestamp time.Time
+}
+
+// The states each state may change to.
+var stateTransitions = map[SessionState][]SessionState{
+	SessionInitializing: {SessionConnecting, SessionClosing},
+	SessionConnecting:   {SessionActive, SessionFailed, SessionClosing},
+	SessionActive:       {SessionReconnecting, SessionFailed, SessionClosing},
+	SessionReconnecting: {SessionActive, SessionFailed, SessionClosing},
+	SessionFailed:       {SessionReconnecting, SessionClosing},
+	SessionClosing:      {SessionClosed},
+}
+
+// The state of a session, embedded in every session type. The zero value is
+// SessionInitializing. Safe for concurrent use.
+type sessionState struct {
+	mu     sync.Mutex
+	state  SessionState
+	events chan StateEvent // made by the first call to subscribe
+	done   chan struct{}   // made by the first call to closing
+}
+
+// Returns the current state.
+func (s *sessionState) get() SessionState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// Returns the channel StateEvents are sent on. Events are dropped if the
+// channel is not read.
+func (s *sessionState) subscribe() <-chan StateEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.events == nil {
+		s.events = make(chan StateEvent, 16)
+	}
+	return s.events
+}
+
+// Returns a channel that is closed once the session is being torn down, to
+// end waits that would otherwise outlive it.
+func (s *sessionState) closing() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done == nil {
+		s.done = make(chan struct{})
+		if s.state == SessionClosing || s.state == SessionClosed {
+			close(s.done)
+		}
+	}
+	return s.done
+}
+
+// Changes the state to to, or returns ErrInvalidStateTransition if the
+// current state can not change to it.
+func (s *sessionState) transition(to SessionState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, next := range stateTransitions[s.state] {
+		if next == to {
+			ev := StateEvent{From: s.state, To: to, Timestamp: time.Now()}
+			s.state = to
+			if to == SessionClosing && s.done != nil {
+				close(s.done)
+			}
+			select {
+			case s.events <- ev:
+			default:
+			}
+			return nil
+		}
+	}
+	return ErrInvalidStateTransition
+}
+
+// Marks a session that is being created with the bridge.
+func (s *sessionState) connect() error {
+	return s.transition(SessionConnecting)
+}
+
+// Marks a session whose creation, or recreation, succeeded.
+func (s *sessionState) established() error {
+	return s.transition(SessionActive)
+}
+
+// Marks a session whose creation, or recreation, failed.
+func (s *sessionState) fail() error {
+	return s.transition(SessionFailed)
+}
+
+// Marks a session that is being recreated.
+func (s *sessionState) reconnect() error {
+	return s.transition(SessionReconnecting)
+}
+
+// Marks a session that is being torn down. Reports false if it already is, or
+// has been, in which case there is nothing left to do: closing a session
+// again is a no-op.
+func (s *sessionState) close() bool {
+	return s.transition(SessionClosing) == nil
+}
+
+// Marks a session that has been torn down.
+func (s *sessionState) closed() error {
+	return s.transition(SessionClosed)
+}
+
+// Marks a session created with the bridge before it was returned, by going
+// through SessionConnecting to SessionActive.
+func (s *sessionState) start() {
+	s.connect()
+	s.established()
+}
+
+// Returns the state of the session.
+func (s *StreamSession) State() SessionState {
+	return s.state.get()
+}
+
+// Returns the channel the state changes of the session are sent on, starting
+// with the first change after the call. Events are dropped if the channel is
+// not read.
+func (s *StreamSession) StateEvents() <-chan StateEvent {
+	return s.state.subscribe()
+}
+
+// Returns the state of the session.
+func (s *DatagramSession) State() SessionState {
+	return s.state.get()
+}
+
+// Returns the channel the state changes of the session are sent on, starting
+// with the first change after the call. Events are dropped if the channel is
+// not read.
+func (s *DatagramSession) StateEvents() <-chan StateEvent {
+	return s.state.subscribe()
+}
+
+// Returns the state of the session.
+func (s *RawSession) State() SessionState {
+	return s.state.get()
+}
+
+// Returns the channel the state changes of the session are sent on, starting
+// with the first change after the call. Events are dropped if the channel is
+// not read.
+func (s *RawSession) StateEvents() <-chan StateEvent {
+	return s.state.subscribe()
+}
+
+// Returns the state of the master session.
+func (m *MasterSession) State() SessionState {
+	return m.state.get()
+}
+
+// Returns the channel the state changes of the master session are sent on,
+// starting with the first change after the call. Events are dropped if the
+// channel is not read.
+func (m *MasterSession) StateEvents() <-chan StateEvent {
+	return m.state.subscribe()
+}
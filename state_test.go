@@ -0,0 +1,80 @@
+package sam3
+
+import (
+	"testing"
+)
+
+func Test_SessionStateTransitions(t *testing.T) {
+	all := []SessionState{SessionInitializing, SessionConnecting, SessionActive, SessionReconnecting, SessionClosing, SessionClosed, SessionFailed}
+	valid := map[[2]SessionState]bool{
+		{SessionInitializing, SessionConnecting}: true,
+		{SessionInitializing, SessionClosing}:    true,
+		{SessionConnecting, SessionActive}:       true,
+		{SessionConnecting, SessionFailed}:       true,
+		{SessionActive, SessionFailed}:           true,
+		{SessionConnecting, SessionClosing}:      true,
+		{SessionActive, SessionReconnecting}:     true,
+		{SessionActive, SessionClosing}:          true,
+		{SessionReconnecting, SessionActive}:     true,
+		{SessionReconnecting, SessionFailed}:     true,
+		{SessionReconnecting, SessionClosing}:    true,
+		{SessionFailed, SessionReconnecting}:     true,
+		{SessionFailed, SessionClosing}:          true,
+		{SessionClosing, SessionClosed}:          true,
+	}
+	for _, from := range all {
+		for _, to := range all {
+			st := sessionState{state: from}
+			events := st.subscribe()
+			err := st.transition(to)
+			if valid[[2]SessionState{from, to}] {
+				if err != nil || st.get() != to {
+					t.Errorf("%v -> %v: %v", from, to, err)
+					continue
+				}
+				if ev := <-events; ev.From != from || ev.To != to {
+					t.Errorf("%v -> %v: sent %v -> %v", from, to, ev.From, ev.To)
+				}
+			} else if err != ErrInvalidStateTransition || st.get() != from {
+				t.Errorf("%v -> %v: allowed, %v", from, to, err)
+			}
+		}
+	}
+}
+
+func Test_SessionStateLifeCycle(t *testing.T) {
+	mock := newMockSAM(t, mockOK)
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	ss, err := sam.NewStreamSession("stateTun", NewKeys(I2PAddr("pub"), "pubpriv"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ss.State() != SessionActive {
+		t.Fatalf("new session is %v", ss.State())
+	}
+	events := ss.StateEvents()
+	if err := ss.UpdateOptions([]string{"inbound.length=1"}); err != nil {
+		t.Fatal(err)
+	}
+	if ev := <-events; ev.To != SessionReconnecting {
+		t.Fatalf("unexpected event %v -> %v", ev.From, ev.To)
+	}
+	if ev := <-events; ev.To != SessionActive {
+		t.Fatalf("unexpected event %v -> %v", ev.From, ev.To)
+	}
+	ss.Close()
+	if ss.State() != SessionClosed {
+		t.Fatalf("closed session is %v", ss.State())
+	}
+	if err := ss.Close(); err != nil {
+		t.Fatalf("closed twice: %v", err)
+	}
+	if err := ss.UpdateOptions(nil); err != ErrInvalidStateTransition {
+		t.Fatalf("recreated after Close: %v", err)
+	}
+}
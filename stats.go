@@ -0,0 +1,92 @@
+package sam3
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// The write duration above which a StatTrackingConn counts a write as slow,
+// unless its SlowWriteThreshold is set.
+const defaultSlowWriteThreshold = 2 * time.Second
+
+// Counters of a StatTrackingConn.
+type ConnStats struct {
+	Reads       int64
+	Writes      int64
+	ReadErrors  int64 // failed reads, not counting io.EOF
+	WriteErrors int64
+	ShortReads  int64 // reads returning less than was asked for
+	SlowWrites  int64 // writes taking longer than the SlowWriteThreshold
+}
+
+// Wraps a net.Conn and counts errors, short reads and slow writes on it. The
+// router retransmits lost messages of a stream without telling anybody, so
+// these counters are the only sign of a bad tunnel an application gets.
+type StatTrackingConn struct {
+	net.Conn
+
+	// Writes taking longer than this count as slow.
+	SlowWriteThreshold time.Duration
+
+	mu    sync.Mutex
+	stats ConnStats
+}
+
+// Wraps conn, typically accepted or dialed with s, in a StatTrackingConn.
+func (s *StreamSession) NewStatTrackingConn(conn net.Conn) *StatTrackingConn {
+	return &StatTrackingConn{Conn: conn, SlowWriteThreshold: defaultSlowWriteThreshold}
+}
+
+func (c *StatTrackingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.mu.Lock()
+	c.stats.Reads++
+	if err != nil && err != io.EOF {
+		c.stats.ReadErrors++
+	} else if err == nil && n < len(b) {
+		c.stats.ShortReads++
+	}
+	c.mu.Unlock()
+	return n, err
+}
+
+func (c *StatTrackingConn) Write(b []byte) (int, error) {
+	start := time.Now()
+	n, err := c.Conn.Write(b)
+	took := time.Since(start)
+	c.mu.Lock()
+	c.stats.Writes++
+	if err != nil {
+		c.stats.WriteErrors++
+	} else if took > c.SlowWriteThreshold {
+		c.stats.SlowWrites++
+	}
+	c.mu.Unlock()
+	return n, err
+}
+
+// Returns a snapshot of the counters.
+func (c *StatTrackingConn) Stats() ConnStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Returns a score from 0.0 (useless) to 1.0 (no problems seen). Errors weigh
+// fully, slow writes half and short reads, which are common on a healthy
+// stream, a tenth, relative to the number of reads and writes.
+func (c *StatTrackingConn) ConnectionQuality() float64 {
+	st := c.Stats()
+	ops := st.Reads + st.Writes
+	if ops == 0 {
+		return 1
+	}
+	bad := float64(st.ReadErrors+st.WriteErrors) + 0.5*float64(st.SlowWrites) + 0.1*float64(st.ShortReads)
+	q := 1 - bad/float64(ops)
+	if q < 0 {
+		return 0
+	}
+	return q
+}
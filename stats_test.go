@@ -0,0 +1,38 @@
+package sam3
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_StatTrackingConn(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := (*StreamSession)(nil).NewStatTrackingConn(a)
+	c.SlowWriteThreshold = 50 * time.Millisecond
+	if c.ConnectionQuality() != 1 {
+		t.Fatalf("unused conn has quality %v", c.ConnectionQuality())
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		b.Read(make([]byte, 4))
+		b.Write([]byte("hi"))
+	}()
+	if _, err := c.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Read(make([]byte, 8)); err != nil {
+		t.Fatal(err)
+	}
+	a.Close()
+	c.Write([]byte("x"))
+	st := c.Stats()
+	want := ConnStats{Reads: 1, Writes: 2, ShortReads: 1, SlowWrites: 1, WriteErrors: 1}
+	if st != want {
+		t.Fatalf("got %+v, want %+v", st, want)
+	}
+	if q := c.ConnectionQuality(); q <= 0 || q >= 1 {
+		t.Fatalf("unexpected quality %v", q)
+	}
+}
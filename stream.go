@@ -3,62 +3,219 @@ package sam3
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
+	"io"
 	"net"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
-// Represents a streaming session.
+// Represents a streaming session. A StreamSession has a two-level lifecycle:
+// the session itself owns the tunnels and the I2P destination, and lives until
+// Close() is called on it. Every connection dialed or accepted through it is a
+// separate connection to the SAM bridge, and closing one of those (or a
+// listener) only ends that connection - the session, and its other
+// connections, keep working.
+//
+// The SAM protocol requires this: the connection a session was created on is
+// reserved for controlling it, and every STREAM CONNECT and STREAM FORWARD
+// takes over the connection it was sent on. So each dial opens a new control
+// connection, naming the session by its id, and a listener holds one for as
+// long as it is open. This is also what makes it safe to dial and accept on
+// the same session at the same time, from any number of goroutines.
 type StreamSession struct {
-	samAddr string   // address to the sam bridge (ipv4:port)
-	id      string   // tunnel name
-	conn    net.Conn // connection to sam bridge
-	keys    I2PKeys  // i2p destination keys
+	cfg    Config          // how to connect to the sam bridge
+	id     string          // tunnel name
+	keys   I2PKeys         // i2p destination keys
+	mu     sync.Mutex      // guards conn, opts and heal, see controlConn; ID and Keys read under it
+	conn   net.Conn        // connection to sam bridge
+	opts   *sessionOptions // the options the session was created with
+	heal   *selfHeal       // nil unless self-healing is enabled
+	dials  chan bool       // limits concurrent dials, nil if unlimited
+	master *MasterSession  // set if this is a subsession
+	names  *NameCache      // caches lookups, if set
+
+	traffic trafficCounter // bytes moved by the connections of the session
+
+	persistMu  sync.Mutex
+	persistent map[string]string // set with SetOption, see PersistentOptions
+
+	readyMu    sync.Mutex
+	rebuilding chan struct{} // closed once the session has been recreated
+	failFast   bool
+
+	state sessionState
+
+	discMu       sync.Mutex
+	onDisconnect []func(error) // set with OnDisconnect
+	watched      net.Conn      // the control connection watched for disconnects
 }
 
+// Errors returned when dialing fails because of the tunnels of the session,
+// rather than because of the destination dialed.
+var (
+	ErrCantReachPeer = errors.New("Can not reach peer")
+	ErrI2PInternal   = errors.New("I2P internal error")
+)
+
+// Returned when the router gave up connecting to the destination.
+var ErrConnectTimeout = errors.New("Timeout")
+
 // Returns the local tunnel name of the I2P tunnel used for the stream session
-func (ss StreamSession) ID() string {
+func (ss *StreamSession) ID() string {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
 	return ss.id
 }
 
 // Returns the I2P destination (the address) of the stream session
-func (ss StreamSession) Addr() I2PAddr {
-	return ss.keys.Addr()
+func (ss *StreamSession) Addr() I2PAddr {
+	return ss.Keys().Addr()
 }
 
 // Returns the keys associated with the stream session
-func (ss StreamSession) Keys() I2PKeys {
+func (ss *StreamSession) Keys() I2PKeys {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
 	return ss.keys
 }
 
+// Closes the stream session, tearing down its tunnels. Connections and
+// listeners created from the session should be closed first, they stop working
+// once the session is closed. Waits a second at most for the bridge to tear
+// down the tunnels, see CloseContext.
+func (s *StreamSession) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	return s.CloseContext(ctx)
+}
+
 // Creates a new StreamSession with the I2CP- and streaminglib options as
-// specified. See the I2P documentation for a full list of options.
-func (sam *SAM) NewStreamSession(id string, keys I2PKeys, options []string) (*StreamSession, error) {
-	conn, err := sam.newGenericSession("STREAM", id, keys, options, []string{})
+// specified, followed by any opts. See the I2P documentation for a full list
+// of options.
+func (sam *SAM) NewStreamSession(id string, keys I2PKeys, options []string, opts ...Option) (*StreamSession, error) {
+	so, err := applyOptions(options, opts)
+	if err != nil {
+		return nil, err
+	}
+	conn, reply, err := sam.newGenericSessionReply(sam.closed, "STREAM", id, keys, so.options(), so.extras())
 	if err != nil {
 		return nil, err
 	}
-	return &StreamSession{sam.address, id, conn, keys}, nil
+	if keys, err = sessionKeys(keys, reply); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	ss := &StreamSession{cfg: sam.cfg, id: id, conn: conn, keys: keys, opts: so}
+	ss.state.start()
+	return ss, nil
 }
 
 // Dials to an I2P destination and returns a SAMConn, which implements a net.Conn.
 func (s *StreamSession) DialI2P(addr I2PAddr) (*SAMConn, error) {
-	sam, err := NewSAM(s.samAddr)
+	return s.DialContextI2P(context.Background(), addr)
+}
+
+// Like DialI2P, but gives up and returns ctx.Err() if ctx is done before the
+// connection has been established.
+func (s *StreamSession) DialContextI2P(ctx context.Context, addr I2PAddr) (*SAMConn, error) {
+	return s.dialI2P(ctx, addr, s.selfHealer() != nil)
+}
+
+func (s *StreamSession) dialI2P(ctx context.Context, addr I2PAddr, heal bool) (*SAMConn, error) {
+	if s.isFailFast() {
+		if err := s.unavailable(); err != nil {
+			return nil, err
+		}
+		if !s.Ready() {
+			return nil, ErrTunnelsNotReady
+		}
+	} else if err := s.WaitReady(ctx); err != nil {
+		return nil, err
+	}
+	if dials := s.dials; dials != nil {
+		select {
+		case dials <- true:
+			defer func() { <-dials }()
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	s.warnLegacy(addr)
+	sam, err := NewSAMConfig(s.cfg)
 	if err != nil {
 		return nil, err
 	}
 	conn := sam.conn
-	_, err = conn.Write([]byte("STREAM CONNECT ID=" + s.id + " DESTINATION=" + addr.Base64() + " SILENT=false\n"))
+	stop := watchContext(ctx, conn)
+	c, err := s.connect(conn, addr)
+	if stop() {
+		conn.Close()
+		return nil, ctx.Err()
+	}
 	if err != nil {
+		conn.Close()
+		if h := s.selfHealer(); heal && h != nil && h.failed(err) {
+			if err := s.recreate(h); err != nil {
+				return nil, err
+			}
+			return s.dialI2P(ctx, addr, false)
+		}
 		return nil, err
 	}
-	buf := make([]byte, 4096)
+	return c, nil
+}
+
+// Limits how many dials (including probes) may be in progress at the same time
+// on the session, further dials wait for their turn. Zero means no limit,
+// which is the default. Must not be called while dials are in progress.
+func (s *StreamSession) SetMaxConcurrentDials(n int) {
+	if n <= 0 {
+		s.dials = nil
+		return
+	}
+	s.dials = make(chan bool, n)
+}
+
+// Checks whether addr can be reached, by connecting to it and closing the
+// connection right away. Returns nil if it could, otherwise the error from
+// connecting, such as ErrCantReachPeer or ErrConnectTimeout. Useful for
+// health checks of peers, without the overhead of an application session.
+func (s *StreamSession) Probe(ctx context.Context, addr I2PAddr) error {
+	conn, err := s.DialContextI2P(ctx, addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// Sends STREAM CONNECT on conn, which must be a fresh connection to SAM.
+func (s *StreamSession) connect(conn net.Conn, addr I2PAddr) (*SAMConn, error) {
+	cmd, err := s.cfg.build(streamConnect(s.ID(), addr, false))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(cmd); err != nil {
+		return nil, err
+	}
+	buf := getBuffer(4096)
+	defer putBuffer(buf)
 	n, err := conn.Read(buf)
 	if err != nil {
 		return nil, err
 	}
-	scanner := bufio.NewScanner(bytes.NewReader(buf[:n]))
+	if err := parseStreamStatus(buf[:n]); err != nil {
+		return nil, err
+	}
+	return &SAMConn{laddr: s.Addr(), raddr: addr, conn: conn, traffic: &s.traffic}, nil
+}
+
+// Parses a STREAM STATUS reply.
+func parseStreamStatus(reply []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(reply))
 	scanner.Split(bufio.ScanWords)
 	for scanner.Scan() {
 		switch scanner.Text() {
@@ -66,100 +223,233 @@ func (s *StreamSession) DialI2P(addr I2PAddr) (*SAMConn, error) {
 			continue
 		case "STATUS":
 			continue
-		case "RESULT=OK":
-			return &SAMConn{s.keys.addr, addr, conn}, nil
-		case "RESULT=CANT_REACH_PEER":
-			return nil, errors.New("Can not reach peer")
-		case "RESULT=I2P_ERROR":
-			return nil, errors.New("I2P internal error")
-		case "RESULT=INVALID_KEY":
-			return nil, errors.New("Invalid key")
-		case "RESULT=INVALID_ID":
-			return nil, errors.New("Invalid tunnel ID")
-		case "RESULT=TIMEOUT":
-			return nil, errors.New("Timeout")
+		case "RESULT=" + ResultOK:
+			return nil
+		case "RESULT=" + ResultCantReachPeer:
+			return ErrCantReachPeer
+		case "RESULT=" + ResultI2PError:
+			return ErrI2PInternal
+		case "RESULT=" + ResultInvalidKey:
+			return errors.New("Invalid key")
+		case "RESULT=" + ResultInvalidID:
+			return errors.New("Invalid tunnel ID")
+		case "RESULT=" + ResultTimeout:
+			return ErrConnectTimeout
 		default:
-			return nil, errors.New("Unknown error: " + scanner.Text() + " : " + string(buf[:n]))
+			return errors.New("Unknown error: " + scanner.Text() + " : " + string(reply))
 		}
 	}
-	panic("sam3 go library error in StreamSession.DialI2P()")
+	return newParseError("STREAM", reply)
+}
+
+// Resolves name to an I2P destination, using a new connection to the SAM
+// bridge of the session, unless it is in the cache set by SetNameCache.
+func (s *StreamSession) Lookup(name string) (I2PAddr, error) {
+	if s.names != nil {
+		return s.names.lookup(name, s.lookup)
+	}
+	return s.lookup(name)
+}
+
+func (s *StreamSession) lookup(name string) (I2PAddr, error) {
+	sam, err := NewSAMConfig(s.cfg)
+	if err != nil {
+		return I2PAddr(""), err
+	}
+	defer sam.Close()
+	return sam.Lookup(name)
 }
 
 // Returns a listener for the I2P destination (I2PAddr) associated with the
 // StreamSession.
 func (s *StreamSession) Listen() (*StreamListener, error) {
-	sam, err := NewSAM(s.conn.RemoteAddr().String())
+	sam, err := NewSAMConfig(s.cfg)
 	if err != nil {
 		return nil, err
 	}
-	lhost, _, err := net.SplitHostPort(s.conn.LocalAddr().String())
+	lhost, _, err := net.SplitHostPort(s.controlConn().LocalAddr().String())
 	if err != nil {
 		sam.Close()
 		return nil, err
 	}
 	listener, err := net.Listen("tcp4", lhost+":0")
+	if err != nil {
+		sam.Close()
+		return nil, err
+	}
 	_, lport, err := net.SplitHostPort(listener.Addr().String())
 	if err != nil {
+		listener.Close()
 		sam.Close()
 		return nil, err
 	}
 	conn := sam.conn
-	_, err = conn.Write([]byte("STREAM FORWARD ID=" + s.id + " PORT=" + lport + " SILENT=false\n"))
+	cmd, err := sam.cfg.build(commandOf(CmdStreamForward).Set("ID", s.ID()).Set("PORT", lport).Set("SILENT", "false"))
+	if err == nil {
+		_, err = conn.Write(cmd)
+	}
 	if err != nil {
+		listener.Close()
 		conn.Close()
 		return nil, err
 	}
-	buf := make([]byte, 512)
+	buf := getBuffer(512)
+	defer putBuffer(buf)
 	n, err := conn.Read(buf)
 	if err != nil {
+		listener.Close()
 		conn.Close()
 		return nil, err
 	}
-	scanner := bufio.NewScanner(bytes.NewReader(buf[:n]))
-	scanner.Split(bufio.ScanWords)
-	for scanner.Scan() {
-		switch strings.TrimSpace(scanner.Text()) {
-		case "STREAM":
-			continue
-		case "STATUS":
-			continue
-		case "RESULT=OK":
-			port, _ := strconv.Atoi(lport)
-			return &StreamListener{conn, listener, port, s.keys.Addr()}, nil
-		case "RESULT=I2P_ERROR":
-			conn.Close()
-			return nil, errors.New("I2P internal error")
-		case "RESULT=INVALID_ID":
-			conn.Close()
-			return nil, errors.New("Invalid tunnel ID")
-		default:
-			conn.Close()
-			return nil, errors.New("Unknown error: " + scanner.Text() + " : " + string(buf[:n]))
-		}
+	if err := parseStreamStatus(buf[:n]); err != nil {
+		listener.Close()
+		conn.Close()
+		return nil, err
 	}
-	panic("sam3 go library error in StreamSession.Listener()")
+	port, _ := strconv.Atoi(lport)
+	l := &StreamListener{conn: conn, listener: listener, lport: port, laddr: s.Addr(), traffic: &s.traffic, closed: make(chan struct{}), draining: make(chan struct{})}
+	if so := s.sessionOpts(); so != nil {
+		l.readBPS, l.writeBPS = so.readBPS, so.writeBPS
+	}
+	return l, nil
 }
 
-// Implements net.Listener for I2P streaming sessions
+// A listener for I2P streaming sessions. The router pushes connections to it
+// with STREAM FORWARD; see ForwardListener for one implementing net.Listener.
 type StreamListener struct {
 	conn     net.Conn
 	listener net.Listener
 	lport    int
 	laddr    I2PAddr
+	traffic  *trafficCounter // of the session
+
+	readBPS, writeBPS float64 // throttle accepted connections, see WithThrottle
+
+	// Connections are accepted by a goroutine and handed to Accept over
+	// accepted, so an AcceptContext can give up waiting without disturbing
+	// the listener. When the listener fails, err is set and accepted closed.
+	start     sync.Once
+	accepted  chan net.Conn
+	err       error
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	// Closed by CloseWithDrain; the accept goroutine then stops once no
+	// connection is left waiting, and closes loopDone.
+	drainOnce sync.Once
+	draining  chan struct{}
+	loopDone  chan struct{}
 }
 
 const defaultListenReadLen = 516
 
-// Accepts incomming connections to your StreamSession tunnel. Implements net.Listener
+// Accepts incomming connections to your StreamSession tunnel. Implements
+// net.Listener. Safe to call from several goroutines at once.
 func (l *StreamListener) Accept() (*SAMConn, error) {
-	conn, err := l.listener.Accept()
-	if err != nil {
-		return nil, err
+	return l.AcceptContext(context.Background())
+}
+
+// Like Accept, but gives up and returns ctx.Err() once ctx is done, so a
+// server can stop accepting on shutdown, while connections already accepted
+// keep working. Giving up only affects this call: the listener stays open, and
+// peers that have not been taken from it yet are handed to the next Accept.
+// A peer whose destination the bridge was still sending when ctx was done is
+// lost, though: its connection is closed.
+func (l *StreamListener) AcceptContext(ctx context.Context) (*SAMConn, error) {
+	l.startAccepting()
+	var conn net.Conn
+	select {
+	case c, ok := <-l.accepted:
+		if !ok {
+			return nil, l.err
+		}
+		conn = c
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	stop := watchContext(ctx, conn)
+	c, err := l.readDestination(conn)
+	if stop() {
+		conn.Close()
+		return nil, ctx.Err()
+	}
+	return c, err
+}
+
+// Starts the goroutine accepting connections, unless it runs already.
+func (l *StreamListener) startAccepting() {
+	l.start.Do(func() {
+		l.accepted = make(chan net.Conn)
+		l.loopDone = make(chan struct{})
+		go l.acceptLoop()
+	})
+}
+
+func (l *StreamListener) acceptLoop() {
+	defer close(l.loopDone)
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				// only draining sets a deadline: nothing is left waiting
+				err = errListenerDrained
+			}
+			l.err = err
+			close(l.accepted)
+			return
+		}
+		select {
+		case l.accepted <- conn:
+		case <-l.closed:
+			conn.Close()
+		}
+		select {
+		case <-l.draining:
+			l.drainDeadline()
+		default:
+		}
+	}
+}
+
+// How long a draining listener waits for another connection the router
+// already forwarded, before it considers the queue to be empty.
+const drainPoll = 50 * time.Millisecond
+
+// Returned by Accept once CloseWithDrain accepted every connection that was
+// waiting.
+var errListenerDrained = errors.New("Listener closed")
+
+// Makes the pending Accept of the local socket give up soon, unless another
+// connection is waiting in its queue.
+func (l *StreamListener) drainDeadline() {
+	if dl, ok := l.listener.(interface{ SetDeadline(time.Time) error }); ok {
+		dl.SetDeadline(time.Now().Add(drainPoll))
 	}
+}
+
+// Returned by Accept when a single incoming connection failed, rather than the
+// listener.
+type handshakeError struct {
+	err error
+}
+
+func (e handshakeError) Error() string {
+	return e.err.Error()
+}
+
+func (e handshakeError) Unwrap() error {
+	return e.err
+}
+
+// Reads the destination of the peer, which the bridge sends first on every
+// accepted connection, and the ports it connected from and to, if sent.
+func (l *StreamListener) readDestination(conn net.Conn) (*SAMConn, error) {
 	buf := make([]byte, defaultListenReadLen)
-	n, err := conn.Read(buf)
-	if n < defaultListenReadLen {
-		return nil, errors.New("Unknown destination type: " + string(buf[:n]))
+	// the destination may arrive in several pieces
+	n, err := io.ReadFull(conn, buf)
+	if err != nil {
+		conn.Close()
+		return nil, handshakeError{errors.New("Unknown destination type: " + string(buf[:n]))}
 	}
 	// I2P inserts the I2P address ("destination") of the connecting peer into the datastream, followed by
 	// a \n. Since the length of a destination may vary, this reads until a newline is found. At the time
@@ -170,7 +460,8 @@ func (l *StreamListener) Accept() (*SAMConn, error) {
 		for {
 			n, err := conn.Read(abuf)
 			if n != 1 || err != nil {
-				return nil, errors.New("Failed to decode connecting peers I2P destination.")
+				conn.Close()
+				return nil, handshakeError{errors.New("Failed to decode connecting peers I2P destination.")}
 			}
 			buf = append(buf, abuf[0])
 			if rune(abuf[0]) == '\n' {
@@ -178,16 +469,36 @@ func (l *StreamListener) Accept() (*SAMConn, error) {
 			}
 		}
 	}
-	rAddr, err := NewI2PAddrFromString(string(buf[:len(buf)-1])) // the address minus the trailing newline
+	// SAM 3.2 bridges follow the address with FROM_PORT= and TO_PORT=
+	fields := strings.Fields(string(buf[:len(buf)-1]))
+	if len(fields) == 0 {
+		conn.Close()
+		return nil, handshakeError{errors.New("Could not determine connecting tunnels address.")}
+	}
+	rAddr, err := NewI2PAddrFromString(fields[0])
 	if err != nil {
 		conn.Close()
-		return nil, errors.New("Could not determine connecting tunnels address.")
+		return nil, handshakeError{errors.New("Could not determine connecting tunnels address.")}
 	}
-	return &SAMConn{l.laddr, rAddr, conn}, nil
+	if l.readBPS > 0 || l.writeBPS > 0 {
+		conn = NewThrottledConn(conn, l.readBPS, l.writeBPS)
+	}
+	sc := &SAMConn{laddr: l.laddr, raddr: rAddr, conn: conn, traffic: l.traffic}
+	for _, f := range fields[1:] {
+		if strings.HasPrefix(f, "FROM_PORT=") {
+			sc.fromPort, _ = strconv.Atoi(f[len("FROM_PORT="):])
+		} else if strings.HasPrefix(f, "TO_PORT=") {
+			sc.toPort, _ = strconv.Atoi(f[len("TO_PORT="):])
+		}
+	}
+	return sc, nil
 }
 
-// Closes the stream session. Implements net.Listener
+// Closes the listener and the local socket the router forwards connections
+// to, abruptly: connections the router already forwarded, but that were not
+// accepted yet, are lost. The session stays open. See CloseWithDrain.
 func (l *StreamListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
 	err := l.listener.Close()
 	err2 := l.conn.Close()
 	if err2 != nil {
@@ -196,7 +507,58 @@ func (l *StreamListener) Close() error {
 	return err
 }
 
+// Closes the listener gracefully, for restarts that drop no connections: the
+// router is told to stop forwarding new connections, and those it already
+// forwarded can still be accepted. Returns once every one of them has been
+// accepted, or ctx is done, whichever is first, and the listener is then
+// closed like Close does.
+func (l *StreamListener) CloseWithDrain(ctx context.Context) error {
+	// ends the STREAM FORWARD, and waits for the bridge to hang up, after
+	// which it forwards nothing more
+	err := closeGracefully(ctx, l.conn, nil)
+	l.startAccepting()
+	l.drainOnce.Do(func() { close(l.draining) })
+	l.drainDeadline()
+	select {
+	case <-l.loopDone:
+	case <-ctx.Done():
+	}
+	l.closeOnce.Do(func() { close(l.closed) })
+	err2 := l.listener.Close()
+	if err != nil {
+		return err
+	}
+	return err2
+}
+
 // Returns the I2P destination (address) of the stream session. Implements net.Listener
 func (l *StreamListener) Addr() net.Addr {
 	return l.laddr
 }
+
+// A StreamListener whose Accept returns a net.Conn, so it implements
+// net.Listener and can be handed to anything serving one, such as
+// http.Serve.
+type ForwardListener struct {
+	*StreamListener
+}
+
+// Like Listen, but returns a listener implementing net.Listener. Internally,
+// the router forwards every incoming connection (STREAM FORWARD) to a local
+// TCP socket opened by the library, which is closed along with the listener.
+func (s *StreamSession) ListenForward() (*ForwardListener, error) {
+	l, err := s.Listen()
+	if err != nil {
+		return nil, err
+	}
+	return &ForwardListener{l}, nil
+}
+
+// Accepts the next incoming connection, a *SAMConn. Implements net.Listener.
+func (l *ForwardListener) Accept() (net.Conn, error) {
+	conn, err := l.StreamListener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
@@ -1,9 +1,15 @@
 package sam3
 
 import (
+	"bufio"
+	"context"
 	"fmt"
+	"io"
+	"net"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func Test_StreamingDial(t *testing.T) {
@@ -277,3 +283,365 @@ func ExampleStreamListener() {
 	// Output:
 	//Hello world!
 }
+
+func Test_StreamConnCloseKeepsSession(t *testing.T) {
+	mock := newMockSAM(t, mockOK)
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	ss, err := sam.NewStreamSession("closeTun", NewKeys(I2PAddr("pub"), "pubpriv"), []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := ss.DialI2P(I2PAddr("peer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	conn, err = ss.DialI2P(I2PAddr("peer"))
+	if err != nil {
+		t.Fatalf("session unusable after closing a dialed conn: %v", err)
+	}
+	conn.Close()
+	if err := ss.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_StreamProbe(t *testing.T) {
+	mock := newMockSAM(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "STREAM CONNECT") && mockField(cmd, "DESTINATION") == "down" {
+			return "STREAM STATUS RESULT=CANT_REACH_PEER\n"
+		}
+		return mockOK(cmd)
+	})
+	defer mock.Close()
+	ss := &StreamSession{cfg: Config{Address: mock.Addr()}, id: "probeTun"}
+	ss.SetMaxConcurrentDials(1)
+	if err := ss.Probe(context.Background(), I2PAddr("up")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.Probe(context.Background(), I2PAddr("down")); err != ErrCantReachPeer {
+		t.Fatalf("expected ErrCantReachPeer, got %v", err)
+	}
+}
+
+func Test_StreamMeasureRTT(t *testing.T) {
+	mock := newMockSAM(t, mockOK)
+	defer mock.Close()
+	ss := &StreamSession{cfg: Config{Address: mock.Addr()}, id: "rttTun"}
+	rtt, err := ss.MeasureRTT(context.Background(), I2PAddr("peer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rtt <= 0 {
+		t.Fatalf("expected a positive round trip time, got %v", rtt)
+	}
+}
+
+func Test_StreamConcurrentAcceptDial(t *testing.T) {
+	const n = 20
+	mock := newMockSAM(t, func(cmd string) string {
+		switch {
+		case strings.HasPrefix(cmd, "STREAM FORWARD"):
+			// play the peers connecting to the session
+			port := mockField(cmd, "PORT")
+			for i := 0; i < n; i++ {
+				go func(i int) {
+					conn, err := net.Dial("tcp4", "127.0.0.1:"+port)
+					if err != nil {
+						return
+					}
+					defer conn.Close()
+					fmt.Fprintf(conn, "%s\nhello %d\n", testDest, i)
+					conn.Read(make([]byte, 1))
+				}(i)
+			}
+			return "STREAM STATUS RESULT=OK\n"
+		case strings.HasPrefix(cmd, "ping "):
+			return "pong " + cmd[5:] + "\n"
+		}
+		return mockOK(cmd)
+	})
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	ss, err := sam.NewStreamSession("p2pTun", NewKeys(I2PAddr("pub"), "pubpriv"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	l, err := ss.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	errs := make(chan error, 2*n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			conn, err := l.Accept()
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer conn.Close()
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			if err != nil {
+				errs <- err
+			} else if conn.RemoteAddr().String() != string(testDest) || !strings.HasPrefix(line, "hello ") {
+				errs <- fmt.Errorf("accepted %q from %q", line, conn.RemoteAddr())
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			conn, err := ss.DialI2P(I2PAddr("peer"))
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer conn.Close()
+			fmt.Fprintf(conn, "ping %d\n", i)
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			if err != nil {
+				errs <- err
+			} else if line != fmt.Sprintf("pong %d\n", i) {
+				errs <- fmt.Errorf("dial %d got %q", i, line)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func Test_StreamAcceptContext(t *testing.T) {
+	var port string
+	forwarded := make(chan bool, 1)
+	mock := newMockSAM(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "STREAM FORWARD") {
+			port = mockField(cmd, "PORT")
+			forwarded <- true
+			return "STREAM STATUS RESULT=OK\n"
+		}
+		return mockOK(cmd)
+	})
+	defer mock.Close()
+	ss := &StreamSession{cfg: Config{Address: mock.Addr()}, id: "acceptTun", keys: NewKeys(testDest, testPrivKeys)}
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	ss.conn = sam.conn
+	l, err := ss.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	<-forwarded
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := l.AcceptContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	// a peer whose destination is not complete when the accept gives up is
+	// closed
+	slow, err := net.Dial("tcp4", "127.0.0.1:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slow.Close()
+	fmt.Fprint(slow, string(testDest)[:100])
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := l.AcceptContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	slow.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := slow.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected the connection of the peer to be closed, got %v", err)
+	}
+	// the listener still works after a cancelled accept
+	peer, err := net.Dial("tcp4", "127.0.0.1:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	fmt.Fprintf(peer, "%s\n", testDest)
+	conn, err := l.AcceptContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	l.Close()
+	if _, err := l.Accept(); err == nil {
+		t.Fatal("accepted on a closed listener")
+	}
+}
+
+func Test_SAMConnWriteTimeout(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	conn := SAMConn{conn: a}
+	if _, err := conn.TryWrite([]byte("nobody reads")); err != ErrWriteWouldBlock {
+		t.Fatalf("expected ErrWriteWouldBlock, got %v", err)
+	}
+	go b.Read(make([]byte, 16))
+	if n, err := conn.WriteTimeout([]byte("read"), time.Second); err != nil || n != 4 {
+		t.Fatalf("write failed: %d, %v", n, err)
+	}
+}
+
+func Test_ForwardListener(t *testing.T) {
+	mock := newMockSAM(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "STREAM FORWARD") {
+			go func(port string) {
+				conn, err := net.Dial("tcp4", "127.0.0.1:"+port)
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				fmt.Fprintf(conn, "%s FROM_PORT=5 TO_PORT=80\nhello\n", testDest)
+				conn.Read(make([]byte, 1))
+			}(mockField(cmd, "PORT"))
+			return "STREAM STATUS RESULT=OK\n"
+		}
+		return mockOK(cmd)
+	})
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	ss, err := sam.NewStreamSession("fwdTun", NewKeys(I2PAddr("pub"), "pubpriv"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	fl, err := ss.ListenForward()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l net.Listener = fl
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "hello\n" || conn.(*SAMConn).ToPort() != 80 {
+		t.Fatalf("accepted %q, %v", line, err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Accept(); err == nil {
+		t.Fatal("accepted after Close")
+	}
+}
+
+func Test_StreamListenerCloseWithDrain(t *testing.T) {
+	forwarded := make(chan string, 1)
+	mock := newMockSAM(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "STREAM FORWARD") {
+			forwarded <- mockField(cmd, "PORT")
+			return "STREAM STATUS RESULT=OK\n"
+		}
+		return mockOK(cmd)
+	})
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	ss, err := sam.NewStreamSession("drainTun", NewKeys(I2PAddr("pub"), "pubpriv"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	l, err := ss.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a connection the router forwarded before the listener is closed
+	peer, err := net.Dial("tcp4", "127.0.0.1:"+<-forwarded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	fmt.Fprintf(peer, "%s\n", testDest)
+
+	// ctx is only the upper bound; it returns once the queue is empty
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	closed := make(chan error, 1)
+	go func() { closed <- l.CloseWithDrain(ctx) }()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("queued connection was dropped: %v", err)
+	}
+	conn.Close()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("CloseWithDrain did not return once the queue was drained")
+	}
+	if _, err := l.Accept(); err == nil {
+		t.Fatal("accepted after CloseWithDrain")
+	}
+}
+
+func Test_TransientSessionKeys(t *testing.T) {
+	mock := newMockSAM(t, func(cmd string) string {
+		if strings.HasPrefix(cmd, "SESSION CREATE") {
+			// the generated keys, followed by fields of newer bridges
+			return "SESSION STATUS RESULT=OK DESTINATION=" + testPrivKeys + " MESSAGE=\"created\"\n"
+		}
+		return mockOK(cmd)
+	})
+	defer mock.Close()
+	sam, err := NewSAM(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	ss, err := sam.NewStreamSession("transientTun", TransientKeys(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	if keys := ss.Keys(); keys.Addr() != testDest || keys.String() != testPrivKeys {
+		t.Fatalf("unexpected keys %.20q, %.20q", keys.Addr(), keys.String())
+	}
+	if mockField(mock.Commands()[2], "DESTINATION") != "TRANSIENT" {
+		t.Fatalf("unexpected SESSION CREATE %q", mock.Commands()[2])
+	}
+	ds, err := sam.NewDatagramSession("transientDg", TransientKeys(), nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close()
+	if ds.LocalAddr() != testDest {
+		t.Fatalf("unexpected datagram address %.20q", ds.LocalAddr())
+	}
+}
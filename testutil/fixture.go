@@ -0,0 +1,126 @@
+// Helpers for testing code that uses sam3, without a running I2P router.
+package testutil
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Scripted replies of a SAM bridge, for exactly the commands a test expects.
+// Set up the replies with the With* methods, and hand the SAM bridge to the
+// code under test with Conn or Dial:
+//
+//	f := testutil.NewSessionFixture().WithSessionCreate(dest)
+//	sam, err := sam3.NewSAM("fixture", sam3.WithConn(f.Conn()), sam3.WithDialFunc(f.Dial))
+//
+// HELLO is answered with version 3.0 unless WithHello says otherwise. Commands
+// without a scripted reply are answered with RESULT=I2P_ERROR.
+type SessionFixture struct {
+	mu      sync.Mutex
+	replies map[string]string // by the first two words of the command
+	cmds    []string
+}
+
+// Creates a SessionFixture that only answers HELLO.
+func NewSessionFixture() *SessionFixture {
+	f := &SessionFixture{replies: make(map[string]string)}
+	return f.WithHello("3.0")
+}
+
+func (f *SessionFixture) set(cmd, reply string) *SessionFixture {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.replies[cmd] = reply
+	return f
+}
+
+// Makes HELLO agree on version ver.
+func (f *SessionFixture) WithHello(ver string) *SessionFixture {
+	return f.set("HELLO VERSION", "HELLO REPLY RESULT=OK VERSION="+ver+"\n")
+}
+
+// Makes DEST GENERATE return the given keys.
+func (f *SessionFixture) WithDestGenerate(pub, priv string) *SessionFixture {
+	return f.set("DEST GENERATE", "DEST REPLY PUB="+pub+" PRIV="+priv+"\n")
+}
+
+// Makes SESSION CREATE succeed, replying with dest. Note that sam3 checks that
+// dest are the keys the session was created with.
+func (f *SessionFixture) WithSessionCreate(dest string) *SessionFixture {
+	return f.set("SESSION CREATE", "SESSION STATUS RESULT=OK DESTINATION="+dest+"\n")
+}
+
+// Makes STREAM ACCEPT succeed, with a connection from remoteDest.
+func (f *SessionFixture) WithStreamAccept(remoteDest string) *SessionFixture {
+	return f.set("STREAM ACCEPT", "STREAM STATUS RESULT=OK\n"+remoteDest+"\n")
+}
+
+// Makes STREAM CONNECT succeed.
+func (f *SessionFixture) WithStreamConnect() *SessionFixture {
+	return f.set("STREAM CONNECT", "STREAM STATUS RESULT=OK\n")
+}
+
+// Returns a new connection to the scripted bridge. Each connection is served
+// separately, from the same script.
+func (f *SessionFixture) Conn() net.Conn {
+	client, server := net.Pipe()
+	go f.serve(server)
+	return client
+}
+
+// Like Conn, with the signature of sam3.Config.DialFunc.
+func (f *SessionFixture) Dial(network, address string) (net.Conn, error) {
+	return f.Conn(), nil
+}
+
+// Returns the commands received so far, on any connection, without the
+// trailing newlines.
+func (f *SessionFixture) Commands() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.cmds...)
+}
+
+func (f *SessionFixture) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimRight(line, "\n")
+		fields := strings.Fields(cmd)
+		key := strings.Join(fields[:min2(len(fields))], " ")
+		f.mu.Lock()
+		f.cmds = append(f.cmds, cmd)
+		reply, ok := f.replies[key]
+		f.mu.Unlock()
+		if !ok {
+			topic := "SESSION"
+			if len(fields) > 0 {
+				topic = fields[0]
+			}
+			reply = topic + " STATUS RESULT=I2P_ERROR MESSAGE=\"unexpected command\"\n"
+		}
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+		if ok && (key == "STREAM CONNECT" || key == "STREAM ACCEPT") {
+			// the connection carries data from now on
+			io.Copy(ioutil.Discard, r)
+			return
+		}
+	}
+}
+
+func min2(n int) int {
+	if n > 2 {
+		return 2
+	}
+	return n
+}
@@ -0,0 +1,52 @@
+package testutil_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dajohi/sam3"
+	"github.com/dajohi/sam3/testutil"
+)
+
+func Test_SessionFixture(t *testing.T) {
+	keys := sam3.NewKeys(sam3.I2PAddr("pub"), "pubpriv")
+	f := testutil.NewSessionFixture().
+		WithHello("3.1").
+		WithDestGenerate("pub", "pubpriv").
+		WithSessionCreate(keys.String()).
+		WithStreamConnect()
+	sam, err := sam3.NewSAM("fixture", sam3.WithConn(f.Conn()), sam3.WithDialFunc(f.Dial))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	if sam.Version() != "3.1" {
+		t.Fatalf("expected version 3.1, got %q", sam.Version())
+	}
+	generated, err := sam.NewKeys()
+	if err != nil || generated.String() != keys.String() {
+		t.Fatalf("unexpected keys %v, %v", generated, err)
+	}
+	ss, err := sam.NewStreamSession("fixtureTun", keys, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	conn, err := ss.DialI2P(sam3.I2PAddr("peer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if _, err := sam.Lookup("unscripted.i2p"); err == nil {
+		t.Fatal("an unscripted command succeeded")
+	}
+	var connects int
+	for _, cmd := range f.Commands() {
+		if strings.HasPrefix(cmd, "STREAM CONNECT ID=fixtureTun") {
+			connects++
+		}
+	}
+	if connects != 1 {
+		t.Fatalf("expected one STREAM CONNECT, got %q", f.Commands())
+	}
+}
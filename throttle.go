@@ -0,0 +1,210 @@
+package sam3
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// A net.Conn whose reads and writes are limited to a number of bytes per
+// second, so an application can keep its I2P bandwidth below what the router
+// or a usage policy allows. Short bursts of up to a seconds worth of bytes go
+// through at once. Waiting for the limit ends at the read or write deadline,
+// or when the connection is closed, like blocking I/O does.
+//
+// The limits are kept by the token bucket DatagramSession.SetRateLimit uses,
+// rather than by golang.org/x/time/rate, so that the package keeps depending
+// on the standard library only; it behaves like a rate.Limiter with a burst
+// of one second.
+type ThrottledConn struct {
+	net.Conn
+
+	mu            sync.Mutex
+	read          *tokenBucket // nil if reads are not limited
+	write         *tokenBucket // nil if writes are not limited
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// Wraps conn, limiting reads to readBPS and writes to writeBPS bytes per
+// second. A rate of 0 does not limit.
+func NewThrottledConn(conn net.Conn, readBPS, writeBPS float64) *ThrottledConn {
+	c := &ThrottledConn{Conn: conn, closed: make(chan struct{})}
+	c.SetReadLimit(readBPS)
+	c.SetWriteLimit(writeBPS)
+	return c
+}
+
+// Limits reads to bps bytes per second, or removes the limit if bps is 0.
+func (c *ThrottledConn) SetReadLimit(bps float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.read = throttleBucket(bps)
+}
+
+// Limits writes to bps bytes per second, or removes the limit if bps is 0.
+func (c *ThrottledConn) SetWriteLimit(bps float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.write = throttleBucket(bps)
+}
+
+func throttleBucket(bps float64) *tokenBucket {
+	if bps <= 0 {
+		return nil
+	}
+	return newTokenBucket(bps)
+}
+
+func (c *ThrottledConn) buckets() (read, write *tokenBucket) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.read, c.write
+}
+
+// Waits until n bytes may be written, or returns ctx.Err() once ctx is done,
+// for callers that want to wait with a context before writing. It only waits,
+// and takes nothing from the limit: Write does that. If other writes use up
+// the limit in the meantime, Write may still have to wait.
+func (c *ThrottledConn) Wait(ctx context.Context, n int) error {
+	_, write := c.buckets()
+	if write == nil {
+		return nil
+	}
+	for {
+		d := write.until(float64(n))
+		if d == 0 {
+			return nil
+		}
+		t := time.NewTimer(d)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-c.closed:
+			t.Stop()
+			return net.ErrClosed
+		}
+	}
+}
+
+// Sleeps for d, unless the deadline (if set) comes first, which returns
+// os.ErrDeadlineExceeded, or closed is closed, which returns net.ErrClosed.
+func sleepUntil(d time.Duration, deadline time.Time, closed <-chan struct{}) error {
+	var err error
+	if !deadline.IsZero() && time.Now().Add(d).After(deadline) {
+		d, err = time.Until(deadline), os.ErrDeadlineExceeded
+	}
+	if d <= 0 {
+		return err
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return err
+	case <-closed:
+		return net.ErrClosed
+	}
+}
+
+// Reads at most a seconds worth of bytes, and then waits until reading them
+// was allowed. Implements net.Conn
+func (c *ThrottledConn) Read(buf []byte) (int, error) {
+	read, _ := c.buckets()
+	if read == nil {
+		return c.Conn.Read(buf)
+	}
+	if float64(len(buf)) > read.burst {
+		buf = buf[:int(read.burst)]
+	}
+	n, err := c.Conn.Read(buf)
+	if n > 0 {
+		c.mu.Lock()
+		deadline := c.readDeadline
+		c.mu.Unlock()
+		if werr := sleepUntil(read.reserve(float64(n)), deadline, c.closed); err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+// Writes buf in pieces of at most a seconds worth of bytes, waiting before
+// each until it may be written. Implements net.Conn
+func (c *ThrottledConn) Write(buf []byte) (int, error) {
+	_, write := c.buckets()
+	if write == nil {
+		return c.Conn.Write(buf)
+	}
+	var written int
+	for len(buf) > 0 {
+		chunk := buf
+		if float64(len(chunk)) > write.burst {
+			chunk = chunk[:int(write.burst)]
+		}
+		c.mu.Lock()
+		deadline := c.writeDeadline
+		c.mu.Unlock()
+		if err := sleepUntil(write.reserve(float64(len(chunk))), deadline, c.closed); err != nil {
+			write.refund(float64(len(chunk)))
+			return written, err
+		}
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		buf = buf[n:]
+	}
+	return written, nil
+}
+
+// Closes the connection, ending any wait for the limit. Implements net.Conn
+func (c *ThrottledConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+// Sets the read and write deadlines, which also end waiting for the limit.
+// Implements net.Conn
+func (c *ThrottledConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline, c.writeDeadline = t, t
+	c.mu.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+// Sets the read deadline, which also ends waiting for the limit. Implements
+// net.Conn
+func (c *ThrottledConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.mu.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+// Sets the write deadline, which also ends waiting for the limit. Implements
+// net.Conn
+func (c *ThrottledConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.writeDeadline = t
+	c.mu.Unlock()
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// Limits the bandwidth of every connection accepted by the listeners of a
+// StreamSession to readBPS and writeBPS bytes per second each, see
+// ThrottledConn. A rate of 0 does not limit. Nothing is sent to the bridge.
+func WithThrottle(readBPS, writeBPS float64) Option {
+	return func(so *sessionOptions) error {
+		so.readBPS, so.writeBPS = readBPS, writeBPS
+		return nil
+	}
+}
@@ -0,0 +1,90 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_ThrottledConnWrite(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go io.Copy(ioutil.Discard, server)
+	c := NewThrottledConn(client, 0, 1000)
+	defer c.Close()
+	start := time.Now()
+	if n, err := c.Write(make([]byte, 1500)); n != 1500 || err != nil {
+		t.Fatalf("expected 1500 bytes written, got %d, %v", n, err)
+	}
+	if d := time.Since(start); d < 400*time.Millisecond {
+		t.Fatalf("expected the write to be throttled, took %v", d)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.Wait(ctx, 1000); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	c.SetWriteLimit(0)
+	if err := c.Wait(ctx, 1000); err != nil {
+		t.Fatalf("expected no wait without a limit, got %v", err)
+	}
+}
+
+func Test_ThrottledConnWait(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go io.Copy(ioutil.Discard, server)
+	c := NewThrottledConn(client, 0, 1000)
+	defer c.Close()
+	c.Write(make([]byte, 1000))
+	// Wait does not take what Write takes again
+	if err := c.Wait(context.Background(), 500); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	c.Write(make([]byte, 500))
+	if d := time.Since(start); d > 200*time.Millisecond {
+		t.Fatalf("expected the write to go through after Wait, took %v", d)
+	}
+
+	c.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	start = time.Now()
+	if _, err := c.Write(make([]byte, 1000)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected the deadline to end the wait, got %v", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("waited past the deadline: %v", d)
+	}
+	c.SetWriteDeadline(time.Time{})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		c.Close()
+	}()
+	start = time.Now()
+	if _, err := c.Write(make([]byte, 3000)); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected Close to end the wait, got %v", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("waited after Close: %v", d)
+	}
+}
+
+func Test_ThrottledAccept(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go client.Write([]byte(string(testDest) + "\n"))
+	l := &StreamListener{readBPS: 1000}
+	sc, err := l.readDestination(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+	if _, ok := sc.conn.(*ThrottledConn); !ok {
+		t.Fatalf("expected the accepted connection to be throttled, got %T", sc.conn)
+	}
+}
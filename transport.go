@@ -0,0 +1,57 @@
+package sam3
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+)
+
+// Opens connections to a SAM bridge. Implement it to reach bridges through
+// something other than plain TCP, such as an obfuscation layer (obfs4 and the
+// like) or a tunnel: Dial is called with the Address of the Config for the
+// connection of the SAM and of every session, and whatever net.Conn it
+// returns is spoken SAM over. See WithTransport.
+type Transport interface {
+	Dial(ctx context.Context, address string) (net.Conn, error)
+}
+
+// Connects over plain TCP, the default.
+type PlainTCPTransport struct {
+	Dialer  *net.Dialer // a zero net.Dialer if nil
+	Network string      // "tcp4" if empty
+}
+
+func (t PlainTCPTransport) Dial(ctx context.Context, address string) (net.Conn, error) {
+	d, network := t.Dialer, t.Network
+	if d == nil {
+		d = &net.Dialer{}
+	}
+	if network == "" {
+		network = "tcp4"
+	}
+	return d.DialContext(ctx, network, address)
+}
+
+// Returns a Transport connecting over TLS with config, for bridges behind a
+// TLS endpoint, such as a reverse proxy on another host. A nil config uses
+// the defaults, with the server name taken from the address.
+func TLSTransport(config *tls.Config) Transport {
+	return tlsTransport{config: config}
+}
+
+type tlsTransport struct {
+	config *tls.Config
+}
+
+func (t tlsTransport) Dial(ctx context.Context, address string) (net.Conn, error) {
+	d := &tls.Dialer{Config: t.config}
+	return d.DialContext(ctx, "tcp", address)
+}
+
+// Makes every connection to the bridge, including those of sessions, be opened
+// with t, see Config.Transport.
+func WithTransport(t Transport) SAMOption {
+	return func(cfg *Config) {
+		cfg.Transport = t
+	}
+}
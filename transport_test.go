@@ -0,0 +1,71 @@
+package sam3
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http/httptest"
+	"testing"
+)
+
+type countingTransport struct {
+	dials int
+}
+
+func (t *countingTransport) Dial(ctx context.Context, address string) (net.Conn, error) {
+	t.dials++
+	return PlainTCPTransport{}.Dial(ctx, address)
+}
+
+func Test_Transport(t *testing.T) {
+	mock := newMockSAM(t, mockOK)
+	defer mock.Close()
+	tr := &countingTransport{}
+	sam, err := NewSAM(mock.Addr(), WithTransport(tr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	ss, err := sam.NewStreamSession("transportTun", NewKeys(I2PAddr("pub"), "pubpriv"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	if tr.dials != 2 {
+		t.Fatalf("expected the SAM and the session to dial with the transport, got %d dials", tr.dials)
+	}
+}
+
+func Test_TLSTransport(t *testing.T) {
+	// borrow the certificate of a TLS test server
+	srv := httptest.NewTLSServer(nil)
+	defer srv.Close()
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: srv.TLS.Certificates})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, err := bufio.NewReader(conn).ReadString('\n'); err != nil {
+			return
+		}
+		conn.Write([]byte("HELLO REPLY RESULT=OK VERSION=3.0\n"))
+	}()
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	sam, err := NewSAM(l.Addr().String(), WithTransport(TLSTransport(&tls.Config{RootCAs: roots, ServerName: "example.com"})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	if sam.Version() != "3.0" {
+		t.Fatalf("expected SAM 3.0 over TLS, got %q", sam.Version())
+	}
+}
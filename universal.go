@@ -0,0 +1,112 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+)
+
+// A dialer for both I2P and clearnet addresses: names ending in .i2p
+// (including .b32.i2p) are dialed over I2P, everything else over the ordinary
+// network. Unlike FallbackDialer, it never tries the other network, so no I2P
+// traffic leaks to clearnet. Set it as the DialContext of an http.Transport to
+// route every request the right way.
+type UniversalDialer struct {
+	session  *StreamSession
+	pool     *PriorityPool
+	clearnet *net.Dialer
+
+	candidates CandidateFunc // see WithCandidates
+	order      CandidateOrder
+	next       uint64 // the candidate to start with, for CandidatesRoundRobin
+}
+
+// Configures a UniversalDialer.
+type UniversalDialerOption func(*UniversalDialer)
+
+// Makes the dialer resolve I2P names on connections from pool, rather than
+// opening a new connection to the bridge for every lookup.
+func WithI2PPool(pool *PriorityPool) UniversalDialerOption {
+	return func(d *UniversalDialer) {
+		d.pool = pool
+	}
+}
+
+// Sets the dialer used for clearnet addresses, instead of a zero net.Dialer.
+func WithClearnetDialer(dialer *net.Dialer) UniversalDialerOption {
+	return func(d *UniversalDialer) {
+		d.clearnet = dialer
+	}
+}
+
+// Creates a UniversalDialer, which dials I2P addresses using session.
+func NewUniversalDialer(session *StreamSession, opts ...UniversalDialerOption) *UniversalDialer {
+	d := &UniversalDialer{session: session, clearnet: &net.Dialer{}}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Reports whether host is an I2P name.
+func isI2PHost(host string) bool {
+	return strings.HasSuffix(strings.ToLower(strings.TrimSuffix(host, ".")), ".i2p")
+}
+
+// Dials addr, such as "zzz.i2p:80" or "example.com:80". Any port is ignored
+// when dialing over I2P.
+func (d *UniversalDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// Like Dial, but gives up when ctx is done.
+func (d *UniversalDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+	if !isI2PHost(host) {
+		return d.clearnet.DialContext(ctx, network, addr)
+	}
+	if d.candidates != nil {
+		if conn, ok, err := d.dialCandidates(ctx, host); ok {
+			if err != nil {
+				return nil, err
+			}
+			return conn, nil
+		}
+	}
+	dest, err := d.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := d.session.DialContextI2P(ctx, dest)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (d *UniversalDialer) lookup(ctx context.Context, name string) (I2PAddr, error) {
+	if d.pool == nil {
+		return d.session.Lookup(name)
+	}
+	sam, err := d.pool.Get(ctx, PriorityNormal)
+	if err != nil {
+		return I2PAddr(""), err
+	}
+	stop := watchContext(ctx, sam.conn)
+	dest, err := sam.Lookup(name)
+	if stop() {
+		d.pool.Discard(sam)
+		return I2PAddr(""), ctx.Err()
+	}
+	if err != nil && !errors.Is(err, ErrNameNotFound) {
+		// the connection may be broken
+		d.pool.Discard(sam)
+		return I2PAddr(""), err
+	}
+	d.pool.Put(sam)
+	return dest, err
+}
@@ -0,0 +1,65 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func Test_UniversalDialer(t *testing.T) {
+	mock := newMockSAM(t, func(cmd string) string {
+		if r := mockLookup(cmd); r != "" {
+			return r
+		}
+		return mockOK(cmd)
+	})
+	defer mock.Close()
+	pool, err := NewPriorityPool(mock.Addr(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	ss := &StreamSession{cfg: Config{Address: mock.Addr()}, id: "universalTun"}
+
+	clearnet, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clearnet.Close()
+	go func() {
+		for {
+			conn, err := clearnet.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	d := NewUniversalDialer(ss, WithI2PPool(pool), WithClearnetDialer(&net.Dialer{}))
+	conn, err := d.DialContext(context.Background(), "tcp", "known.i2p:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := conn.(*SAMConn); !ok {
+		t.Fatalf("dialed an I2P name with %T", conn)
+	}
+	conn.Close()
+	if _, err := d.Dial("tcp", "unknown.I2P"); !errors.Is(err, ErrNameNotFound) {
+		t.Fatalf("expected ErrNameNotFound, got %v", err)
+	}
+	conn, err = d.Dial("tcp", clearnet.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := conn.(*net.TCPConn); !ok {
+		t.Fatalf("dialed a clearnet address with %T", conn)
+	}
+	conn.Close()
+	for _, cmd := range mock.Commands() {
+		if mockField(cmd, "NAME") == clearnet.Addr().String() {
+			t.Fatal("looked up a clearnet address in I2P")
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package sam3
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// A parsed i2p:// or i2ps:// URL.
+type I2PURL struct {
+	Scheme string  // "i2p" or "i2ps"
+	Name   string  // the host name, such as "zzz.i2p", if the host was a name
+	Addr   I2PAddr // the destination, if the host was one or has been resolved
+	Path   string  // everything after the host, "/" if there was nothing
+}
+
+// Parses an i2p:// or i2ps:// URL. The host is either a name (including
+// *.b32.i2p names) or a base64 destination. Names are not resolved, use
+// I2PURL.Resolve() for that.
+func NewI2PURL(rawurl string) (*I2PURL, error) {
+	var u I2PURL
+	i := strings.Index(rawurl, "://")
+	if i < 0 {
+		return nil, errors.New("Not a URL: " + rawurl)
+	}
+	u.Scheme = strings.ToLower(rawurl[:i])
+	if u.Scheme != "i2p" && u.Scheme != "i2ps" {
+		return nil, errors.New("Not an i2p:// or i2ps:// URL: " + rawurl)
+	}
+	host, path := rawurl[i+3:], "/"
+	if j := strings.IndexAny(host, "/?#"); j >= 0 {
+		host, path = host[:j], host[j:]
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+	}
+	if host == "" {
+		return nil, errors.New("URL has no host: " + rawurl)
+	}
+	u.Path = path
+	if strings.HasSuffix(strings.ToLower(host), ".i2p") {
+		u.Name = strings.ToLower(host)
+		return &u, nil
+	}
+	addr, err := NewI2PAddrFromString(host)
+	if err != nil {
+		return nil, errors.New("URL host is neither an .i2p name nor a destination: " + rawurl)
+	}
+	u.Addr = addr
+	return &u, nil
+}
+
+// Parses an i2p:// or i2ps:// URL, see NewI2PURL. Exactly one of addr and name
+// is set.
+func ParseI2PURL(rawurl string) (scheme string, addr I2PAddr, name string, path string, err error) {
+	u, err := NewI2PURL(rawurl)
+	if err != nil {
+		return "", I2PAddr(""), "", "", err
+	}
+	return u.Scheme, u.Addr, u.Name, u.Path, nil
+}
+
+// Looks up the name of the URL using sam, and sets Addr. Does nothing if Addr
+// is already set.
+func (u *I2PURL) Resolve(sam *SAM) error {
+	if u.Addr != "" {
+		return nil
+	}
+	addr, err := sam.Lookup(u.Name)
+	if err != nil {
+		return err
+	}
+	u.Addr = addr
+	return nil
+}
+
+// Returns the host part of the URL: the name, or else the b32 address.
+func (u *I2PURL) Host() string {
+	if u.Name != "" {
+		return u.Name
+	}
+	return u.Addr.Base32()
+}
+
+// Converts the URL to an ordinary HTTP(S) URL. If localProxyPort is zero, the
+// URL keeps its I2P host, which is what an I2P HTTP proxy expects. Otherwise it
+// points to port localProxyPort on the local machine, for use with a client
+// tunnel listening there that forwards to the destination of the URL.
+func (u *I2PURL) ToHTTPURL(localProxyPort int) string {
+	scheme := "http"
+	if u.Scheme == "i2ps" {
+		scheme = "https"
+	}
+	if localProxyPort == 0 {
+		return scheme + "://" + u.Host() + u.Path
+	}
+	return scheme + "://127.0.0.1:" + strconv.Itoa(localProxyPort) + u.Path
+}
@@ -0,0 +1,33 @@
+package sam3
+
+import (
+	"testing"
+)
+
+func Test_ParseI2PURL(t *testing.T) {
+	scheme, addr, name, path, err := ParseI2PURL("i2p://ZZZ.i2p/topics/1?page=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scheme != "i2p" || addr != "" || name != "zzz.i2p" || path != "/topics/1?page=2" {
+		t.Fatalf("unexpected parse %q %q %q %q", scheme, addr, name, path)
+	}
+	u, err := NewI2PURL("i2ps://" + string(testDest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Addr != testDest || u.Path != "/" {
+		t.Fatalf("unexpected parse %+v", u)
+	}
+	if got := u.ToHTTPURL(0); got != "https://"+testDest.Base32()+"/" {
+		t.Fatalf("unexpected HTTP URL %q", got)
+	}
+	if got := u.ToHTTPURL(7000); got != "https://127.0.0.1:7000/" {
+		t.Fatalf("unexpected HTTP URL %q", got)
+	}
+	for _, bad := range []string{"http://zzz.i2p/", "i2p://", "i2p://example.com/", "zzz.i2p"} {
+		if _, err := NewI2PURL(bad); err == nil {
+			t.Errorf("accepted %q", bad)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package sam3
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Returned when a feature needs a newer SAM version than the one negotiated
+// with the bridge. It matches ErrNotSupported with errors.Is.
+type VersionError struct {
+	Feature    string // such as "subsessions"
+	Required   string // the SAM version the feature needs, such as "3.3"
+	Negotiated string // the SAM version negotiated with the bridge
+}
+
+func (e *VersionError) Error() string {
+	return e.Feature + " require SAM bridge version " + e.Required + ", connected to " + e.Negotiated
+}
+
+func (e *VersionError) Is(target error) bool {
+	return target == ErrNotSupported
+}
+
+// Checks features against the SAM version negotiated with a bridge, so code
+// depending on a version can fail early with a clear error. See also
+// Features, for deciding rather than failing.
+type VersionGate struct {
+	negotiated string
+}
+
+// Returns a VersionGate for the SAM version negotiated with the bridge.
+func (sam *SAM) VersionGate() VersionGate {
+	return VersionGate{negotiated: sam.version}
+}
+
+// Returns nil if the negotiated SAM version is minVersion ("3.X") or later,
+// and otherwise a *VersionError naming feature, such as "subsessions", which
+// ends up in its message. Versions are compared as numbers, so "3.10" comes
+// after "3.9".
+func (g VersionGate) RequireVersion(feature, minVersion string) error {
+	if !validVersion(minVersion) {
+		return errors.New("Invalid SAM version " + minVersion)
+	}
+	return requireVersion(g.negotiated, minVersion, feature)
+}
+
+// Returns a *VersionError naming feature, such as "subsessions", unless the
+// negotiated SAM version is at least min.
+func requireVersion(negotiated, min, feature string) error {
+	if versionAtLeast(negotiated, min) {
+		return nil
+	}
+	return &VersionError{Feature: feature, Required: min, Negotiated: negotiated}
+}
+
+// The SAM versions that parameters of SESSION CREATE and SESSION ADD need, by
+// key, and the features they are named as in a *VersionError.
+var paramVersions = map[string]struct{ version, feature string }{
+	"SIGNATURE_TYPE":  {"3.1", "signature types other than DSA-SHA1"},
+	"FROM_PORT":       {"3.2", "ports"},
+	"TO_PORT":         {"3.2", "ports"},
+	"HEADER":          {"3.2", "raw headers"},
+	"LISTEN_PORT":     {"3.3", "listen ports"},
+	"LISTEN_PROTOCOL": {"3.3", "listen protocols"},
+}
+
+// Returns a *VersionError if one of params, the KEY=VALUE parameters of
+// SESSION CREATE or SESSION ADD, needs a later SAM version than negotiated,
+// such as those of WithPorts, WithRawHeader or WithListenPort.
+func requireParams(negotiated string, params []string) error {
+	for _, p := range params {
+		key := p
+		if i := strings.IndexByte(p, '='); i >= 0 {
+			key = p[:i]
+		}
+		if need, ok := paramVersions[key]; ok {
+			if err := requireVersion(negotiated, need.version, need.feature); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Reports whether the SAM version v is at least min. Versions are compared as
+// major.minor numbers, so "3.10" comes after "3.9".
+func versionAtLeast(v, min string) bool {
+	vMajor, vMinor := splitVersion(v)
+	mMajor, mMinor := splitVersion(min)
+	if vMajor != mMajor {
+		return vMajor > mMajor
+	}
+	return vMinor >= mMinor
+}
+
+func splitVersion(v string) (major, minor int) {
+	parts := strings.SplitN(v, ".", 2)
+	major, _ = strconv.Atoi(parts[0])
+	if len(parts) == 2 {
+		minor, _ = strconv.Atoi(parts[1])
+	}
+	return major, minor
+}
+
+// Reports whether v is a SAM version of the form "major.minor".
+func validVersion(v string) bool {
+	parts := strings.Split(v, ".")
+	if len(parts) != 2 {
+		return false
+	}
+	for _, p := range parts {
+		if _, err := strconv.ParseUint(p, 10, 16); err != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,43 @@
+package sam3
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_VersionGate(t *testing.T) {
+	g := VersionGate{negotiated: "3.10"}
+	for _, min := range []string{"3.0", "3.3", "3.10"} {
+		if err := g.RequireVersion("anything", min); err != nil {
+			t.Errorf("3.10 does not satisfy %s: %v", min, err)
+		}
+	}
+	err := g.RequireVersion("flux capacitors", "3.11")
+	var verr *VersionError
+	if !errors.As(err, &verr) || verr.Feature != "flux capacitors" || verr.Required != "3.11" || verr.Negotiated != "3.10" || !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for _, bad := range []string{"", "3", "3.x", "3.1.2"} {
+		if err := g.RequireVersion("anything", bad); err == nil || errors.Is(err, ErrNotSupported) {
+			t.Errorf("accepted version %q: %v", bad, err)
+		}
+	}
+	err = requireVersion("3.1", "3.3", "subsessions")
+	if err == nil || err.Error() != "subsessions require SAM bridge version 3.3, connected to 3.1" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := requireParams("3.2", []string{"FROM_PORT=1", "TO_PORT=2", "HEADER=true", "SIGNATURE_TYPE=7"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct{ version, param string }{
+		{"3.0", "SIGNATURE_TYPE=7"},
+		{"3.1", "FROM_PORT=1"},
+		{"3.1", "HEADER=true"},
+		{"3.2", "LISTEN_PORT=80"},
+		{"3.2", "LISTEN_PROTOCOL=18"},
+	} {
+		if err := requireParams(tt.version, []string{tt.param}); !errors.Is(err, ErrNotSupported) {
+			t.Errorf("%s accepted on SAM %s: %v", tt.param, tt.version, err)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package sam3
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Counts the bytes moved by a session, accessed atomically. The methods do
+// nothing on a nil counter.
+type trafficCounter struct {
+	in, out int64
+}
+
+func (c *trafficCounter) addIn(n int) {
+	if c != nil && n > 0 {
+		atomic.AddInt64(&c.in, int64(n))
+	}
+}
+
+func (c *trafficCounter) addOut(n int) {
+	if c != nil && n > 0 {
+		atomic.AddInt64(&c.out, int64(n))
+	}
+}
+
+// Returns the number of bytes read from the connections of the session, since
+// it was created.
+func (s *StreamSession) BytesIn() int64 { return atomic.LoadInt64(&s.traffic.in) }
+
+// Returns the number of bytes written to the connections of the session, since
+// it was created.
+func (s *StreamSession) BytesOut() int64 { return atomic.LoadInt64(&s.traffic.out) }
+
+// Returns the number of datagram payload bytes received on the session.
+func (s *DatagramSession) BytesIn() int64 { return atomic.LoadInt64(&s.traffic.in) }
+
+// Returns the number of datagram payload bytes sent on the session.
+func (s *DatagramSession) BytesOut() int64 { return atomic.LoadInt64(&s.traffic.out) }
+
+// Returns the number of bytes received on the session, including the headers
+// of a session created WithRawHeader.
+func (s *RawSession) BytesIn() int64 { return atomic.LoadInt64(&s.traffic.in) }
+
+// Returns the number of bytes sent on the session.
+func (s *RawSession) BytesOut() int64 { return atomic.LoadInt64(&s.traffic.out) }
+
+// A session whose traffic can be watched by a DataLimitWatchdog.
+// StreamSession, DatagramSession and RawSession implement it.
+type MeteredSession interface {
+	BytesIn() int64
+	BytesOut() int64
+	Close() error
+}
+
+// Why a DataLimitWatchdog closed a session.
+type WatchdogReason int
+
+const (
+	WatchdogByteLimit WatchdogReason = iota // the session moved too many bytes
+	WatchdogTimeLimit                       // the session lived too long
+)
+
+// Sent by a DataLimitWatchdog when it closed a session.
+type WatchdogEvent struct {
+	Session   MeteredSession
+	Reason    WatchdogReason
+	BytesIn   int64
+	BytesOut  int64
+	Age       time.Duration // how long the session was watched
+	Timestamp time.Time
+}
+
+// Closes sessions that moved more bytes, or lived longer, than allowed, for
+// services on metered bandwidth or with a policy on how long a session may
+// last. Closing a StreamSession does not cut its connections that are already
+// open; they keep working until closed.
+type DataLimitWatchdog struct {
+	byteLimit int64         // zero for no limit
+	timeLimit time.Duration // zero for no limit
+
+	// How often the byte counts are checked. Defaults to one second, as does
+	// a value that is not positive.
+	Interval time.Duration
+
+	events chan WatchdogEvent
+	stop   chan struct{}
+	once   sync.Once
+}
+
+// Creates a DataLimitWatchdog closing sessions once BytesIn+BytesOut exceeds
+// limit.
+func ByteLimit(limit int64) *DataLimitWatchdog {
+	w := newDataLimitWatchdog()
+	w.byteLimit = limit
+	return w
+}
+
+// Creates a DataLimitWatchdog closing sessions once they have been watched for
+// longer than limit.
+func TimeLimit(limit time.Duration) *DataLimitWatchdog {
+	w := newDataLimitWatchdog()
+	w.timeLimit = limit
+	return w
+}
+
+// How often a DataLimitWatchdog checks byte counts by default.
+const defaultWatchdogInterval = time.Second
+
+func newDataLimitWatchdog() *DataLimitWatchdog {
+	return &DataLimitWatchdog{
+		Interval: defaultWatchdogInterval,
+		events:   make(chan WatchdogEvent, 16),
+		stop:     make(chan struct{}),
+	}
+}
+
+// Starts watching sess, until it is closed, by the watchdog or otherwise, or
+// Stop is called. Sessions of other types than StreamSession, DatagramSession
+// and RawSession are watched until the watchdog closes them, or Stop.
+func (w *DataLimitWatchdog) Watch(sess MeteredSession) {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = defaultWatchdogInterval
+	}
+	closing := sessionClosing(sess)
+	go func() {
+		start := time.Now()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var deadline <-chan time.Time
+		if w.timeLimit > 0 {
+			timer := time.NewTimer(w.timeLimit)
+			defer timer.Stop()
+			deadline = timer.C
+		}
+		for {
+			select {
+			case <-ticker.C:
+				if w.byteLimit > 0 && sess.BytesIn()+sess.BytesOut() > w.byteLimit {
+					w.expire(sess, WatchdogByteLimit, start)
+					return
+				}
+			case <-deadline:
+				w.expire(sess, WatchdogTimeLimit, start)
+				return
+			case <-closing:
+				return
+			case <-w.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Returns a channel that is closed once sess is being torn down, or nil if
+// sess is of a type that can not tell.
+func sessionClosing(sess MeteredSession) <-chan struct{} {
+	switch s := sess.(type) {
+	case *StreamSession:
+		return s.state.closing()
+	case *DatagramSession:
+		return s.state.closing()
+	case *RawSession:
+		return s.state.closing()
+	}
+	return nil
+}
+
+// Returns the channel WatchdogEvents are sent on. Events are dropped if the
+// channel is not read.
+func (w *DataLimitWatchdog) Events() <-chan WatchdogEvent {
+	return w.events
+}
+
+// Stops watching all sessions, without closing them.
+func (w *DataLimitWatchdog) Stop() {
+	w.once.Do(func() { close(w.stop) })
+}
+
+func (w *DataLimitWatchdog) expire(sess MeteredSession, reason WatchdogReason, start time.Time) {
+	sess.Close()
+	ev := WatchdogEvent{
+		Session:   sess,
+		Reason:    reason,
+		BytesIn:   sess.BytesIn(),
+		BytesOut:  sess.BytesOut(),
+		Age:       time.Since(start),
+		Timestamp: time.Now(),
+	}
+	select {
+	case w.events <- ev:
+	default:
+	}
+}
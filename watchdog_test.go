@@ -0,0 +1,93 @@
+package sam3
+
+import (
+	"net"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type meteredStub struct {
+	in, out int64
+	closed  int32
+}
+
+func (s *meteredStub) BytesIn() int64  { return atomic.LoadInt64(&s.in) }
+func (s *meteredStub) BytesOut() int64 { return atomic.LoadInt64(&s.out) }
+func (s *meteredStub) Close() error {
+	atomic.StoreInt32(&s.closed, 1)
+	return nil
+}
+
+func Test_DataLimitWatchdog(t *testing.T) {
+	w := ByteLimit(100)
+	w.Interval = 5 * time.Millisecond
+	defer w.Stop()
+	sess := &meteredStub{in: 60}
+	w.Watch(sess)
+	time.Sleep(20 * time.Millisecond)
+	if atomic.LoadInt32(&sess.closed) != 0 {
+		t.Fatal("closed below the limit")
+	}
+	atomic.StoreInt64(&sess.out, 41)
+	select {
+	case ev := <-w.Events():
+		if ev.Reason != WatchdogByteLimit || ev.BytesIn != 60 || ev.BytesOut != 41 || atomic.LoadInt32(&sess.closed) != 1 {
+			t.Fatalf("unexpected event %+v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the session was not closed")
+	}
+
+	w2 := TimeLimit(10 * time.Millisecond)
+	defer w2.Stop()
+	w2.Watch(&meteredStub{})
+	select {
+	case ev := <-w2.Events():
+		if ev.Reason != WatchdogTimeLimit || ev.Age < 10*time.Millisecond {
+			t.Fatalf("unexpected event %+v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the session was not closed")
+	}
+}
+
+func Test_StreamSessionTraffic(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	s := &StreamSession{}
+	conn := SAMConn{conn: a, traffic: &s.traffic}
+	go func() {
+		buf := make([]byte, 5)
+		b.Read(buf)
+		b.Write([]byte("abc"))
+	}()
+	conn.Write([]byte("hello"))
+	conn.Read(make([]byte, 8))
+	if s.BytesOut() != 5 || s.BytesIn() != 3 {
+		t.Fatalf("counted %d bytes out and %d in", s.BytesOut(), s.BytesIn())
+	}
+}
+
+func Test_DataLimitWatchdogClosedElsewhere(t *testing.T) {
+	w := ByteLimit(100)
+	w.Interval = 0 // the default, rather than a panic
+	defer w.Stop()
+	before := runtime.NumGoroutine()
+	ss := &StreamSession{}
+	ss.state.start()
+	w.Watch(ss)
+	ss.state.close()
+	ss.state.closed()
+	for deadline := time.Now().Add(5 * time.Second); runtime.NumGoroutine() > before; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("still watching a closed session")
+		}
+	}
+	select {
+	case ev := <-w.Events():
+		t.Fatalf("unexpected event %+v", ev)
+	default:
+	}
+}